@@ -3,18 +3,24 @@ package main
 //go:generate swagger generate spec --scan-models -o ../../internal/docs/swagger.json
 
 import (
+	"context"
 	"fmt"
 	"os"
 
+	"github.com/Tarick/naca-rss-feeds/internal/admin"
 	_ "github.com/Tarick/naca-rss-feeds/internal/docs"
+	"github.com/Tarick/naca-rss-feeds/internal/itempublish"
 	"github.com/Tarick/naca-rss-feeds/internal/logger/zaplogger"
+	"github.com/Tarick/naca-rss-feeds/internal/maintenance"
 
 	"github.com/Tarick/naca-rss-feeds/internal/application/server"
+	"github.com/Tarick/naca-rss-feeds/internal/hostpolicy"
 	"github.com/Tarick/naca-rss-feeds/internal/messaging/nsqclient/producer"
 	"github.com/Tarick/naca-rss-feeds/internal/processor"
 	"github.com/Tarick/naca-rss-feeds/internal/repository/postgresql"
 	"github.com/Tarick/naca-rss-feeds/internal/tracing"
 	"github.com/Tarick/naca-rss-feeds/internal/version"
+	"github.com/Tarick/naca-rss-feeds/internal/webhook"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -78,10 +84,10 @@ func startServer(cfgFile string) error {
 		return fmt.Errorf("Failure reading 'tracing' configuration, %v", err)
 	}
 	tracer, tracerCloser, err := tracing.New(tracingCfg, tracing.NewZapLogger(logger))
-	defer tracerCloser.Close()
 	if err != nil {
 		return fmt.Errorf("FATAL: Cannot init tracing, %v", err)
 	}
+	defer tracerCloser.Close()
 
 	// Create db configuration
 	databaseViperConfig := viper.Sub("database")
@@ -89,11 +95,18 @@ func startServer(cfgFile string) error {
 	if err := databaseViperConfig.UnmarshalExact(dbCfg); err != nil {
 		return fmt.Errorf("FATAL: failure reading 'database' configuration, %v", err)
 	}
+	if err := dbCfg.Validate(); err != nil {
+		return fmt.Errorf("FATAL: invalid 'database' configuration, %v", err)
+	}
 	// Open db
 	db, err := postgresql.New(dbCfg, postgresql.NewZapLogger(logger.Desugar()), tracer)
 	if err != nil {
 		return fmt.Errorf("FATAL: failure creating database connection, %v", err)
 	}
+	defer db.Close()
+	if err := db.CheckTables(context.Background()); err != nil {
+		return fmt.Errorf("FATAL: database schema check failed, %v", err)
+	}
 
 	// Create NSQ producer
 	publishViperConfig := viper.Sub("publish")
@@ -101,10 +114,14 @@ func startServer(cfgFile string) error {
 	if err := publishViperConfig.UnmarshalExact(&publishCfg); err != nil {
 		return fmt.Errorf("FATAL: failure reading NSQ 'publish' configuration, %v", err)
 	}
-	messageProducer, err := producer.New(publishCfg)
+	if err := publishCfg.Validate(); err != nil {
+		return fmt.Errorf("FATAL: invalid NSQ 'publish' configuration, %v", err)
+	}
+	messageProducer, err := producer.New(publishCfg, logger)
 	if err != nil {
 		return fmt.Errorf("FATAL: failure initialising NSQ producer, %v", err)
 	}
+	defer messageProducer.Stop()
 	rssFeedsUpdateProducer := processor.NewFeedsUpdateProducer(messageProducer, tracer)
 	// Create web server
 	serverCfg := server.Config{}
@@ -112,7 +129,107 @@ func startServer(cfgFile string) error {
 	if err := serverViperConfig.UnmarshalExact(&serverCfg); err != nil {
 		return fmt.Errorf("FATAL: failure reading 'server' configuration, %v", err)
 	}
-	handler := server.NewHandler(logger, tracer, db, rssFeedsUpdateProducer)
-	srv := server.New(serverCfg, logger, handler)
+	if err := serverCfg.Validate(); err != nil {
+		return fmt.Errorf("FATAL: invalid 'server' configuration, %v", err)
+	}
+	// Fetch configuration is optional, its host policy is used to validate feed urls
+	fetchCfg := &processor.FetchConfig{}
+	if viper.IsSet("fetch") {
+		if err := viper.Sub("fetch").UnmarshalExact(fetchCfg); err != nil {
+			return fmt.Errorf("FATAL: failure reading 'fetch' configuration, %v", err)
+		}
+	}
+	if err := fetchCfg.Validate(); err != nil {
+		return fmt.Errorf("FATAL: invalid 'fetch' configuration, %v", err)
+	}
+	if err := fetchCfg.HostPolicy.Validate(); err != nil {
+		return fmt.Errorf("FATAL: invalid 'fetch.host_policy' configuration, %v", err)
+	}
+	if err := fetchCfg.TLS.Validate(); err != nil {
+		return fmt.Errorf("FATAL: invalid 'fetch.tls' configuration, %v", err)
+	}
+	if err := fetchCfg.Transport.Validate(); err != nil {
+		return fmt.Errorf("FATAL: invalid 'fetch.transport' configuration, %v", err)
+	}
+	if err := fetchCfg.ParseCache.Validate(); err != nil {
+		return fmt.Errorf("FATAL: invalid 'fetch.parse_cache' configuration, %v", err)
+	}
+	// Synchronous feed refresh (streamed to client) is optional, enabled with 'itemPublish' configuration section.
+	// Items are published the same way as by worker, so the section is the same as in worker configuration
+	var feedRefresher server.FeedRefresher
+	if viper.IsSet("itemPublish") {
+		itemPublisherClientCfg := &processor.ItemPublishConfig{}
+		if err := viper.Sub("itemPublish").UnmarshalExact(itemPublisherClientCfg); err != nil {
+			return fmt.Errorf("FATAL: failure reading 'itemPublish' configuration, %v", err)
+		}
+		if err := itemPublisherClientCfg.Validate(); err != nil {
+			return fmt.Errorf("FATAL: invalid 'itemPublish' configuration, %v", err)
+		}
+		itemPublishers, err := itempublish.New(itemPublisherClientCfg, publishCfg, logger)
+		if err != nil {
+			return fmt.Errorf("FATAL: failure creating items publishers, %v", err)
+		}
+		defer itemPublishers.Stop()
+		// Webhook notifications are optional, enabled with 'webhook' configuration section
+		var webhookNotifier processor.WebhookNotifier
+		if viper.IsSet("webhook") {
+			webhookCfg := &webhook.Config{}
+			if err := viper.Sub("webhook").UnmarshalExact(webhookCfg); err != nil {
+				return fmt.Errorf("FATAL: failure reading 'webhook' configuration, %v", err)
+			}
+			if err := webhookCfg.Validate(); err != nil {
+				return fmt.Errorf("FATAL: invalid 'webhook' configuration, %v", err)
+			}
+			webhookNotifier = webhook.New(webhookCfg, hostpolicy.New(&fetchCfg.HostPolicy))
+		}
+		processingCfg := &processor.ProcessingConfig{}
+		if viper.IsSet("processing") {
+			if err := viper.Sub("processing").UnmarshalExact(processingCfg); err != nil {
+				return fmt.Errorf("FATAL: failure reading 'processing' configuration, %v", err)
+			}
+		}
+		if err := processingCfg.Validate(); err != nil {
+			return fmt.Errorf("FATAL: invalid 'processing' configuration, %v", err)
+		}
+		rssFeedsProcessor := processor.NewRSSFeedsProcessor(fetchCfg, processingCfg, db, rssFeedsUpdateProducer, itemPublishers.Item, webhookNotifier, nil, nil, logger, tracer)
+		if itemPublishers.Digest != nil {
+			rssFeedsProcessor.EnableItemsDigest(itemPublishers.Digest, itemPublisherClientCfg)
+		}
+		feedRefresher = rssFeedsProcessor
+	}
+	// Maintenance mode state is shared by instances via database, 'maintenance' configuration section is optional
+	maintenanceCfg := &maintenance.Config{}
+	if viper.IsSet("maintenance") {
+		if err := viper.Sub("maintenance").UnmarshalExact(maintenanceCfg); err != nil {
+			return fmt.Errorf("FATAL: failure reading 'maintenance' configuration, %v", err)
+		}
+	}
+	maintenanceMode := maintenance.New(maintenanceCfg, db, logger)
+	if err := maintenanceMode.Start(context.Background()); err != nil {
+		return fmt.Errorf("FATAL: failure reading maintenance mode state, %v", err)
+	}
+	handler := server.NewHandler(logger, tracer, db, rssFeedsUpdateProducer, feedRefresher, serverCfg.DefaultLanguageCode, hostpolicy.New(&fetchCfg.HostPolicy), maintenanceMode)
+	srv, err := server.New(serverCfg, logger, handler)
+	if err != nil {
+		return fmt.Errorf("FATAL: failure creating server, %v", err)
+	}
+	// Admin listener with profiling, dependency checks and maintenance mode toggle is optional, enabled with 'admin' configuration section
+	if viper.IsSet("admin") {
+		adminCfg := &admin.Config{}
+		if err := viper.Sub("admin").UnmarshalExact(adminCfg); err != nil {
+			return fmt.Errorf("FATAL: failure reading 'admin' configuration, %v", err)
+		}
+		checks := []admin.DependencyCheck{
+			{Name: "postgresql", Check: db.Ping},
+			{Name: "nsqd", Check: func(context.Context) error { return messageProducer.Ping() }},
+		}
+		if !tracingCfg.Disabled {
+			checks = append(checks, admin.DependencyCheck{Name: "tracing", Check: func(ctx context.Context) error {
+				return tracing.CheckReachability(ctx, tracingCfg)
+			}})
+		}
+		pattern, maintenanceToggle := handler.MaintenanceAdminRoute()
+		admin.Start(adminCfg, checks, logger, admin.Route{Pattern: pattern, Handler: maintenanceToggle})
+	}
 	return srv.StartAndServe()
 }
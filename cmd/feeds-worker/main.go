@@ -1,19 +1,30 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"os"
+	"time"
 
-	"github.com/Tarick/naca-items/pkg/itempublisher"
+	"github.com/Tarick/naca-rss-feeds/internal/admin"
+	"github.com/Tarick/naca-rss-feeds/internal/alerting"
 	"github.com/Tarick/naca-rss-feeds/internal/application/worker"
+	"github.com/Tarick/naca-rss-feeds/internal/circuitbreaker"
+	"github.com/Tarick/naca-rss-feeds/internal/entity"
+	"github.com/Tarick/naca-rss-feeds/internal/hostpolicy"
+	"github.com/Tarick/naca-rss-feeds/internal/itempublish"
 	"github.com/Tarick/naca-rss-feeds/internal/logger/zaplogger"
+	"github.com/Tarick/naca-rss-feeds/internal/maintenance"
 	"github.com/Tarick/naca-rss-feeds/internal/messaging/nsqclient/consumer"
 	"github.com/Tarick/naca-rss-feeds/internal/messaging/nsqclient/producer"
 	"github.com/Tarick/naca-rss-feeds/internal/processor"
 	"github.com/Tarick/naca-rss-feeds/internal/repository/postgresql"
 	"github.com/Tarick/naca-rss-feeds/internal/tracing"
 	"github.com/Tarick/naca-rss-feeds/internal/version"
+	"github.com/Tarick/naca-rss-feeds/internal/webhook"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -43,6 +54,7 @@ func main() {
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is ./config.yaml)")
 	rootCmd.AddCommand(versionCmd)
+	rootCmd.AddCommand(newRedriveCmd(&cfgFile))
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -50,8 +62,8 @@ func main() {
 	}
 }
 
-// We read config file and use dependency injection to create worker
-func startWorker(cfgFile string) error {
+// readConfig reads config file from the flag or from working directory
+func readConfig(cfgFile string) error {
 	if cfgFile != "" {
 		viper.SetConfigFile(cfgFile)
 	} else {
@@ -64,6 +76,38 @@ func startWorker(cfgFile string) error {
 		return fmt.Errorf("FATAL: error in config file %s, %v", viper.ConfigFileUsed(), err)
 	}
 	fmt.Println("Using config file:", viper.ConfigFileUsed())
+	return nil
+}
+
+// We read config file and use dependency injection to create worker
+// readPublishConfig reads and validates NSQ 'publish' configuration
+func readPublishConfig() (*producer.MessageProducerConfig, error) {
+	publishCfg := &producer.MessageProducerConfig{}
+	if err := viper.Sub("publish").UnmarshalExact(&publishCfg); err != nil {
+		return nil, fmt.Errorf("FATAL: failure reading NSQ 'publish' configuration, %v", err)
+	}
+	if err := publishCfg.Validate(); err != nil {
+		return nil, fmt.Errorf("FATAL: invalid NSQ 'publish' configuration, %v", err)
+	}
+	return publishCfg, nil
+}
+
+// readConsumeConfig reads and validates NSQ 'consume' configuration
+func readConsumeConfig() (*consumer.MessageConsumerConfig, error) {
+	consumeCfg := &consumer.MessageConsumerConfig{}
+	if err := viper.Sub("consume").UnmarshalExact(&consumeCfg); err != nil {
+		return nil, fmt.Errorf("FATAL: failure reading 'consume' configuration, %v", err)
+	}
+	if err := consumeCfg.Validate(); err != nil {
+		return nil, fmt.Errorf("FATAL: invalid 'consume' configuration, %v", err)
+	}
+	return consumeCfg, nil
+}
+
+func startWorker(cfgFile string) error {
+	if err := readConfig(cfgFile); err != nil {
+		return err
+	}
 	// Init logging
 	logCfg := &zaplogger.Config{}
 	if err := viper.UnmarshalKey("logging", logCfg); err != nil {
@@ -78,10 +122,27 @@ func startWorker(cfgFile string) error {
 		return fmt.Errorf("FATAL: Failure reading 'tracing' configuration, %v", err)
 	}
 	tracer, tracerCloser, err := tracing.New(tracingCfg, tracing.NewZapLogger(logger))
-	defer tracerCloser.Close()
 	if err != nil {
 		return fmt.Errorf("FATAL: Cannot init tracing, %v", err)
 	}
+	// Dependencies are closed in reverse order of their creation, so users are closed before what they use.
+	// Worker closes them after in-flight messages are drained, startup failure closes already created ones here.
+	var closers []worker.Closer
+	addCloser := func(name string, close func() error) {
+		closers = append([]worker.Closer{{Name: name, Close: close}}, closers...)
+	}
+	started := false
+	defer func() {
+		if started {
+			return
+		}
+		for _, closer := range closers {
+			if err := closer.Close(); err != nil {
+				logger.Error("Failure closing ", closer.Name, ": ", err)
+			}
+		}
+	}()
+	addCloser("tracer", tracerCloser.Close)
 
 	// Create db configuration
 	databaseViperConfig := viper.Sub("database")
@@ -89,48 +150,192 @@ func startWorker(cfgFile string) error {
 	if err := databaseViperConfig.UnmarshalExact(dbCfg); err != nil {
 		return fmt.Errorf("FATAL: failure reading 'database' configuration: %v", err)
 	}
+	if err := dbCfg.Validate(); err != nil {
+		return fmt.Errorf("FATAL: invalid 'database' configuration, %v", err)
+	}
 	// Open db
 	db, err := postgresql.New(dbCfg, postgresql.NewZapLogger(logger.Desugar()), tracer)
 	if err != nil {
 		return fmt.Errorf("FATAL: failure creating database connection, %v", err)
 	}
+	addCloser("database", func() error { db.Close(); return nil })
+	if err := db.CheckSchema(context.Background()); err != nil {
+		return fmt.Errorf("FATAL: database schema check failed, %v", err)
+	}
 
 	// Create NSQ producer
-	publishViperConfig := viper.Sub("publish")
-	publishCfg := &producer.MessageProducerConfig{}
-	if err := publishViperConfig.UnmarshalExact(&publishCfg); err != nil {
-		return fmt.Errorf("FATAL: failure reading NSQ 'publish' configuration, %v", err)
+	publishCfg, err := readPublishConfig()
+	if err != nil {
+		return err
 	}
-	messageProducer, err := producer.New(publishCfg)
+	messageProducer, err := producer.New(publishCfg, logger)
 	if err != nil {
 		return fmt.Errorf("FATAL: failure initialising NSQ producer, %v", err)
 	}
+	addCloser("NSQ producer", func() error { messageProducer.Stop(); return nil })
+	// Admin listener with profiling and dependency checks is optional, enabled with 'admin' configuration section
+	if viper.IsSet("admin") {
+		adminCfg := &admin.Config{}
+		if err := viper.Sub("admin").UnmarshalExact(adminCfg); err != nil {
+			return fmt.Errorf("FATAL: failure reading 'admin' configuration, %v", err)
+		}
+		checks := []admin.DependencyCheck{
+			{Name: "postgresql", Check: db.Ping},
+			{Name: "nsqd", Check: func(context.Context) error { return messageProducer.Ping() }},
+		}
+		if !tracingCfg.Disabled {
+			checks = append(checks, admin.DependencyCheck{Name: "tracing", Check: func(ctx context.Context) error {
+				return tracing.CheckReachability(ctx, tracingCfg)
+			}})
+		}
+		admin.Start(adminCfg, checks, logger)
+	}
 	rssFeedsUpdateProducer := processor.NewFeedsUpdateProducer(messageProducer, tracer)
 
-	consumeViperConfig := viper.Sub("consume")
-	consumeCfg := &consumer.MessageConsumerConfig{}
-	if err := consumeViperConfig.UnmarshalExact(&consumeCfg); err != nil {
-		return fmt.Errorf("FATAL: failure reading 'consume' configuration, %v", err)
-	}
-	itemPublisherClientViperConfig := viper.Sub("itemPublish")
-	// FIXME: rather unclear initialization of config
-	itemPublisherClientCfg := struct {
-		Host  string `mapstructure:"host"`
-		Topic string `mapstructure:"topic"`
-	}{}
-	if err := itemPublisherClientViperConfig.UnmarshalExact(&itemPublisherClientCfg); err != nil {
+	consumeCfg, err := readConsumeConfig()
+	if err != nil {
+		return err
+	}
+	if consumeCfg.Compression != publishCfg.Compression {
+		logger.Warn("NSQ compression of 'consume' (", consumeCfg.Compression.Compression, ") and 'publish' (", publishCfg.Compression.Compression, ") differ, messages are compressed only on part of the path")
+	}
+	itemPublisherClientCfg := &processor.ItemPublishConfig{}
+	if err := viper.Sub("itemPublish").UnmarshalExact(itemPublisherClientCfg); err != nil {
 		return fmt.Errorf("FATAL: failure reading 'itemPublish' configuration, %v", err)
 	}
-	itemPublisherClient, err := itempublisher.New(itemPublisherClientCfg.Host, itemPublisherClientCfg.Topic)
+	if err := itemPublisherClientCfg.Validate(); err != nil {
+		return fmt.Errorf("FATAL: invalid 'itemPublish' configuration, %v", err)
+	}
+	itemPublishers, err := itempublish.New(itemPublisherClientCfg, publishCfg, logger)
 	if err != nil {
-		return fmt.Errorf("FATAL: failure creating itemPublisher client, %v", err)
+		return fmt.Errorf("FATAL: failure creating items publishers, %v", err)
+	}
+	addCloser("NSQ producer of items digests", func() error { itemPublishers.Stop(); return nil })
+	// Prometheus metrics endpoint is optional, enabled with 'metrics' configuration section
+	if viper.IsSet("metrics") {
+		metricsCfg := struct {
+			Address string `mapstructure:"address"`
+		}{}
+		if err := viper.Sub("metrics").UnmarshalExact(&metricsCfg); err != nil {
+			return fmt.Errorf("FATAL: failure reading 'metrics' configuration, %v", err)
+		}
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", promhttp.Handler())
+			if err := http.ListenAndServe(metricsCfg.Address, mux); err != nil {
+				logger.Error("Metrics endpoint failure: ", err)
+			}
+		}()
+	}
+	// Chat alerting about failing feeds is optional, enabled with 'alerting' configuration section
+	var failureAlerter processor.FeedFailureAlerter
+	if viper.IsSet("alerting") {
+		alertingCfg := &alerting.Config{}
+		if err := viper.Sub("alerting").UnmarshalExact(alertingCfg); err != nil {
+			return fmt.Errorf("FATAL: failure reading 'alerting' configuration, %v", err)
+		}
+		chatAlerter, err := alerting.New(alertingCfg)
+		if err != nil {
+			return fmt.Errorf("FATAL: failure creating alerter, %v", err)
+		}
+		failureAlerter = chatAlerter
+	}
+	fetchCfg := &processor.FetchConfig{}
+	if err := viper.Sub("fetch").UnmarshalExact(fetchCfg); err != nil {
+		return fmt.Errorf("FATAL: failure reading 'fetch' configuration, %v", err)
+	}
+	if err := fetchCfg.Validate(); err != nil {
+		return fmt.Errorf("FATAL: invalid 'fetch' configuration, %v", err)
+	}
+	if err := fetchCfg.HostPolicy.Validate(); err != nil {
+		return fmt.Errorf("FATAL: invalid 'fetch.host_policy' configuration, %v", err)
+	}
+	if err := fetchCfg.TLS.Validate(); err != nil {
+		return fmt.Errorf("FATAL: invalid 'fetch.tls' configuration, %v", err)
+	}
+	if err := fetchCfg.Transport.Validate(); err != nil {
+		return fmt.Errorf("FATAL: invalid 'fetch.transport' configuration, %v", err)
+	}
+	if err := fetchCfg.ParseCache.Validate(); err != nil {
+		return fmt.Errorf("FATAL: invalid 'fetch.parse_cache' configuration, %v", err)
+	}
+	// Webhook notifications are optional, enabled with 'webhook' configuration section.
+	// Webhook urls are restricted by the same host policy as feeds
+	var webhookNotifier processor.WebhookNotifier
+	if viper.IsSet("webhook") {
+		webhookCfg := &webhook.Config{}
+		if err := viper.Sub("webhook").UnmarshalExact(webhookCfg); err != nil {
+			return fmt.Errorf("FATAL: failure reading 'webhook' configuration, %v", err)
+		}
+		if err := webhookCfg.Validate(); err != nil {
+			return fmt.Errorf("FATAL: invalid 'webhook' configuration, %v", err)
+		}
+		webhookNotifier = webhook.New(webhookCfg, hostpolicy.New(&fetchCfg.HostPolicy))
+	}
+	processingCfg := &processor.ProcessingConfig{}
+	if err := viper.Sub("processing").UnmarshalExact(processingCfg); err != nil {
+		return fmt.Errorf("FATAL: failure reading 'processing' configuration, %v", err)
+	}
+	if err := processingCfg.Validate(); err != nil {
+		return fmt.Errorf("FATAL: invalid 'processing' configuration, %v", err)
+	}
+	var hostBreakers processor.HostCircuitBreakers
+	if fetchCfg.HostCircuitBreaker.FailureThreshold > 0 {
+		hostBreakers = circuitbreaker.NewRegistry(&fetchCfg.HostCircuitBreaker)
 	}
 	// Construct consumer with message handler
-	rssFeedsProcessor := processor.NewRSSFeedsProcessor(db, rssFeedsUpdateProducer, itemPublisherClient, logger, tracer)
+	rssFeedsProcessor := processor.NewRSSFeedsProcessor(fetchCfg, processingCfg, db, rssFeedsUpdateProducer, itemPublishers.Item, webhookNotifier, failureAlerter, hostBreakers, logger, tracer)
+	if itemPublishers.Digest != nil {
+		rssFeedsProcessor.EnableItemsDigest(itemPublishers.Digest, itemPublisherClientCfg)
+	}
 	consumer, err := consumer.New(consumeCfg, rssFeedsProcessor, logger)
 	if err != nil {
 		return fmt.Errorf("FATAL: consumer creation failed, %v", err)
 	}
-	wrkr := worker.New(consumer, logger)
+	// Maintenance mode state is shared by instances via database, 'maintenance' configuration section is optional
+	maintenanceCfg := &maintenance.Config{}
+	if viper.IsSet("maintenance") {
+		if err := viper.Sub("maintenance").UnmarshalExact(maintenanceCfg); err != nil {
+			return fmt.Errorf("FATAL: failure reading 'maintenance' configuration, %v", err)
+		}
+	}
+	maintenanceMode := maintenance.New(maintenanceCfg, db, logger)
+	// Paused consumer leaves refresh messages queued in nsqd until maintenance is over
+	maintenanceMode.OnChange(func(state entity.Maintenance) {
+		if state.Enabled {
+			consumer.Pause()
+		} else {
+			consumer.Resume()
+		}
+	})
+	maintenanceCtx, stopMaintenance := context.WithCancel(context.Background())
+	addCloser("maintenance mode polling", func() error { stopMaintenance(); return nil })
+	if err := maintenanceMode.Start(maintenanceCtx); err != nil {
+		return fmt.Errorf("FATAL: failure reading maintenance mode state, %v", err)
+	}
+	// Health endpoints for Kubernetes probes are optional, enabled with 'health' configuration section
+	if viper.IsSet("health") {
+		healthCfg := &worker.HealthConfig{}
+		if err := viper.Sub("health").UnmarshalExact(healthCfg); err != nil {
+			return fmt.Errorf("FATAL: failure reading 'health' configuration, %v", err)
+		}
+		if err := healthCfg.Validate(); err != nil {
+			return fmt.Errorf("FATAL: invalid 'health' configuration, %v", err)
+		}
+		healthServer := worker.NewHealthServer(healthCfg, consumer, db, logger)
+		addCloser("health server", func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			return healthServer.Shutdown(ctx)
+		})
+		go func() {
+			if err := healthServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("Health endpoint failure: ", err)
+			}
+		}()
+	}
+	// Worker owns closing of dependencies from now on
+	started = true
+	wrkr := worker.New(consumer, closers, logger)
 	return wrkr.Start()
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Tarick/naca-rss-feeds/internal/logger/zaplogger"
+	"github.com/Tarick/naca-rss-feeds/internal/messaging/nsqclient/redrive"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// newRedriveCmd creates command to move messages from dead-letter topic back to the main topic
+func newRedriveCmd(cfgFile *string) *cobra.Command {
+	redriveCfg := &redrive.Config{}
+	cmd := &cobra.Command{
+		Use:   "redrive",
+		Short: "Republish dead-lettered messages to the main topic",
+		Long:  `Consumes messages from dead-letter topic and republishes them to the main topic to be processed again`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return startRedrive(*cfgFile, redriveCfg)
+		},
+	}
+	cmd.Flags().StringVar(&redriveCfg.FromTopic, "from-topic", "", "dead-letter topic to consume messages from")
+	cmd.Flags().StringVar(&redriveCfg.ToTopic, "to-topic", "", "topic to republish messages to (default is 'publish.topic' from config)")
+	cmd.Flags().StringVar(&redriveCfg.Channel, "channel", "redrive", "channel to consume dead-letter topic with")
+	cmd.Flags().IntVar(&redriveCfg.Limit, "limit", 0, "maximum number of messages to re-drive, 0 means all")
+	cmd.Flags().BoolVar(&redriveCfg.DryRun, "dry-run", false, "only log messages, leaving them in dead-letter topic")
+	cmd.Flags().DurationVar(&redriveCfg.IdleTimeout, "idle-timeout", 10*time.Second, "stop when no messages arrive for this time")
+	cmd.MarkFlagRequired("from-topic")
+	return cmd
+}
+
+// startRedrive uses consume and publish NSQ configuration to connect to dead-letter and main topics
+func startRedrive(cfgFile string, redriveCfg *redrive.Config) error {
+	if err := readConfig(cfgFile); err != nil {
+		return err
+	}
+	logCfg := &zaplogger.Config{}
+	if err := viper.UnmarshalKey("logging", logCfg); err != nil {
+		return fmt.Errorf("FATAL: Failure reading 'logging' configuration, %v", err)
+	}
+	logger := zaplogger.New(logCfg).Sugar()
+	defer logger.Sync()
+
+	consumeCfg, err := readConsumeConfig()
+	if err != nil {
+		return err
+	}
+	publishCfg, err := readPublishConfig()
+	if err != nil {
+		return err
+	}
+	redriveCfg.NSQLookup = consumeCfg.NSQLookup
+	redriveCfg.NSQD = consumeCfg.NSQD
+	redriveCfg.Host = publishCfg.Host
+	redriveCfg.ConsumeSecurity = consumeCfg.Security
+	redriveCfg.PublishSecurity = publishCfg.Security
+	if redriveCfg.ToTopic == "" {
+		redriveCfg.ToTopic = publishCfg.Topic
+	}
+	logger.Info("Re-driving messages from ", redriveCfg.FromTopic, " to ", redriveCfg.ToTopic, ", dry run: ", redriveCfg.DryRun)
+	count, err := redrive.Run(redriveCfg, logger)
+	if err != nil {
+		return fmt.Errorf("FATAL: re-drive failed after %d messages, %v", count, err)
+	}
+	logger.Info("Re-driven ", count, " messages")
+	return nil
+}
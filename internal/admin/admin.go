@@ -0,0 +1,132 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"sync"
+	"time"
+)
+
+// depsCheckTimeout bounds each dependency check
+const depsCheckTimeout = 5 * time.Second
+
+// Config defines admin listener configuration
+type Config struct {
+	// Address to bind admin listener to, keep it private (e.g. localhost:6060)
+	Address string `mapstructure:"address"`
+	// Pprof enables /debug/pprof profiling handlers
+	Pprof bool `mapstructure:"pprof"`
+	// Deps enables /debug/deps, which actively checks dependencies, e.g. for deploy smoke tests
+	Deps bool `mapstructure:"deps"`
+	// Token is required as "Authorization: Bearer <token>" by admin handlers, empty relies on private address only
+	Token string `mapstructure:"token"`
+}
+
+type Logger interface {
+	Info(args ...interface{})
+	Warn(args ...interface{})
+	Error(args ...interface{})
+}
+
+// DependencyCheck actively checks single dependency, e.g. pings database
+type DependencyCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// Route is additional admin handler of the service, e.g. operational toggle not exposed on the main serving mux
+type Route struct {
+	Pattern string
+	Handler http.Handler
+}
+
+// DependencyStatus is the result of dependency check
+type DependencyStatus struct {
+	Name      string  `json:"name"`
+	OK        bool    `json:"ok"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Start serves admin handlers and routes on separate listener in background, so they are never exposed on the main serving mux
+func Start(config *Config, checks []DependencyCheck, logger Logger, routes ...Route) {
+	mux := http.NewServeMux()
+	if config.Pprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	if config.Deps {
+		mux.Handle("/debug/deps", depsHandler(checks))
+	}
+	for _, route := range routes {
+		mux.Handle(route.Pattern, route.Handler)
+	}
+	var handler http.Handler = mux
+	if config.Token != "" {
+		handler = requireToken(config.Token, mux)
+	} else if config.Deps || len(routes) > 0 {
+		logger.Warn("Admin listener token is not set, /debug/deps and service routes are protected only by listener address ", config.Address)
+	}
+	go func() {
+		logger.Info("Starting admin listener on ", config.Address)
+		if err := http.ListenAndServe(config.Address, handler); err != nil {
+			logger.Error("Admin listener failure: ", err)
+		}
+	}()
+}
+
+// requireToken rejects requests without bearer token
+func requireToken(token string, next http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// depsHandler runs dependency checks concurrently and reports their status and latency.
+// Response status is 503 if any dependency fails, so smoke tests can rely on it
+func depsHandler(checks []DependencyCheck) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		statuses := make([]DependencyStatus, len(checks))
+		var wg sync.WaitGroup
+		for i, check := range checks {
+			wg.Add(1)
+			go func(i int, check DependencyCheck) {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(r.Context(), depsCheckTimeout)
+				defer cancel()
+				started := time.Now()
+				err := check.Check(ctx)
+				statuses[i] = DependencyStatus{
+					Name:      check.Name,
+					OK:        err == nil,
+					LatencyMs: float64(time.Since(started).Microseconds()) / 1000,
+				}
+				if err != nil {
+					statuses[i].Error = err.Error()
+				}
+			}(i, check)
+		}
+		wg.Wait()
+		status := http.StatusOK
+		for _, s := range statuses {
+			if !s.OK {
+				status = http.StatusServiceUnavailable
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(statuses)
+	})
+}
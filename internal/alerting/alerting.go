@@ -0,0 +1,105 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Tarick/naca-rss-feeds/internal/entity"
+)
+
+// Config defines chat alerting configuration
+type Config struct {
+	// Kind of chat webhook, "slack" or "discord"
+	Kind string `mapstructure:"kind"`
+	// WebhookURL is incoming webhook of the chat channel
+	WebhookURL string `mapstructure:"webhook_url"`
+	// FailureThreshold is the number of consecutive feed failures to alert on
+	FailureThreshold int `mapstructure:"failure_threshold"`
+	// Timeout of webhook call, in seconds
+	Timeout int `mapstructure:"timeout"`
+}
+
+type chatAlerter struct {
+	kind             string
+	webhookURL       string
+	failureThreshold int
+	httpClient       *http.Client
+}
+
+// New creates alerter, which posts feed failure and recovery messages to Slack or Discord webhook
+func New(config *Config) (*chatAlerter, error) {
+	if config.Kind != "slack" && config.Kind != "discord" {
+		return nil, fmt.Errorf("unsupported alerting kind '%s', must be 'slack' or 'discord'", config.Kind)
+	}
+	if config.WebhookURL == "" {
+		return nil, fmt.Errorf("alerting webhook_url is required")
+	}
+	if config.FailureThreshold < 1 {
+		return nil, fmt.Errorf("alerting failure_threshold must be positive")
+	}
+	return &chatAlerter{
+		kind:             config.Kind,
+		webhookURL:       config.WebhookURL,
+		failureThreshold: config.FailureThreshold,
+		httpClient: &http.Client{
+			Timeout: time.Duration(config.Timeout) * time.Second,
+		},
+	}, nil
+}
+
+// FeedFailed alerts once, when feed consecutive failures cross the threshold, so chat is not spammed on every poll
+func (a *chatAlerter) FeedFailed(ctx context.Context, feed *entity.Feed, failures int, err error) error {
+	if failures != a.failureThreshold {
+		return nil
+	}
+	return a.post(ctx, fmt.Sprintf(":rotating_light: Feed %s (id %s, publication %s) failed %d times in a row, last error: %v", feed.URL, feed.ID, feed.PublicationUUID, failures, err))
+}
+
+// FeedRecovered alerts about recovery only for feeds, which were alerted as failing before
+func (a *chatAlerter) FeedRecovered(ctx context.Context, feed *entity.Feed, previousFailures int) error {
+	if previousFailures < a.failureThreshold {
+		return nil
+	}
+	return a.post(ctx, fmt.Sprintf(":white_check_mark: Feed %s (id %s, publication %s) recovered after %d failures", feed.URL, feed.ID, feed.PublicationUUID, previousFailures))
+}
+
+// FeedDisabled alerts about feed disabled after sustained failures
+func (a *chatAlerter) FeedDisabled(ctx context.Context, feed *entity.Feed, failures int) error {
+	return a.post(ctx, fmt.Sprintf(":no_entry: Feed %s (id %s, publication %s) disabled after %d failures in a row, re-enable it via API after fixing", feed.URL, feed.ID, feed.PublicationUUID, failures))
+}
+
+// FeedQuarantined alerts about feed quarantined for manual review
+func (a *chatAlerter) FeedQuarantined(ctx context.Context, feed *entity.Feed) error {
+	return a.post(ctx, fmt.Sprintf(":warning: Feed %s (id %s, publication %s) quarantined (%s), review its output and release it via API", feed.URL, feed.ID, feed.PublicationUUID, feed.QuarantineReason))
+}
+
+func (a *chatAlerter) post(ctx context.Context, text string) error {
+	// Slack and Discord incoming webhooks differ only in message field name
+	payload := map[string]string{"text": text}
+	if a.kind == "discord" {
+		payload = map[string]string{"content": text}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, a.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s webhook returned status %s", a.kind, resp.Status)
+	}
+	return nil
+}
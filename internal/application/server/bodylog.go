@@ -0,0 +1,89 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"regexp"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+// defaultBodyLogMaxSize caps logged body when size is not configured
+const defaultBodyLogMaxSize = 4096
+
+// redactedFields matches JSON string values of fields, which could carry credentials.
+// Regexp instead of JSON decoding, since malformed bodies are the point of logging them.
+var redactedFields = regexp.MustCompile(`(?i)("[^"]*(password|secret|token|authorization|auth|cookie|api_?key)[^"]*"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+
+// BodyLogConfig enables request and response bodies logging of mutating endpoints.
+// Debug only: bodies may contain personal data, never enable it in production.
+type BodyLogConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// MaxSize in bytes of logged body, the rest is truncated. 4096 if not set
+	MaxSize int `mapstructure:"max_size"`
+}
+
+// cappedBuffer keeps the first max bytes written to it and discards the rest, never failing the writer
+type cappedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if free := b.max - b.Len(); free < len(p) {
+		b.truncated = true
+		if free > 0 {
+			b.Buffer.Write(p[:free])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+func (b *cappedBuffer) String() string {
+	body := redactedFields.ReplaceAllString(b.Buffer.String(), `$1"[REDACTED]"`)
+	if b.truncated {
+		body += "...(truncated)"
+	}
+	return body
+}
+
+// teeReadCloser reads request body through tee, closing the original body
+type teeReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// middlewareBodyLogger logs request and response bodies of POST, PUT, PATCH and DELETE requests at Debug level.
+// Bodies are teed while handler reads and writes them, so handler behaviour doesn't change.
+func middlewareBodyLogger(logger Logger, config BodyLogConfig) func(next http.Handler) http.Handler {
+	maxSize := config.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultBodyLogMaxSize
+	}
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+			default:
+				next.ServeHTTP(w, r)
+				return
+			}
+			requestBody := &cappedBuffer{max: maxSize}
+			if r.Body != nil {
+				r.Body = teeReadCloser{io.TeeReader(r.Body, requestBody), r.Body}
+			}
+			responseBody := &cappedBuffer{max: maxSize}
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			ww.Tee(responseBody)
+			defer func() {
+				logger.Debug("Request ", middleware.GetReqID(r.Context()), " ", r.Method, " ", r.URL.Path,
+					" body: ", requestBody.String(), ", response ", ww.Status(), " body: ", responseBody.String())
+			}()
+			next.ServeHTTP(ww, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
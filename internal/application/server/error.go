@@ -61,6 +61,50 @@ func ErrInternal(err error) *ErrResponse {
 	}
 }
 
+// ErrNotImplemented returns failure for functionality, which is not configured
+func ErrNotImplemented(err error) *ErrResponse {
+	return &ErrResponse{
+		HTTPStatusCode: http.StatusNotImplemented,
+		Body: ErrResponseBody{
+			StatusText: "Not implemented.",
+			ErrorText:  err.Error(),
+		},
+	}
+}
+
+// ErrUnprocessable returns failure for well-formed request, which can't be processed
+func ErrUnprocessable(err error) *ErrResponse {
+	return &ErrResponse{
+		HTTPStatusCode: http.StatusUnprocessableEntity,
+		Body: ErrResponseBody{
+			StatusText: "Unprocessable request.",
+			ErrorText:  err.Error(),
+		},
+	}
+}
+
+// ErrConflict returns failure for request conflicting with the current state
+func ErrConflict(err error) *ErrResponse {
+	return &ErrResponse{
+		HTTPStatusCode: http.StatusConflict,
+		Body: ErrResponseBody{
+			StatusText: "Conflict.",
+			ErrorText:  err.Error(),
+		},
+	}
+}
+
+// ErrServiceUnavailable returns failure for request, which can't be served temporarily, e.g. in maintenance mode
+func ErrServiceUnavailable(err error) *ErrResponse {
+	return &ErrResponse{
+		HTTPStatusCode: http.StatusServiceUnavailable,
+		Body: ErrResponseBody{
+			StatusText: "Service unavailable.",
+			ErrorText:  err.Error(),
+		},
+	}
+}
+
 // ErrNotFound is 404
 var ErrNotFound = &ErrResponse{
 	HTTPStatusCode: http.StatusNotFound,
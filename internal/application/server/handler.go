@@ -2,21 +2,28 @@ package server
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Tarick/naca-rss-feeds/internal/entity"
+	"github.com/Tarick/naca-rss-feeds/internal/maintenance"
+	"github.com/Tarick/naca-rss-feeds/internal/processor"
 	"github.com/asaskevich/govalidator"
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/go-ozzo/ozzo-validation/v4/is"
 	opentracing "github.com/opentracing/opentracing-go"
-	"github.com/opentracing/opentracing-go/ext"
 	otLog "github.com/opentracing/opentracing-go/log"
 
 	"github.com/gofrs/uuid"
 
 	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/render"
 )
 
@@ -25,32 +32,81 @@ type Handler struct {
 	logger     Logger
 	repository FeedsRepository
 	producer   RSSFeedsUpdateProducer
+	refresher  FeedRefresher
 	tracer     opentracing.Tracer
+	// defaultLanguageCode is set for created feeds without language code
+	defaultLanguageCode string
+	feedURLChecker      FeedURLChecker
+	maintenance         MaintenanceMode
+	// refreshSpread and refreshAllCheckpointTTL configure refresh of all feeds, see Config
+	refreshSpread           time.Duration
+	refreshAllCheckpointTTL time.Duration
+}
+
+// MaintenanceMode is runtime togglable flag, which rejects feed changes and refreshes while reads keep working
+type MaintenanceMode interface {
+	Get() entity.Maintenance
+	Set(ctx context.Context, enabled bool, reason string) (entity.Maintenance, error)
+}
+
+// FeedURLChecker checks if feed url is allowed to be fetched
+type FeedURLChecker interface {
+	CheckURL(ctx context.Context, url string) error
+}
+
+// FeedRefresher refreshes feed synchronously, reporting progress
+type FeedRefresher interface {
+	RefreshFeed(ctx context.Context, feedID uuid.UUID, force bool, progress processor.RefreshProgressFunc) error
+	RepublishFeed(ctx context.Context, feedID uuid.UUID, since time.Time, progress processor.RefreshProgressFunc) error
 }
 
 // RSSFeedsUpdateProducer provides methods to call update (refresh news from) RSS Feed via messaging subsystem
 type RSSFeedsUpdateProducer interface {
-	SendUpdateOne(context.Context, uuid.UUID) error
+	SendUpdateOne(ctx context.Context, feedID uuid.UUID, force bool) error
+	SendUpdateOneDeferred(ctx context.Context, feedID uuid.UUID, force bool, delay time.Duration) error
 	SendUpdateAll(context.Context) error
 }
 
 // FeedsRepository defines repository methods used to manage feeds
 type FeedsRepository interface {
-	Create(context.Context, *entity.Feed) error
-	Update(context.Context, *entity.Feed) error
-	Delete(context.Context, uuid.UUID) error
+	processor.RefreshAllCheckpointStore
+	CreateWithAudit(context.Context, *entity.Feed, *entity.AuditRecord) error
+	UpdateWithAudit(context.Context, *entity.Feed, *entity.AuditRecord) error
+	DeleteWithAudit(context.Context, uuid.UUID, *entity.AuditRecord) error
+	GetAuditRecords(ctx context.Context, publicationUUID uuid.UUID, feedID uuid.UUID, limit int, offset int) ([]entity.AuditRecord, error)
 	GetAll(context.Context) ([]entity.Feed, error)
-	GetByPublicationUUID(context.Context, uuid.UUID) (*entity.Feed, error)
+	GetByID(context.Context, uuid.UUID) (*entity.Feed, error)
+	GetPage(ctx context.Context, limit int, offset int) ([]entity.Feed, int64, error)
+	GetByPublicationUUID(context.Context, uuid.UUID) ([]entity.Feed, error)
+	GetStaleFeeds(context.Context, time.Time) ([]entity.Feed, error)
+	GetFailingFeeds(ctx context.Context, minFailures int, limit int, offset int) ([]entity.Feed, error)
+	GetSlowestFeeds(ctx context.Context, limit int) ([]entity.Feed, error)
+	GetQuarantinedFeeds(context.Context) ([]entity.Feed, error)
+	ClearFeedQuarantine(context.Context, uuid.UUID) error
+	CreateProcessedItem(context.Context, *entity.ProcessedItem) error
+	GetItemSnapshots(ctx context.Context, feedID uuid.UUID, since time.Time) ([]entity.ItemSnapshot, error)
+	GetFeedStats(ctx context.Context, feedID uuid.UUID) (*entity.FeedStats, error)
+	GetFeedsRefreshStatus(ctx context.Context, since time.Time) ([]entity.FeedRefreshStatus, error)
+	GetByPublicationUUIDs(context.Context, []uuid.UUID) ([]entity.Feed, error)
+	GetPublicationUUIDsByURLs(ctx context.Context, urls []string) (map[string]uuid.UUID, error)
 	Healthcheck(context.Context) error
 }
 
 // NewHandler creates http handler
-func NewHandler(logger Logger, tracer opentracing.Tracer, feedRepository FeedsRepository, messageProducer RSSFeedsUpdateProducer) *Handler {
+// feedRefresher is optional, nil disables synchronous feed refresh endpoints
+// defaultLanguageCode is optional, empty leaves language code of created feeds to detection from the feed
+// feedURLChecker is optional, nil accepts any feed url
+// maintenanceMode is optional, nil disables maintenance mode
+func NewHandler(logger Logger, tracer opentracing.Tracer, feedRepository FeedsRepository, messageProducer RSSFeedsUpdateProducer, feedRefresher FeedRefresher, defaultLanguageCode string, feedURLChecker FeedURLChecker, maintenanceMode MaintenanceMode) *Handler {
 	return &Handler{
-		logger:     logger,
-		repository: feedRepository,
-		producer:   messageProducer,
-		tracer:     tracer,
+		logger:              logger,
+		repository:          feedRepository,
+		producer:            messageProducer,
+		refresher:           feedRefresher,
+		tracer:              tracer,
+		defaultLanguageCode: defaultLanguageCode,
+		feedURLChecker:      feedURLChecker,
+		maintenance:         maintenanceMode,
 	}
 }
 
@@ -62,9 +118,51 @@ type FeedResponse struct {
 }
 
 // FeedResponseBody is returned on successfull operations to get, create or delete feed.
+// It is mapped from entity.Feed field by field, so fields added to the entity are not exposed until they are added here.
 type FeedResponseBody struct {
-	// swagger:allOf
-	*entity.Feed
+	// ID of the feed, used in feed routes
+	ID uuid.UUID `json:"id"`
+	// PublicationUUID that owns this feed
+	PublicationUUID uuid.UUID `json:"publication_uuid"`
+	URL             string    `json:"url"`
+	LanguageCode    string    `json:"language_code"`
+	// WebhookURL is optional endpoint to notify about new items in the feed
+	WebhookURL string `json:"webhook_url,omitempty"`
+	// Headers are extra HTTP headers sent with every fetch of this feed, with values redacted. Only returned for single feed.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Login is feed login request with form fields values redacted
+	Login *FeedLoginResponseBody `json:"login,omitempty"`
+	// Filter selects items to publish, all items are published if not set
+	Filter *entity.FeedFilter `json:"filter,omitempty"`
+	// MaxItemsPerRefresh overrides worker wide cap of new items published per feed refresh
+	MaxItemsPerRefresh *int `json:"max_items_per_refresh,omitempty"`
+	// FetchTimeout in seconds overrides worker wide fetch timeout of this feed
+	FetchTimeout *int `json:"fetch_timeout,omitempty"`
+	// LastItemPublished is the most recent publication date of the items seen in this feed
+	LastItemPublished *time.Time `json:"last_item_published,omitempty"`
+	// ConsecutiveFailures is the number of feed refreshes failed in a row
+	ConsecutiveFailures int `json:"consecutive_failures"`
+	// LastError is the error of the last failed refresh
+	LastError string `json:"last_error,omitempty"`
+	// LastFetched is the time of the last feed fetch attempt
+	LastFetched *time.Time `json:"last_fetched,omitempty"`
+	// LastFetchDurationMs is fetch and parse time of the last successful refresh, in milliseconds
+	LastFetchDurationMs *int `json:"last_fetch_duration_ms,omitempty"`
+	// Enabled feeds are refreshed, disabled are skipped
+	Enabled bool `json:"enabled"`
+	// DisabledReason tells who disabled the feed
+	DisabledReason string `json:"disabled_reason,omitempty"`
+	// QuarantineReason is set for feeds quarantined for manual review
+	QuarantineReason string `json:"quarantine_reason,omitempty"`
+	// QuarantinedAt is the time feed was quarantined
+	QuarantinedAt *time.Time `json:"quarantined_at,omitempty"`
+}
+
+// FeedLoginResponseBody is feed login request with form fields values redacted
+type FeedLoginResponseBody struct {
+	URL    string            `json:"url"`
+	Method string            `json:"method,omitempty"`
+	Fields map[string]string `json:"fields,omitempty"`
 }
 
 // Render converts FeedResponseBody to json and sends it to client
@@ -74,41 +172,74 @@ func (fp *FeedResponse) Render(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, fp.Body)
 }
 
-// NewFeedResponse creates new response struct body for feed
+// NewFeedResponse creates new response struct body for feed, custom headers and login form fields values are redacted
 func NewFeedResponse(f *entity.Feed) *FeedResponse {
-	return &FeedResponse{Body: FeedResponseBody{
-		Feed: f,
-	}}
+	body := FeedResponseBody{
+		ID:                  f.ID,
+		PublicationUUID:     f.PublicationUUID,
+		URL:                 f.URL,
+		LanguageCode:        f.LanguageCode,
+		WebhookURL:          f.WebhookURL,
+		Headers:             f.Headers,
+		Filter:              f.Filter,
+		MaxItemsPerRefresh:  f.MaxItemsPerRefresh,
+		FetchTimeout:        f.FetchTimeout,
+		LastItemPublished:   f.LastItemPublished,
+		ConsecutiveFailures: f.ConsecutiveFailures,
+		LastError:           f.LastError,
+		LastFetched:         f.LastFetched,
+		LastFetchDurationMs: f.LastFetchDurationMs,
+		Enabled:             f.Enabled,
+		DisabledReason:      f.DisabledReason,
+		QuarantineReason:    f.QuarantineReason,
+		QuarantinedAt:       f.QuarantinedAt,
+	}
+	if f.Headers != nil {
+		body.Headers = make(map[string]string, len(f.Headers))
+		for name := range f.Headers {
+			body.Headers[name] = redactedValue
+		}
+	}
+	if f.Login != nil {
+		body.Login = &FeedLoginResponseBody{
+			URL:    f.Login.URL,
+			Method: f.Login.Method,
+			Fields: make(map[string]string, len(f.Login.Fields)),
+		}
+		for name := range f.Login.Fields {
+			body.Login.Fields[name] = redactedValue
+		}
+	}
+	return &FeedResponse{Body: body}
 }
 
+// redactedValue replaces secrets in responses
+const redactedValue = "[REDACTED]"
+
 // Used as middleware to load an feed object from the URL parameters passed through as the request.
 // If not found - 404
 func (h *Handler) feedCtx(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		span, ctx := h.setupTracingSpan(r, "retrieve-feed-middleware")
-		defer span.Finish()
+		span, ctx := requestSpan(r)
 		var err error
 
-		feedPublicationUUIDParam := chi.URLParam(r, "publication_uuid")
-		feedPublicationUUID, err := uuid.FromString(feedPublicationUUIDParam)
+		feedIDParam := chi.URLParam(r, "feed_id")
+		feedID, err := uuid.FromString(feedIDParam)
 		if err != nil {
-			ext.HTTPStatusCode.Set(span, http.StatusBadRequest)
 			span.LogFields(
 				otLog.Error(err),
 			)
 			ErrInvalidRequest(fmt.Errorf("Wrong UUID format: %v", err)).Render(w, r)
 			return
 		}
-		span.SetTag("feed.PublicationUUID", feedPublicationUUID.String())
-		dbFeed, err := h.repository.GetByPublicationUUID(ctx, feedPublicationUUID)
+		span.SetTag("feed.ID", feedID.String())
+		dbFeed, err := h.repository.GetByID(ctx, feedID)
 		if err != nil {
-			ext.HTTPStatusCode.Set(span, http.StatusInternalServerError)
 			ErrInternal(err).Render(w, r)
 			return
 		}
 		// empty result
 		if dbFeed == nil {
-			ext.HTTPStatusCode.Set(span, http.StatusNotFound)
 			ErrNotFound.Render(w, r)
 			return
 		}
@@ -128,6 +259,8 @@ type FeedRequest struct {
 type FeedRequestBody struct {
 	// swagger:allOf
 	*entity.Feed
+	// Enabled enables or disables feed on update, omitted keeps it unchanged. Feeds are created enabled.
+	Enabled *bool `json:"enabled,omitempty"`
 }
 
 var isLanguageCode = validation.NewStringRuleWithError(
@@ -139,10 +272,118 @@ func (b FeedRequestBody) Validate() error {
 	return validation.ValidateStruct(&b,
 		validation.Field(&b.PublicationUUID, validation.Required, is.UUID, validation.By(checkUUIDNotNil)),
 		validation.Field(&b.URL, validation.Required, validation.Length(5, 100), is.URL),
-		validation.Field(&b.LanguageCode, validation.Required, validation.Length(2, 2), isLanguageCode),
+		// Language code is optional - if omitted, server default is used on create, or it is detected from the feed on refresh
+		validation.Field(&b.LanguageCode, validation.Length(2, 2), isLanguageCode),
+		validation.Field(&b.WebhookURL, validation.Length(5, 255), is.URL),
+		validation.Field(&b.MaxItemsPerRefresh, validation.Min(1)),
+		validation.Field(&b.FetchTimeout, validation.Min(minFeedFetchTimeout), validation.Max(maxFeedFetchTimeout)),
+		validation.Field(&b.Headers, validation.Length(0, maxFeedHeaders), validation.By(checkFeedHeaders)),
+		validation.Field(&b.Login, validation.By(checkFeedLogin)),
+		validation.Field(&b.Filter, validation.By(checkFeedFilter)),
+	)
+}
+
+// Feed fetch timeout override bounds, in seconds
+const (
+	minFeedFetchTimeout = 1
+	maxFeedFetchTimeout = 300
+)
+
+// maxFeedFilterRules limits number of include and exclude rules of feed filter, each
+const maxFeedFilterRules = 20
+
+// validation helper to check feed filter rules
+func checkFeedFilter(value interface{}) error {
+	filter, _ := value.(*entity.FeedFilter)
+	if filter == nil {
+		return nil
+	}
+	return validation.ValidateStruct(filter,
+		validation.Field(&filter.Include, validation.Length(0, maxFeedFilterRules), validation.Each(validation.By(checkFeedFilterRule))),
+		validation.Field(&filter.Exclude, validation.Length(0, maxFeedFilterRules), validation.Each(validation.By(checkFeedFilterRule))),
+	)
+}
+
+// validation helper to check single feed filter rule, patterns must compile as regular expressions for title and content
+func checkFeedFilterRule(value interface{}) error {
+	rule, _ := value.(entity.FeedFilterRule)
+	err := validation.ValidateStruct(&rule,
+		validation.Field(&rule.Field, validation.Required, validation.In(entity.FeedFilterFieldTitle, entity.FeedFilterFieldContent, entity.FeedFilterFieldCategory)),
+		validation.Field(&rule.Pattern, validation.Required, validation.Length(1, 255)),
+	)
+	if err != nil {
+		return err
+	}
+	if rule.Field != entity.FeedFilterFieldCategory {
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern %q, %v", rule.Pattern, err)
+		}
+	}
+	return nil
+}
+
+// maxFeedLoginFields limits number of feed login form fields
+const maxFeedLoginFields = 20
+
+// isEmptyFeedLogin reports login without any settings, which removes feed login on update
+func isEmptyFeedLogin(login *entity.FeedLogin) bool {
+	return login.URL == "" && login.Method == "" && len(login.Fields) == 0
+}
+
+// validation helper to check feed login
+func checkFeedLogin(value interface{}) error {
+	login, _ := value.(*entity.FeedLogin)
+	if login == nil || isEmptyFeedLogin(login) {
+		return nil
+	}
+	return validation.ValidateStruct(login,
+		validation.Field(&login.URL, validation.Required, validation.Length(5, 255), is.URL),
+		validation.Field(&login.Method, validation.In(http.MethodGet, http.MethodPost)),
+		validation.Field(&login.Fields, validation.Length(0, maxFeedLoginFields)),
 	)
 }
 
+// maxFeedHeaders limits number of feed custom headers
+const maxFeedHeaders = 20
+
+// headerNamePattern matches RFC 7230 token
+var headerNamePattern = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// reservedFeedHeaders are set by HTTP client or fetcher itself and can't be overridden by feed headers
+var reservedFeedHeaders = map[string]bool{
+	"Host":                true,
+	"Connection":          true,
+	"Content-Length":      true,
+	"Transfer-Encoding":   true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Upgrade":             true,
+	"Keep-Alive":          true,
+	"Proxy-Authorization": true,
+	"Proxy-Connection":    true,
+	"Accept-Encoding":     true,
+	"If-None-Match":       true,
+	"If-Modified-Since":   true,
+	"Uber-Trace-Id":       true,
+}
+
+// validation helper to check feed custom headers names and values
+func checkFeedHeaders(value interface{}) error {
+	headers, _ := value.(map[string]string)
+	for name, headerValue := range headers {
+		if !headerNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if reservedFeedHeaders[http.CanonicalHeaderKey(name)] {
+			return fmt.Errorf("header %s is managed by fetcher and can't be set", name)
+		}
+		if strings.ContainsAny(headerValue, "\r\n\x00") || len(headerValue) > 4096 {
+			return fmt.Errorf("invalid value of header %s", name)
+		}
+	}
+	return nil
+}
+
 // Bind implements Bind interface for chi Bind to map request body to request body struct
 func (b *FeedRequestBody) Bind(r *http.Request) error {
 	return b.Validate()
@@ -159,10 +400,8 @@ func checkUUIDNotNil(value interface{}) error {
 
 // Response with single feed
 func (h *Handler) getFeed(w http.ResponseWriter, r *http.Request) {
-	span, _ := h.setupTracingSpan(r, "get-feed")
-	defer span.Finish()
+	span, _ := requestSpan(r)
 	dbFeed := r.Context().Value("feed").(*entity.Feed)
-	ext.HTTPStatusCode.Set(span, http.StatusOK)
 	span.LogKV("event", "got feed")
 	NewFeedResponse(dbFeed).Render(w, r)
 }
@@ -180,98 +419,251 @@ func (h *Handler) healthCheck(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) createFeed(w http.ResponseWriter, r *http.Request) {
-	span, ctx := h.setupTracingSpan(r, "create-feed")
-	defer span.Finish()
+	span, ctx := requestSpan(r)
 	body := new(FeedRequestBody)
 	// data := new(FeedRequest)
 	if err := render.Bind(r, body); err != nil {
 		h.logger.Error("Failure accepting input for updating feed", body, " with error: ", err)
-		ext.HTTPStatusCode.Set(span, http.StatusBadRequest)
 		span.LogFields(
 			otLog.Error(err),
 		)
 		ErrInvalidRequest(err).Render(w, r)
 		return
 	}
+	if err := h.checkFeedURL(ctx, body.URL); err != nil {
+		h.logger.Error("Feed url ", body.URL, " is not allowed: ", err)
+		span.LogFields(
+			otLog.Error(err),
+		)
+		ErrInvalidRequest(err).Render(w, r)
+		return
+	}
+	if body.WebhookURL != "" {
+		if err := h.checkFeedURL(ctx, body.WebhookURL); err != nil {
+			h.logger.Error("Feed webhook url ", body.WebhookURL, " is not allowed: ", err)
+			span.LogFields(
+				otLog.Error(err),
+			)
+			ErrInvalidRequest(err).Render(w, r)
+			return
+		}
+	}
+	if body.Login != nil && isEmptyFeedLogin(body.Login) {
+		body.Login = nil
+	}
+	if body.Login != nil {
+		if err := h.checkFeedURL(ctx, body.Login.URL); err != nil {
+			h.logger.Error("Feed login url ", body.Login.URL, " is not allowed: ", err)
+			span.LogFields(
+				otLog.Error(err),
+			)
+			ErrInvalidRequest(err).Render(w, r)
+			return
+		}
+	}
 	f := &entity.Feed{
-		PublicationUUID: body.PublicationUUID,
-		URL:             body.URL,
-		LanguageCode:    body.LanguageCode,
+		ID:                 uuid.Must(uuid.NewV4()),
+		PublicationUUID:    body.PublicationUUID,
+		URL:                body.URL,
+		LanguageCode:       body.LanguageCode,
+		WebhookURL:         body.WebhookURL,
+		MaxItemsPerRefresh: body.MaxItemsPerRefresh,
+		FetchTimeout:       body.FetchTimeout,
+		Headers:            body.Headers,
+		Login:              body.Login,
+		Filter:             body.Filter,
+		// Feeds are created enabled, disabling is done with update
+		Enabled: true,
+	}
+	if f.LanguageCode == "" {
+		f.LanguageCode = h.defaultLanguageCode
 	}
-	// TODO: create validator on record, that already exist
-	if err := h.repository.Create(ctx, f); err != nil {
-		ext.HTTPStatusCode.Set(span, http.StatusInternalServerError)
+	audit := &entity.AuditRecord{
+		Action:          entity.AuditActionCreate,
+		PublicationUUID: f.PublicationUUID,
+		FeedID:          f.ID,
+		After:           auditSnapshot(f),
+		Actor:           callerIdentity(r),
+	}
+	// Publication may have several feeds, but not with the same url
+	if err := h.repository.CreateWithAudit(ctx, f, audit); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		if errors.Is(err, entity.ErrFeedExists) {
+			ErrConflict(err).Render(w, r)
+			return
+		}
 		ErrInternal(err).Render(w, r)
 		return
 	}
 	// return 201 on create
-	ext.HTTPStatusCode.Set(span, http.StatusCreated)
 	span.LogKV("event", "created feed")
 	render.Status(r, http.StatusCreated)
 	NewFeedResponse(f).Render(w, r)
 }
 
 func (h *Handler) updateFeed(w http.ResponseWriter, r *http.Request) {
-	span, ctx := h.setupTracingSpan(r, "update-feed")
-	defer span.Finish()
+	span, ctx := requestSpan(r)
 	dbFeed := r.Context().Value("feed").(*entity.Feed)
+	// Snapshot is taken before binding, since request decoding merges into prefilled filter of dbFeed
+	before := auditSnapshot(dbFeed)
 
-	body := new(FeedRequestBody)
+	body := &FeedRequestBody{Feed: &entity.Feed{}}
 	body.URL = dbFeed.URL
 	body.LanguageCode = dbFeed.LanguageCode
+	body.WebhookURL = dbFeed.WebhookURL
+	body.MaxItemsPerRefresh = dbFeed.MaxItemsPerRefresh
+	body.FetchTimeout = dbFeed.FetchTimeout
+	body.Filter = dbFeed.Filter
 	body.PublicationUUID = dbFeed.PublicationUUID
 	h.logger.Debug("Updating feed: ", body)
 	if err := render.Bind(r, body); err != nil {
 		h.logger.Error("Failure accepting input for updating feed", body, " with error: ", err)
 		ErrInvalidRequest(err).Render(w, r)
-		ext.HTTPStatusCode.Set(span, http.StatusBadRequest)
 		span.LogFields(
 			otLog.Error(err),
 		)
 		return
 	}
+	// Processed items are deduplicated by publication, so feed can't move to another publication
+	if body.PublicationUUID != dbFeed.PublicationUUID {
+		err := errors.New("publication_uuid of feed can't be changed")
+		h.logger.Error("Failure updating feed ", dbFeed.ID, ": ", err)
+		span.LogFields(
+			otLog.Error(err),
+		)
+		ErrInvalidRequest(err).Render(w, r)
+		return
+	}
+	if body.URL != dbFeed.URL {
+		if err := h.checkFeedURL(ctx, body.URL); err != nil {
+			h.logger.Error("Feed url ", body.URL, " is not allowed: ", err)
+			span.LogFields(
+				otLog.Error(err),
+			)
+			ErrInvalidRequest(err).Render(w, r)
+			return
+		}
+	}
+	if body.WebhookURL != "" && body.WebhookURL != dbFeed.WebhookURL {
+		if err := h.checkFeedURL(ctx, body.WebhookURL); err != nil {
+			h.logger.Error("Feed webhook url ", body.WebhookURL, " is not allowed: ", err)
+			span.LogFields(
+				otLog.Error(err),
+			)
+			ErrInvalidRequest(err).Render(w, r)
+			return
+		}
+	}
+	if body.Login != nil && !isEmptyFeedLogin(body.Login) {
+		if err := h.checkFeedURL(ctx, body.Login.URL); err != nil {
+			h.logger.Error("Feed login url ", body.Login.URL, " is not allowed: ", err)
+			span.LogFields(
+				otLog.Error(err),
+			)
+			ErrInvalidRequest(err).Render(w, r)
+			return
+		}
+	}
 	dbFeed.URL = body.URL
 	dbFeed.LanguageCode = body.LanguageCode
-	dbFeed.PublicationUUID = body.PublicationUUID
-	if err := h.repository.Update(ctx, dbFeed); err != nil {
+	dbFeed.WebhookURL = body.WebhookURL
+	dbFeed.MaxItemsPerRefresh = body.MaxItemsPerRefresh
+	dbFeed.FetchTimeout = body.FetchTimeout
+	dbFeed.Filter = body.Filter
+	// Headers are not prefilled, since JSON decoding merges into existing map: omitted headers are kept,
+	// sent headers replace all existing ones and empty object removes them.
+	// Redacted value, as returned by API, keeps the current value of the header.
+	if body.Headers != nil {
+		for name, value := range body.Headers {
+			if current, ok := dbFeed.Headers[name]; ok && value == redactedValue {
+				body.Headers[name] = current
+			}
+		}
+		dbFeed.Headers = body.Headers
+	}
+	// Login is not prefilled either, since responses redact its fields: omitted login is kept, empty object removes it
+	switch {
+	case body.Login == nil:
+	case isEmptyFeedLogin(body.Login):
+		dbFeed.Login = nil
+	default:
+		dbFeed.Login = body.Login
+	}
+	if body.Enabled != nil {
+		switch {
+		case *body.Enabled:
+			dbFeed.DisabledReason = ""
+		case dbFeed.Enabled:
+			dbFeed.DisabledReason = entity.FeedDisabledReasonManual
+		}
+		dbFeed.Enabled = *body.Enabled
+	}
+	audit := &entity.AuditRecord{
+		Action:          entity.AuditActionUpdate,
+		PublicationUUID: dbFeed.PublicationUUID,
+		FeedID:          dbFeed.ID,
+		Before:          before,
+		After:           auditSnapshot(dbFeed),
+		Actor:           callerIdentity(r),
+	}
+	if err := h.repository.UpdateWithAudit(ctx, dbFeed, audit); err != nil {
+		if errors.Is(err, entity.ErrFeedExists) {
+			ErrConflict(err).Render(w, r)
+			return
+		}
 		h.logger.Error("Failure updating feed in repository", dbFeed, " with error: ", err)
 		ErrInternal(err).Render(w, r)
 		return
 	}
 	h.logger.Debug("Updated feed: ", dbFeed)
 	span.LogKV("event", "updated feed")
-	ext.HTTPStatusCode.Set(span, http.StatusOK)
 	render.Status(r, http.StatusOK)
 	NewFeedResponse(dbFeed).Render(w, r)
 }
 
 func (h *Handler) deleteFeed(w http.ResponseWriter, r *http.Request) {
-	span, ctx := h.setupTracingSpan(r, "serve-delete-feed")
-	defer span.Finish()
+	span, ctx := requestSpan(r)
 	dbFeed := r.Context().Value("feed").(*entity.Feed)
 
-	if err := h.repository.Delete(ctx, dbFeed.PublicationUUID); err != nil {
+	audit := &entity.AuditRecord{
+		Action:          entity.AuditActionDelete,
+		PublicationUUID: dbFeed.PublicationUUID,
+		FeedID:          dbFeed.ID,
+		Before:          auditSnapshot(dbFeed),
+		Actor:           callerIdentity(r),
+	}
+	if err := h.repository.DeleteWithAudit(ctx, dbFeed.ID, audit); err != nil {
 		h.logger.Error("Failure deleting feed", dbFeed, " with error: ", err)
-		ext.HTTPStatusCode.Set(span, http.StatusInternalServerError)
 		ErrInternal(err).Render(w, r)
 		return
 	}
 	span.LogKV("event", "deleted feed")
-	ext.HTTPStatusCode.Set(span, http.StatusNoContent)
 	render.NoContent(w, r)
 }
 
 func (h *Handler) refreshFeed(w http.ResponseWriter, r *http.Request) {
-	span, ctx := h.setupTracingSpan(r, "serve-refresh-feed")
-	defer span.Finish()
+	span, ctx := requestSpan(r)
 
 	dbFeed := r.Context().Value("feed").(*entity.Feed)
-	h.logger.Debug("Sending message to update feed: ", dbFeed)
-	err := h.producer.SendUpdateOne(ctx, dbFeed.PublicationUUID)
+	force := false
+	if forceParam := r.URL.Query().Get("force"); forceParam != "" {
+		var err error
+		if force, err = strconv.ParseBool(forceParam); err != nil {
+			span.LogFields(
+				otLog.Error(err),
+			)
+			ErrInvalidRequest(fmt.Errorf("Wrong 'force' parameter value: %v", err)).Render(w, r)
+			return
+		}
+	}
+	span.SetTag("feed.forceRefresh", force)
+	h.logger.Debug("Sending message to update feed: ", dbFeed, ", force: ", force)
+	err := h.producer.SendUpdateOne(ctx, dbFeed.ID, force)
 	if err != nil {
 		h.logger.Error("Failure sending message to refresh one feed: ", err)
 		ErrInternal(err).Render(w, r)
-		ext.HTTPStatusCode.Set(span, http.StatusInternalServerError)
 		span.LogFields(
 			otLog.Error(err),
 		)
@@ -279,62 +671,986 @@ func (h *Handler) refreshFeed(w http.ResponseWriter, r *http.Request) {
 	}
 	h.logger.Debug("Sent message to refresh one feed: ", dbFeed)
 	span.LogKV("event", "sent refresh for one feed")
-	ext.HTTPStatusCode.Set(span, http.StatusNoContent)
 	render.NoContent(w, r)
 }
 
-func (h *Handler) refreshAllFeeds(w http.ResponseWriter, r *http.Request) {
-	span, ctx := h.setupTracingSpan(r, "serve-refresh-all-feeds")
-	defer span.Finish()
-	h.logger.Debug("Sending refresh for all feeds")
-	if err := h.producer.SendUpdateAll(ctx); err != nil {
-		ext.HTTPStatusCode.Set(span, http.StatusInternalServerError)
+// FeedsBatchGetRequestBody defines list of feeds to get
+type FeedsBatchGetRequestBody struct {
+	PublicationUUIDs []uuid.UUID `json:"publication_uuids"`
+}
+
+// Validate request body
+func (b FeedsBatchGetRequestBody) Validate() error {
+	return validation.ValidateStruct(&b,
+		validation.Field(&b.PublicationUUIDs, validation.Required, validation.Length(1, 100), validation.Each(validation.By(checkUUIDNotNil))),
+	)
+}
+
+// Bind implements Bind interface for chi Bind to map request body to request body struct
+func (b *FeedsBatchGetRequestBody) Bind(r *http.Request) error {
+	return b.Validate()
+}
+
+// FeedsBatchGetResponse defines found feeds and requested publication UUIDs, which have no feeds
+// swagger:response
+type FeedsBatchGetResponse struct {
+	// in: body
+	Body FeedsBatchGetResponseBody
+}
+
+// FeedsBatchGetResponseBody is returned on batch get of feeds
+type FeedsBatchGetResponseBody struct {
+	Feeds   []FeedResponseBody `json:"feeds"`
+	Missing []uuid.UUID        `json:"missing"`
+}
+
+// Returns feeds of publications from the list, reporting publications without feeds as missing
+func (h *Handler) batchGetFeeds(w http.ResponseWriter, r *http.Request) {
+	span, ctx := requestSpan(r)
+	body := new(FeedsBatchGetRequestBody)
+	if err := render.Bind(r, body); err != nil {
+		h.logger.Error("Failure accepting input for batch get of feeds", body, " with error: ", err)
 		span.LogFields(
 			otLog.Error(err),
 		)
-		ErrInternal(err).Render(w, r)
+		ErrInvalidRequest(err).Render(w, r)
 		return
 	}
-	h.logger.Debug("Sent refresh message for all feeds")
-	span.LogKV("event", "sent refresh for all feeds")
-	ext.HTTPStatusCode.Set(span, http.StatusNoContent)
-	render.NoContent(w, r)
-}
-
-// Returns feeds entries
-// TODO: filtering
-func (h *Handler) getFeeds(w http.ResponseWriter, r *http.Request) {
-	span, ctx := h.setupTracingSpan(r, "serve-get-all-feeds")
-	defer span.Finish()
-
-	dbFeeds, err := h.repository.GetAll(ctx)
-	span.LogKV("event", "got feeds from repository")
+	dbFeeds, err := h.repository.GetByPublicationUUIDs(ctx, body.PublicationUUIDs)
 	if err != nil {
 		h.logger.Error("Failure reading feeds from database: ", err)
 		ErrInternal(fmt.Errorf("Failure reading feeds from database")).Render(w, r)
-		ext.HTTPStatusCode.Set(span, http.StatusInternalServerError)
 		return
 	}
-	feedsResponse := make([]FeedResponseBody, len(dbFeeds), len(dbFeeds))
+	found := make(map[uuid.UUID]bool, len(dbFeeds))
+	response := FeedsBatchGetResponseBody{
+		Feeds:   make([]FeedResponseBody, len(dbFeeds)),
+		Missing: []uuid.UUID{},
+	}
 	for i := 0; i < len(dbFeeds); i++ {
-		feedsResponse[i] = NewFeedResponse(&dbFeeds[i]).Body
+		response.Feeds[i] = NewFeedResponse(&dbFeeds[i]).Body
+		found[dbFeeds[i].PublicationUUID] = true
+	}
+	for _, publicationUUID := range body.PublicationUUIDs {
+		if !found[publicationUUID] {
+			response.Missing = append(response.Missing, publicationUUID)
+			// report duplicates in request once
+			found[publicationUUID] = true
+		}
 	}
-	span.LogKV("event", "populated response feeds slice")
 	span.LogFields(
-		otLog.Int("feedsNumber", len(dbFeeds)),
+		otLog.Int("feedsNumber", len(response.Feeds)),
+		otLog.Int("missingNumber", len(response.Missing)),
 	)
-	// ext.HTTPStatusCode.Set(span, http.StatusOK)
-	// FIXME: convert to encoder, record span status code only after everything is sent
-	render.JSON(w, r, feedsResponse)
+	render.JSON(w, r, response)
+}
+
+// maxBulkValidateFeeds limits feeds of single bulk validation request
+const maxBulkValidateFeeds = 500
+
+// FeedsBulkValidateRequestBody defines feeds to check before bulk creation or import
+type FeedsBulkValidateRequestBody struct {
+	Feeds []FeedRequestBody `json:"feeds"`
+}
+
+// Bind implements Bind interface for chi Bind to map request body to request body struct.
+// Feeds are validated one by one by the handler, so invalid ones are reported instead of failing the request
+func (b *FeedsBulkValidateRequestBody) Bind(r *http.Request) error {
+	return validation.ValidateStruct(b,
+		validation.Field(&b.Feeds, validation.Required, validation.Length(1, maxBulkValidateFeeds)),
+	)
+}
+
+// Outcomes of bulk validation of feed
+const (
+	// BulkValidateCreate feed would be created
+	BulkValidateCreate = "create"
+	// BulkValidateDuplicate feed would be skipped, its url already has feed or is earlier in the request
+	BulkValidateDuplicate = "duplicate"
+	// BulkValidateInvalid feed would be rejected
+	BulkValidateInvalid = "invalid"
+)
+
+// FeedsBulkValidateResponse defines outcome of every submitted feed
+// swagger:response
+type FeedsBulkValidateResponse struct {
+	// in: body
+	Body FeedsBulkValidateResponseBody
+}
+
+// FeedsBulkValidateResponseBody is returned on bulk validation of feeds, results are in the order of submitted feeds
+type FeedsBulkValidateResponseBody struct {
+	Results []FeedBulkValidateResult `json:"results"`
+	// Counts of results by outcome
+	Counts map[string]int `json:"counts"`
+}
+
+// FeedBulkValidateResult is outcome of submitted feed: create, duplicate or invalid
+type FeedBulkValidateResult struct {
+	// Index of feed in the request
+	Index           int        `json:"index"`
+	PublicationUUID *uuid.UUID `json:"publication_uuid,omitempty"`
+	URL             string     `json:"url,omitempty"`
+	Outcome         string     `json:"outcome"`
+	// Reason of duplicate or invalid outcome
+	Reason string `json:"reason,omitempty"`
+}
+
+// Checks feeds of bulk creation or import without creating them: reports which would be created,
+// skipped as duplicates of existing feeds or of feeds earlier in the request, or rejected as invalid
+func (h *Handler) bulkValidateFeeds(w http.ResponseWriter, r *http.Request) {
+	span, ctx := requestSpan(r)
+	body := new(FeedsBulkValidateRequestBody)
+	if err := render.Bind(r, body); err != nil {
+		h.logger.Error("Failure accepting input for bulk validation of feeds with error: ", err)
+		span.LogFields(
+			otLog.Error(err),
+		)
+		ErrInvalidRequest(err).Render(w, r)
+		return
+	}
+	results := make([]FeedBulkValidateResult, len(body.Feeds))
+	urls := []string{}
+	for i, feed := range body.Feeds {
+		results[i] = FeedBulkValidateResult{Index: i, Outcome: BulkValidateCreate}
+		if feed.Feed == nil {
+			results[i].Outcome, results[i].Reason = BulkValidateInvalid, "feed must not be empty"
+			continue
+		}
+		publicationUUID := feed.PublicationUUID
+		results[i].PublicationUUID, results[i].URL = &publicationUUID, feed.URL
+		if err := feed.Validate(); err != nil {
+			results[i].Outcome, results[i].Reason = BulkValidateInvalid, err.Error()
+			continue
+		}
+		if err := h.checkFeedURL(ctx, feed.URL); err != nil {
+			results[i].Outcome, results[i].Reason = BulkValidateInvalid, fmt.Sprintf("url is not allowed: %v", err)
+			continue
+		}
+		urls = append(urls, feed.URL)
+	}
+	existingURLs := map[string]uuid.UUID{}
+	if len(urls) > 0 {
+		var err error
+		if existingURLs, err = h.repository.GetPublicationUUIDsByURLs(ctx, urls); err != nil {
+			h.logger.Error("Failure reading feeds from database: ", err)
+			ErrInternal(fmt.Errorf("Failure reading feeds from database")).Render(w, r)
+			return
+		}
+	}
+	// Feeds earlier in the request, which would be created.
+	// Publication may have several feeds, so only urls are checked
+	requestedURLs := map[string]int{}
+	response := FeedsBulkValidateResponseBody{
+		Results: results,
+		Counts:  map[string]int{BulkValidateCreate: 0, BulkValidateDuplicate: 0, BulkValidateInvalid: 0},
+	}
+	for i := range results {
+		result := &results[i]
+		if result.Outcome == BulkValidateCreate {
+			switch existingPublicationUUID := existingURLs[result.URL]; {
+			case existingPublicationUUID == *result.PublicationUUID:
+				result.Outcome, result.Reason = BulkValidateDuplicate, "publication already has feed with the url"
+			case existingPublicationUUID != uuid.Nil:
+				result.Outcome, result.Reason = BulkValidateDuplicate, fmt.Sprintf("url is already used by feed of publication %s", existingPublicationUUID)
+			default:
+				if index, ok := requestedURLs[result.URL]; ok {
+					result.Outcome, result.Reason = BulkValidateDuplicate, fmt.Sprintf("url is the same as of feed %d", index)
+				} else {
+					requestedURLs[result.URL] = i
+				}
+			}
+		}
+		response.Counts[result.Outcome]++
+	}
+	span.LogFields(
+		otLog.Int("createNumber", response.Counts[BulkValidateCreate]),
+		otLog.Int("duplicateNumber", response.Counts[BulkValidateDuplicate]),
+		otLog.Int("invalidNumber", response.Counts[BulkValidateInvalid]),
+	)
+	render.JSON(w, r, response)
+}
+
+// refreshFeedStream runs synchronous feed refresh and streams its progress as Server-Sent Events.
+// Client disconnect cancels the refresh.
+func (h *Handler) refreshFeedStream(w http.ResponseWriter, r *http.Request) {
+	span, ctx := requestSpan(r)
+	dbFeed := r.Context().Value("feed").(*entity.Feed)
+
+	if h.refresher == nil {
+		ErrNotImplemented(errors.New("synchronous feed refresh is not configured")).Render(w, r)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		ErrInternal(errors.New("streaming is not supported")).Render(w, r)
+		return
+	}
+	force := false
+	if forceParam := r.URL.Query().Get("force"); forceParam != "" {
+		var err error
+		if force, err = strconv.ParseBool(forceParam); err != nil {
+			ErrInvalidRequest(fmt.Errorf("Wrong 'force' parameter value: %v", err)).Render(w, r)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events := make(chan processor.RefreshEvent, 16)
+	go func() {
+		defer close(events)
+		err := h.refresher.RefreshFeed(ctx, dbFeed.ID, force, func(event processor.RefreshEvent) {
+			select {
+			case events <- event:
+			case <-ctx.Done():
+			}
+		})
+		if err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				err = fmt.Errorf("refresh didn't finish within refresh stream timeout, %v", err)
+			}
+			h.logger.Error("Failure refreshing feed ", dbFeed.ID, ": ", err)
+			span.LogFields(
+				otLog.Error(err),
+			)
+			events <- processor.RefreshEvent{Type: processor.RefreshEventError, Error: err.Error()}
+			return
+		}
+		events <- processor.RefreshEvent{Type: processor.RefreshEventDone}
+	}()
+	for event := range events {
+		// Deadline exceeded refresh is reported with error event, canceled context means client is gone
+		if ctx.Err() == context.Canceled {
+			// drain events until refresh stops
+			continue
+		}
+		data, err := json.Marshal(event)
+		if err != nil {
+			h.logger.Error("Failure marshalling refresh event: ", err)
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+			h.logger.Debug("Client disconnected from refresh stream: ", err)
+			cancel()
+			continue
+		}
+		flusher.Flush()
+	}
+	span.LogKV("event", "streamed feed refresh")
+}
+
+// maxRepublishWindow limits how far back items can be republished, to avoid flooding Items service
+const maxRepublishWindow = 30 * 24 * time.Hour
+
+// RepublishFeedResponseBody contains the result of feed republishing
+type RepublishFeedResponseBody struct {
+	// Since is the date items were republished from
+	Since time.Time `json:"since"`
+	// Published is the number of republished items
+	Published int `json:"published"`
+}
+
+// RepublishFeedResponse contains the result of feed republishing
+// swagger:response
+type RepublishFeedResponse struct {
+	// in: body
+	Body RepublishFeedResponseBody
+}
+
+// republishFeed re-fetches feed and publishes again its items since the date, bypassing processed items check
+func (h *Handler) republishFeed(w http.ResponseWriter, r *http.Request) {
+	span, ctx := requestSpan(r)
+	dbFeed := r.Context().Value("feed").(*entity.Feed)
+
+	if h.refresher == nil {
+		ErrNotImplemented(errors.New("synchronous feed refresh is not configured")).Render(w, r)
+		return
+	}
+	since, err := time.Parse(time.RFC3339, r.URL.Query().Get("since"))
+	if err != nil {
+		err = fmt.Errorf("Wrong 'since' date format, must be RFC3339: %v", err)
+	} else if since.After(time.Now()) {
+		err = errors.New("'since' must not be in the future")
+	} else if time.Since(since) > maxRepublishWindow {
+		err = fmt.Errorf("'since' must not be older than %v", maxRepublishWindow)
+	}
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		ErrInvalidRequest(err).Render(w, r)
+		return
+	}
+	if !dbFeed.Enabled {
+		ErrConflict(errors.New("feed is disabled")).Render(w, r)
+		return
+	}
+	span.SetTag("feed.republishSince", since.String())
+	published := 0
+	err = h.refresher.RepublishFeed(ctx, dbFeed.ID, since, func(event processor.RefreshEvent) {
+		if event.Type == processor.RefreshEventItemPublished {
+			published++
+		}
+	})
+	if err != nil {
+		h.logger.Error("Failure republishing feed ", dbFeed.ID, ": ", err)
+		span.LogFields(
+			otLog.Error(err),
+		)
+		ErrInternal(err).Render(w, r)
+		return
+	}
+	h.logger.Info("Republished ", published, " items of feed ", dbFeed.ID, " since ", since)
+	render.JSON(w, r, RepublishFeedResponseBody{Since: since, Published: published})
+}
+
+// RefreshAllFeedsResponse contains summary of scheduled feeds refresh
+// swagger:response
+type RefreshAllFeedsResponse struct {
+	// in: body
+	Body processor.RefreshAllSummary
 }
 
-func (h *Handler) setupTracingSpan(r *http.Request, name string) (opentracing.Span, context.Context) {
-	// we ignore error since if there are missing headers it will start new trace
-	spanContext, _ := h.tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header))
-	span := h.tracer.StartSpan(name, opentracing.ChildOf(spanContext))
-	ctx := opentracing.ContextWithSpan(r.Context(), span)
-	ext.Component.Set(span, "httpServer-chi")
-	ext.HTTPMethod.Set(span, r.Method)
-	ext.HTTPUrl.Set(span, r.URL.String())
-	return span, ctx
+// refreshAllFeeds schedules refresh for every feed as worker does, resuming refresh interrupted by request timeout from checkpoint.
+// Returns 200 if all feeds were scheduled, 207 if some failed
+func (h *Handler) refreshAllFeeds(w http.ResponseWriter, r *http.Request) {
+	span, ctx := requestSpan(r)
+	h.logger.Debug("Sending refresh for all feeds")
+	summary, err := processor.ScheduleRefreshAllCheckpointed(ctx, h.repository, h.repository, h.producer, h.refreshAllCheckpointTTL, h.refreshSpread, h.logger)
+	if err != nil {
+		h.logger.Error("Failure scheduling refresh of all feeds: ", err)
+		span.LogFields(
+			otLog.Error(err),
+		)
+		ErrInternal(err).Render(w, r)
+		return
+	}
+	h.logger.Debug("Sent refresh messages for all feeds: ", summary.Scheduled, " scheduled, ", summary.Failed, " failed")
+	span.LogKV("event", "sent refresh for all feeds", "scheduled", summary.Scheduled, "failed", summary.Failed)
+	status := http.StatusOK
+	if summary.Failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	render.Status(r, status)
+	render.JSON(w, r, summary)
+}
+
+// Returns feeds entries
+// TODO: filtering
+func (h *Handler) getFeeds(w http.ResponseWriter, r *http.Request) {
+	span, ctx := requestSpan(r)
+
+	query := r.URL.Query()
+	if query.Get("limit") != "" || query.Get("offset") != "" {
+		h.getFeedsPage(w, r)
+		return
+	}
+	dbFeeds, err := h.repository.GetAll(ctx)
+	span.LogKV("event", "got feeds from repository")
+	if err != nil {
+		h.logger.Error("Failure reading feeds from database: ", err)
+		ErrInternal(fmt.Errorf("Failure reading feeds from database")).Render(w, r)
+		return
+	}
+	feedsResponse := make([]FeedResponseBody, len(dbFeeds), len(dbFeeds))
+	for i := 0; i < len(dbFeeds); i++ {
+		feedsResponse[i] = NewFeedResponse(&dbFeeds[i]).Body
+	}
+	span.LogKV("event", "populated response feeds slice")
+	span.LogFields(
+		otLog.Int("feedsNumber", len(dbFeeds)),
+	)
+	render.JSON(w, r, feedsResponse)
+}
+
+// Returns feeds of the publication, empty list if publication has no feeds
+func (h *Handler) getPublicationFeeds(w http.ResponseWriter, r *http.Request) {
+	span, ctx := requestSpan(r)
+
+	publicationUUID, err := uuid.FromString(chi.URLParam(r, "publication_uuid"))
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		ErrInvalidRequest(fmt.Errorf("Wrong UUID format: %v", err)).Render(w, r)
+		return
+	}
+	span.SetTag("feed.PublicationUUID", publicationUUID.String())
+	dbFeeds, err := h.repository.GetByPublicationUUID(ctx, publicationUUID)
+	if err != nil {
+		h.logger.Error("Failure reading feeds from database: ", err)
+		ErrInternal(fmt.Errorf("Failure reading feeds from database")).Render(w, r)
+		return
+	}
+	feedsResponse := make([]FeedResponseBody, len(dbFeeds))
+	for i := 0; i < len(dbFeeds); i++ {
+		feedsResponse[i] = NewFeedResponse(&dbFeeds[i]).Body
+	}
+	span.LogFields(
+		otLog.Int("feedsNumber", len(dbFeeds)),
+	)
+	render.JSON(w, r, feedsResponse)
+}
+
+const (
+	defaultFeedsPageSize = 100
+	maxFeedsPageSize     = 1000
+)
+
+// Returns page of feeds with total count in X-Total-Count header and navigation links in Link header
+func (h *Handler) getFeedsPage(w http.ResponseWriter, r *http.Request) {
+	span, ctx := requestSpan(r)
+
+	limit, err := intQueryParam(r, "limit", defaultFeedsPageSize)
+	if err == nil && (limit < 1 || limit > maxFeedsPageSize) {
+		err = fmt.Errorf("'limit' must be between 1 and %d", maxFeedsPageSize)
+	}
+	offset, offsetErr := intQueryParam(r, "offset", 0)
+	if err == nil && offsetErr != nil {
+		err = offsetErr
+	}
+	if err == nil && offset < 0 {
+		err = fmt.Errorf("'offset' must not be negative")
+	}
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		ErrInvalidRequest(err).Render(w, r)
+		return
+	}
+	dbFeeds, total, err := h.repository.GetPage(ctx, limit, offset)
+	if err != nil {
+		h.logger.Error("Failure reading feeds from database: ", err)
+		ErrInternal(fmt.Errorf("Failure reading feeds from database")).Render(w, r)
+		return
+	}
+	feedsResponse := make([]FeedResponseBody, len(dbFeeds), len(dbFeeds))
+	for i := 0; i < len(dbFeeds); i++ {
+		feedsResponse[i] = NewFeedResponse(&dbFeeds[i]).Body
+	}
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	w.Header().Set("Link", paginationLinks(r.URL, limit, offset, total))
+	span.LogFields(
+		otLog.Int("feedsNumber", len(dbFeeds)),
+		otLog.Int64("feedsTotal", total),
+	)
+	render.JSON(w, r, feedsResponse)
+}
+
+// Returns feeds without new items since the date in 'since' query parameter (RFC3339)
+func (h *Handler) getStaleFeeds(w http.ResponseWriter, r *http.Request) {
+	span, ctx := requestSpan(r)
+
+	since, err := time.Parse(time.RFC3339, r.URL.Query().Get("since"))
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		ErrInvalidRequest(fmt.Errorf("Wrong 'since' date format, must be RFC3339: %v", err)).Render(w, r)
+		return
+	}
+	span.SetTag("feeds.since", since.String())
+	dbFeeds, err := h.repository.GetStaleFeeds(ctx, since)
+	if err != nil {
+		h.logger.Error("Failure reading stale feeds from database: ", err)
+		ErrInternal(fmt.Errorf("Failure reading stale feeds from database")).Render(w, r)
+		return
+	}
+	feedsResponse := make([]FeedResponseBody, len(dbFeeds), len(dbFeeds))
+	for i := 0; i < len(dbFeeds); i++ {
+		feedsResponse[i] = NewFeedResponse(&dbFeeds[i]).Body
+	}
+	span.LogFields(
+		otLog.Int("feedsNumber", len(dbFeeds)),
+	)
+	render.JSON(w, r, feedsResponse)
+}
+
+// FeedsRefreshStatusResponseBody is refresh status of all feeds since the date with counts by outcome
+// swagger:model
+type FeedsRefreshStatusResponseBody struct {
+	Since     time.Time                  `json:"since"`
+	Pending   int                        `json:"pending"`
+	Succeeded int                        `json:"succeeded"`
+	Failed    int                        `json:"failed"`
+	Skipped   int                        `json:"skipped"`
+	Feeds     []entity.FeedRefreshStatus `json:"feeds"`
+}
+
+// getFeedsRefreshStatus returns refresh outcome of all feeds since the date, e.g. to confirm refresh of all feeds completed
+func (h *Handler) getFeedsRefreshStatus(w http.ResponseWriter, r *http.Request) {
+	span, ctx := requestSpan(r)
+
+	since, err := time.Parse(time.RFC3339, r.URL.Query().Get("since"))
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		ErrInvalidRequest(fmt.Errorf("Wrong 'since' date format, must be RFC3339: %v", err)).Render(w, r)
+		return
+	}
+	span.SetTag("feeds.since", since.String())
+	statuses, err := h.repository.GetFeedsRefreshStatus(ctx, since)
+	if err != nil {
+		h.logger.Error("Failure reading feeds refresh status from database: ", err)
+		ErrInternal(fmt.Errorf("Failure reading feeds refresh status from database")).Render(w, r)
+		return
+	}
+	response := FeedsRefreshStatusResponseBody{Since: since, Feeds: statuses}
+	for _, status := range statuses {
+		switch status.Outcome {
+		case entity.RefreshOutcomePending:
+			response.Pending++
+		case entity.RefreshOutcomeSucceeded:
+			response.Succeeded++
+		case entity.RefreshOutcomeFailed:
+			response.Failed++
+		case entity.RefreshOutcomeSkipped:
+			response.Skipped++
+		}
+	}
+	span.LogFields(
+		otLog.Int("feedsNumber", len(statuses)),
+		otLog.Int("pending", response.Pending),
+	)
+	render.JSON(w, r, response)
+}
+
+const (
+	defaultFailingFeedsMinFailures = 1
+	defaultFailingFeedsPageSize    = 100
+	maxFailingFeedsPageSize        = 1000
+)
+
+// Returns page of feeds failing refresh for at least min_failures times in a row
+func (h *Handler) getFailingFeeds(w http.ResponseWriter, r *http.Request) {
+	span, ctx := requestSpan(r)
+
+	minFailures, err := intQueryParam(r, "min_failures", defaultFailingFeedsMinFailures)
+	if err == nil && minFailures < 1 {
+		err = fmt.Errorf("'min_failures' must be positive")
+	}
+	limit, limitErr := intQueryParam(r, "limit", defaultFailingFeedsPageSize)
+	if err == nil && limitErr != nil {
+		err = limitErr
+	}
+	if err == nil && (limit < 1 || limit > maxFailingFeedsPageSize) {
+		err = fmt.Errorf("'limit' must be between 1 and %d", maxFailingFeedsPageSize)
+	}
+	offset, offsetErr := intQueryParam(r, "offset", 0)
+	if err == nil && offsetErr != nil {
+		err = offsetErr
+	}
+	if err == nil && offset < 0 {
+		err = fmt.Errorf("'offset' must not be negative")
+	}
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		ErrInvalidRequest(err).Render(w, r)
+		return
+	}
+	span.SetTag("feeds.minFailures", minFailures)
+	dbFeeds, err := h.repository.GetFailingFeeds(ctx, minFailures, limit, offset)
+	if err != nil {
+		h.logger.Error("Failure reading failing feeds from database: ", err)
+		ErrInternal(fmt.Errorf("Failure reading failing feeds from database")).Render(w, r)
+		return
+	}
+	feedsResponse := make([]FeedResponseBody, len(dbFeeds), len(dbFeeds))
+	for i := 0; i < len(dbFeeds); i++ {
+		feedsResponse[i] = NewFeedResponse(&dbFeeds[i]).Body
+	}
+	span.LogFields(
+		otLog.Int("feedsNumber", len(dbFeeds)),
+	)
+	render.JSON(w, r, feedsResponse)
+}
+
+const (
+	defaultSlowestFeedsLimit = 20
+	maxSlowestFeedsLimit     = 1000
+)
+
+// Returns feeds with the longest last fetch duration
+func (h *Handler) getSlowestFeeds(w http.ResponseWriter, r *http.Request) {
+	span, ctx := requestSpan(r)
+
+	limit, err := intQueryParam(r, "limit", defaultSlowestFeedsLimit)
+	if err == nil && (limit < 1 || limit > maxSlowestFeedsLimit) {
+		err = fmt.Errorf("'limit' must be between 1 and %d", maxSlowestFeedsLimit)
+	}
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		ErrInvalidRequest(err).Render(w, r)
+		return
+	}
+	dbFeeds, err := h.repository.GetSlowestFeeds(ctx, limit)
+	if err != nil {
+		h.logger.Error("Failure reading slowest feeds from database: ", err)
+		ErrInternal(fmt.Errorf("Failure reading slowest feeds from database")).Render(w, r)
+		return
+	}
+	feedsResponse := make([]FeedResponseBody, len(dbFeeds), len(dbFeeds))
+	for i := 0; i < len(dbFeeds); i++ {
+		feedsResponse[i] = NewFeedResponse(&dbFeeds[i]).Body
+	}
+	span.LogFields(
+		otLog.Int("feedsNumber", len(dbFeeds)),
+	)
+	render.JSON(w, r, feedsResponse)
+}
+
+// Returns feeds quarantined for manual review
+func (h *Handler) getQuarantinedFeeds(w http.ResponseWriter, r *http.Request) {
+	span, ctx := requestSpan(r)
+
+	dbFeeds, err := h.repository.GetQuarantinedFeeds(ctx)
+	if err != nil {
+		h.logger.Error("Failure reading quarantined feeds from database: ", err)
+		ErrInternal(fmt.Errorf("Failure reading quarantined feeds from database")).Render(w, r)
+		return
+	}
+	feedsResponse := make([]FeedResponseBody, len(dbFeeds), len(dbFeeds))
+	for i := 0; i < len(dbFeeds); i++ {
+		feedsResponse[i] = NewFeedResponse(&dbFeeds[i]).Body
+	}
+	span.LogFields(
+		otLog.Int("feedsNumber", len(dbFeeds)),
+	)
+	render.JSON(w, r, feedsResponse)
+}
+
+// Releases feed from quarantine, so it is refreshed automatically again
+func (h *Handler) clearFeedQuarantine(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	dbFeed := r.Context().Value("feed").(*entity.Feed)
+
+	if dbFeed.QuarantineReason == "" {
+		ErrConflict(errors.New("feed is not quarantined")).Render(w, r)
+		return
+	}
+	if err := h.repository.ClearFeedQuarantine(ctx, dbFeed.ID); err != nil {
+		h.logger.Error("Failure clearing feed ", dbFeed.ID, " quarantine: ", err)
+		ErrInternal(err).Render(w, r)
+		return
+	}
+	h.logger.Info("Feed ", dbFeed.ID, " released from quarantine (", dbFeed.QuarantineReason, ")")
+	dbFeed.QuarantineReason = ""
+	dbFeed.QuarantinedAt = nil
+	NewFeedResponse(dbFeed).Render(w, r)
+}
+
+// ProcessedItemRequestBody defines feed item to mark as processed
+type ProcessedItemRequestBody struct {
+	GUID string `json:"guid"`
+	// PubDate is the item publication date, matched by strict dedup mode
+	PubDate *time.Time `json:"pub_date,omitempty"`
+}
+
+// Validate request body
+func (b ProcessedItemRequestBody) Validate() error {
+	return validation.ValidateStruct(&b,
+		validation.Field(&b.GUID, validation.Required, validation.Length(1, 2048)),
+		validation.Field(&b.PubDate, validation.NilOrNotEmpty),
+	)
+}
+
+// Bind implements Bind interface for chi Bind to map request body to request body struct
+func (b *ProcessedItemRequestBody) Bind(r *http.Request) error {
+	return b.Validate()
+}
+
+// Marks feed item as processed, so refreshes skip it as already seen and it's never published
+func (h *Handler) createProcessedItem(w http.ResponseWriter, r *http.Request) {
+	span, ctx := requestSpan(r)
+	dbFeed := r.Context().Value("feed").(*entity.Feed)
+
+	body := &ProcessedItemRequestBody{}
+	if err := render.Bind(r, body); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		ErrInvalidRequest(err).Render(w, r)
+		return
+	}
+	processedItem := &entity.ProcessedItem{
+		PublicationUUID: dbFeed.PublicationUUID,
+		FeedID:          dbFeed.ID,
+		GUID:            body.GUID,
+		PublicationDate: time.Now().UTC(),
+	}
+	if body.PubDate != nil {
+		processedItem.PublicationDate = body.PubDate.UTC()
+	}
+	span.SetTag("item.GUID", processedItem.GUID)
+	if err := h.repository.CreateProcessedItem(ctx, processedItem); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		if errors.Is(err, entity.ErrProcessedItemExists) {
+			ErrConflict(err).Render(w, r)
+			return
+		}
+		h.logger.Error("Failure saving processed item ", processedItem.GUID, " of feed ", dbFeed.ID, ": ", err)
+		ErrInternal(err).Render(w, r)
+		return
+	}
+	h.logger.Info("Item ", processedItem.GUID, " of feed ", dbFeed.ID, " marked as processed")
+	span.LogKV("event", "marked item as processed")
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, processedItem)
+}
+
+// Returns snapshots of feed items published since the date, if item snapshots are enabled in worker
+func (h *Handler) getItemSnapshots(w http.ResponseWriter, r *http.Request) {
+	span, ctx := requestSpan(r)
+	dbFeed := r.Context().Value("feed").(*entity.Feed)
+
+	since, err := time.Parse(time.RFC3339, r.URL.Query().Get("since"))
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		ErrInvalidRequest(fmt.Errorf("Wrong 'since' date format, must be RFC3339: %v", err)).Render(w, r)
+		return
+	}
+	snapshots, err := h.repository.GetItemSnapshots(ctx, dbFeed.ID, since)
+	if err != nil {
+		h.logger.Error("Failure reading item snapshots from database: ", err)
+		ErrInternal(fmt.Errorf("Failure reading item snapshots from database")).Render(w, r)
+		return
+	}
+	span.LogFields(
+		otLog.Int("snapshotsNumber", len(snapshots)),
+	)
+	body, err := json.Marshal(snapshots)
+	if err != nil {
+		ErrRender(err).Render(w, r)
+		return
+	}
+	etag := itemsETag(body)
+	w.Header().Set("ETag", etag)
+	if etagMatches(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(body)
+}
+
+// getFeedStats returns aggregate numbers of feed processing
+func (h *Handler) getFeedStats(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	dbFeed := r.Context().Value("feed").(*entity.Feed)
+
+	stats, err := h.repository.GetFeedStats(ctx, dbFeed.ID)
+	if err != nil {
+		h.logger.Error("Failure reading feed stats from database: ", err)
+		ErrInternal(fmt.Errorf("Failure reading feed stats from database")).Render(w, r)
+		return
+	}
+	if stats == nil {
+		// Feed is deleted after it was read by feedCtx
+		ErrNotFound.Render(w, r)
+		return
+	}
+	render.JSON(w, r, stats)
+}
+
+const (
+	defaultAuditRecordsPageSize = 100
+	maxAuditRecordsPageSize     = 1000
+)
+
+// getAuditRecords returns page of feed changes audit records, optionally of the single feed
+func (h *Handler) getAuditRecords(w http.ResponseWriter, r *http.Request) {
+	span, ctx := requestSpan(r)
+
+	var err error
+	publicationUUID, feedID := uuid.Nil, uuid.Nil
+	if param := r.URL.Query().Get("publication_uuid"); param != "" {
+		if publicationUUID, err = uuid.FromString(param); err != nil {
+			err = fmt.Errorf("Wrong UUID format: %v", err)
+		}
+	}
+	if param := r.URL.Query().Get("feed_id"); err == nil && param != "" {
+		if feedID, err = uuid.FromString(param); err != nil {
+			err = fmt.Errorf("Wrong UUID format: %v", err)
+		}
+	}
+	limit, limitErr := intQueryParam(r, "limit", defaultAuditRecordsPageSize)
+	if err == nil && limitErr != nil {
+		err = limitErr
+	}
+	if err == nil && (limit < 1 || limit > maxAuditRecordsPageSize) {
+		err = fmt.Errorf("'limit' must be between 1 and %d", maxAuditRecordsPageSize)
+	}
+	offset, offsetErr := intQueryParam(r, "offset", 0)
+	if err == nil && offsetErr != nil {
+		err = offsetErr
+	}
+	if err == nil && offset < 0 {
+		err = fmt.Errorf("'offset' must not be negative")
+	}
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		ErrInvalidRequest(err).Render(w, r)
+		return
+	}
+	span.SetTag("feed.PublicationUUID", publicationUUID.String())
+	span.SetTag("feed.ID", feedID.String())
+	records, err := h.repository.GetAuditRecords(ctx, publicationUUID, feedID, limit, offset)
+	if err != nil {
+		h.logger.Error("Failure reading audit records from database: ", err)
+		ErrInternal(fmt.Errorf("Failure reading audit records from database")).Render(w, r)
+		return
+	}
+	span.LogFields(
+		otLog.Int("recordsNumber", len(records)),
+	)
+	render.JSON(w, r, records)
+}
+
+// auditSnapshot returns feed JSON snapshot for audit record, with headers and login fields redacted as in responses
+func auditSnapshot(f *entity.Feed) json.RawMessage {
+	snapshot, err := json.Marshal(NewFeedResponse(f).Body)
+	if err != nil {
+		// Feed always marshals, null snapshot still keeps the change audited
+		return nil
+	}
+	return snapshot
+}
+
+// callerIdentityKey is request context key of authenticated caller identity
+type callerIdentityKey struct{}
+
+// WithCallerIdentity returns request context with caller identity, to be used by authentication middleware
+func WithCallerIdentity(ctx context.Context, identity string) context.Context {
+	return context.WithValue(ctx, callerIdentityKey{}, identity)
+}
+
+// callerIdentity returns identity of the caller for audit records.
+// Without authentication the caller is anonymous and identified by remote address
+func callerIdentity(r *http.Request) string {
+	if identity, ok := r.Context().Value(callerIdentityKey{}).(string); ok && identity != "" {
+		return identity
+	}
+	return "anonymous@" + r.RemoteAddr
+}
+
+// rejectInMaintenance is middleware of feed changes and refreshes routes, which rejects them in maintenance mode.
+// It's applied by route, not by HTTP method, e.g. GET refresh stream publishes items too
+func (h *Handler) rejectInMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.maintenance == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if state := h.maintenance.Get(); state.Enabled {
+			h.logger.Debug("Rejecting ", r.Method, " ", r.URL.Path, " in maintenance mode")
+			ErrServiceUnavailable(fmt.Errorf("service is in maintenance mode, only reads are served: %s", state.Reason)).Render(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// MaintenanceRequestBody defines maintenance mode toggle
+// swagger:model
+type MaintenanceRequestBody struct {
+	// required: true
+	Enabled *bool `json:"enabled"`
+	// Reason is shown to clients of rejected requests
+	Reason string `json:"reason"`
+}
+
+// Bind checks maintenance toggle request
+func (m *MaintenanceRequestBody) Bind(r *http.Request) error {
+	if m.Enabled == nil {
+		return errors.New("'enabled' is required")
+	}
+	return nil
+}
+
+func (h *Handler) getMaintenance(w http.ResponseWriter, r *http.Request) {
+	if h.maintenance == nil {
+		ErrNotImplemented(errors.New("maintenance mode is not configured")).Render(w, r)
+		return
+	}
+	render.JSON(w, r, h.maintenance.Get())
+}
+
+// MaintenanceAdminRoute returns PUT /maintenance for admin listener, so maintenance mode isn't toggled via public API.
+// It enables or disables maintenance mode on all instances. In maintenance mode feed changes and refreshes
+// are rejected with 503, reads are served and workers pause processing of refresh messages.
+// Other instances apply the change within their maintenance.check_interval.
+func (h *Handler) MaintenanceAdminRoute() (string, http.Handler) {
+	r := chi.NewRouter()
+	r.Use(middleware.AllowContentType("application/json"))
+	r.Use(render.SetContentType(render.ContentTypeJSON))
+	r.Put("/maintenance", h.setMaintenance)
+	return "/maintenance", r
+}
+
+func (h *Handler) setMaintenance(w http.ResponseWriter, r *http.Request) {
+	span, ctx := requestSpan(r)
+	if h.maintenance == nil {
+		ErrNotImplemented(errors.New("maintenance mode is not configured")).Render(w, r)
+		return
+	}
+	body := &MaintenanceRequestBody{}
+	if err := render.Bind(r, body); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		ErrInvalidRequest(err).Render(w, r)
+		return
+	}
+	state, err := h.maintenance.Set(ctx, *body.Enabled, body.Reason)
+	if errors.Is(err, maintenance.ErrForcedByConfig) {
+		ErrConflict(err).Render(w, r)
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failure setting maintenance mode: ", err)
+		ErrInternal(err).Render(w, r)
+		return
+	}
+	h.logger.Warn("Maintenance mode is set to ", state.Enabled, " by ", callerIdentity(r), ": ", state.Reason)
+	span.LogKV("maintenance", state.Enabled)
+	render.JSON(w, r, state)
+}
+
+// checkFeedURL checks feed url with feed url checker, if configured
+func (h *Handler) checkFeedURL(ctx context.Context, url string) error {
+	if h.feedURLChecker == nil {
+		return nil
+	}
+	return h.feedURLChecker.CheckURL(ctx, url)
+}
+
+// intQueryParam parses integer query parameter, returning default value if parameter is absent
+func intQueryParam(r *http.Request, name string, defaultValue int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' must be integer: %v", name, err)
+	}
+	return parsed, nil
 }
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+// IdempotencyKeyHeader is request header with client generated key, which makes retries of the request safe
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// idempotencyReplayedHeader marks responses replayed from idempotency store
+const idempotencyReplayedHeader = "Idempotent-Replayed"
+
+// defaultIdempotencyKeyTTL is used when TTL is not configured
+const defaultIdempotencyKeyTTL = 24 * time.Hour
+
+type idempotentResponse struct {
+	requestHash [sha256.Size]byte
+	// completed is false while the first request with the key is being processed
+	completed bool
+	status    int
+	header    http.Header
+	body      []byte
+	expires   time.Time
+}
+
+// idempotencyStore keeps in memory responses of requests with idempotency keys for TTL
+type idempotencyStore struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	responses map[string]*idempotentResponse
+}
+
+func newIdempotencyStore(ttl time.Duration) *idempotencyStore {
+	if ttl <= 0 {
+		ttl = defaultIdempotencyKeyTTL
+	}
+	return &idempotencyStore{
+		ttl:       ttl,
+		responses: map[string]*idempotentResponse{},
+	}
+}
+
+// begin returns stored response for the key, or registers the key as being processed if it is new or expired
+func (s *idempotencyStore) begin(key string, requestHash [sha256.Size]byte) (*idempotentResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for k, response := range s.responses {
+		if response.completed && now.After(response.expires) {
+			delete(s.responses, k)
+		}
+	}
+	if response, ok := s.responses[key]; ok {
+		return response, true
+	}
+	s.responses[key] = &idempotentResponse{requestHash: requestHash}
+	return nil, false
+}
+
+// complete stores response for the key, server errors are not stored so the request could be retried
+func (s *idempotencyStore) complete(key string, status int, header http.Header, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if status >= 500 {
+		delete(s.responses, key)
+		return
+	}
+	response := s.responses[key]
+	response.completed = true
+	response.status = status
+	response.header = header
+	response.body = body
+	response.expires = time.Now().Add(s.ttl)
+}
+
+// idempotent middleware replays the stored response for repeated Idempotency-Key instead of executing request again.
+// Key reused with different request body is rejected with 422, key of request being still processed - with 409.
+func idempotent(store *idempotencyStore) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				ErrInvalidRequest(err).Render(w, r)
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			key = r.Method + " " + r.URL.Path + " " + key
+			requestHash := sha256.Sum256(body)
+
+			response, exists := store.begin(key, requestHash)
+			if exists {
+				switch {
+				case response.requestHash != requestHash:
+					ErrUnprocessable(errors.New("idempotency key is already used for different request")).Render(w, r)
+				case !response.completed:
+					ErrConflict(errors.New("request with the same idempotency key is being processed")).Render(w, r)
+				default:
+					for name, values := range response.header {
+						w.Header()[name] = values
+					}
+					w.Header().Set(idempotencyReplayedHeader, "true")
+					w.WriteHeader(response.status)
+					w.Write(response.body)
+				}
+				return
+			}
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			buf := &bytes.Buffer{}
+			ww.Tee(buf)
+			served := false
+			defer func() {
+				// release the key if handler panicked
+				if !served {
+					store.complete(key, http.StatusInternalServerError, nil, nil)
+				}
+			}()
+			next.ServeHTTP(ww, r)
+			served = true
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			store.complete(key, status, w.Header().Clone(), buf.Bytes())
+		}
+		return http.HandlerFunc(fn)
+	}
+}
@@ -1,7 +1,9 @@
 package server
 
 import (
+	"math/rand"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/middleware"
@@ -16,17 +18,63 @@ type Logger interface {
 	Fatal(args ...interface{})
 }
 
+// defaultAccessLogExcludePaths are scraped frequently and not logged unless configured otherwise
+var defaultAccessLogExcludePaths = []string{"/metrics", "/healthz"}
+
+// kubeProbeUserAgent is the prefix of kubelet healthchecks User-Agent
+const kubeProbeUserAgent = "kube-probe/"
+
+// AccessLogConfig defines which served requests are logged. Client and server errors (4xx, 5xx) are always logged.
+type AccessLogConfig struct {
+	// ExcludePaths are not logged on success, /metrics and /healthz if not set. Set empty list to log all paths
+	ExcludePaths []string `mapstructure:"exclude_paths"`
+	// SuccessSampleRate is the fraction (0-1] of successful requests to log, 0 logs all of them
+	SuccessSampleRate float64 `mapstructure:"success_sample_rate"`
+}
+
+// accessLogFilter decides if served request should be logged
+type accessLogFilter struct {
+	excludePaths map[string]bool
+	sampleRate   float64
+}
+
+func newAccessLogFilter(config AccessLogConfig) *accessLogFilter {
+	excludePaths := config.ExcludePaths
+	if excludePaths == nil {
+		excludePaths = defaultAccessLogExcludePaths
+	}
+	f := &accessLogFilter{excludePaths: make(map[string]bool, len(excludePaths)), sampleRate: config.SuccessSampleRate}
+	for _, path := range excludePaths {
+		f.excludePaths[path] = true
+	}
+	return f
+}
+
+func (f *accessLogFilter) shouldLog(r *http.Request, status int) bool {
+	if status >= http.StatusBadRequest {
+		return true
+	}
+	// Do not log kube-probe healthchecks and excluded paths
+	if strings.HasPrefix(r.UserAgent(), kubeProbeUserAgent) || f.excludePaths[r.URL.Path] {
+		return false
+	}
+	return f.sampleRate <= 0 || f.sampleRate >= 1 || rand.Float64() < f.sampleRate
+}
+
 // middlewareLogger is used for request logging. Only Zap logger is supported now, or dummy.
-func middlewareLogger(logger Logger) func(next http.Handler) http.Handler {
+func middlewareLogger(logger Logger, config AccessLogConfig) func(next http.Handler) http.Handler {
 	l, ok := logger.(*zap.SugaredLogger)
 	if ok {
 		log := l.Desugar()
+		filter := newAccessLogFilter(config)
 		return func(next http.Handler) http.Handler {
 			fn := func(w http.ResponseWriter, r *http.Request) {
 				ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
 				t := time.Now()
 				defer func() {
-					// Do not log kube-probe healtchecks
+					if !filter.shouldLog(r, ww.Status()) {
+						return
+					}
 					log.Info("Served",
 						zap.Any("metadata", map[string]interface{}{
 							"request-headers": map[string]interface{}{
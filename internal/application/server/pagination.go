@@ -0,0 +1,39 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// paginationLinks returns RFC 8288 (former RFC 5988) Link header value with first, prev, next and last pages
+// of limit/offset paginated list. Links are relative to the request url and keep its other query parameters
+func paginationLinks(requestURL *url.URL, limit int, offset int, total int64) string {
+	link := func(rel string, pageOffset int64) string {
+		query := requestURL.Query()
+		query.Set("limit", strconv.Itoa(limit))
+		query.Set("offset", strconv.FormatInt(pageOffset, 10))
+		u := url.URL{Path: requestURL.Path, RawQuery: query.Encode()}
+		return fmt.Sprintf(`<%s>; rel="%s"`, u.String(), rel)
+	}
+	pageSize := int64(limit)
+	current := int64(offset)
+	last := int64(0)
+	if total > 0 {
+		last = (total - 1) / pageSize * pageSize
+	}
+	links := []string{link("first", 0)}
+	if current > 0 {
+		prev := current - pageSize
+		if prev < 0 {
+			prev = 0
+		}
+		links = append(links, link("prev", prev))
+	}
+	if current+pageSize < total {
+		links = append(links, link("next", current+pageSize))
+	}
+	links = append(links, link("last", last))
+	return strings.Join(links, ", ")
+}
@@ -15,6 +15,9 @@ import (
 	"github.com/go-chi/cors"
 	"github.com/go-chi/render"
 	"github.com/go-chi/stampede"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+
+	"github.com/Tarick/naca-rss-feeds/internal/processor"
 )
 
 // Server defines HTTP server
@@ -26,24 +29,105 @@ type Server struct {
 
 // Config defines webserver configuration
 type Config struct {
-	Address        string `mapstructure:"address"`
-	RequestTimeout int    `mapstructure:"request_timeout"`
+	Address string `mapstructure:"address"`
+	// RequestTimeout in seconds bounds every request except refresh stream, 60 if not set or not positive
+	RequestTimeout int `mapstructure:"request_timeout"`
+	// RefreshStreamTimeout in seconds bounds feed refresh streamed as Server-Sent Events, 600 if not set or not positive
+	RefreshStreamTimeout int `mapstructure:"refresh_stream_timeout"`
+	// IdempotencyKeyTTL is the time in seconds to keep responses of requests with Idempotency-Key, 24 hours if not set
+	IdempotencyKeyTTL int `mapstructure:"idempotency_key_ttl"`
+	// DefaultLanguageCode is set for created feeds without language code, takes precedence over detection from the feed
+	DefaultLanguageCode string `mapstructure:"default_language_code"`
+	// AccessLog configures request logging exclusions and sampling
+	AccessLog AccessLogConfig `mapstructure:"access_log"`
+	// BodyLog is debug only logging of mutating requests and responses bodies, disabled by default
+	BodyLog BodyLogConfig `mapstructure:"body_log"`
+	// RequestValidation validates requests against swagger spec before handlers, disabled by default
+	RequestValidation RequestValidationConfig `mapstructure:"request_validation"`
+	// RefreshSpread in seconds spreads feed refreshes scheduled by refresh of all feeds, as processing.refresh_spread of worker
+	RefreshSpread int `mapstructure:"refresh_spread"`
+	// RefreshAllCheckpointTTL in seconds limits resuming of interrupted refresh of all feeds, as processing.refresh_all_checkpoint_ttl
+	// of worker. 3600 if not set
+	RefreshAllCheckpointTTL int `mapstructure:"refresh_all_checkpoint_ttl"`
+}
+
+// Validate server configuration
+func (c Config) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.RequestTimeout, validation.Max(maxRequestTimeout)),
+		validation.Field(&c.RefreshStreamTimeout, validation.Max(maxRequestTimeout)),
+		validation.Field(&c.DefaultLanguageCode, validation.Length(2, 2), isLanguageCode),
+		validation.Field(&c.AccessLog),
+		validation.Field(&c.RequestValidation),
+		validation.Field(&c.RefreshSpread, validation.Min(0), validation.Max(processor.MaxRefreshSpread)),
+		validation.Field(&c.RefreshAllCheckpointTTL, validation.Min(0)),
+	)
+}
+
+// defaultRequestTimeout is used when request timeout is not configured, zero timeout would disable it
+const defaultRequestTimeout = time.Minute
+
+// maxRequestTimeout in seconds, longer timeout is a misconfiguration
+const maxRequestTimeout = 3600
+
+func (c Config) requestTimeout() time.Duration {
+	if c.RequestTimeout > 0 {
+		return time.Duration(c.RequestTimeout) * time.Second
+	}
+	return defaultRequestTimeout
+}
+
+// defaultRefreshStreamTimeout is used when refresh stream timeout is not configured
+const defaultRefreshStreamTimeout = 10 * time.Minute
+
+func (c Config) refreshStreamTimeout() time.Duration {
+	if c.RefreshStreamTimeout > 0 {
+		return time.Duration(c.RefreshStreamTimeout) * time.Second
+	}
+	return defaultRefreshStreamTimeout
 }
 
-// New creates new server configuration and configurates middleware
+func (c Config) refreshAllCheckpointTTL() time.Duration {
+	if c.RefreshAllCheckpointTTL > 0 {
+		return time.Duration(c.RefreshAllCheckpointTTL) * time.Second
+	}
+	return processor.DefaultRefreshAllCheckpointTTL
+}
+
+// Validate access log configuration
+func (c AccessLogConfig) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.SuccessSampleRate, validation.Min(0.0), validation.Max(1.0)),
+	)
+}
+
+// New creates new server configuration and configurates middleware.
+// Returns error if request validation is enabled and swagger spec can't be loaded
 // TODO: move routes to handler file
-func New(serverConfig Config, logger Logger, handler *Handler) *Server {
+func New(serverConfig Config, logger Logger, handler *Handler) (*Server, error) {
+	var requestValidator *specValidator
+	if serverConfig.RequestValidation.Enabled {
+		v, err := loadSpecValidator(serverConfig.RequestValidation.specFile())
+		if err != nil {
+			return nil, fmt.Errorf("failure loading request validation spec, %v", err)
+		}
+		requestValidator = v
+	}
 	r := chi.NewRouter()
 	s := &Server{
 		httpServer: &http.Server{Addr: serverConfig.Address, Handler: r},
 		logger:     logger,
 		handler:    handler,
 	}
+	// Refresh of all feeds is scheduled as by worker, with the same spread and checkpoint
+	handler.refreshSpread = time.Duration(serverConfig.RefreshSpread) * time.Second
+	handler.refreshAllCheckpointTTL = serverConfig.refreshAllCheckpointTTL()
 	// Specify here only shared middlewares
 	r.Use(middleware.Recoverer)
 
 	r.Group(func(r chi.Router) {
-		r.Use(middleware.Timeout(time.Duration(serverConfig.RequestTimeout) * time.Second))
+		r.Use(middlewareLogger(logger, serverConfig.AccessLog))
+		r.Use(middleware.Timeout(serverConfig.requestTimeout()))
 		// Prometheus metrics
 		r.Handle("/metrics", promhttp.Handler())
 		r.Get("/healthz", http.HandlerFunc(handler.healthCheck))
@@ -52,15 +136,15 @@ func New(serverConfig Config, logger Logger, handler *Handler) *Server {
 		// Basic CORS to allow API calls from browsers (Swagger-UI)
 		// for more ideas, see: https://developer.github.com/v3/#cross-origin-resource-sharing
 		r.Use(middleware.RequestID)
-		r.Use(middlewareLogger(logger))
+		r.Use(middlewareLogger(logger, serverConfig.AccessLog))
 		r.Use(cors.Handler(cors.Options{
 			// AllowedOrigins: []string{"https://foo.com"},
 			// Use this to allow specific origin hosts
 			AllowedOrigins: []string{"*"},
 			// AllowOriginFunc:  func(r *http.Request, origin string) bool { return true },
 			AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-			AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-			ExposedHeaders:   []string{"Link"},
+			AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", IdempotencyKeyHeader},
+			ExposedHeaders:   []string{"Link", "X-Total-Count"},
 			AllowCredentials: false,
 			MaxAge:           300, // Maximum value not ignored by any of major browsers
 		}))
@@ -72,17 +156,66 @@ func New(serverConfig Config, logger Logger, handler *Handler) *Server {
 	})
 	r.Group(func(r chi.Router) {
 		r.Use(middleware.RequestID)
-		r.Use(middlewareLogger(logger))
+		r.Use(middlewareTracing(handler.tracer))
+		r.Use(middlewareLogger(logger, serverConfig.AccessLog))
+		if serverConfig.BodyLog.Enabled {
+			logger.Warn("Request and response bodies logging is enabled, it is for debugging only and must be disabled in production")
+			r.Use(middlewareBodyLogger(logger, serverConfig.BodyLog))
+		}
 		r.Use(middleware.AllowContentType("application/json"))
 		r.Use(render.SetContentType(render.ContentTypeJSON))
-		r.Use(middleware.Timeout(time.Duration(serverConfig.RequestTimeout) * time.Second))
+		if requestValidator != nil {
+			r.Use(middlewareRequestValidator(requestValidator))
+		}
+		// swagger:operation GET /feeds/{feed_id}/refresh/stream refreshFeedStream
+		// Refreshes feed synchronously and streams progress as Server-Sent Events.
+		// Refresh, which doesn't finish within refresh stream timeout, is stopped and the stream ends with error event
+		// ---
+		// produces:
+		//  - text/event-stream
+		// parameters:
+		//  - name: feed_id
+		//    in: path
+		//    description: Feed id to refresh
+		//    required: true
+		//    type: string
+		//  - name: force
+		//    in: query
+		//    description: ignore ETag and Last-Modified and process the full feed
+		//    required: false
+		//    type: boolean
+		// responses:
+		//  '200':
+		//    description: stream of not_modified, fetched, items_found, item_published, done or error events
+		//  default:
+		//    $ref: "#/responses/ErrResponse"
+		// Refresh stream lasts as long as the refresh does, so it is routed besides /feeds subrouter
+		// with its own deadline instead of request timeout
+		r.With(middleware.Timeout(serverConfig.refreshStreamTimeout()), handler.feedCtx, handler.rejectInMaintenance).Get("/feeds/{feed_id}/refresh/stream", handler.refreshFeedStream)
+		// The rest of routes are bounded by request timeout
+		r = r.With(middleware.Timeout(serverConfig.requestTimeout()))
+		idempotencyStore := newIdempotencyStore(time.Duration(serverConfig.IdempotencyKeyTTL) * time.Second)
 		r.Route("/feeds", func(r chi.Router) {
+			// Feed changes and refreshes are rejected in maintenance mode, reads (including POST batch reads) are served
+			inMaintenance := handler.rejectInMaintenance
 			// Set 1 second caching and requests coalescing to avoid requests stampede. Beware of any user specific responses.
 			cached := stampede.Handler(512, 1*time.Second)
 
 			// swagger:operation GET /feeds getFeeds
-			// Returns all feeds registered in db
+			// Returns all feeds registered in db, or page of them ordered by publication_uuid and id if limit or offset is set.
+			// Page response has total number of feeds in X-Total-Count header and Link header with first, prev, next and last pages
 			// ---
+			// parameters:
+			//  - name: limit
+			//    in: query
+			//    description: page size, 100 by default, 1000 at most
+			//    required: false
+			//    type: integer
+			//  - name: offset
+			//    in: query
+			//    description: number of feeds to skip
+			//    required: false
+			//    type: integer
 			// responses:
 			//   '200':
 			//     description: list all feeds
@@ -90,30 +223,183 @@ func New(serverConfig Config, logger Logger, handler *Handler) *Server {
 			//       type: array
 			//       items:
 			//         $ref: "#/definitions/FeedResponseBody"
-			r.With(cached).Get("/", handler.getFeeds)
+			r.With(cachedWithoutQuery(cached)).Get("/", handler.getFeeds)
 
 			// swagger:operation  POST /feeds createFeed
-			// Creates feed using supplied params from body
+			// Creates feed using supplied params from body.
+			// Repeated request with the same Idempotency-Key returns the original response instead of creating feed again.
+			// Feed id is generated. Publication may have several feeds, feed with url the publication already has is rejected with 409.
 			// ---
 			// parameters:
-			//  - $ref: "#/definitions/Feed"
+			//  - name: feed
+			//    in: body
+			//    required: true
+			//    schema:
+			//      $ref: "#/definitions/Feed"
+			//  - name: Idempotency-Key
+			//    in: header
+			//    description: client generated unique key of the request, safe to retry
+			//    required: false
+			//    type: string
 			// responses:
 			//    '201':
 			//      $ref: "#/responses/FeedResponse"
+			//    '409':
+			//      $ref: "#/responses/ErrResponse"
+			//    '422':
+			//      $ref: "#/responses/ErrResponse"
 			//    default:
 			//      $ref: "#/responses/ErrResponse"
-			r.Post("/", handler.createFeed)
+			r.With(inMaintenance, idempotent(idempotencyStore)).Post("/", handler.createFeed)
+
+			// swagger:operation GET /feeds/refresh-status getFeedsRefreshStatus
+			// Returns refresh outcome of every feed since the date: pending (not fetched since), succeeded, failed
+			// or skipped (disabled or quarantined), with the number of items processed since then and counts by outcome.
+			// Use it to confirm refresh of all feeds completed.
+			// ---
+			// parameters:
+			//  - name: since
+			//    in: query
+			//    description: date in RFC3339 format, e.g. time of refresh of all feeds
+			//    required: true
+			//    type: string
+			// responses:
+			//   '200':
+			//     description: feeds refresh status
+			//     schema:
+			//       $ref: "#/definitions/FeedsRefreshStatusResponseBody"
+			//   default:
+			//     $ref: "#/responses/ErrResponse"
+			r.Get("/refresh-status", handler.getFeedsRefreshStatus)
+
+			// swagger:operation GET /feeds/stale getStaleFeeds
+			// Returns feeds, which didn't publish new items since the date
+			// ---
+			// parameters:
+			//  - name: since
+			//    in: query
+			//    description: cutoff date in RFC3339 format
+			//    required: true
+			//    type: string
+			// responses:
+			//   '200':
+			//     description: list stale feeds
+			//     schema:
+			//       type: array
+			//       items:
+			//         $ref: "#/definitions/FeedResponseBody"
+			//   default:
+			//     $ref: "#/responses/ErrResponse"
+			r.Get("/stale", handler.getStaleFeeds)
+
+			// swagger:operation GET /feeds/failing getFailingFeeds
+			// Returns page of feeds, which failed refresh at least min_failures times in a row, most failing first
+			// ---
+			// parameters:
+			//  - name: min_failures
+			//    in: query
+			//    description: minimum number of consecutive failures, 1 by default
+			//    required: false
+			//    type: integer
+			//  - name: limit
+			//    in: query
+			//    description: page size, 100 by default, 1000 at most
+			//    required: false
+			//    type: integer
+			//  - name: offset
+			//    in: query
+			//    description: number of feeds to skip
+			//    required: false
+			//    type: integer
+			// responses:
+			//   '200':
+			//     description: list failing feeds with the last error and fetch time
+			//     schema:
+			//       type: array
+			//       items:
+			//         $ref: "#/definitions/FeedResponseBody"
+			//   default:
+			//     $ref: "#/responses/ErrResponse"
+			r.Get("/failing", handler.getFailingFeeds)
+
+			// swagger:operation GET /feeds/slow getSlowestFeeds
+			// Returns feeds with the longest fetch and parse duration of the last successful refresh, slowest first
+			// ---
+			// parameters:
+			//  - name: limit
+			//    in: query
+			//    description: number of feeds to return, 20 by default, 1000 at most
+			//    required: false
+			//    type: integer
+			// responses:
+			//   '200':
+			//     description: list slowest feeds
+			//     schema:
+			//       type: array
+			//       items:
+			//         $ref: "#/definitions/FeedResponseBody"
+			//   default:
+			//     $ref: "#/responses/ErrResponse"
+			r.Get("/slow", handler.getSlowestFeeds)
 
-			r.Route("/{publication_uuid}", func(r chi.Router) {
-				r.Use(handler.feedCtx) // handle publication_uuid
+			// swagger:operation GET /feeds/quarantined getQuarantinedFeeds
+			// Returns feeds quarantined for manual review, the longest quarantined first
+			// ---
+			// responses:
+			//   '200':
+			//     description: list quarantined feeds with quarantine reason and time
+			//     schema:
+			//       type: array
+			//       items:
+			//         $ref: "#/definitions/FeedResponseBody"
+			//   default:
+			//     $ref: "#/responses/ErrResponse"
+			r.Get("/quarantined", handler.getQuarantinedFeeds)
 
-				// swagger:operation GET /feeds/{publication_uuid} getFeed
-				// Gets single feed using its publication_uuid as parameter
+			// swagger:operation POST /feeds/batch-get batchGetFeeds
+			// Returns all feeds of publications by the list of publication UUIDs and the list of UUIDs without feeds
+			// ---
+			// parameters:
+			//  - name: body
+			//    in: body
+			//    required: true
+			//    schema:
+			//      $ref: "#/definitions/FeedsBatchGetRequestBody"
+			// responses:
+			//   '200':
+			//     $ref: "#/responses/FeedsBatchGetResponse"
+			//   default:
+			//     $ref: "#/responses/ErrResponse"
+			r.Post("/batch-get", handler.batchGetFeeds)
+
+			// swagger:operation POST /feeds/bulk-validate bulkValidateFeeds
+			// Checks feeds of bulk creation or OPML import without creating them. Every feed is reported as
+			// create, duplicate (url already has feed, or repeats earlier feed of the request) or invalid.
+			// At most 500 feeds per request
+			// ---
+			// parameters:
+			//  - name: body
+			//    in: body
+			//    required: true
+			//    schema:
+			//      $ref: "#/definitions/FeedsBulkValidateRequestBody"
+			// responses:
+			//   '200':
+			//     $ref: "#/responses/FeedsBulkValidateResponse"
+			//   default:
+			//     $ref: "#/responses/ErrResponse"
+			r.Post("/bulk-validate", handler.bulkValidateFeeds)
+
+			r.Route("/{feed_id}", func(r chi.Router) {
+				r.Use(handler.feedCtx) // handle feed_id
+
+				// swagger:operation GET /feeds/{feed_id} getFeed
+				// Gets single feed using its id as parameter
 				// ---
 				// parameters:
-				//  - name: publication_uuid
+				//  - name: feed_id
 				//    in: path
-				//    description: feed publication_uuid to get
+				//    description: feed id to get
 				//    required: true
 				//    type: string
 				// responses:
@@ -123,30 +409,36 @@ func New(serverConfig Config, logger Logger, handler *Handler) *Server {
 				//      $ref: "#/responses/ErrResponse"
 				r.Get("/", handler.getFeed)
 
-				// swagger:operation PUT /feeds/{publication_uuid} updateFeed
+				// swagger:operation PUT /feeds/{feed_id} updateFeed
 				// Modifies feed using supplied params from body
 				// ---
 				// parameters:
-				//  - name: publication_uuid
+				//  - name: feed_id
 				//    in: path
-				//    description: Feed publication_uuid to update
+				//    description: Feed id to update
 				//    required: true
 				//    type: string
-				//  - $ref: "#/definitions/Feed"
+				//  - name: feed
+				//    in: body
+				//    required: true
+				//    schema:
+				//      $ref: "#/definitions/Feed"
 				// responses:
 				//    '200':
 				//      $ref: "#/responses/FeedResponse"
+				//    '409':
+				//      $ref: "#/responses/ErrResponse"
 				//    default:
 				//      $ref: "#/responses/ErrResponse"
-				r.Put("/", handler.updateFeed)
+				r.With(inMaintenance).Put("/", handler.updateFeed)
 
-				// swagger:operation DELETE /feeds/{publication_uuid} deleteFeed
-				// Deletes feed using its publication_uuid
+				// swagger:operation DELETE /feeds/{feed_id} deleteFeed
+				// Deletes feed using its id
 				// ---
 				// parameters:
-				//  - name: publication_uuid
+				//  - name: feed_id
 				//    in: path
-				//    description: Feed publication_uuid to update
+				//    description: Feed id to update
 				//    required: true
 				//    type: string
 				// responses:
@@ -154,10 +446,139 @@ func New(serverConfig Config, logger Logger, handler *Handler) *Server {
 				//    description: Send success
 				//  default:
 				//    $ref: "#/responses/ErrResponse"
-				r.Delete("/", handler.deleteFeed)
+				r.With(inMaintenance).Delete("/", handler.deleteFeed)
+
+				// swagger:operation POST /feeds/{feed_id}/republish republishFeed
+				// Re-fetches feed and publishes again its items since the date, including already processed ones.
+				// Items, which are not in the feed anymore, can't be republished. Items cap per refresh applies.
+				// ---
+				// parameters:
+				//  - name: feed_id
+				//    in: path
+				//    description: Feed id to republish
+				//    required: true
+				//    type: string
+				//  - name: since
+				//    in: query
+				//    description: date in RFC3339 format to republish items from, not older than 30 days
+				//    required: true
+				//    type: string
+				// responses:
+				//  '200':
+				//    $ref: "#/responses/RepublishFeedResponse"
+				//  default:
+				//    $ref: "#/responses/ErrResponse"
+				r.With(inMaintenance).Post("/republish", handler.republishFeed)
+
+				// swagger:operation GET /feeds/{feed_id}/snapshots getItemSnapshots
+				// Returns snapshots of feed items published since the date, newest first. Snapshots are saved if enabled in worker.
+				// Response has ETag, repeated request with it in If-None-Match gets 304 if snapshots didn't change
+				// ---
+				// parameters:
+				//  - name: feed_id
+				//    in: path
+				//    description: Feed id
+				//    required: true
+				//    type: string
+				//  - name: since
+				//    in: query
+				//    description: publication date in RFC3339 format
+				//    required: true
+				//    type: string
+				//  - name: If-None-Match
+				//    in: header
+				//    description: ETag of the previous response
+				//    required: false
+				//    type: string
+				// responses:
+				//   '200':
+				//     description: list item snapshots
+				//     schema:
+				//       type: array
+				//       items:
+				//         $ref: "#/definitions/ItemSnapshot"
+				//   '304':
+				//     description: snapshots didn't change since the response with ETag from If-None-Match
+				//   default:
+				//     $ref: "#/responses/ErrResponse"
+				r.Get("/snapshots", handler.getItemSnapshots)
+
+				// swagger:operation GET /feeds/{feed_id}/stats getFeedStats
+				// Returns aggregate numbers of feed processing: processed items in total and in the last 24 hours, 7 and 30 days,
+				// last fetch time, last item publication date and consecutive failures
+				// ---
+				// parameters:
+				//  - name: feed_id
+				//    in: path
+				//    description: Feed id
+				//    required: true
+				//    type: string
+				// responses:
+				//   '200':
+				//     description: feed statistics
+				//     schema:
+				//       $ref: "#/definitions/FeedStats"
+				//   default:
+				//     $ref: "#/responses/ErrResponse"
+				r.Get("/stats", handler.getFeedStats)
+
+				// swagger:operation POST /feeds/{feed_id}/items createProcessedItem
+				// Marks feed item as processed, so refreshes skip it as already seen and it's never published,
+				// e.g. test post or known bad entry. Processed items are matched by GUID and publication date
+				// in strict dedup mode of worker, so pub_date must be the item date then.
+				// Without pub_date current time is saved, which suppresses item only in guid_only dedup mode.
+				// ---
+				// parameters:
+				//  - name: feed_id
+				//    in: path
+				//    description: Feed id
+				//    required: true
+				//    type: string
+				//  - name: body
+				//    in: body
+				//    required: true
+				//    schema:
+				//      type: object
+				//      required:
+				//        - guid
+				//      properties:
+				//        guid:
+				//          type: string
+				//        pub_date:
+				//          type: string
+				//          format: date-time
+				// responses:
+				//   '201':
+				//     description: item is marked as processed
+				//   '409':
+				//     $ref: "#/responses/ErrResponse"
+				//   default:
+				//     $ref: "#/responses/ErrResponse"
+				r.With(inMaintenance).Post("/items", handler.createProcessedItem)
+
+				// swagger:operation DELETE /feeds/{feed_id}/quarantine clearFeedQuarantine
+				// Releases feed from quarantine, so it is refreshed automatically again.
+				// Quarantine heuristics state is reset, current response content type becomes the new baseline.
+				// ---
+				// parameters:
+				//  - name: feed_id
+				//    in: path
+				//    description: Feed id to release
+				//    required: true
+				//    type: string
+				// responses:
+				//    '200':
+				//      $ref: "#/responses/FeedResponse"
+				//    '409':
+				//      $ref: "#/responses/ErrResponse"
+				//    default:
+				//      $ref: "#/responses/ErrResponse"
+				r.With(inMaintenance).Delete("/quarantine", handler.clearFeedQuarantine)
 			})
 		})
 		r.Route("/refreshFeeds", func(r chi.Router) {
+			// All routes are refreshes
+			r.Use(handler.rejectInMaintenance)
 			// Set 60 second caching and requests coalescing to avoid requests stampede for all feeds refresh
 			cachedAll := stampede.Handler(512, 60*time.Second)
 			// Set 10 second caching and requests coalescing to avoid requests stampede for one feed refresh
@@ -166,35 +587,129 @@ func New(serverConfig Config, logger Logger, handler *Handler) *Server {
 			// Triggers refresh (pull of content) for all feeds
 			// ---
 			// responses:
-			//    '204':
-			//      description: Send success
+			//    '200':
+			//      $ref: "#/responses/RefreshAllFeedsResponse"
+			//    '207':
+			//      $ref: "#/responses/RefreshAllFeedsResponse"
 			//    default:
 			//      description: Error payload
 			//      schema:
 			//        $ref: "#/responses/ErrResponse"
 			r.With(cachedAll).Put("/", handler.refreshAllFeeds)
-			// swagger:operation PUT /refreshFeeds/{publication_uuid} refreshFeed
+			// swagger:operation PUT /refreshFeeds/{feed_id} refreshFeed
 			// Triggers refresh (pull of content) for single feeds
 			// ---
 			// parameters:
-			//  - name: publication_uuid
+			//  - name: feed_id
 			//    in: path
-			//    description: Feed publication_uuid to update
+			//    description: Feed id to update
 			//    required: true
 			//    type: string
+			//  - name: force
+			//    in: query
+			//    description: ignore ETag and Last-Modified and process the full feed
+			//    required: false
+			//    type: boolean
 			// responses:
 			//    '204':
 			//      description: Send success
 			//    default:
 			//      $ref: "#/responses/ErrResponse"
-			r.Route("/{publication_uuid}", func(r chi.Router) {
-				r.Use(handler.feedCtx)                          // handle publication_uuid
-				r.With(cachedOne).Put("/", handler.refreshFeed) // PUT /refreshFeeds/sfsd-fds-fsd-fsd
+			r.Route("/{feed_id}", func(r chi.Router) {
+				r.Use(handler.feedCtx) // handle feed_id
+				// Forced refresh isn't coalesced with plain one, which response may be cached
+				r.With(cachedWithoutQuery(cachedOne)).Put("/", handler.refreshFeed) // PUT /refreshFeeds/sfsd-fds-fsd-fsd
 			})
 		})
+		// swagger:operation GET /publications/{publication_uuid}/feeds getPublicationFeeds
+		// Returns feeds of the publication ordered by id, empty list if publication has no feeds
+		// ---
+		// parameters:
+		//  - name: publication_uuid
+		//    in: path
+		//    description: publication UUID
+		//    required: true
+		//    type: string
+		// responses:
+		//   '200':
+		//     description: list feeds of the publication
+		//     schema:
+		//       type: array
+		//       items:
+		//         $ref: "#/definitions/FeedResponseBody"
+		//   default:
+		//     $ref: "#/responses/ErrResponse"
+		r.Get("/publications/{publication_uuid}/feeds", handler.getPublicationFeeds)
+		// swagger:operation GET /audit getAuditRecords
+		// Returns page of feeds create, update and delete audit records, the newest first
+		// ---
+		// parameters:
+		//  - name: publication_uuid
+		//    in: query
+		//    description: return records of the publication feeds only, all feeds by default
+		//    required: false
+		//    type: string
+		//  - name: feed_id
+		//    in: query
+		//    description: return records of the feed only, all feeds by default
+		//    required: false
+		//    type: string
+		//  - name: limit
+		//    in: query
+		//    description: page size, 100 by default, 1000 at most
+		//    required: false
+		//    type: integer
+		//  - name: offset
+		//    in: query
+		//    description: number of records to skip
+		//    required: false
+		//    type: integer
+		// responses:
+		//   '200':
+		//     description: list audit records with feed snapshots before and after the change
+		//     schema:
+		//       type: array
+		//       items:
+		//         $ref: "#/definitions/AuditRecord"
+		//   default:
+		//     $ref: "#/responses/ErrResponse"
+		r.Get("/audit", handler.getAuditRecords)
+		r.Route("/maintenance", func(r chi.Router) {
+			// swagger:operation GET /maintenance getMaintenance
+			// Returns maintenance mode state
+			// ---
+			// responses:
+			//   '200':
+			//     description: maintenance mode state
+			//     schema:
+			//       $ref: "#/definitions/Maintenance"
+			//   default:
+			//     $ref: "#/responses/ErrResponse"
+			r.Get("/", handler.getMaintenance)
+			// Toggle is served by admin listener only, see MaintenanceAdminRoute
+		})
 	})
-	return s
+	return s, nil
+
+}
+
+// cachedWithoutQuery applies stampede cache to requests without query only, since cache key ignores query string
+func cachedWithoutQuery(cached func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		cachedNext := cached(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.RawQuery == "" {
+				cachedNext.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
 
+// Handler returns routes of the server with their middlewares, e.g. to serve them in tests
+func (s *Server) Handler() http.Handler {
+	return s.httpServer.Handler
 }
 
 // StartAndServe configures routers and starts http server
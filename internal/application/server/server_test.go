@@ -0,0 +1,587 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Tarick/naca-rss-feeds/internal/entity"
+	"github.com/Tarick/naca-rss-feeds/internal/processor"
+	"github.com/gofrs/uuid"
+	"github.com/opentracing/opentracing-go"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(args ...interface{}) {}
+func (nopLogger) Info(args ...interface{})  {}
+func (nopLogger) Warn(args ...interface{})  {}
+func (nopLogger) Error(args ...interface{}) {}
+func (nopLogger) Fatal(args ...interface{}) {}
+
+// fakeRepository serves single feed with its item snapshots and records deadline of request context, methods not overridden panic
+type fakeRepository struct {
+	FeedsRepository
+	feed      *entity.Feed
+	snapshots []entity.ItemSnapshot
+	mu        sync.Mutex
+	deadline  time.Time
+	// audit is the last saved audit record
+	audit *entity.AuditRecord
+	// feeds are all feeds of repository
+	feeds []entity.Feed
+}
+
+func (r *fakeRepository) GetByID(ctx context.Context, id uuid.UUID) (*entity.Feed, error) {
+	r.mu.Lock()
+	r.deadline, _ = ctx.Deadline()
+	r.mu.Unlock()
+	if r.feed != nil && r.feed.ID == id {
+		return r.feed, nil
+	}
+	return nil, nil
+}
+
+func (r *fakeRepository) GetByPublicationUUID(ctx context.Context, publicationUUID uuid.UUID) ([]entity.Feed, error) {
+	if r.feed != nil && r.feed.PublicationUUID == publicationUUID {
+		return []entity.Feed{*r.feed}, nil
+	}
+	return []entity.Feed{}, nil
+}
+
+func (r *fakeRepository) GetAll(ctx context.Context) ([]entity.Feed, error) {
+	return r.feeds, nil
+}
+
+func (r *fakeRepository) GetRefreshAllCheckpoint(ctx context.Context) (*entity.RefreshAllCheckpoint, error) {
+	return nil, nil
+}
+
+func (r *fakeRepository) ClearRefreshAllCheckpoint(ctx context.Context) error {
+	return nil
+}
+
+func (r *fakeRepository) UpdateWithAudit(ctx context.Context, feed *entity.Feed, audit *entity.AuditRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.feed = feed
+	r.audit = audit
+	return nil
+}
+
+// fakeRefresher refreshes feed in duration, or until context is done
+type fakeRefresher struct {
+	FeedRefresher
+	duration time.Duration
+}
+
+func (r *fakeRefresher) RefreshFeed(ctx context.Context, feedID uuid.UUID, force bool, progress processor.RefreshProgressFunc) error {
+	select {
+	case <-time.After(r.duration):
+		progress(processor.RefreshEvent{Type: processor.RefreshEventNotModified})
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *fakeRepository) GetItemSnapshots(ctx context.Context, feedID uuid.UUID, since time.Time) ([]entity.ItemSnapshot, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]entity.ItemSnapshot{}, r.snapshots...), nil
+}
+
+// fakeProducer records feeds with sent refresh message, sending fails for failing feed. Methods not overridden panic
+type fakeProducer struct {
+	RSSFeedsUpdateProducer
+	mu        sync.Mutex
+	failing   uuid.UUID
+	refreshed []uuid.UUID
+	forced    []uuid.UUID
+}
+
+func (p *fakeProducer) SendUpdateOne(ctx context.Context, feedID uuid.UUID, force bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.refreshed = append(p.refreshed, feedID)
+	if force {
+		p.forced = append(p.forced, feedID)
+	}
+	return nil
+}
+
+func (p *fakeProducer) SendUpdateOneDeferred(ctx context.Context, feedID uuid.UUID, force bool, delay time.Duration) error {
+	if feedID == p.failing {
+		return errors.New("nsqd is unavailable")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.refreshed = append(p.refreshed, feedID)
+	return nil
+}
+
+func TestRefreshFeedStreamTimeout(t *testing.T) {
+	feed := &entity.Feed{ID: uuid.Must(uuid.NewV4()), PublicationUUID: uuid.Must(uuid.NewV4()), URL: "https://example.com/feed.xml"}
+	tests := []struct {
+		name            string
+		refreshDuration time.Duration
+		wantEvent       string
+		wantBody        string
+	}{
+		{
+			name:            "refresh longer than request timeout finishes",
+			refreshDuration: 1500 * time.Millisecond,
+			wantEvent:       "event: " + string(processor.RefreshEventDone),
+		},
+		{
+			name:            "refresh longer than stream timeout ends with error event",
+			refreshDuration: time.Minute,
+			wantEvent:       "event: " + string(processor.RefreshEventError),
+			wantBody:        "refresh stream timeout",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			handler := NewHandler(nopLogger{}, opentracing.NoopTracer{}, &fakeRepository{feed: feed}, nil, &fakeRefresher{duration: tt.refreshDuration}, "", nil, nil)
+			srv, err := New(Config{RequestTimeout: 1, RefreshStreamTimeout: 2}, nopLogger{}, handler)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ts := httptest.NewServer(srv.httpServer.Handler)
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/feeds/"+feed.ID.String()+"/refresh/stream", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(body), tt.wantEvent) {
+				t.Errorf("stream %q doesn't end with %q", body, tt.wantEvent)
+			}
+			if !strings.Contains(string(body), tt.wantBody) {
+				t.Errorf("stream %q doesn't contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestConfigRequestTimeout(t *testing.T) {
+	tests := []struct {
+		name           string
+		requestTimeout int
+		want           time.Duration
+		wantErr        bool
+	}{
+		{name: "default when not configured", requestTimeout: 0, want: defaultRequestTimeout},
+		{name: "default when negative", requestTimeout: -5, want: defaultRequestTimeout},
+		{name: "configured", requestTimeout: 30, want: 30 * time.Second},
+		{name: "maximum", requestTimeout: maxRequestTimeout, want: maxRequestTimeout * time.Second},
+		{name: "above maximum is rejected", requestTimeout: maxRequestTimeout + 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Config{RequestTimeout: tt.requestTimeout}
+			if err := c.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := c.requestTimeout(); got != tt.want {
+				t.Errorf("requestTimeout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestTimeoutDefaultApplied(t *testing.T) {
+	feed := &entity.Feed{ID: uuid.Must(uuid.NewV4()), PublicationUUID: uuid.Must(uuid.NewV4()), URL: "https://example.com/feed.xml"}
+	repository := &fakeRepository{feed: feed}
+	handler := NewHandler(nopLogger{}, opentracing.NoopTracer{}, repository, nil, nil, "", nil, nil)
+	// Request timeout isn't configured
+	srv, err := New(Config{}, nopLogger{}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.httpServer.Handler)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/feeds/" + feed.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	repository.mu.Lock()
+	deadline := repository.deadline
+	repository.mu.Unlock()
+	if deadline.IsZero() {
+		t.Fatal("request context has no deadline")
+	}
+	if remaining := time.Until(deadline); remaining > defaultRequestTimeout || remaining < defaultRequestTimeout-10*time.Second {
+		t.Errorf("request deadline in %v, want %v", remaining, defaultRequestTimeout)
+	}
+}
+
+func TestGetPublicationFeeds(t *testing.T) {
+	feed := &entity.Feed{ID: uuid.Must(uuid.NewV4()), PublicationUUID: uuid.Must(uuid.NewV4()), URL: "https://example.com/feed.xml"}
+	handler := NewHandler(nopLogger{}, opentracing.NoopTracer{}, &fakeRepository{feed: feed}, nil, nil, "", nil, nil)
+	srv, err := New(Config{}, nopLogger{}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.httpServer.Handler)
+	defer ts.Close()
+
+	tests := []struct {
+		name            string
+		publicationUUID uuid.UUID
+		wantIDs         []uuid.UUID
+	}{
+		{name: "publication with feed", publicationUUID: feed.PublicationUUID, wantIDs: []uuid.UUID{feed.ID}},
+		{name: "publication without feeds", publicationUUID: uuid.Must(uuid.NewV4()), wantIDs: []uuid.UUID{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(ts.URL + "/publications/" + tt.publicationUUID.String() + "/feeds")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+			feeds := []entity.Feed{}
+			if err := json.NewDecoder(resp.Body).Decode(&feeds); err != nil {
+				t.Fatal(err)
+			}
+			ids := []uuid.UUID{}
+			for _, feed := range feeds {
+				ids = append(ids, feed.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("feed ids = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestRefreshAllFeedsSummary(t *testing.T) {
+	enabled := entity.Feed{ID: uuid.Must(uuid.NewV4()), Enabled: true}
+	failing := entity.Feed{ID: uuid.Must(uuid.NewV4()), Enabled: true}
+	disabled := entity.Feed{ID: uuid.Must(uuid.NewV4()), Enabled: false}
+	tests := []struct {
+		name       string
+		failing    uuid.UUID
+		wantStatus int
+		want       processor.RefreshAllSummary
+	}{
+		{name: "all feeds scheduled", wantStatus: http.StatusOK, want: processor.RefreshAllSummary{Total: 3, Disabled: 1, Scheduled: 2}},
+		{name: "some feeds failed", failing: failing.ID, wantStatus: http.StatusMultiStatus, want: processor.RefreshAllSummary{Total: 3, Disabled: 1, Scheduled: 1, Failed: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := &fakeRepository{feeds: []entity.Feed{enabled, failing, disabled}}
+			producer := &fakeProducer{failing: tt.failing}
+			handler := NewHandler(nopLogger{}, opentracing.NoopTracer{}, repository, producer, nil, "", nil, nil)
+			srv, err := New(Config{}, nopLogger{}, handler)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ts := httptest.NewServer(srv.Handler())
+			defer ts.Close()
+
+			req, err := http.NewRequest("PUT", ts.URL+"/refreshFeeds/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			var summary processor.RefreshAllSummary
+			if err := json.NewDecoder(resp.Body).Decode(&summary); err != nil {
+				t.Fatal(err)
+			}
+			if summary != tt.want {
+				t.Errorf("summary = %+v, want %+v", summary, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestValidation(t *testing.T) {
+	specFile := filepath.Join(t.TempDir(), "swagger.json")
+	spec := `{"paths":{"/audit":{"get":{"parameters":[{"name":"limit","in":"query","type":"integer"}]}}}}`
+	if err := ioutil.WriteFile(specFile, []byte(spec), 0644); err != nil {
+		t.Fatal(err)
+	}
+	handler := NewHandler(nopLogger{}, opentracing.NoopTracer{}, &fakeRepository{}, nil, nil, "", nil, nil)
+
+	// Server must not start without validation it is configured with
+	missing := RequestValidationConfig{Enabled: true, SpecFile: filepath.Join(t.TempDir(), "missing.json")}
+	if _, err := New(Config{RequestValidation: missing}, nopLogger{}, handler); err == nil {
+		t.Error("New() with missing spec file error = nil, want error")
+	}
+
+	srv, err := New(Config{RequestValidation: RequestValidationConfig{Enabled: true, SpecFile: specFile}}, nopLogger{}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.httpServer.Handler)
+	defer ts.Close()
+	resp, err := http.Get(ts.URL + "/audit?limit=many")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestItemSnapshotsETag(t *testing.T) {
+	feed := &entity.Feed{ID: uuid.Must(uuid.NewV4()), PublicationUUID: uuid.Must(uuid.NewV4()), URL: "https://example.com/feed.xml"}
+	created := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	repository := &fakeRepository{feed: feed, snapshots: []entity.ItemSnapshot{
+		{FeedID: feed.ID, GUID: "https://example.com/1", Title: "First", PublicationDate: created, CreatedAt: created},
+	}}
+	handler := NewHandler(nopLogger{}, opentracing.NoopTracer{}, repository, nil, nil, "", nil, nil)
+	srv, err := New(Config{}, nopLogger{}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.httpServer.Handler)
+	defer ts.Close()
+	get := func(etag string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest("GET", ts.URL+"/feeds/"+feed.ID.String()+"/snapshots?since=2020-01-01T00:00:00Z", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	etag := get("").Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("response has no ETag")
+	}
+	if resp := get(etag); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("status of unchanged snapshots = %d, want %d", resp.StatusCode, http.StatusNotModified)
+	}
+	// Snapshot changed in place keeps the number of snapshots and the latest creation time
+	repository.mu.Lock()
+	repository.snapshots[0].Title = "First, corrected"
+	repository.mu.Unlock()
+	resp := get(etag)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status of changed snapshots = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if resp.Header.Get("ETag") == etag {
+		t.Error("ETag didn't change with snapshots")
+	}
+}
+
+// denyingURLChecker rejects the denied url
+type denyingURLChecker struct {
+	denied string
+}
+
+func (c denyingURLChecker) CheckURL(ctx context.Context, url string) error {
+	if url == c.denied {
+		return errors.New("host is not allowed")
+	}
+	return nil
+}
+
+func TestCreateFeedWebhookURLPolicy(t *testing.T) {
+	const deniedURL = "http://169.254.169.254/latest/meta-data"
+	handler := NewHandler(nopLogger{}, opentracing.NoopTracer{}, &fakeRepository{}, nil, nil, "", denyingURLChecker{denied: deniedURL}, nil)
+	srv, err := New(Config{}, nopLogger{}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.httpServer.Handler)
+	defer ts.Close()
+
+	body := `{"publication_uuid":"` + uuid.Must(uuid.NewV4()).String() + `","url":"https://example.com/feed.xml","language_code":"en","webhook_url":"` + deniedURL + `"}`
+	resp, err := http.Post(ts.URL+"/feeds/", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestUpdateFeedPublicationUUIDChange(t *testing.T) {
+	feed := &entity.Feed{ID: uuid.Must(uuid.NewV4()), PublicationUUID: uuid.Must(uuid.NewV4()), URL: "https://example.com/feed.xml", LanguageCode: "en", Enabled: true}
+	handler := NewHandler(nopLogger{}, opentracing.NoopTracer{}, &fakeRepository{feed: feed}, nil, nil, "", nil, nil)
+	srv, err := New(Config{}, nopLogger{}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	body := `{"publication_uuid":"` + uuid.Must(uuid.NewV4()).String() + `","url":"` + feed.URL + `"}`
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/feeds/"+feed.ID.String(), strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestUpdateFeedHeadersRedacted(t *testing.T) {
+	const secret = "session=secret"
+	feed := &entity.Feed{ID: uuid.Must(uuid.NewV4()), PublicationUUID: uuid.Must(uuid.NewV4()), URL: "https://example.com/feed.xml", LanguageCode: "en", Enabled: true}
+	repository := &fakeRepository{feed: feed}
+	handler := NewHandler(nopLogger{}, opentracing.NoopTracer{}, repository, nil, nil, "", nil, nil)
+	srv, err := New(Config{}, nopLogger{}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	body := `{"publication_uuid":"` + feed.PublicationUUID.String() + `","url":"` + feed.URL + `","headers":{"Cookie":"` + secret + `"}}`
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/feeds/"+feed.ID.String(), strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	var got FeedResponseBody
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"Cookie": redactedValue}; !reflect.DeepEqual(got.Headers, want) {
+		t.Errorf("response headers = %v, want %v", got.Headers, want)
+	}
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+	if repository.feed.Headers["Cookie"] != secret {
+		t.Errorf("saved Cookie header = %q, want %q", repository.feed.Headers["Cookie"], secret)
+	}
+	if strings.Contains(string(repository.audit.After), secret) {
+		t.Errorf("audit snapshot has header value: %s", repository.audit.After)
+	}
+}
+
+func TestUpdateFeedRedactedHeaderKept(t *testing.T) {
+	feed := &entity.Feed{ID: uuid.Must(uuid.NewV4()), PublicationUUID: uuid.Must(uuid.NewV4()), URL: "https://example.com/feed.xml", LanguageCode: "en", Enabled: true,
+		Headers: map[string]string{"Cookie": "session=secret", "Referer": "https://example.com/"}}
+	repository := &fakeRepository{feed: feed}
+	handler := NewHandler(nopLogger{}, opentracing.NoopTracer{}, repository, nil, nil, "", nil, nil)
+	srv, err := New(Config{}, nopLogger{}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	// Headers are sent back as returned by API, with Referer changed
+	body := `{"publication_uuid":"` + feed.PublicationUUID.String() + `","url":"` + feed.URL + `","headers":{"Cookie":"` + redactedValue + `","Referer":"https://example.com/news"}}`
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/feeds/"+feed.ID.String(), strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+	want := map[string]string{"Cookie": "session=secret", "Referer": "https://example.com/news"}
+	if !reflect.DeepEqual(repository.feed.Headers, want) {
+		t.Errorf("saved headers = %v, want %v", repository.feed.Headers, want)
+	}
+}
+
+func TestRefreshFeedForceNotCached(t *testing.T) {
+	feed := &entity.Feed{ID: uuid.Must(uuid.NewV4()), PublicationUUID: uuid.Must(uuid.NewV4()), URL: "https://example.com/feed.xml", Enabled: true}
+	producer := &fakeProducer{}
+	handler := NewHandler(nopLogger{}, opentracing.NoopTracer{}, &fakeRepository{feed: feed}, producer, nil, "", nil, nil)
+	srv, err := New(Config{}, nopLogger{}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	// Forced refresh follows plain one within cache time
+	for _, query := range []string{"", "?force=true"} {
+		req, err := http.NewRequest(http.MethodPut, ts.URL+"/refreshFeeds/"+feed.ID.String()+"/"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusNoContent)
+		}
+	}
+	producer.mu.Lock()
+	defer producer.mu.Unlock()
+	if want := []uuid.UUID{feed.ID}; !reflect.DeepEqual(producer.forced, want) {
+		t.Errorf("forced refreshes = %v, want %v", producer.forced, want)
+	}
+}
@@ -0,0 +1,329 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofrs/uuid"
+)
+
+// defaultSpecFile is the swagger spec generated by go:generate in cmd/feeds-api and shipped with Swagger-UI
+const defaultSpecFile = "swaggerui/swagger.json"
+
+// maxValidationErrors reported in single response
+const maxValidationErrors = 10
+
+// RequestValidationConfig enables validation of requests against swagger spec
+type RequestValidationConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// SpecFile is swagger 2.0 JSON spec, swaggerui/swagger.json in working directory if not set
+	SpecFile string `mapstructure:"spec_file"`
+}
+
+// Validate checks that spec file can be loaded, if validation is enabled
+func (c RequestValidationConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	_, err := loadSpecValidator(c.specFile())
+	return err
+}
+
+func (c RequestValidationConfig) specFile() string {
+	if c.SpecFile == "" {
+		return defaultSpecFile
+	}
+	return c.SpecFile
+}
+
+// specSchema is the subset of JSON schema used in swagger spec generated from code annotations
+type specSchema struct {
+	Ref                  string                 `json:"$ref"`
+	Type                 string                 `json:"type"`
+	Format               string                 `json:"format"`
+	Required             []string               `json:"required"`
+	Properties           map[string]*specSchema `json:"properties"`
+	AdditionalProperties *schemaOrBool          `json:"additionalProperties"`
+	Items                *specSchema            `json:"items"`
+	AllOf                []*specSchema          `json:"allOf"`
+	Enum                 []interface{}          `json:"enum"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Pattern              string                 `json:"pattern"`
+}
+
+// schemaOrBool is additionalProperties, which is either schema or boolean. Boolean isn't used for validation here
+type schemaOrBool struct {
+	schema *specSchema
+}
+
+func (s *schemaOrBool) UnmarshalJSON(data []byte) error {
+	if string(data) == "true" || string(data) == "false" {
+		return nil
+	}
+	s.schema = &specSchema{}
+	return json.Unmarshal(data, s.schema)
+}
+
+type specParameter struct {
+	specSchema
+	Name     string      `json:"name"`
+	In       string      `json:"in"`
+	Required bool        `json:"required"`
+	Schema   *specSchema `json:"schema"`
+}
+
+type specOperation struct {
+	Parameters []*specParameter `json:"parameters"`
+}
+
+type spec struct {
+	Paths       map[string]map[string]*specOperation `json:"paths"`
+	Definitions map[string]*specSchema               `json:"definitions"`
+}
+
+// specRoute is spec path compiled to match request paths
+type specRoute struct {
+	pattern    *regexp.Regexp
+	operations map[string]*specOperation
+}
+
+// specValidator validates requests against operations of swagger spec
+type specValidator struct {
+	routes      []specRoute
+	definitions map[string]*specSchema
+}
+
+var pathParamPattern = regexp.MustCompile(`\{[^}/]+\}`)
+
+func loadSpecValidator(file string) (*specValidator, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading swagger spec, %v", err)
+	}
+	s := spec{}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failure parsing swagger spec %s, %v", file, err)
+	}
+	v := &specValidator{definitions: s.Definitions}
+	for path, operations := range s.Paths {
+		// QuoteMeta escapes braces, so path parameters are replaced after unescaping them back
+		pattern := "^" + pathParamPattern.ReplaceAllString(strings.NewReplacer(`\{`, "{", `\}`, "}").Replace(regexp.QuoteMeta(path)), `[^/]+`) + "/?$"
+		v.routes = append(v.routes, specRoute{pattern: regexp.MustCompile(pattern), operations: operations})
+	}
+	// Static paths go first, so /feeds/failing isn't matched by /feeds/{feed_id}
+	sort.SliceStable(v.routes, func(i, j int) bool {
+		return strings.Count(v.routes[i].pattern.String(), "[^/]+") < strings.Count(v.routes[j].pattern.String(), "[^/]+")
+	})
+	return v, nil
+}
+
+// operation returns spec operation of the request, nil if it's not in spec
+func (v *specValidator) operation(r *http.Request) *specOperation {
+	for _, route := range v.routes {
+		if route.pattern.MatchString(r.URL.Path) {
+			return route.operations[strings.ToLower(r.Method)]
+		}
+	}
+	return nil
+}
+
+// middlewareRequestValidator rejects requests, which query parameters or JSON body don't match swagger spec.
+// Requests to paths and methods missing from spec are passed as is. Handlers still validate domain rules.
+func middlewareRequestValidator(v *specValidator) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			operation := v.operation(r)
+			if operation == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			errs, err := v.validateRequest(r, operation)
+			if err != nil {
+				ErrUnprocessable(err).Render(w, r)
+				return
+			}
+			if len(errs) > 0 {
+				if len(errs) > maxValidationErrors {
+					errs = errs[:maxValidationErrors]
+				}
+				ErrUnprocessable(errors.New(strings.Join(errs, "; "))).Render(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// validateRequest returns validation errors of query parameters and body, body is restored for handler
+func (v *specValidator) validateRequest(r *http.Request, operation *specOperation) ([]string, error) {
+	errs := []string{}
+	query := r.URL.Query()
+	for _, param := range operation.Parameters {
+		switch param.In {
+		case "query":
+			value := query.Get(param.Name)
+			if value == "" {
+				if param.Required {
+					errs = append(errs, fmt.Sprintf("query parameter '%s' is required", param.Name))
+				}
+				continue
+			}
+			errs = append(errs, v.validateQueryValue(param, value)...)
+		case "body":
+			if param.Schema == nil || r.Body == nil {
+				continue
+			}
+			data, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				return nil, err
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(data))
+			if len(bytes.TrimSpace(data)) == 0 {
+				if param.Required {
+					errs = append(errs, "request body is required")
+				}
+				continue
+			}
+			decoder := json.NewDecoder(bytes.NewReader(data))
+			decoder.UseNumber()
+			var body interface{}
+			if err := decoder.Decode(&body); err != nil {
+				return nil, fmt.Errorf("request body is not valid JSON, %v", err)
+			}
+			errs = append(errs, v.validateValue(param.Schema, body, "body")...)
+		}
+	}
+	return errs, nil
+}
+
+func (v *specValidator) validateQueryValue(param *specParameter, value string) []string {
+	var typed interface{} = value
+	switch param.Type {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return []string{fmt.Sprintf("query parameter '%s' must be %s", param.Name, param.Type)}
+		}
+		typed = json.Number(value)
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return []string{fmt.Sprintf("query parameter '%s' must be boolean", param.Name)}
+		}
+		typed = b
+	}
+	return v.validateValue(&param.specSchema, typed, param.Name)
+}
+
+// validateValue validates decoded JSON value against schema, path names the value in errors
+func (v *specValidator) validateValue(schema *specSchema, value interface{}, path string) []string {
+	if schema.Ref != "" {
+		definition, ok := v.definitions[strings.TrimPrefix(schema.Ref, "#/definitions/")]
+		if !ok {
+			return nil
+		}
+		schema = definition
+	}
+	errs := []string{}
+	for _, part := range schema.AllOf {
+		errs = append(errs, v.validateValue(part, value, path)...)
+	}
+	if value == nil {
+		// Nullable fields are common in generated spec, absence is checked by required
+		return errs
+	}
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return append(errs, fmt.Sprintf("%s must be object", path))
+		}
+		for _, name := range schema.Required {
+			if _, ok := object[name]; !ok {
+				errs = append(errs, fmt.Sprintf("%s.%s is required", path, name))
+			}
+		}
+		for name, fieldValue := range object {
+			if property, ok := schema.Properties[name]; ok {
+				errs = append(errs, v.validateValue(property, fieldValue, path+"."+name)...)
+			} else if schema.AdditionalProperties != nil && schema.AdditionalProperties.schema != nil {
+				errs = append(errs, v.validateValue(schema.AdditionalProperties.schema, fieldValue, path+"."+name)...)
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return append(errs, fmt.Sprintf("%s must be array", path))
+		}
+		if schema.Items != nil {
+			for i, item := range array {
+				errs = append(errs, v.validateValue(schema.Items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return append(errs, fmt.Sprintf("%s must be string", path))
+		}
+		if schema.MinLength != nil && len(s) < *schema.MinLength {
+			errs = append(errs, fmt.Sprintf("%s must be at least %d characters", path, *schema.MinLength))
+		}
+		if schema.MaxLength != nil && len(s) > *schema.MaxLength {
+			errs = append(errs, fmt.Sprintf("%s must be at most %d characters", path, *schema.MaxLength))
+		}
+		if schema.Pattern != "" {
+			if re, err := regexp.Compile(schema.Pattern); err == nil && !re.MatchString(s) {
+				errs = append(errs, fmt.Sprintf("%s must match %s", path, schema.Pattern))
+			}
+		}
+		if schema.Format == "uuid" {
+			if _, err := uuid.FromString(s); err != nil {
+				errs = append(errs, fmt.Sprintf("%s must be UUID", path))
+			}
+		}
+	case "integer", "number":
+		n, ok := value.(json.Number)
+		if !ok {
+			return append(errs, fmt.Sprintf("%s must be %s", path, schema.Type))
+		}
+		if schema.Type == "integer" {
+			if _, err := n.Int64(); err != nil {
+				return append(errs, fmt.Sprintf("%s must be integer", path))
+			}
+		}
+		f, _ := n.Float64()
+		if schema.Minimum != nil && f < *schema.Minimum {
+			errs = append(errs, fmt.Sprintf("%s must be at least %v", path, *schema.Minimum))
+		}
+		if schema.Maximum != nil && f > *schema.Maximum {
+			errs = append(errs, fmt.Sprintf("%s must be at most %v", path, *schema.Maximum))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return append(errs, fmt.Sprintf("%s must be boolean", path))
+		}
+	}
+	if len(schema.Enum) > 0 && !inEnum(schema.Enum, value) {
+		errs = append(errs, fmt.Sprintf("%s must be one of %v", path, schema.Enum))
+	}
+	return errs
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, allowed := range enum {
+		if fmt.Sprint(allowed) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
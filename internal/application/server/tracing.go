@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// middlewareTracing starts span of every request, continuing trace of the caller if request has its headers.
+// Span is named by method and route pattern and records method, URL and status code actually written.
+// Handlers annotate the span of request context with business events.
+func middlewareTracing(tracer opentracing.Tracer) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// we ignore error since if there are missing headers it will start new trace
+			spanContext, _ := tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header))
+			span := tracer.StartSpan("HTTP "+r.Method, ext.RPCServerOption(spanContext))
+			defer span.Finish()
+			ext.Component.Set(span, "httpServer-chi")
+			ext.HTTPMethod.Set(span, r.Method)
+			ext.HTTPUrl.Set(span, r.URL.String())
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(opentracing.ContextWithSpan(r.Context(), span)))
+			// Route pattern is complete only after routing is done
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				span.SetOperationName("HTTP " + r.Method + " " + rctx.RoutePattern())
+			}
+			status := ww.Status()
+			// Handler, which didn't write anything, is responded with 200 by net/http
+			if status == 0 {
+				status = http.StatusOK
+			}
+			ext.HTTPStatusCode.Set(span, uint16(status))
+			if status >= http.StatusInternalServerError {
+				ext.Error.Set(span, true)
+			}
+		})
+	}
+}
+
+// requestSpan returns span of request started by tracing middleware and request context.
+// Request served without the middleware gets noop span.
+func requestSpan(r *http.Request) (opentracing.Span, context.Context) {
+	ctx := r.Context()
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		return span, ctx
+	}
+	return opentracing.NoopTracer{}.StartSpan(""), ctx
+}
@@ -0,0 +1,89 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// HealthConfig defines worker health endpoints server
+type HealthConfig struct {
+	Address string `mapstructure:"address"`
+	// StalenessWindow in seconds, worker processing messages without successfully processing any of them
+	// for this long is not ready. Should exceed processing message timeout. 600 if not set
+	StalenessWindow int `mapstructure:"staleness_window"`
+}
+
+// defaultStalenessWindow of messages processing
+const defaultStalenessWindow = 10 * time.Minute
+
+// healthCheckTimeout bounds database check of readiness probe
+const healthCheckTimeout = 5 * time.Second
+
+// Validate checks health server configuration
+func (c *HealthConfig) Validate() error {
+	if c.Address == "" {
+		return errors.New("address must be set")
+	}
+	if c.StalenessWindow < 0 {
+		return errors.New("staleness_window must not be negative")
+	}
+	return nil
+}
+
+func (c *HealthConfig) stalenessWindow() time.Duration {
+	if c.StalenessWindow > 0 {
+		return time.Duration(c.StalenessWindow) * time.Second
+	}
+	return defaultStalenessWindow
+}
+
+// ConsumerHealth reports messages consumption state
+type ConsumerHealth interface {
+	Connected() bool
+	Stalled(window time.Duration) bool
+}
+
+// DatabasePinger checks database connection
+type DatabasePinger interface {
+	Ping(ctx context.Context) error
+}
+
+// NewHealthServer creates server of /livez (process is up) and /readyz (consumer is connected to nsqd,
+// database is reachable and messages processing is not stuck) endpoints for Kubernetes probes
+func NewHealthServer(config *HealthConfig, consumer ConsumerHealth, db DatabasePinger, logger Logger) *http.Server {
+	window := config.stalenessWindow()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livez", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("."))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		defer cancel()
+		var failure string
+		switch {
+		case !consumer.Connected():
+			failure = "Consumer is not connected to nsqd"
+		case consumer.Stalled(window):
+			failure = "Messages processing is stalled"
+		default:
+			if err := db.Ping(ctx); err != nil {
+				logger.Error("Readiness check: database ping failed with: ", err)
+				failure = "Database is unavailable"
+			}
+		}
+		if failure != "" {
+			logger.Warn("Readiness check failed: ", failure)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(failure))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("."))
+	})
+	return &http.Server{Addr: config.Address, Handler: mux}
+}
@@ -8,16 +8,26 @@ import (
 
 type MessageConsumer interface {
 	Start() error
-	Stop()
+	// Stop stops receiving messages and waits for in-flight messages to be processed
+	Stop() error
+}
+
+// Closer releases worker dependency on shutdown
+type Closer struct {
+	Name  string
+	Close func() error
 }
 
 type Worker struct {
 	consumer MessageConsumer
+	closers  []Closer
 	logger   Logger
 }
 
-func New(consumer MessageConsumer, logger Logger) *Worker {
-	return &Worker{consumer: consumer, logger: logger}
+// New creates worker. Closers are called in the given order after consumer is stopped and in-flight messages are drained,
+// so dependencies used by message processing (e.g. producer, database, tracer) must be listed after their users.
+func New(consumer MessageConsumer, closers []Closer, logger Logger) *Worker {
+	return &Worker{consumer: consumer, closers: closers, logger: logger}
 }
 
 // Start launches worker
@@ -25,6 +35,7 @@ func (w *Worker) Start() error {
 	// TODO: error handling
 	if err := w.consumer.Start(); err != nil {
 		w.logger.Error("Failure starting consumer: ", err)
+		w.close()
 		return err
 	}
 	w.logger.Info("Started consumer")
@@ -42,8 +53,26 @@ func (w *Worker) Start() error {
 	return w.Stop()
 }
 
+// Stop shuts worker down: stops consuming, drains in-flight messages, then closes dependencies in order
 func (w *Worker) Stop() error {
-	w.consumer.Stop()
-	w.logger.Info("Stopped consumer")
-	return nil
+	err := w.consumer.Stop()
+	if err != nil {
+		// Dependencies are closed anyway, remaining messages are redelivered by nsqd after their timeout
+		w.logger.Error("Failure draining consumer: ", err)
+	} else {
+		w.logger.Info("Stopped consumer")
+	}
+	w.close()
+	return err
+}
+
+// close calls closers in order, failure of one doesn't prevent closing the rest
+func (w *Worker) close() {
+	for _, c := range w.closers {
+		if err := c.Close(); err != nil {
+			w.logger.Error("Failure closing ", c.Name, ": ", err)
+			continue
+		}
+		w.logger.Info("Closed ", c.Name)
+	}
 }
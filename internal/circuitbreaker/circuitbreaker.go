@@ -0,0 +1,150 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrOpen is returned when calls are short-circuited by open breaker
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State of the circuit breaker
+type State int
+
+const (
+	// Closed breaker lets all calls through
+	Closed State = iota
+	// Open breaker rejects all calls until open timeout passes
+	Open
+	// HalfOpen breaker lets single probe call through to check recovery
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// Config defines circuit breaker configuration
+type Config struct {
+	// FailureThreshold is the number of consecutive failures to open the breaker, 0 disables breaker
+	FailureThreshold int `mapstructure:"failure_threshold"`
+	// OpenTimeout is the time in seconds to keep breaker open before probing
+	OpenTimeout int `mapstructure:"open_timeout"`
+}
+
+// CircuitBreaker counts consecutive failures of the guarded calls and short-circuits them when threshold is reached
+type CircuitBreaker struct {
+	mu               sync.Mutex
+	state            State
+	failures         int
+	openedAt         time.Time
+	failureThreshold int
+	openTimeout      time.Duration
+}
+
+// New creates circuit breaker in closed state
+func New(config *Config) *CircuitBreaker {
+	return &CircuitBreaker{
+		failureThreshold: config.FailureThreshold,
+		openTimeout:      time.Duration(config.OpenTimeout) * time.Second,
+	}
+}
+
+// Allow returns ErrOpen if the call must be short-circuited.
+// After open timeout passes, single probe call is allowed in half-open state.
+func (cb *CircuitBreaker) Allow() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state {
+	case Open:
+		if time.Since(cb.openedAt) < cb.openTimeout {
+			return ErrOpen
+		}
+		cb.state = HalfOpen
+		return nil
+	case HalfOpen:
+		// probe is already in flight
+		return ErrOpen
+	default:
+		return nil
+	}
+}
+
+// Success records successful call and closes the breaker
+func (cb *CircuitBreaker) Success() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.failures = 0
+	cb.state = Closed
+}
+
+// Failure records failed call, returns true if the breaker has been tripped open by this failure
+func (cb *CircuitBreaker) Failure() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.failures++
+	if cb.state == HalfOpen || (cb.state == Closed && cb.failures >= cb.failureThreshold) {
+		cb.state = Open
+		cb.openedAt = time.Now()
+		return true
+	}
+	return false
+}
+
+// State returns current breaker state
+func (cb *CircuitBreaker) State() State {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+// Registry keeps separate circuit breakers per key (e.g. host), created on first use with shared configuration
+type Registry struct {
+	mu       sync.Mutex
+	config   Config
+	breakers map[string]*CircuitBreaker
+}
+
+// NewRegistry creates registry of keyed circuit breakers
+func NewRegistry(config *Config) *Registry {
+	return &Registry{
+		config:   *config,
+		breakers: map[string]*CircuitBreaker{},
+	}
+}
+
+func (r *Registry) get(key string) *CircuitBreaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cb, ok := r.breakers[key]
+	if !ok {
+		cb = New(&r.config)
+		r.breakers[key] = cb
+	}
+	return cb
+}
+
+// Allow returns ErrOpen if the call for key must be short-circuited
+func (r *Registry) Allow(key string) error {
+	return r.get(key).Allow()
+}
+
+// Success records successful call for key
+func (r *Registry) Success(key string) {
+	r.get(key).Success()
+}
+
+// Failure records failed call for key, returns true if the breaker for key has been tripped open
+func (r *Registry) Failure(key string) bool {
+	return r.get(key).Failure()
+}
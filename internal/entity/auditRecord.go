@@ -0,0 +1,37 @@
+package entity
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// Audit record actions
+const (
+	AuditActionCreate = "create"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+)
+
+// AuditRecord is a record of feed change, kept for audit trail
+// swagger:model
+type AuditRecord struct {
+	ID              int64     `json:"id"`
+	Action          string    `json:"action"`
+	PublicationUUID uuid.UUID `json:"publication_uuid"`
+	// FeedID of the changed feed, records of deleted feed keep it
+	FeedID uuid.UUID `json:"feed_id"`
+	// Before is feed snapshot before the change, empty for created feed
+	Before json.RawMessage `json:"before,omitempty"`
+	// After is feed snapshot after the change, empty for deleted feed
+	After json.RawMessage `json:"after,omitempty"`
+	// Actor is identity of the caller, who made the change
+	Actor     string    `json:"actor"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (a *AuditRecord) String() string {
+	return fmt.Sprintf("Action: %s, PublicationUUID: %v, Actor: %s, Created At: %v", a.Action, a.PublicationUUID, a.Actor, a.CreatedAt)
+}
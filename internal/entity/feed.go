@@ -1,32 +1,125 @@
 package entity
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/gofrs/uuid"
 )
 
+// ErrFeedExists is returned on creation of feed for publication, which already has feed with the same url
+var ErrFeedExists = errors.New("feed already exists")
+
 // Feed defines minimal feed type
 // swagger:model
 type Feed struct {
-	// PublicationUUID that owns this feed (since publication uuid is one to one mapping, no need for other ID as DB serial key)
+	// ID of the feed, generated on creation
+	ID uuid.UUID `json:"id"`
+	// PublicationUUID that owns this feed, publication may have several feeds with different urls
 	PublicationUUID uuid.UUID `json:"publication_uuid"`
 	// URL of the feed
 	// TODO: separate type, validation (value object)
 	URL          string `json:"url"`
 	LanguageCode string `json:"language_code"`
+	// WebhookURL is optional endpoint to notify about new items in the feed
+	WebhookURL string `json:"webhook_url,omitempty"`
+	// Headers are extra HTTP headers sent with every fetch of this feed, e.g. Referer or Cookie.
+	// Values may hold credentials, so API returns header names only, with redacted values, and only for single feed.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Login is optional request executed before each fetch to get session cookie, see FeedLogin
+	Login *FeedLogin `json:"login,omitempty"`
+	// Filter selects items to publish, all items are published if not set
+	Filter *FeedFilter `json:"filter,omitempty"`
+	// MaxItemsPerRefresh overrides worker wide cap of new items published per feed refresh
+	MaxItemsPerRefresh *int `json:"max_items_per_refresh,omitempty"`
+	// FetchTimeout in seconds overrides worker wide fetch timeout of this feed
+	FetchTimeout *int `json:"fetch_timeout,omitempty"`
+	// LastItemPublished is the most recent publication date of the items seen in this feed, nil if nothing was processed yet
+	LastItemPublished *time.Time `json:"last_item_published,omitempty"`
+	// ConsecutiveFailures is the number of feed refreshes failed in a row, reset on success
+	ConsecutiveFailures int `json:"consecutive_failures"`
+	// LastError is the error of the last failed refresh, empty after successful refresh
+	LastError string `json:"last_error,omitempty"`
+	// LastFetched is the time of the last feed fetch attempt, nil if feed was never fetched
+	LastFetched *time.Time `json:"last_fetched,omitempty"`
+	// LastFetchDurationMs is fetch and parse time of the last successful refresh, in milliseconds
+	LastFetchDurationMs *int `json:"last_fetch_duration_ms,omitempty"`
+	// Enabled feeds are refreshed, disabled are skipped
+	Enabled bool `json:"enabled"`
+	// DisabledReason tells who disabled the feed, see FeedDisabledReason* constants
+	DisabledReason string `json:"disabled_reason,omitempty"`
+	// QuarantineReason is set for feeds quarantined for manual review, see FeedQuarantineReason* constants.
+	// Quarantined feeds are not refreshed automatically.
+	QuarantineReason string `json:"quarantine_reason,omitempty"`
+	// QuarantinedAt is the time feed was quarantined, nil if feed is not quarantined
+	QuarantinedAt *time.Time `json:"quarantined_at,omitempty"`
+}
+
+// FeedLogin defines login request for feeds behind cookie session auth.
+// Session cookies set by login response (following redirects) are sent with the feed request of the same fetch.
+type FeedLogin struct {
+	// URL of the login form handler
+	URL string `json:"url"`
+	// Method is POST (form fields in urlencoded body, default) or GET (form fields in query)
+	Method string `json:"method,omitempty"`
+	// Fields are login form fields, e.g. username and password
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// FeedFilter selects feed items to publish.
+// Filter is applied to new items only, after processed items check, and filtered out items don't count to items cap.
+type FeedFilter struct {
+	// Include, if not empty, publishes only items matching any of its rules
+	Include []FeedFilterRule `json:"include,omitempty"`
+	// Exclude skips items matching any of its rules, takes precedence over Include
+	Exclude []FeedFilterRule `json:"exclude,omitempty"`
+	// RecordFiltered saves filtered out items as processed, so they are not reconsidered on later refreshes,
+	// even if filter changes. Otherwise filtered out items are checked again on every refresh while they are in the feed.
+	RecordFiltered bool `json:"record_filtered,omitempty"`
+}
+
+// FeedFilterRule matches item field against pattern
+type FeedFilterRule struct {
+	// Field is one of FeedFilterField* constants
+	Field string `json:"field"`
+	// Pattern is regular expression for title and content, category name (case insensitive) for category
+	Pattern string `json:"pattern"`
 }
 
+// Feed filter rule fields
+const (
+	// FeedFilterFieldTitle matches item title
+	FeedFilterFieldTitle = "title"
+	// FeedFilterFieldContent matches item description or content
+	FeedFilterFieldContent = "content"
+	// FeedFilterFieldCategory matches any of item categories
+	FeedFilterFieldCategory = "category"
+)
+
+const (
+	// FeedDisabledReasonManual is set for feeds disabled via API
+	FeedDisabledReasonManual = "manual"
+	// FeedDisabledReasonFailures is set for feeds disabled by worker after sustained refresh failures
+	FeedDisabledReasonFailures = "consecutive_failures"
+)
+
+const (
+	// FeedQuarantineReasonEmpty is set for feeds, which returned no items for several refreshes in a row
+	FeedQuarantineReasonEmpty = "empty_refreshes"
+	// FeedQuarantineReasonContentType is set for feeds, which changed response content type
+	FeedQuarantineReasonContentType = "content_type_changed"
+)
+
 func (f *Feed) String() string {
-	return fmt.Sprintf("PublicationUUID: %v, URL: %s, Language: %s", f.PublicationUUID, f.URL, f.LanguageCode)
+	return fmt.Sprintf("ID: %v, PublicationUUID: %v, URL: %s, Language: %s, Last item published: %v", f.ID, f.PublicationUUID, f.URL, f.LanguageCode, f.LastItemPublished)
 }
 
 // FeeFeedHTTPMetadata is used during feed retrieval and parsing
 type FeedHTTPMetadata struct {
-	PublicationUUID uuid.UUID `json:"publication_uuid"`
-	LastModified    time.Time `json:"last_modified"`
-	ETag            string    `json:"etag"`
+	FeedID       uuid.UUID `json:"feed_id"`
+	LastModified time.Time `json:"last_modified"`
+	ETag         string    `json:"etag"`
 }
 
 func (f *FeedHTTPMetadata) String() string {
@@ -0,0 +1,38 @@
+package entity
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// Feed refresh outcomes since the date
+const (
+	// RefreshOutcomePending means feed wasn't fetched since the date yet
+	RefreshOutcomePending = "pending"
+	// RefreshOutcomeSucceeded means the last fetch since the date succeeded
+	RefreshOutcomeSucceeded = "succeeded"
+	// RefreshOutcomeFailed means the last fetch since the date failed
+	RefreshOutcomeFailed = "failed"
+	// RefreshOutcomeSkipped means feed is disabled or quarantined, so it isn't refreshed automatically
+	RefreshOutcomeSkipped = "skipped"
+)
+
+// FeedRefreshStatus tells if feed is refreshed since the date
+// swagger:model
+type FeedRefreshStatus struct {
+	FeedID          uuid.UUID `json:"feed_id"`
+	PublicationUUID uuid.UUID `json:"publication_uuid"`
+	URL             string    `json:"url"`
+	// Outcome is one of "pending", "succeeded", "failed" or "skipped"
+	Outcome     string     `json:"outcome"`
+	LastFetched *time.Time `json:"last_fetched,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	// NewItems is the number of items processed since the date
+	NewItems int64 `json:"new_items"`
+}
+
+func (s *FeedRefreshStatus) String() string {
+	return fmt.Sprintf("FeedID: %v, Outcome: %s, Last Fetched: %v, New Items: %d", s.FeedID, s.Outcome, s.LastFetched, s.NewItems)
+}
@@ -0,0 +1,34 @@
+package entity
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// FeedStats are aggregate numbers of feed processing
+// swagger:model
+type FeedStats struct {
+	FeedID          uuid.UUID `json:"feed_id"`
+	PublicationUUID uuid.UUID `json:"publication_uuid"`
+	// ProcessedItems is the total number of processed items, including filtered out and duplicate ones
+	ProcessedItems int64 `json:"processed_items"`
+	// ProcessedItemsLastDay is the number of items processed in the last 24 hours
+	ProcessedItemsLastDay int64 `json:"processed_items_last_day"`
+	// ProcessedItemsLastWeek is the number of items processed in the last 7 days
+	ProcessedItemsLastWeek int64 `json:"processed_items_last_week"`
+	// ProcessedItemsLastMonth is the number of items processed in the last 30 days
+	ProcessedItemsLastMonth int64      `json:"processed_items_last_month"`
+	LastFetched             *time.Time `json:"last_fetched,omitempty"`
+	LastItemPublished       *time.Time `json:"last_item_published,omitempty"`
+	ConsecutiveFailures     int        `json:"consecutive_failures"`
+	LastError               string     `json:"last_error,omitempty"`
+	// ConsecutiveEmptyRefreshes is the number of successful refreshes in a row, which returned no items.
+	// It is tracked only if feeds quarantine heuristics are enabled in worker
+	ConsecutiveEmptyRefreshes int `json:"consecutive_empty_refreshes"`
+}
+
+func (s *FeedStats) String() string {
+	return fmt.Sprintf("FeedID: %v, Processed Items: %d, Last Fetched: %v, Consecutive Failures: %d", s.FeedID, s.ProcessedItems, s.LastFetched, s.ConsecutiveFailures)
+}
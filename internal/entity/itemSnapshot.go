@@ -0,0 +1,28 @@
+package entity
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// ItemSnapshot is a record of item published to Items service, kept for auditing
+// swagger:model
+type ItemSnapshot struct {
+	FeedID          uuid.UUID `json:"feed_id"`
+	PublicationUUID uuid.UUID `json:"publication_uuid"`
+	GUID            string    `json:"guid"`
+	Title           string    `json:"title"`
+	URL             string    `json:"url"`
+	// ContentHash is hex SHA256 of published description and content
+	ContentHash     string    `json:"content_hash"`
+	LanguageCode    string    `json:"language_code"`
+	PublicationDate time.Time `json:"publication_date"`
+	// CreatedAt is the time item was published
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (i *ItemSnapshot) String() string {
+	return fmt.Sprintf("PublicationUUID: %v, GUID: %s, Title: %s, Publication Date: %v", i.PublicationUUID, i.GUID, i.Title, i.PublicationDate)
+}
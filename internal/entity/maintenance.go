@@ -0,0 +1,19 @@
+package entity
+
+import (
+	"fmt"
+	"time"
+)
+
+// Maintenance is the service maintenance mode state, which rejects feed changes and pauses refreshes
+// swagger:model
+type Maintenance struct {
+	Enabled bool `json:"enabled"`
+	// Reason is shown to clients of rejected requests
+	Reason    string    `json:"reason"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (m *Maintenance) String() string {
+	return fmt.Sprintf("Enabled: %v, Reason: %s, Updated At: %v", m.Enabled, m.Reason, m.UpdatedAt)
+}
@@ -1,18 +1,26 @@
 package entity
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/gofrs/uuid"
 )
 
+// ErrProcessedItemExists is returned on creation of processed item, which GUID is already processed
+var ErrProcessedItemExists = errors.New("item is already processed")
+
 // ProcessedItem defines already processed items from the feed
 type ProcessedItem struct {
-	// PublicationUUID that owns this feed (since publication uuid is one to one mapping, no need for int ID as DB serial key)
+	// PublicationUUID that owns the feed, items are deduplicated across feeds of the publication
 	PublicationUUID uuid.UUID `json:"publication_uuid"`
+	// FeedID of the feed item was processed from
+	FeedID          uuid.UUID `json:"feed_id"`
 	GUID            string    `json:"guid"`
 	PublicationDate time.Time `json:"publication_date"`
+	// DedupKey identifies item across feeds (normalized url or content hash), empty if global dedup is disabled
+	DedupKey string `json:"dedup_key,omitempty"`
 }
 
 func (i *ProcessedItem) String() string {
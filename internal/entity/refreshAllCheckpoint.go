@@ -0,0 +1,21 @@
+package entity
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// RefreshAllCheckpoint is the progress of unfinished refresh of all feeds
+type RefreshAllCheckpoint struct {
+	// LastFeedID is the last feed handled by refresh, feeds are handled in feed ID order
+	LastFeedID uuid.UUID
+	// StartedAt is the start of the refresh, which checkpoint belongs to
+	StartedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (c *RefreshAllCheckpoint) String() string {
+	return fmt.Sprintf("Last Feed ID: %s, Started At: %v, Updated At: %v", c.LastFeedID, c.StartedAt, c.UpdatedAt)
+}
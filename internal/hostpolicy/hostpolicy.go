@@ -0,0 +1,218 @@
+package hostpolicy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrHostNotAllowed is returned for hosts and addresses rejected by policy
+var ErrHostNotAllowed = errors.New("host is not allowed")
+
+// Config defines which hosts feeds can be fetched from
+type Config struct {
+	// AllowedHosts restricts fetches to these hosts and their subdomains, empty allows all hosts
+	AllowedHosts []string `mapstructure:"allowed_hosts"`
+	// DeniedHosts are never fetched, including their subdomains, takes precedence over AllowedHosts
+	DeniedHosts []string `mapstructure:"denied_hosts"`
+	// AllowPrivateNetworks permits loopback, private and link-local addresses, denied by default to prevent SSRF
+	AllowPrivateNetworks bool `mapstructure:"allow_private_networks"`
+	// AllowedNetworks are CIDRs permitted even if they are private, for legitimate internal feeds
+	AllowedNetworks []string `mapstructure:"allowed_networks"`
+}
+
+// Validate checks host policy configuration
+func (c *Config) Validate() error {
+	for _, cidr := range c.AllowedNetworks {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid allowed_networks entry '%s', %v", cidr, err)
+		}
+	}
+	return nil
+}
+
+// Policy checks hosts and addresses against configured lists and private networks
+type Policy struct {
+	allowedHosts         Hosts
+	deniedHosts          Hosts
+	allowPrivateNetworks bool
+	allowedNetworks      []*net.IPNet
+}
+
+// New creates host policy, invalid allowed networks are ignored, check them with Config.Validate
+func New(config *Config) *Policy {
+	allowedNetworks := []*net.IPNet{}
+	for _, cidr := range config.AllowedNetworks {
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			allowedNetworks = append(allowedNetworks, network)
+		}
+	}
+	return &Policy{
+		allowedHosts:         NewHosts(config.AllowedHosts),
+		deniedHosts:          NewHosts(config.DeniedHosts),
+		allowPrivateNetworks: config.AllowPrivateNetworks,
+		allowedNetworks:      allowedNetworks,
+	}
+}
+
+// Hosts is normalized list of host names, matching listed hosts and their subdomains
+type Hosts []string
+
+// NewHosts lowercases hosts and trims spaces and dots around them, empty entries are skipped
+func NewHosts(hosts []string) Hosts {
+	normalized := make(Hosts, 0, len(hosts))
+	for _, host := range hosts {
+		host = strings.Trim(strings.ToLower(strings.TrimSpace(host)), ".")
+		if host != "" {
+			normalized = append(normalized, host)
+		}
+	}
+	return normalized
+}
+
+// Match returns true if host (without port) is one of hosts or their subdomain, case insensitive
+func (hosts Hosts) Match(host string) bool {
+	host = strings.Trim(strings.ToLower(host), ".")
+	for _, h := range hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckHost checks host name (without port) against allowed and denied hosts.
+// IP literals are checked against private networks too.
+func (p *Policy) CheckHost(host string) error {
+	host = strings.Trim(strings.ToLower(host), ".")
+	if p.deniedHosts.Match(host) {
+		return fmt.Errorf("%w: %s is denied", ErrHostNotAllowed, host)
+	}
+	if len(p.allowedHosts) > 0 && !p.allowedHosts.Match(host) {
+		return fmt.Errorf("%w: %s is not in allowed hosts", ErrHostNotAllowed, host)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return p.CheckIP(ip)
+	}
+	return nil
+}
+
+// CheckIP rejects loopback, private, link-local (including cloud metadata) and other non-public addresses,
+// unless private networks are allowed or address is in allowed networks
+func (p *Policy) CheckIP(ip net.IP) error {
+	if p.allowPrivateNetworks || isPublicIP(ip) {
+		return nil
+	}
+	for _, network := range p.allowedNetworks {
+		if network.Contains(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s is not a public address", ErrHostNotAllowed, ip)
+}
+
+// CheckURL checks url scheme, host and all addresses it resolves to.
+// Resolved addresses may change later (DNS rebinding), so fetches must use Transport, which checks dialed addresses.
+func (p *Policy) CheckURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: unsupported url scheme '%s'", ErrHostNotAllowed, u.Scheme)
+	}
+	host := u.Hostname()
+	if err := p.CheckHost(host); err != nil {
+		return err
+	}
+	if p.allowPrivateNetworks || net.ParseIP(host) != nil {
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("couldn't resolve host %s, %v", host, err)
+	}
+	for _, addr := range addrs {
+		if err := p.CheckIP(addr.IP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Transport returns http transport, which enforces policy on every dialed address,
+// so it covers redirects and DNS records changed after validation
+func (p *Policy) Transport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	p.Apply(transport)
+	return transport
+}
+
+// Apply makes transport enforce policy on every dialed address, keeping its connection pooling and TLS settings.
+// Proxy is disabled, since it would dial addresses on our behalf
+func (p *Policy) Apply(transport *http.Transport) {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   p.dialControl,
+	}
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+}
+
+func (p *Policy) dialControl(network string, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("%w: dialed address %s is not an IP", ErrHostNotAllowed, host)
+	}
+	return p.CheckIP(ip)
+}
+
+var nonPublicNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func isPublicIP(ip net.IP) bool {
+	if ip.IsUnspecified() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package itempublish
+
+import (
+	"fmt"
+
+	"github.com/Tarick/naca-items/pkg/itempublisher"
+	"github.com/Tarick/naca-rss-feeds/internal/circuitbreaker"
+	"github.com/Tarick/naca-rss-feeds/internal/messaging/nsqclient/producer"
+	"github.com/Tarick/naca-rss-feeds/internal/processor"
+)
+
+// Logger is used by publishers of log and digest modes
+type Logger interface {
+	Debug(args ...interface{})
+	Info(args ...interface{})
+	Warn(args ...interface{})
+	Error(args ...interface{})
+	Fatal(args ...interface{})
+}
+
+// Publishers publish new items of refreshed feeds according to 'itemPublish' configuration,
+// the same way for worker and synchronous refresh of API
+type Publishers struct {
+	// Item publishes new items one by one, it discards items in digest mode
+	Item processor.ItemPublisherClient
+	// Digest publishes new items of feed refresh in batches, nil unless in digest mode
+	Digest         processor.ItemsDigestPublisher
+	digestProducer interface{ Stop() }
+}
+
+// New creates publishers of configured mode, guarded by circuit breaker if it is configured.
+// Digests go to the same nsqd as refresh messages usually, so connection settings of publishConfig are reused in digest mode.
+// Config must be validated
+func New(config *processor.ItemPublishConfig, publishConfig *producer.MessageProducerConfig, logger Logger) (*Publishers, error) {
+	p := &Publishers{}
+	switch config.Mode {
+	case processor.ItemPublishModeNoop:
+		logger.Warn("Items publishing is in noop mode, new items are discarded")
+		p.Item = processor.NewNoopItemPublisher()
+	case processor.ItemPublishModeLog:
+		logger.Warn("Items publishing is in log mode, new items are logged instead of publishing")
+		p.Item = processor.NewLoggingItemPublisher(logger)
+	case processor.ItemPublishModeDigest:
+		digestProducer, err := producer.New(&producer.MessageProducerConfig{
+			Host:            config.Host,
+			Topic:           config.Topic,
+			MaxMessageSize:  publishConfig.MaxMessageSize,
+			ConnectAttempts: publishConfig.ConnectAttempts,
+			ConnectBackoff:  publishConfig.ConnectBackoff,
+			Security:        publishConfig.Security,
+			Compression:     publishConfig.Compression,
+		}, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failure initialising NSQ producer of items digests, %v", err)
+		}
+		p.digestProducer = digestProducer
+		p.Digest = processor.NewItemsDigestPublisher(digestProducer)
+		// Items are published by digest publisher only
+		p.Item = processor.NewNoopItemPublisher()
+	default:
+		itemPublisherClient, err := itempublisher.New(config.Host, config.Topic)
+		if err != nil {
+			return nil, fmt.Errorf("failure creating itemPublisher client, %v", err)
+		}
+		p.Item = itemPublisherClient
+	}
+	if config.CircuitBreaker.FailureThreshold > 0 {
+		if p.Digest != nil {
+			p.Digest = processor.NewCircuitBreakingItemsDigestPublisher(p.Digest, circuitbreaker.New(&config.CircuitBreaker), logger)
+		} else {
+			p.Item = processor.NewCircuitBreakingItemPublisher(p.Item, circuitbreaker.New(&config.CircuitBreaker), logger)
+		}
+	}
+	return p, nil
+}
+
+// Stop closes connection of digest mode producer
+func (p *Publishers) Stop() {
+	if p.digestProducer != nil {
+		p.digestProducer.Stop()
+	}
+}
@@ -0,0 +1,145 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Tarick/naca-rss-feeds/internal/entity"
+)
+
+// defaultCheckInterval of maintenance state in database
+const defaultCheckInterval = 10 * time.Second
+
+// ErrForcedByConfig is returned on attempt to disable maintenance mode, which is enabled in configuration
+var ErrForcedByConfig = errors.New("maintenance mode is enabled in configuration, disable it there and restart")
+
+// Config defines maintenance mode configuration
+type Config struct {
+	// Enabled forces maintenance mode for this instance regardless of the shared state in database
+	Enabled bool `mapstructure:"enabled"`
+	// Reason of forced maintenance, shown to clients
+	Reason string `mapstructure:"reason"`
+	// CheckInterval in seconds to re-read shared state from database, 10 seconds if not set
+	CheckInterval int `mapstructure:"check_interval"`
+}
+
+// Store keeps maintenance state shared by all instances
+type Store interface {
+	GetMaintenance(context.Context) (*entity.Maintenance, error)
+	SetMaintenance(context.Context, *entity.Maintenance) error
+}
+
+type Logger interface {
+	Info(args ...interface{})
+	Warn(args ...interface{})
+	Error(args ...interface{})
+}
+
+// Mode is runtime togglable maintenance flag.
+// State is stored in database and polled by every instance, so toggling it on one instance propagates
+// to others within check interval. Checks use the cached state and never hit database.
+type Mode struct {
+	config   Config
+	store    Store
+	logger   Logger
+	mu       sync.RWMutex
+	state    entity.Maintenance
+	onChange func(entity.Maintenance)
+}
+
+// New creates maintenance mode, call Start to load and poll shared state
+func New(config *Config, store Store, logger Logger) *Mode {
+	return &Mode{config: *config, store: store, logger: logger}
+}
+
+// OnChange sets function called when maintenance mode is enabled or disabled, set it before Start
+func (m *Mode) OnChange(fn func(entity.Maintenance)) {
+	m.onChange = fn
+}
+
+// Start loads shared state and polls it in background until context is done
+func (m *Mode) Start(ctx context.Context) error {
+	if m.config.Enabled {
+		m.logger.Warn("Maintenance mode is enabled in configuration: ", m.config.Reason)
+	}
+	if err := m.refresh(ctx); err != nil {
+		return err
+	}
+	if m.config.Enabled && m.onChange != nil {
+		m.onChange(m.Get())
+	}
+	interval := time.Duration(m.config.CheckInterval) * time.Second
+	if interval <= 0 {
+		interval = defaultCheckInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := m.refresh(ctx); err != nil {
+					// Keep the last known state
+					m.logger.Error("Failure reading maintenance mode state: ", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Get returns current maintenance state, configuration takes precedence over shared state
+func (m *Mode) Get() entity.Maintenance {
+	if m.config.Enabled {
+		return entity.Maintenance{Enabled: true, Reason: m.config.Reason}
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state
+}
+
+// Set stores shared maintenance state and applies it to this instance immediately
+func (m *Mode) Set(ctx context.Context, enabled bool, reason string) (entity.Maintenance, error) {
+	if m.config.Enabled && !enabled {
+		return m.Get(), ErrForcedByConfig
+	}
+	state := &entity.Maintenance{Enabled: enabled, Reason: reason}
+	if err := m.store.SetMaintenance(ctx, state); err != nil {
+		return m.Get(), err
+	}
+	m.apply(*state)
+	return m.Get(), nil
+}
+
+func (m *Mode) refresh(ctx context.Context) error {
+	state, err := m.store.GetMaintenance(ctx)
+	if err != nil {
+		return err
+	}
+	m.apply(*state)
+	return nil
+}
+
+// apply sets cached state, logging and notifying about the change
+func (m *Mode) apply(state entity.Maintenance) {
+	m.mu.Lock()
+	changed := m.state.Enabled != state.Enabled
+	m.state = state
+	m.mu.Unlock()
+	if !changed {
+		return
+	}
+	if state.Enabled {
+		m.logger.Warn("Maintenance mode is enabled: ", state.Reason)
+	} else {
+		m.logger.Info("Maintenance mode is disabled")
+	}
+	// Forced maintenance doesn't change with shared state
+	if m.onChange != nil && !m.config.Enabled {
+		m.onChange(state)
+	}
+}
@@ -0,0 +1,54 @@
+package compression
+
+import (
+	"fmt"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// Compression algorithms, negotiated with nsqd per connection
+const (
+	None    = ""
+	Deflate = "deflate"
+	Snappy  = "snappy"
+)
+
+// Config defines NSQ connection compression.
+// Compression applies to the whole connection stream, not to single messages, so small messages gain little
+// and cost CPU on both sides. Use it for remote nsqd with bulk traffic.
+type Config struct {
+	// Compression is one of "deflate", "snappy" or empty to disable
+	Compression string `mapstructure:"compression"`
+	// CompressionLevel is deflate level 1-9 (nsqd --max-deflate-level caps it), 0 uses default 6
+	CompressionLevel int `mapstructure:"compression_level"`
+}
+
+// Validate checks compression algorithm and level
+func (c *Config) Validate() error {
+	switch c.Compression {
+	case None, Snappy:
+		if c.CompressionLevel != 0 {
+			return fmt.Errorf("compression_level is only supported with %s compression", Deflate)
+		}
+	case Deflate:
+		if c.CompressionLevel < 0 || c.CompressionLevel > 9 {
+			return fmt.Errorf("compression_level must be between 1 and 9, got %d", c.CompressionLevel)
+		}
+	default:
+		return fmt.Errorf("unsupported compression %q, must be %s or %s", c.Compression, Deflate, Snappy)
+	}
+	return nil
+}
+
+// Apply enables compression on NSQ configuration
+func (c *Config) Apply(nsqConfig *nsq.Config) {
+	switch c.Compression {
+	case Deflate:
+		nsqConfig.Deflate = true
+		if c.CompressionLevel > 0 {
+			nsqConfig.DeflateLevel = c.CompressionLevel
+		}
+	case Snappy:
+		nsqConfig.Snappy = true
+	}
+}
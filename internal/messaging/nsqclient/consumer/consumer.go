@@ -1,25 +1,106 @@
 package consumer
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Tarick/naca-rss-feeds/internal/messaging/nsqclient/compression"
+	"github.com/Tarick/naca-rss-feeds/internal/messaging/nsqclient/security"
+
 	"github.com/nsqio/go-nsq"
 )
 
 // MessageConsumerConfig defines NSQ publish configuration
 type MessageConsumerConfig struct {
 	NSQLookup string `mapstructure:"nsqlookup"`
-	Topic     string `mapstructure:"topic"`
-	Channel   string `mapstructure:"channel"`
-	Prefetch  int    `mapstructure:"prefetch"`
-	Workers   int    `mapstructure:"workers"`
-	Attempts  uint16 `mapstructure:"attempts"`
+	// NSQD is nsqd address for direct connection, used when NSQLookup is not set (e.g. in development setup without nsqlookupd)
+	NSQD     string `mapstructure:"nsqd"`
+	Topic    string `mapstructure:"topic"`
+	Channel  string `mapstructure:"channel"`
+	Prefetch int    `mapstructure:"prefetch"`
+	Workers  int    `mapstructure:"workers"`
+	Attempts uint16 `mapstructure:"attempts"`
+	// RequeueDelay is the base delay in seconds for requeue of failed message, 0 leaves requeue delay to NSQ backoff
+	RequeueDelay int `mapstructure:"requeue_delay"`
+	// RequeueMultiplier grows requeue delay with each attempt, at least 1 if requeue delay is set
+	RequeueMultiplier float64 `mapstructure:"requeue_multiplier"`
+	// RequeueMaxDelay caps requeue delay, in seconds. 0 caps it by nsqd default --max-req-timeout of 1 hour
+	RequeueMaxDelay int `mapstructure:"requeue_max_delay"`
+	// TouchInterval in seconds to extend in-flight message timeout while it is processed, 0 disables touching.
+	// Must be less than nsqd message timeout (60 seconds by default).
+	TouchInterval int `mapstructure:"touch_interval"`
+	// DrainTimeout in seconds to wait on stop for in-flight messages to be processed, 30 seconds if not set
+	DrainTimeout int `mapstructure:"drain_timeout"`
+	// Security defines TLS and nsqd auth, applied to nsqd connections (not to nsqlookupd HTTP queries)
+	Security security.Config `mapstructure:",squash"`
+	// Compression of nsqd connections, set it the same as in producer of the topic to compress messages end to end
+	Compression compression.Config `mapstructure:",squash"`
+}
+
+// Validate checks that consumer has nsqd to connect to
+func (c *MessageConsumerConfig) Validate() error {
+	if c.NSQLookup == "" && c.NSQD == "" {
+		return errors.New("either nsqlookup or nsqd address must be set")
+	}
+	if c.RequeueDelay < 0 {
+		return errors.New("requeue_delay must not be negative")
+	}
+	if c.RequeueDelay > 0 && c.RequeueMultiplier < 1 {
+		return errors.New("requeue_multiplier must be at least 1")
+	}
+	if c.RequeueMaxDelay < 0 || time.Duration(c.RequeueMaxDelay)*time.Second > maxRequeueDelay {
+		return fmt.Errorf("requeue_max_delay must be between 0 and %d", int(maxRequeueDelay/time.Second))
+	}
+	if err := c.Security.Validate(); err != nil {
+		return err
+	}
+	return c.Compression.Validate()
 }
 
 type MessageProcessor interface {
 	Process([]byte) error
 }
+
+// permanent is implemented by processing errors, which won't go away with retries, e.g. malformed message
+type permanent interface {
+	Permanent() bool
+}
+
+// defaultDrainTimeout of in-flight messages on stop
+const defaultDrainTimeout = 30 * time.Second
+
+// maxRequeueDelay is nsqd default --max-req-timeout, longer requeue delay is rejected by nsqd
+const maxRequeueDelay = time.Hour
+
+// pausedRequeueDelay of messages received while consumer is paused
+const pausedRequeueDelay = time.Minute
+
 type messageHandler struct {
-	processor MessageProcessor
-	logger    Logger
+	// lastProcessed is unix time in nanoseconds of the last successfully processed message, or of the start of
+	// processing after idle period. It is first in struct for 64-bit alignment of atomic operations
+	lastProcessed int64
+	// inFlight is the number of messages being processed
+	inFlight int32
+	// paused is set to 1 to requeue messages unprocessed
+	paused            int32
+	processor         MessageProcessor
+	logger            Logger
+	requeueDelay      time.Duration
+	requeueMultiplier float64
+	requeueMaxDelay   time.Duration
+	touchInterval     time.Duration
+	// maxAttempts of message processing, checked by handler instead of go-nsq, so requeues while paused don't count.
+	// 0 means no limit
+	maxAttempts uint16
+	// pausedRequeues counts requeues of messages while consumer was paused, by message ID.
+	// Counts are kept in memory, message requeued while paused and redelivered to other worker counts them as attempts
+	pausedRequeues   map[nsq.MessageID]uint16
+	pausedRequeuesMu sync.Mutex
 }
 
 // HandleMessage implements the Handler interface.
@@ -29,48 +110,200 @@ func (h *messageHandler) HandleMessage(m *nsq.Message) error {
 		return nil
 	}
 
+	if atomic.LoadInt32(&h.paused) == 1 {
+		// Message was in flight when consumer was paused, return it to the queue to process after resume
+		h.logger.Debug("Consumer is paused, requeueing message with delay ", pausedRequeueDelay)
+		h.pausedRequeuesMu.Lock()
+		h.pausedRequeues[m.ID]++
+		h.pausedRequeuesMu.Unlock()
+		m.DisableAutoResponse()
+		m.RequeueWithoutBackoff(pausedRequeueDelay)
+		return nil
+	}
 	h.logger.Debug("Message body received: ", string(m.Body))
+	attempts := h.processingAttempts(m)
+	if h.maxAttempts > 0 && attempts > h.maxAttempts {
+		h.logger.Error("Dropping message ", string(m.Body), " attempted ", attempts, " times, giving up")
+		h.forgetPausedRequeues(m)
+		return nil
+	}
+	if atomic.AddInt32(&h.inFlight, 1) == 1 {
+		// Staleness is measured from the start of processing, idle time doesn't count
+		atomic.StoreInt64(&h.lastProcessed, time.Now().UnixNano())
+	}
+	defer atomic.AddInt32(&h.inFlight, -1)
+	if h.touchInterval > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		go h.touchUntilDone(m, done)
+	}
 	err := h.processor.Process(m.Body)
 	if err != nil {
+		var permanentErr permanent
+		if errors.As(err, &permanentErr) && permanentErr.Permanent() {
+			// Requeue would loop forever, so FIN the message
+			h.logger.Error("Dropping message ", string(m.Body), " with permanent failure: ", err)
+			h.forgetPausedRequeues(m)
+			return nil
+		}
 		h.logger.Error("Failure processing message ", string(m.Body), ": ", err)
+		if h.requeueDelay > 0 {
+			// Requeue ourselves with jittered delay instead of NSQ backoff, which throttles the whole consumer
+			delay := h.nextRequeueDelay(attempts)
+			h.logger.Debug("Requeueing message with delay ", delay, ", attempt ", attempts)
+			m.DisableAutoResponse()
+			m.RequeueWithoutBackoff(delay)
+			return nil
+		}
 		// Returning a non-nil error will automatically send a REQ command to NSQ to re-queue a message.
-		//TODO: handle errors that should not cause a reschedule
 		return err
 	}
+	atomic.StoreInt64(&h.lastProcessed, time.Now().UnixNano())
+	h.forgetPausedRequeues(m)
 	return nil
 }
 
+// processingAttempts returns delivery attempts of message, not counting requeues while consumer was paused
+func (h *messageHandler) processingAttempts(m *nsq.Message) uint16 {
+	h.pausedRequeuesMu.Lock()
+	defer h.pausedRequeuesMu.Unlock()
+	return m.Attempts - h.pausedRequeues[m.ID]
+}
+
+// forgetPausedRequeues of finished message
+func (h *messageHandler) forgetPausedRequeues(m *nsq.Message) {
+	h.pausedRequeuesMu.Lock()
+	delete(h.pausedRequeues, m.ID)
+	h.pausedRequeuesMu.Unlock()
+}
+
+// touchUntilDone periodically resets message in-flight timeout, so NSQ doesn't redeliver message still being processed
+func (h *messageHandler) touchUntilDone(m *nsq.Message, done <-chan struct{}) {
+	ticker := time.NewTicker(h.touchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			h.logger.Debug("Touching long running message, attempt ", m.Attempts)
+			m.Touch()
+		}
+	}
+}
+
+// nextRequeueDelay grows base delay exponentially with attempts and randomises it within 50-100% range to avoid retry storms
+func (h *messageHandler) nextRequeueDelay(attempts uint16) time.Duration {
+	multiplier := h.requeueMultiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	exponent := 0.0
+	if attempts > 1 {
+		exponent = float64(attempts - 1)
+	}
+	maxDelay := h.requeueMaxDelay
+	if maxDelay <= 0 || maxDelay > maxRequeueDelay {
+		maxDelay = maxRequeueDelay
+	}
+	// Clamped before conversion, growing delay overflows time.Duration after tens of attempts
+	delay := maxDelay
+	if grown := float64(h.requeueDelay) * math.Pow(multiplier, exponent); grown < float64(maxDelay) {
+		delay = time.Duration(grown)
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
 type MessageConsumer struct {
 	consumer       *nsq.Consumer
 	nsqLookupdHost string
+	nsqdHost       string
 	logger         Logger
 	handler        *messageHandler
+	maxInFlight    int
+	drainTimeout   time.Duration
 }
 
 func (c *MessageConsumer) Start() error {
+	if c.nsqLookupdHost == "" {
+		// No discovery, connect to single nsqd directly
+		c.logger.Info("nsqlookupd is not configured, connecting directly to nsqd ", c.nsqdHost)
+		return c.consumer.ConnectToNSQD(c.nsqdHost)
+	}
 	// Use nsqlookupd to discover nsqd instances.
 	// Could be a load balanced service, so use single connection.
 	// It peridically calls nsqlookupd to refresh.
 	return c.consumer.ConnectToNSQLookupd(c.nsqLookupdHost)
 }
-func (c *MessageConsumer) Stop() {
+
+// Stop stops receiving messages and waits up to drain timeout for in-flight messages to be processed
+func (c *MessageConsumer) Stop() error {
 	c.consumer.Stop()
+	select {
+	case <-c.consumer.StopChan:
+		return nil
+	case <-time.After(c.drainTimeout):
+		return fmt.Errorf("in-flight messages are not processed in %s", c.drainTimeout)
+	}
+}
+
+// Connected returns true if consumer has at least one nsqd connection
+func (c *MessageConsumer) Connected() bool {
+	return c.consumer.Stats().Connections > 0
+}
+
+// Stalled returns true if messages are being processed, but none was processed successfully within window.
+// Idle consumer without messages in flight is not stalled, however long ago it processed the last message.
+func (c *MessageConsumer) Stalled(window time.Duration) bool {
+	if atomic.LoadInt32(&c.handler.inFlight) == 0 {
+		return false
+	}
+	return time.Since(time.Unix(0, atomic.LoadInt64(&c.handler.lastProcessed))) > window
+}
+
+// Pause stops receiving messages, which stay queued in nsqd. Messages already in flight are requeued unprocessed
+func (c *MessageConsumer) Pause() {
+	atomic.StoreInt32(&c.handler.paused, 1)
+	c.consumer.ChangeMaxInFlight(0)
+	c.logger.Info("Paused consumer")
+}
+
+// Resume receiving messages after Pause
+func (c *MessageConsumer) Resume() {
+	atomic.StoreInt32(&c.handler.paused, 0)
+	c.consumer.ChangeMaxInFlight(c.maxInFlight)
+	c.logger.Info("Resumed consumer")
 }
 
 func New(config *MessageConsumerConfig, processor MessageProcessor, logger Logger) (*MessageConsumer, error) {
 	NSQConsumerConfig := nsq.NewConfig()
 	NSQConsumerConfig.MaxInFlight = config.Prefetch
-	NSQConsumerConfig.MaxAttempts = config.Attempts
+	// Attempts are checked by handler, go-nsq would count requeues of paused consumer
+	NSQConsumerConfig.MaxAttempts = 0
+	if err := config.Security.Apply(NSQConsumerConfig); err != nil {
+		return nil, err
+	}
+	config.Compression.Apply(NSQConsumerConfig)
 	consumer, err := nsq.NewConsumer(config.Topic, config.Channel, NSQConsumerConfig)
 	if err != nil {
 		return nil, err
 	}
 	// consumer.SetLogger(log, )
 	handler := &messageHandler{
-		processor,
-		logger,
+		lastProcessed:     time.Now().UnixNano(),
+		processor:         processor,
+		logger:            logger,
+		requeueDelay:      time.Duration(config.RequeueDelay) * time.Second,
+		requeueMultiplier: config.RequeueMultiplier,
+		requeueMaxDelay:   time.Duration(config.RequeueMaxDelay) * time.Second,
+		touchInterval:     time.Duration(config.TouchInterval) * time.Second,
+		maxAttempts:       config.Attempts,
+		pausedRequeues:    map[nsq.MessageID]uint16{},
 	}
 	consumer.AddConcurrentHandlers(handler, config.Workers)
-
-	return &MessageConsumer{consumer: consumer, nsqLookupdHost: config.NSQLookup, handler: handler, logger: logger}, nil
+	drainTimeout := time.Duration(config.DrainTimeout) * time.Second
+	if drainTimeout <= 0 {
+		drainTimeout = defaultDrainTimeout
+	}
+	return &MessageConsumer{consumer: consumer, nsqLookupdHost: config.NSQLookup, nsqdHost: config.NSQD, handler: handler, logger: logger, maxInFlight: config.Prefetch, drainTimeout: drainTimeout}, nil
 }
@@ -0,0 +1,157 @@
+package consumer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(args ...interface{}) {}
+func (nopLogger) Info(args ...interface{})  {}
+func (nopLogger) Warn(args ...interface{})  {}
+func (nopLogger) Error(args ...interface{}) {}
+func (nopLogger) Fatal(args ...interface{}) {}
+
+// countingProcessor counts processed messages and fails with err
+type countingProcessor struct {
+	processed int
+	err       error
+}
+
+func (p *countingProcessor) Process([]byte) error {
+	p.processed++
+	return p.err
+}
+
+// requeueRecorder records requeues of messages
+type requeueRecorder struct {
+	requeues int
+}
+
+func (d *requeueRecorder) OnFinish(*nsq.Message)                                 {}
+func (d *requeueRecorder) OnRequeue(m *nsq.Message, delay time.Duration, b bool) { d.requeues++ }
+func (d *requeueRecorder) OnTouch(*nsq.Message)                                  {}
+
+type permanentError struct{}
+
+func (permanentError) Error() string   { return "malformed message" }
+func (permanentError) Permanent() bool { return true }
+
+func TestNextRequeueDelay(t *testing.T) {
+	tests := []struct {
+		name       string
+		delay      time.Duration
+		multiplier float64
+		maxDelay   time.Duration
+		attempts   uint16
+		wantMax    time.Duration
+	}{
+		{"first attempt", 5 * time.Second, 2, 10 * time.Minute, 1, 5 * time.Second},
+		{"grows with attempts", 5 * time.Second, 2, 10 * time.Minute, 3, 20 * time.Second},
+		{"capped by max delay", 5 * time.Second, 2, 10 * time.Minute, 20, 10 * time.Minute},
+		{"multiplier below 1 doesn't shrink", 5 * time.Second, 0.5, 10 * time.Minute, 5, 5 * time.Second},
+		{"unlimited attempts without max delay", 5 * time.Second, 2, 0, 40, maxRequeueDelay},
+		{"max attempts without max delay", 5 * time.Second, 10, 0, 65535, maxRequeueDelay},
+		{"max delay above nsqd limit", 5 * time.Second, 2, 2 * time.Hour, 100, maxRequeueDelay},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &messageHandler{requeueDelay: tt.delay, requeueMultiplier: tt.multiplier, requeueMaxDelay: tt.maxDelay}
+			for i := 0; i < 100; i++ {
+				got := h.nextRequeueDelay(tt.attempts)
+				if got < tt.wantMax/2 || got > tt.wantMax {
+					t.Fatalf("nextRequeueDelay(%d) = %s, want within [%s, %s]", tt.attempts, got, tt.wantMax/2, tt.wantMax)
+				}
+			}
+		})
+	}
+}
+
+func TestMessageConsumerConfigValidateRequeue(t *testing.T) {
+	tests := []struct {
+		name       string
+		delay      int
+		multiplier float64
+		maxDelay   int
+		wantErr    bool
+	}{
+		{"valid", 5, 2, 600, false},
+		{"requeue disabled ignores multiplier", 0, 0, 0, false},
+		{"negative delay", -1, 2, 600, true},
+		{"multiplier below 1", 5, 0.5, 600, true},
+		{"unset multiplier", 5, 0, 600, true},
+		{"negative max delay", 5, 2, -1, true},
+		{"max delay above nsqd limit", 5, 2, 3601, true},
+		{"max delay at nsqd limit", 5, 2, 3600, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &MessageConsumerConfig{NSQD: "nsqd:4150", RequeueDelay: tt.delay, RequeueMultiplier: tt.multiplier, RequeueMaxDelay: tt.maxDelay}
+			if err := c.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandleMessageAttempts(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxAttempts    uint16
+		pausedRequeues int
+		// attempts of delivery after resume, including paused requeues
+		attempts      uint16
+		processErr    error
+		wantProcessed int
+		wantRequeues  int
+	}{
+		{"first attempt is processed", 1, 0, 1, nil, 1, 0},
+		{"attempts over max are dropped", 1, 0, 2, nil, 0, 0},
+		{"paused requeues don't count", 1, 3, 4, nil, 1, 3},
+		{"attempts over max after paused requeues are dropped", 1, 3, 5, nil, 0, 3},
+		{"no attempts limit", 0, 0, 100, nil, 1, 0},
+		{"failed message is requeued", 2, 0, 1, errors.New("feed is unavailable"), 1, 1},
+		{"permanent failure is dropped", 2, 0, 1, permanentError{}, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := &countingProcessor{err: tt.processErr}
+			h := &messageHandler{
+				processor:         processor,
+				logger:            nopLogger{},
+				requeueDelay:      time.Second,
+				requeueMultiplier: 1,
+				maxAttempts:       tt.maxAttempts,
+				pausedRequeues:    map[nsq.MessageID]uint16{},
+			}
+			delegate := &requeueRecorder{}
+			m := nsq.NewMessage(nsq.MessageID{'1'}, []byte(`{"type":"FeedsUpdateOne"}`))
+			m.Delegate = delegate
+			h.paused = 1
+			for i := 1; i <= tt.pausedRequeues; i++ {
+				m.Attempts = uint16(i)
+				if err := h.HandleMessage(m); err != nil {
+					t.Fatalf("HandleMessage() of paused consumer error = %v", err)
+				}
+				// requeued message is delivered again as new one
+				m = nsq.NewMessage(m.ID, m.Body)
+				m.Delegate = delegate
+			}
+			h.paused = 0
+			m.Attempts = tt.attempts
+			if err := h.HandleMessage(m); err != nil {
+				t.Fatalf("HandleMessage() error = %v", err)
+			}
+			if processor.processed != tt.wantProcessed {
+				t.Errorf("processed %d times, want %d", processor.processed, tt.wantProcessed)
+			}
+			if delegate.requeues != tt.wantRequeues {
+				t.Errorf("requeued %d times, want %d", delegate.requeues, tt.wantRequeues)
+			}
+		})
+	}
+}
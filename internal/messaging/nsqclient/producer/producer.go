@@ -1,40 +1,147 @@
 package producer
 
 import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Tarick/naca-rss-feeds/internal/messaging/nsqclient/compression"
+	"github.com/Tarick/naca-rss-feeds/internal/messaging/nsqclient/security"
+
 	"github.com/nsqio/go-nsq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+// defaultMaxMessageSize matches nsqd --max-msg-size default
+const defaultMaxMessageSize = 1024 * 1024
+
+// nearSizeLimitRatio of max message size, above which message is reported as approaching the limit
+const nearSizeLimitRatio = 0.8
+
+// ErrMessageTooLarge is returned for messages exceeding max message size, nsqd would reject them
+var ErrMessageTooLarge = errors.New("message is too large")
+
+// messagesNearSizeLimit counts published messages, which are close to or over max message size
+var messagesNearSizeLimit = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "naca_rss_feeds",
+	Name:      "nsq_messages_near_size_limit_total",
+	Help:      "NSQ messages above 80% of max message size, by topic and result: published or rejected",
+}, []string{"topic", "result"})
+
 // MessageProducerConfig defines NSQ publish configuration
 type MessageProducerConfig struct {
 	Host  string `mapstructure:"host"`
 	Topic string `mapstructure:"topic"`
+	// MaxMessageSize in bytes, must not exceed nsqd --max-msg-size. 0 uses nsqd default of 1MB
+	MaxMessageSize int `mapstructure:"max_message_size"`
+	// ConnectAttempts to reach nsqd on startup, so producer waits for nsqd starting later. 0 means single attempt
+	ConnectAttempts int `mapstructure:"connect_attempts"`
+	// ConnectBackoff in seconds before the second attempt, doubled for each next one
+	ConnectBackoff int                `mapstructure:"connect_backoff"`
+	Security       security.Config    `mapstructure:",squash"`
+	Compression    compression.Config `mapstructure:",squash"`
 }
+
+// Validate checks producer message size, TLS and compression configuration
+func (c *MessageProducerConfig) Validate() error {
+	if c.MaxMessageSize < 0 {
+		return fmt.Errorf("max_message_size must not be negative, got %d", c.MaxMessageSize)
+	}
+	if c.ConnectAttempts < 0 {
+		return fmt.Errorf("connect_attempts must not be negative, got %d", c.ConnectAttempts)
+	}
+	if c.ConnectBackoff < 0 {
+		return fmt.Errorf("connect_backoff must not be negative, got %d", c.ConnectBackoff)
+	}
+	if err := c.Security.Validate(); err != nil {
+		return err
+	}
+	return c.Compression.Validate()
+}
+
 type messageProducer struct {
-	producer *nsq.Producer
-	topic    string
+	producer       *nsq.Producer
+	topic          string
+	maxMessageSize int
+	logger         Logger
 }
 
 func (p *messageProducer) Stop() {
 	p.producer.Stop()
 }
 
+// Ping checks nsqd connection, connecting if needed
+func (p *messageProducer) Ping() error {
+	return p.producer.Ping()
+}
+
+// Publish checks message size before publishing, so oversized message fails with clear error instead of nsqd E_BAD_MESSAGE
 func (p *messageProducer) Publish(body []byte) error {
+	if err := p.checkSize(body); err != nil {
+		return err
+	}
 	return p.producer.Publish(p.topic, body)
 }
 
+// DeferredPublish publishes message, which nsqd delivers after delay. Delay is limited by nsqd --max-req-timeout
+func (p *messageProducer) DeferredPublish(delay time.Duration, body []byte) error {
+	if err := p.checkSize(body); err != nil {
+		return err
+	}
+	return p.producer.DeferredPublish(p.topic, delay, body)
+}
+
+func (p *messageProducer) checkSize(body []byte) error {
+	if len(body) > p.maxMessageSize {
+		messagesNearSizeLimit.WithLabelValues(p.topic, "rejected").Inc()
+		return fmt.Errorf("%w: %d bytes, limit is %d bytes", ErrMessageTooLarge, len(body), p.maxMessageSize)
+	}
+	if float64(len(body)) > float64(p.maxMessageSize)*nearSizeLimitRatio {
+		messagesNearSizeLimit.WithLabelValues(p.topic, "published").Inc()
+		p.logger.Warn("Message to topic ", p.topic, " of ", len(body), " bytes is approaching size limit of ", p.maxMessageSize, " bytes")
+	}
+	return nil
+}
+
 // New returns producer if infra is ok.
-func New(config *MessageProducerConfig) (*messageProducer, error) {
+func New(config *MessageProducerConfig, logger Logger) (*messageProducer, error) {
 	msgProducer := &messageProducer{
-		topic: config.Topic,
+		topic:          config.Topic,
+		maxMessageSize: config.MaxMessageSize,
+		logger:         logger,
+	}
+	if msgProducer.maxMessageSize == 0 {
+		msgProducer.maxMessageSize = defaultMaxMessageSize
 	}
 
-	producer, err := nsq.NewProducer(config.Host, nsq.NewConfig())
+	NSQProducerConfig := nsq.NewConfig()
+	if err := config.Security.Apply(NSQProducerConfig); err != nil {
+		return nil, err
+	}
+	config.Compression.Apply(NSQProducerConfig)
+	producer, err := nsq.NewProducer(config.Host, NSQProducerConfig)
 	if err != nil {
 		return nil, err
 	}
-	if err := producer.Ping(); err != nil {
+	if err := ping(producer, config, logger); err != nil {
+		producer.Stop()
 		return nil, err
 	}
 	msgProducer.producer = producer
 	return msgProducer, nil
 }
+
+// ping checks nsqd connection, retrying with backoff up to configured attempts
+func ping(producer *nsq.Producer, config *MessageProducerConfig, logger Logger) error {
+	backoff := time.Duration(config.ConnectBackoff) * time.Second
+	for attempt := 1; ; attempt++ {
+		err := producer.Ping()
+		if err == nil || attempt >= config.ConnectAttempts {
+			return err
+		}
+		logger.Warn("Failure connecting to nsqd ", config.Host, " (attempt ", attempt, " of ", config.ConnectAttempts, "): ", err, ", retrying in ", backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
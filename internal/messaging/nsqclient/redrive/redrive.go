@@ -0,0 +1,167 @@
+package redrive
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Tarick/naca-rss-feeds/internal/messaging/nsqclient/security"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// Config defines re-drive of messages from dead-letter topic to the main topic
+type Config struct {
+	// NSQLookup is used to discover nsqd instances with dead-letter topic
+	NSQLookup string
+	// NSQD is nsqd with dead-letter topic to connect directly, used when NSQLookup is not set
+	NSQD string
+	// Host is nsqd to publish re-driven messages to
+	Host      string
+	FromTopic string
+	ToTopic   string
+	Channel   string
+	// Limit of messages to re-drive, 0 means all
+	Limit int
+	// DryRun only logs messages, leaving them in dead-letter topic
+	DryRun bool
+	// IdleTimeout stops re-drive when no messages arrive during this time
+	IdleTimeout time.Duration
+	// ConsumeSecurity and PublishSecurity define TLS and auth of dead-letter and main topic connections
+	ConsumeSecurity security.Config
+	PublishSecurity security.Config
+}
+
+// Run consumes messages from dead-letter topic and republishes them to the main topic.
+// Returns the number of re-driven (or, in dry run, seen) messages.
+func Run(config *Config, logger Logger) (int, error) {
+	if config.FromTopic == "" || config.ToTopic == "" {
+		return 0, errors.New("both source and destination topics are required")
+	}
+	if config.FromTopic == config.ToTopic {
+		return 0, errors.New("source and destination topics must differ")
+	}
+	NSQProducerConfig := nsq.NewConfig()
+	if err := config.PublishSecurity.Apply(NSQProducerConfig); err != nil {
+		return 0, err
+	}
+	producer, err := nsq.NewProducer(config.Host, NSQProducerConfig)
+	if err != nil {
+		return 0, err
+	}
+	if err := producer.Ping(); err != nil {
+		return 0, err
+	}
+	defer producer.Stop()
+
+	NSQConsumerConfig := nsq.NewConfig()
+	// one message at a time, so the limit is exact
+	NSQConsumerConfig.MaxInFlight = 1
+	if err := config.ConsumeSecurity.Apply(NSQConsumerConfig); err != nil {
+		return 0, err
+	}
+	consumer, err := nsq.NewConsumer(config.FromTopic, config.Channel, NSQConsumerConfig)
+	if err != nil {
+		return 0, err
+	}
+	handler := &redriveHandler{
+		config:   config,
+		producer: producer,
+		logger:   logger,
+		seen:     map[nsq.MessageID]bool{},
+		activity: make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	consumer.AddHandler(handler)
+	if config.NSQLookup == "" {
+		// No discovery, connect to single nsqd directly
+		logger.Info("nsqlookupd is not configured, connecting directly to nsqd ", config.NSQD)
+		err = consumer.ConnectToNSQD(config.NSQD)
+	} else {
+		err = consumer.ConnectToNSQLookupd(config.NSQLookup)
+	}
+	if err != nil {
+		return 0, err
+	}
+	idle := time.NewTimer(config.IdleTimeout)
+	defer idle.Stop()
+loop:
+	for {
+		select {
+		case <-handler.done:
+			break loop
+		case <-handler.activity:
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(config.IdleTimeout)
+		case <-idle.C:
+			logger.Info("No messages in ", config.FromTopic, " for ", config.IdleTimeout, ", stopping")
+			break loop
+		}
+	}
+	consumer.Stop()
+	<-consumer.StopChan
+	return handler.count(), nil
+}
+
+type redriveHandler struct {
+	config   *Config
+	producer *nsq.Producer
+	logger   Logger
+
+	mu       sync.Mutex
+	redriven int
+	seen     map[nsq.MessageID]bool
+	finished bool
+	activity chan struct{}
+	done     chan struct{}
+}
+
+// HandleMessage republishes message, or in dry run logs and requeues it back to dead-letter topic
+func (h *redriveHandler) HandleMessage(m *nsq.Message) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	select {
+	case h.activity <- struct{}{}:
+	default:
+	}
+	// Dry run requeues messages, so the same message coming back means all of them were seen
+	if h.finished || (h.config.Limit > 0 && h.redriven >= h.config.Limit) || h.seen[m.ID] {
+		m.DisableAutoResponse()
+		m.RequeueWithoutBackoff(0)
+		h.finish()
+		return nil
+	}
+	if h.config.DryRun {
+		h.logger.Info("Dry run, would re-drive message ", string(m.ID[:]), ": ", string(m.Body))
+		h.seen[m.ID] = true
+		h.redriven++
+		m.DisableAutoResponse()
+		m.RequeueWithoutBackoff(0)
+		return nil
+	}
+	if err := h.producer.Publish(h.config.ToTopic, m.Body); err != nil {
+		h.logger.Error("Failure re-driving message ", string(m.ID[:]), " to ", h.config.ToTopic, ": ", err)
+		return err
+	}
+	h.logger.Debug("Re-driven message ", string(m.ID[:]), " to ", h.config.ToTopic)
+	h.redriven++
+	if h.config.Limit > 0 && h.redriven >= h.config.Limit {
+		h.finish()
+	}
+	return nil
+}
+
+func (h *redriveHandler) finish() {
+	if !h.finished {
+		h.finished = true
+		close(h.done)
+	}
+}
+
+func (h *redriveHandler) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.redriven
+}
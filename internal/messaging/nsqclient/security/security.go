@@ -0,0 +1,82 @@
+package security
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// Config defines TLS and authentication of NSQ connections.
+// It is squashed into producer and consumer configuration, so keys are on the same level as host/topic.
+type Config struct {
+	// TLS enables TLS negotiation with nsqd (nsqd must run with --tls-cert and --tls-key)
+	TLS bool `mapstructure:"tls"`
+	// TLSCAFile is PEM file with CA certificates to verify nsqd, system pool is used if empty
+	TLSCAFile string `mapstructure:"tls_ca_file"`
+	// TLSCertFile and TLSKeyFile are client certificate and key, required if nsqd uses --tls-client-auth-policy
+	TLSCertFile string `mapstructure:"tls_cert_file"`
+	TLSKeyFile  string `mapstructure:"tls_key_file"`
+	// TLSInsecureSkipVerify disables nsqd certificate verification, don't use in production
+	TLSInsecureSkipVerify bool `mapstructure:"tls_insecure_skip_verify"`
+	// AuthSecret is sent to nsqd configured with --auth-http-address
+	AuthSecret string `mapstructure:"auth_secret"`
+}
+
+// Validate checks TLS settings consistency and that certificate files are readable
+func (c *Config) Validate() error {
+	if !c.TLS {
+		if c.TLSCAFile != "" || c.TLSCertFile != "" || c.TLSKeyFile != "" {
+			return errors.New("tls certificate files are set, but tls is disabled")
+		}
+		return nil
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return errors.New("both tls_cert_file and tls_key_file must be set for client certificate")
+	}
+	for _, path := range []string{c.TLSCAFile, c.TLSCertFile, c.TLSKeyFile} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("tls file is not accessible, %w", err)
+		}
+	}
+	return nil
+}
+
+// Apply sets TLS and auth secret on NSQ configuration, loading certificates from files
+func (c *Config) Apply(nsqConfig *nsq.Config) error {
+	nsqConfig.AuthSecret = c.AuthSecret
+	if !c.TLS {
+		return nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.TLSInsecureSkipVerify,
+	}
+	if c.TLSCAFile != "" {
+		caCert, err := ioutil.ReadFile(c.TLSCAFile)
+		if err != nil {
+			return fmt.Errorf("failure reading tls_ca_file, %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+			return fmt.Errorf("no certificates found in tls_ca_file %s", c.TLSCAFile)
+		}
+	}
+	if c.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+		if err != nil {
+			return fmt.Errorf("failure loading tls client certificate, %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	nsqConfig.TlsV1 = true
+	nsqConfig.TlsConfig = tlsConfig
+	return nil
+}
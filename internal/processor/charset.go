@@ -0,0 +1,42 @@
+package processor
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+)
+
+// xmlEncodingDeclaration matches encoding in XML prolog, e.g. <?xml version="1.0" encoding="windows-1251"?>
+var xmlEncodingDeclaration = regexp.MustCompile(`^\s*<\?xml[^>]*?encoding=["']([^"']+)["']`)
+
+// toUTF8 transcodes feed body to UTF-8 using encoding, declared in XML prolog or HTTP Content-Type charset.
+// XML prolog declaration is rewritten to UTF-8 after transcoding, so the parser doesn't decode body twice.
+func toUTF8(body []byte, contentType string) ([]byte, error) {
+	label := ""
+	declaration := xmlEncodingDeclaration.FindSubmatchIndex(body)
+	if declaration != nil {
+		label = string(body[declaration[2]:declaration[3]])
+	} else if _, params, err := mime.ParseMediaType(contentType); err == nil {
+		label = params["charset"]
+	}
+	label = strings.ToLower(strings.TrimSpace(label))
+	if label == "" || label == "utf-8" || label == "utf8" {
+		return body, nil
+	}
+	reader, err := charset.NewReaderLabel(label, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if loc := xmlEncodingDeclaration.FindSubmatchIndex(decoded); loc != nil {
+		decoded = bytes.Join([][]byte{decoded[:loc[2]], []byte("UTF-8"), decoded[loc[3]:]}, nil)
+	}
+	return decoded, nil
+}
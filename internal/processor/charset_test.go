@@ -0,0 +1,105 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestToUTF8(t *testing.T) {
+	tests := []struct {
+		name        string
+		fixture     string
+		contentType string
+		wantTitle   string
+		wantItem    string
+	}{
+		{
+			name:        "windows-1251 declared in XML prolog",
+			fixture:     "windows-1251.xml",
+			contentType: "application/rss+xml",
+			wantTitle:   "Новости дня",
+			wantItem:    "Всё о погоде",
+		},
+		{
+			name:        "ISO-8859-1 declared in XML prolog",
+			fixture:     "iso-8859-1.xml",
+			contentType: "text/xml",
+			wantTitle:   "Café façade",
+			wantItem:    "Crème brûlée à la carte",
+		},
+		{
+			name:        "XML prolog takes precedence over HTTP charset",
+			fixture:     "windows-1251.xml",
+			contentType: "application/rss+xml; charset=utf-8",
+			wantTitle:   "Новости дня",
+			wantItem:    "Всё о погоде",
+		},
+		{
+			name:        "KOI8-R from HTTP charset",
+			fixture:     "koi8-r-http-charset.xml",
+			contentType: "application/rss+xml; charset=KOI8-R",
+			wantTitle:   "Привет, мир",
+			wantItem:    "Ещё одна новость",
+		},
+		{
+			name:        "UTF-8 is kept as is",
+			fixture:     "jsonfeed.json",
+			contentType: "application/feed+json; charset=utf-8",
+			wantTitle:   "Example JSON Feed",
+			wantItem:    "Second post summary",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := ioutil.ReadFile(filepath.Join("testdata", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := toUTF8(body, tt.contentType)
+			if err != nil {
+				t.Fatalf("toUTF8() error = %v", err)
+			}
+			if bytes.Contains(decoded, []byte(`encoding="windows-1251"`)) || bytes.Contains(decoded, []byte(`encoding="ISO-8859-1"`)) {
+				t.Error("XML prolog still declares legacy encoding")
+			}
+			feed, err := newFeedParser().Parse(bytes.NewReader(decoded))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if feed.Title != tt.wantTitle {
+				t.Errorf("feed title = %q, want %q", feed.Title, tt.wantTitle)
+			}
+			if len(feed.Items) == 0 || feed.Items[0].Description != tt.wantItem {
+				t.Errorf("item description = %v, want %q", feed.Items, tt.wantItem)
+			}
+		})
+	}
+}
+
+func TestFetchLegacyEncodingFeed(t *testing.T) {
+	tests := []struct {
+		name        string
+		fixture     string
+		contentType string
+		wantTitle   string
+	}{
+		{name: "declared encoding", fixture: "windows-1251.xml", contentType: "application/rss+xml", wantTitle: "Новости дня"},
+		{name: "HTTP charset", fixture: "koi8-r-http-charset.xml", contentType: "text/xml; charset=koi8-r", wantTitle: "Привет, мир"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFixtureServer(t, tt.fixture, tt.contentType)
+			feed, err := FetchFeed(context.Background(), server.Client(), server.URL, "", time.Time{})
+			if err != nil {
+				t.Fatalf("FetchFeed() error = %v", err)
+			}
+			if len(feed.Items) != 1 || feed.Items[0].Title != tt.wantTitle {
+				t.Errorf("items = %v, want single item titled %q", feed.Items, tt.wantTitle)
+			}
+		})
+	}
+}
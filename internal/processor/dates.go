@@ -0,0 +1,65 @@
+package processor
+
+import (
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// itemDate returns item date in UTC: published date, or updated date if published is missing.
+// Raw dates gofeed couldn't parse are parsed with additional Go time layouts. Published date is preferred,
+// so its raw value is tried before falling back to the updated date.
+// unparsed is the first raw date, which couldn't be parsed, ok is false if item has no date at all.
+func itemDate(item *gofeed.Item, layouts []string) (date time.Time, unparsed string, ok bool) {
+	if item.PublishedParsed != nil {
+		return item.PublishedParsed.UTC(), "", true
+	}
+	if item.Published != "" {
+		if date, ok := parseDate(item.Published, layouts); ok {
+			return date, "", true
+		}
+		unparsed = item.Published
+	}
+	if item.UpdatedParsed != nil {
+		return item.UpdatedParsed.UTC(), unparsed, true
+	}
+	if item.Updated != "" {
+		if date, ok := parseDate(item.Updated, layouts); ok {
+			return date, unparsed, true
+		}
+		if unparsed == "" {
+			unparsed = item.Updated
+		}
+	}
+	return time.Time{}, unparsed, false
+}
+
+// parseDate tries layouts in order, returning date in UTC
+func parseDate(raw string, layouts []string) (time.Time, bool) {
+	raw = strings.TrimSpace(raw)
+	for _, layout := range layouts {
+		if date, err := time.Parse(layout, raw); err == nil {
+			return date.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// itemDedupDate returns processed item date of the item with published date: the later of published and updated dates
+// with DedupDateLatest, published date otherwise
+func (p *rssFeedsProcessor) itemDedupDate(item *gofeed.Item, published time.Time) time.Time {
+	if p.processingConfig.DedupDate != DedupDateLatest {
+		return published
+	}
+	updated := item.UpdatedParsed
+	if updated == nil && item.Updated != "" {
+		if date, ok := parseDate(item.Updated, p.processingConfig.DateLayouts); ok {
+			updated = &date
+		}
+	}
+	if updated != nil && updated.After(published) {
+		return updated.UTC()
+	}
+	return published
+}
@@ -0,0 +1,106 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestItemDate(t *testing.T) {
+	layouts := []string{"02.01.2006 15:04 -0700", "2 January 2006, 15:04 -0700", "20060102T150405Z07:00"}
+	type wantDate struct {
+		date     time.Time
+		unparsed string
+		ok       bool
+	}
+	tests := []struct {
+		name    string
+		fixture string
+		layouts []string
+		want    map[string]wantDate
+	}{
+		{
+			name:    "RSS with date layouts",
+			fixture: "awkward-dates.xml",
+			layouts: layouts,
+			want: map[string]wantDate{
+				"Dotted date":     {date: time.Date(2020, 6, 1, 7, 0, 0, 0, time.UTC), ok: true},
+				"Long date":       {date: time.Date(2020, 6, 2, 15, 0, 0, 0, time.UTC), ok: true},
+				"Compact date":    {date: time.Date(2020, 6, 3, 10, 0, 0, 0, time.UTC), ok: true},
+				"Colon in offset": {date: time.Date(2020, 6, 4, 7, 0, 0, 0, time.UTC), ok: true},
+				"Padded date":     {date: time.Date(2020, 6, 5, 10, 0, 0, 0, time.UTC), ok: true},
+				"Ordinal date":    {unparsed: "June 6th, 2020"},
+			},
+		},
+		{
+			name:    "RSS without date layouts",
+			fixture: "awkward-dates.xml",
+			want: map[string]wantDate{
+				"Dotted date":     {unparsed: "01.06.2020 10:00 +0300"},
+				"Long date":       {unparsed: "2 June 2020, 10:00 -0500"},
+				"Compact date":    {unparsed: "20200603T100000Z"},
+				"Colon in offset": {date: time.Date(2020, 6, 4, 7, 0, 0, 0, time.UTC), ok: true},
+				"Padded date":     {unparsed: "05.06.2020 10:00 +0000"},
+				"Ordinal date":    {unparsed: "June 6th, 2020"},
+			},
+		},
+		{
+			name:    "Atom updated date",
+			fixture: "awkward-dates-atom.xml",
+			layouts: layouts,
+			want: map[string]wantDate{
+				"Updated only":                       {date: time.Date(2020, 6, 1, 7, 0, 0, 0, time.UTC), ok: true},
+				"Broken published, updated fallback": {date: time.Date(2020, 6, 2, 10, 0, 0, 0, time.UTC), unparsed: "June 1st, 2020", ok: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			feed, err := newFeedParser().Parse(f)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if len(feed.Items) != len(tt.want) {
+				t.Fatalf("got %d items, want %d", len(feed.Items), len(tt.want))
+			}
+			for _, item := range feed.Items {
+				want := tt.want[item.Title]
+				date, unparsed, ok := itemDate(item, tt.layouts)
+				if !date.Equal(want.date) || unparsed != want.unparsed || ok != want.ok {
+					t.Errorf("itemDate(%q) = %v, %q, %t, want %v, %q, %t", item.Title, date, unparsed, ok, want.date, want.unparsed, want.ok)
+				}
+				if ok && date.Location() != time.UTC {
+					t.Errorf("itemDate(%q) location = %v, want UTC", item.Title, date.Location())
+				}
+			}
+		})
+	}
+}
+
+func TestRefreshFeedAwkwardDates(t *testing.T) {
+	processingConfig := ProcessingConfig{DateLayouts: []string{"02.01.2006 15:04 -0700", "2 January 2006, 15:04 -0700", "20060102T150405Z07:00"}}
+	repository, published := refreshFixtures(t, processingConfig, "awkward-dates.xml")
+
+	// Item without parsable date is skipped, the rest are ordered by dates in UTC
+	want := [][]string{{"Padded date", "Colon in offset", "Compact date", "Long date", "Dotted date"}}
+	if !reflect.DeepEqual(published, want) {
+		t.Errorf("published = %v, want %v", published, want)
+	}
+	item, ok := repository.processedItem("https://example.org/long")
+	if !ok {
+		t.Fatal("item isn't saved as processed")
+	}
+	if wantDate := time.Date(2020, 6, 2, 15, 0, 0, 0, time.UTC); !item.PublicationDate.Equal(wantDate) || item.PublicationDate.Location() != time.UTC {
+		t.Errorf("processed item date = %v, want %v", item.PublicationDate, wantDate)
+	}
+	if _, ok := repository.processedItem("https://example.org/ordinal"); ok {
+		t.Error("item without parsable date is saved as processed")
+	}
+}
@@ -0,0 +1,58 @@
+package processor
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// trackingQueryParams are dropped from item urls on normalization, they differ between syndicated copies
+var trackingQueryParams = map[string]bool{
+	"fbclid": true,
+	"gclid":  true,
+	"mc_cid": true,
+	"mc_eid": true,
+	"ref":    true,
+}
+
+// normalizeItemURL returns url form, which is the same for syndicated copies of the item:
+// scheme, "www." prefix, default port, fragment, trailing slash and tracking query params are dropped,
+// the rest of query is sorted. Empty string is returned for items without valid absolute url.
+func normalizeItemURL(rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host += ":" + port
+	}
+	query := u.Query()
+	for name := range query {
+		if trackingQueryParams[strings.ToLower(name)] || strings.HasPrefix(strings.ToLower(name), "utm_") {
+			query.Del(name)
+		}
+	}
+	normalized := host + strings.TrimSuffix(u.EscapedPath(), "/")
+	// Encode sorts by key
+	if encoded := query.Encode(); encoded != "" {
+		normalized += "?" + encoded
+	}
+	return normalized
+}
+
+// globalDedupKey returns key of item for dedup across feeds according to global dedup mode, empty if it can't be built
+func globalDedupKey(mode string, item *gofeed.Item) string {
+	switch mode {
+	case GlobalDedupURL:
+		if normalized := normalizeItemURL(item.Link); normalized != "" {
+			return GlobalDedupURL + ":" + normalized
+		}
+	case GlobalDedupContentHash:
+		if item.Description != "" || item.Content != "" {
+			return GlobalDedupContentHash + ":" + contentHash(item.Description, item.Content)
+		}
+	}
+	return ""
+}
@@ -1,9 +1,14 @@
 package processor
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"hash/fnv"
+	"sort"
+	"time"
 
+	"github.com/Tarick/naca-rss-feeds/internal/entity"
 	"github.com/gofrs/uuid"
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
@@ -13,6 +18,7 @@ import (
 // MessageProducer is used to publish messages
 type MessageProducer interface {
 	Publish([]byte) error
+	DeferredPublish(time.Duration, []byte) error
 }
 
 // NewFeedsUpdateProducer returns producer to publish feeds update messages
@@ -31,7 +37,12 @@ func (p *rssFeedsUpdateProducer) setupTracingSpan(ctx context.Context, name stri
 	return span, ctx
 }
 
-func (p *rssFeedsUpdateProducer) SendUpdateOne(ctx context.Context, feedPublicationUUID uuid.UUID) error {
+func (p *rssFeedsUpdateProducer) SendUpdateOne(ctx context.Context, feedID uuid.UUID, force bool) error {
+	return p.SendUpdateOneDeferred(ctx, feedID, force, 0)
+}
+
+// SendUpdateOneDeferred sends feed refresh message, which is delivered after delay, non-positive delay sends it immediately
+func (p *rssFeedsUpdateProducer) SendUpdateOneDeferred(ctx context.Context, feedID uuid.UUID, force bool, delay time.Duration) error {
 	span, ctx := p.setupTracingSpan(ctx, "send-update-one-feed")
 	defer span.Finish()
 	carrier := opentracing.TextMapCarrier{}
@@ -39,8 +50,12 @@ func (p *rssFeedsUpdateProducer) SendUpdateOne(ctx context.Context, feedPublicat
 	if err != nil {
 		return err
 	}
-	span.SetTag("feed.PublicationUUID", feedPublicationUUID.String())
-	message := NewFeedsUpdateOneMessage(feedPublicationUUID)
+	span.SetTag("feed.ID", feedID.String())
+	span.SetTag("feed.forceRefresh", force)
+	if delay > 0 {
+		span.SetTag("message.delay", delay.String())
+	}
+	message := NewFeedsUpdateOneMessage(feedID, force)
 	message.Metadata = carrier
 	msgbytes, err := json.Marshal(message)
 	if err != nil {
@@ -50,6 +65,9 @@ func (p *rssFeedsUpdateProducer) SendUpdateOne(ctx context.Context, feedPublicat
 		return err
 	}
 	span.LogKV("event", "sent update one feed message")
+	if delay > 0 {
+		return p.producer.DeferredPublish(delay, msgbytes)
+	}
 	return p.producer.Publish(msgbytes)
 }
 
@@ -80,3 +98,137 @@ func (p *rssFeedsUpdateProducer) SendUpdateAll(ctx context.Context) error {
 	span.LogKV("event", "sent update all feeds message")
 	return err
 }
+
+// FeedsLister returns all feeds to refresh
+type FeedsLister interface {
+	GetAll(context.Context) ([]entity.Feed, error)
+}
+
+// RefreshAllSummary describes the result of scheduling refresh of all feeds
+type RefreshAllSummary struct {
+	// Total number of feeds in repository
+	Total int `json:"total"`
+	// Disabled is the number of skipped disabled feeds
+	Disabled int `json:"disabled"`
+	// Quarantined is the number of skipped quarantined feeds
+	Quarantined int `json:"quarantined"`
+	// Scheduled is the number of feeds with refresh message sent
+	Scheduled int `json:"scheduled"`
+	// Failed is the number of feeds, which failed to enqueue refresh message
+	Failed int `json:"failed"`
+	// Resumed is the number of feeds skipped as already handled by interrupted refresh, which is resumed
+	Resumed int `json:"resumed"`
+}
+
+// scheduleFeedRefresh sends refresh message for enabled and not quarantined feed, counting the result in summary.
+// Positive spread delays the message by the feed phase within spread.
+func scheduleFeedRefresh(ctx context.Context, dbFeed *entity.Feed, updater RSSFeedsUpdateProducer, spread time.Duration, summary *RefreshAllSummary, logger Logger) {
+	if !dbFeed.Enabled {
+		summary.Disabled++
+		return
+	}
+	if dbFeed.QuarantineReason != "" {
+		summary.Quarantined++
+		return
+	}
+	if err := updater.SendUpdateOneDeferred(ctx, dbFeed.ID, false, feedRefreshPhase(dbFeed.ID, spread)); err != nil {
+		logger.Error("Failure publishing feed refresh for feed ", dbFeed.ID, ": ", err)
+		summary.Failed++
+		return
+	}
+	logger.Debug("Published feed refresh for feed ", dbFeed.ID)
+	summary.Scheduled++
+}
+
+// feedRefreshPhase returns stable offset of feed refresh within spread, derived from feed ID.
+// Feeds keep their offsets between refreshes of all feeds, so fetches are spread evenly instead of polling in lockstep.
+func feedRefreshPhase(feedID uuid.UUID, spread time.Duration) time.Duration {
+	if spread <= 0 {
+		return 0
+	}
+	hash := fnv.New64a()
+	hash.Write(feedID.Bytes())
+	return time.Duration(hash.Sum64() % uint64(spread))
+}
+
+// RefreshAllCheckpointStore keeps progress of refresh of all feeds
+type RefreshAllCheckpointStore interface {
+	GetRefreshAllCheckpoint(context.Context) (*entity.RefreshAllCheckpoint, error)
+	SaveRefreshAllCheckpoint(context.Context, *entity.RefreshAllCheckpoint) error
+	ClearRefreshAllCheckpoint(context.Context) error
+}
+
+// refreshAllCheckpointInterval is the number of handled feeds between checkpoint saves,
+// at most this number of feeds gets refresh message twice if worker is killed
+const refreshAllCheckpointInterval = 100
+
+// checkpointSaveTimeout bounds saving of checkpoint of interrupted refresh, which context is already done
+const checkpointSaveTimeout = 5 * time.Second
+
+// ScheduleRefreshAllCheckpointed gets all feeds from repository and sends refresh message for each enabled and not quarantined feed.
+// Messages are sent one per feed and never batched, so fan-out stays far below NSQ max message size
+// and each feed is retried independently. Refresh can be resumed:
+// feeds are handled in feed ID order and the last handled feed is saved as checkpoint every
+// refreshAllCheckpointInterval feeds and on context cancellation. Refresh resumes after the checkpoint,
+// unless it was started longer than checkpointTTL ago, then it starts over. Finished refresh clears checkpoint.
+// Context error is returned with summary, if refresh is interrupted. Positive spread delays refresh messages, see feedRefreshPhase.
+func ScheduleRefreshAllCheckpointed(ctx context.Context, feeds FeedsLister, checkpoints RefreshAllCheckpointStore, updater RSSFeedsUpdateProducer, checkpointTTL time.Duration, spread time.Duration, logger Logger) (*RefreshAllSummary, error) {
+	dbFeeds, err := feeds.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Postgresql orders uuid by bytes too, so the order is stable between runs
+	sort.Slice(dbFeeds, func(i, j int) bool {
+		return bytes.Compare(dbFeeds[i].ID.Bytes(), dbFeeds[j].ID.Bytes()) < 0
+	})
+	checkpoint, err := checkpoints.GetRefreshAllCheckpoint(ctx)
+	if err != nil {
+		// Starting over only sends some refresh messages twice
+		logger.Error("Failure reading refresh all checkpoint, starting from the first feed: ", err)
+		checkpoint = nil
+	}
+	if checkpoint != nil && time.Since(checkpoint.StartedAt) > checkpointTTL {
+		logger.Warn("Refresh all checkpoint of refresh started at ", checkpoint.StartedAt, " is expired, starting from the first feed")
+		checkpoint = nil
+	}
+	if checkpoint == nil {
+		checkpoint = &entity.RefreshAllCheckpoint{StartedAt: time.Now()}
+	} else {
+		logger.Info("Resuming refresh of all feeds started at ", checkpoint.StartedAt, " after feed ", checkpoint.LastFeedID)
+	}
+	summary := &RefreshAllSummary{Total: len(dbFeeds)}
+	handled := 0
+	for i := range dbFeeds {
+		dbFeed := &dbFeeds[i]
+		if bytes.Compare(dbFeed.ID.Bytes(), checkpoint.LastFeedID.Bytes()) <= 0 {
+			summary.Resumed++
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			if handled > 0 {
+				saveCtx, cancel := context.WithTimeout(opentracing.ContextWithSpan(context.Background(), opentracing.SpanFromContext(ctx)), checkpointSaveTimeout)
+				saveRefreshAllCheckpoint(saveCtx, checkpoints, checkpoint, logger)
+				cancel()
+			}
+			return summary, err
+		}
+		scheduleFeedRefresh(ctx, dbFeed, updater, spread, summary, logger)
+		checkpoint.LastFeedID = dbFeed.ID
+		handled++
+		if handled%refreshAllCheckpointInterval == 0 {
+			saveRefreshAllCheckpoint(ctx, checkpoints, checkpoint, logger)
+		}
+	}
+	if err := checkpoints.ClearRefreshAllCheckpoint(ctx); err != nil {
+		// Expires with checkpointTTL, until then the next refresh resumes from the end and handles no feeds
+		logger.Error("Failure clearing refresh all checkpoint: ", err)
+	}
+	return summary, nil
+}
+
+// saveRefreshAllCheckpoint is best effort, lost checkpoint only makes retry send some refresh messages twice
+func saveRefreshAllCheckpoint(ctx context.Context, checkpoints RefreshAllCheckpointStore, checkpoint *entity.RefreshAllCheckpoint, logger Logger) {
+	if err := checkpoints.SaveRefreshAllCheckpoint(ctx, checkpoint); err != nil {
+		logger.Error("Failure saving refresh all checkpoint: ", err)
+	}
+}
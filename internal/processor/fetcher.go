@@ -0,0 +1,448 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otLog "github.com/opentracing/opentracing-go/log"
+
+	"github.com/Tarick/naca-rss-feeds/internal/entity"
+	"github.com/Tarick/naca-rss-feeds/internal/hostpolicy"
+)
+
+// ErrFeedLoginFailed is returned when feed login request fails, the feed itself is not fetched then
+var ErrFeedLoginFailed = errors.New("feed login failed")
+
+// maxRedirects followed by fetches, the default of http client
+const maxRedirects = 10
+
+// maxLoginResponseSize limits login response body read to reuse the connection
+const maxLoginResponseSize = 1024 * 1024
+
+// maxDrainedResponseSize limits unread response body drained to reuse the connection, larger responses close it
+const maxDrainedResponseSize = 64 * 1024
+
+// Fetcher fetches and parses feeds over HTTP, independently of feeds processing,
+// so it can be reused by API handlers and command line tools
+type Fetcher struct {
+	httpClient   *http.Client
+	hostBreakers HostCircuitBreakers
+	hostPolicy   *hostpolicy.Policy
+	fetchSlots   chan struct{}
+	logger       Logger
+	tracer       opentracing.Tracer
+	gmtLocation  *time.Location
+	// parseCache is set by EnableParseCache
+	parseCache *parseCache
+	// lenientParsing is set by EnableLenientParsing
+	lenientParsing bool
+}
+
+// NewFetcher creates feeds fetcher.
+// workers limits concurrent fetches of all callers sharing the fetcher, 0 means unlimited. httpClient, hostBreakers, hostPolicy, logger and tracer are optional:
+// nil means default http client, no per-host circuit breaking, no host restrictions, no logging and no tracing.
+// hostPolicy is applied to a copy of http client transport to check every dialed address, and to its redirects.
+func NewFetcher(httpClient *http.Client, workers int, hostBreakers HostCircuitBreakers, hostPolicy *hostpolicy.Policy, logger Logger, tracer opentracing.Tracer) *Fetcher {
+	gmtLocation, err := time.LoadLocation("GMT")
+	if err != nil {
+		panic(err)
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	if hostPolicy != nil {
+		policyClient := *httpClient
+		if clientTransport, ok := httpClient.Transport.(*http.Transport); ok {
+			transport := clientTransport.Clone()
+			hostPolicy.Apply(transport)
+			policyClient.Transport = transport
+		} else {
+			policyClient.Transport = hostPolicy.Transport()
+		}
+		// Transport checks dialed addresses only, so host of every redirect is checked against allowed and denied hosts
+		checkRedirect := httpClient.CheckRedirect
+		policyClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if err := hostPolicy.CheckHost(req.URL.Hostname()); err != nil {
+				return err
+			}
+			if checkRedirect != nil {
+				return checkRedirect(req, via)
+			}
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return nil
+		}
+		httpClient = &policyClient
+	}
+	var fetchSlots chan struct{}
+	if workers > 0 {
+		fetchSlots = make(chan struct{}, workers)
+	}
+	if logger == nil {
+		logger = nopLogger{}
+	}
+	if tracer == nil {
+		tracer = opentracing.NoopTracer{}
+	}
+	return &Fetcher{
+		httpClient:   httpClient,
+		hostBreakers: hostBreakers,
+		hostPolicy:   hostPolicy,
+		fetchSlots:   fetchSlots,
+		logger:       logger,
+		tracer:       tracer,
+		gmtLocation:  gmtLocation,
+	}
+}
+
+// EnableParseCache enables reuse of parsed feeds for fetches of the same feed url returning the same ETag
+func (p *Fetcher) EnableParseCache(config *ParseCacheConfig) {
+	p.parseCache = newParseCache(config)
+}
+
+// EnableLenientParsing enables recovery of malformed XML feeds, e.g. with raw ampersands or stray control characters,
+// when they fail to parse. Applied recovery is logged, feeds beyond repair fail with the original parse error.
+func (p *Fetcher) EnableLenientParsing() {
+	p.lenientParsing = true
+}
+
+// FetchFeed fetches and parses single feed with the given http client, without tracing, logging, circuit breaking and host restrictions
+func FetchFeed(ctx context.Context, httpClient *http.Client, url string, etag string, lastModified time.Time) (*RSSFeed, error) {
+	return NewFetcher(httpClient, 0, nil, nil, nil, nil).Fetch(ctx, url, nil, nil, etag, lastModified)
+}
+
+// Fetch fetches feed from url and returns parsed feed
+// headers are feed specific request headers, optional. Headers managed by fetcher (conditional, tracing) take precedence.
+// login is optional, if set it is executed before the feed request with the fetch scoped cookie jar.
+// Uses Etag and Last-Modified to verify if feed didn't change, empty etag and zero lastModified fetch the feed unconditionally
+func (p *Fetcher) Fetch(ctx context.Context, url string, headers map[string]string, login *entity.FeedLogin, etag string, lastModified time.Time) (feed *RSSFeed, err error) {
+	span, ctx := p.setupTracingSpan(ctx, "read-feed-from-url")
+	defer span.Finish()
+	span.SetTag("feed.url", url)
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", "Gofeed/1.0")
+	req.Header.Set("Accept", feedAcceptHeader)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	host := req.URL.Host
+	if p.hostPolicy != nil {
+		if err := p.hostPolicy.CheckHost(req.URL.Hostname()); err != nil {
+			p.logger.Warn("Feed ", url, " fetch rejected: ", err)
+			span.LogFields(
+				otLog.Error(err),
+			)
+			return nil, err
+		}
+	}
+
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+		p.logger.Debug("Set etag for feed retrieval: ", req.Header.Get("If-None-Match"))
+	}
+
+	if !lastModified.IsZero() {
+		req.Header.Set("If-Modified-Since", lastModified.In(p.gmtLocation).Format(time.RFC1123))
+		p.logger.Debug("Set If-Modified-Since header for feed retrieval: ", req.Header.Get("If-Modified-Since"))
+	}
+	// Injecting tracing span into outgoing requests - shown with Istio Envoy tracing
+	span.Tracer().Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+
+	// Wait for free fetch slot, it is held until the body is read and parsed
+	if p.fetchSlots != nil {
+		select {
+		case p.fetchSlots <- struct{}{}:
+		case <-ctx.Done():
+			span.LogFields(
+				otLog.Error(ctx.Err()),
+			)
+			return nil, fmt.Errorf("couldn't acquire fetch slot, %w", ctx.Err())
+		}
+		defer func() { <-p.fetchSlots }()
+		span.LogKV("event", "acquired fetch slot")
+	}
+	start := time.Now()
+	defer func() {
+		// Short-circuited fetch made no request
+		if errors.Is(err, ErrFeedHostUnavailable) {
+			return
+		}
+		duration := time.Since(start)
+		fetchDuration.Observe(duration.Seconds())
+		span.SetTag("feed.fetchDurationMs", duration.Milliseconds())
+		if feed != nil {
+			feed.FetchDuration = duration
+		}
+	}()
+	httpClient := p.httpClient
+	if login != nil {
+		httpClient, err = p.login(ctx, login)
+		if err != nil {
+			p.logger.Warn("Feed ", url, " login failed: ", err)
+			span.LogFields(
+				otLog.Error(err),
+			)
+			return nil, err
+		}
+		span.LogKV("event", "logged in")
+	}
+	// Allowed fetch must record its outcome, otherwise half-open breaker waits for probe result forever,
+	// so breaker is checked after the last return before the request
+	if p.hostBreakers != nil {
+		if err := p.hostBreakers.Allow(host); err != nil {
+			p.logger.Debug("Feed ", url, " fetch skipped, host ", host, " circuit breaker is open")
+			span.LogKV("event", "feed host circuit breaker is open, fetch skipped")
+			return nil, ErrFeedHostUnavailable
+		}
+	}
+	resp, err := httpClient.Do(req)
+	span.LogKV("event", "queried feed remote endpoint")
+
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		p.recordHostFailure(span, host, err)
+		return nil, err
+	}
+
+	if resp != nil {
+		defer func() {
+			// Unread body prevents connection reuse, drain the rest of reasonably small error responses
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxDrainedResponseSize))
+			ce := resp.Body.Close()
+			if ce != nil {
+				err = ce
+			}
+		}()
+	}
+	p.logger.Debug("Got HTTP response: ", resp.StatusCode)
+	ext.HTTPStatusCode.Set(span, uint16(resp.StatusCode))
+	// Only server side errors mean the host is down, client errors are specific to the feed
+	if resp.StatusCode >= 500 {
+		p.recordHostFailure(span, host, fmt.Errorf("HTTP status %s", resp.Status))
+	} else if p.hostBreakers != nil {
+		p.hostBreakers.Success(host)
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, ErrNotModified
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, gofeed.HTTPError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+		}
+	}
+
+	if eTag := resp.Header.Get("Etag"); eTag != "" && p.parseCache != nil {
+		if cached := p.parseCache.get(url, eTag); cached != nil {
+			p.logger.Debug("Feed ", url, " with ETag ", eTag, " is reused from parse cache")
+			span.LogKV("event", "reused cached parsed feed")
+			feed = cached
+			return feed, nil
+		}
+	}
+	feed = &RSSFeed{}
+
+	// Content type is only informational - servers often send feeds as text/html or text/plain,
+	// gofeed detects RSS, Atom or JSON Feed format from the body itself
+	span.SetTag("feed.contentType", resp.Header.Get("Content-Type"))
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		feed.ContentType = strings.ToLower(mediaType)
+	}
+	rawBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return nil, err
+	}
+	utf8Body, err := toUTF8(rawBody, resp.Header.Get("Content-Type"))
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return nil, fmt.Errorf("couldn't convert feed to UTF-8, %v", err)
+	}
+	feedBody, err := newFeedParser().Parse(bytes.NewReader(utf8Body))
+	if err != nil && p.lenientParsing {
+		if repaired, fixes := repairFeedXML(utf8Body); len(fixes) > 0 {
+			if repairedFeed, repairErr := newFeedParser().Parse(bytes.NewReader(repaired)); repairErr == nil {
+				p.logger.Warn("Feed ", url, " is malformed (", err, "), parsed after recovery: ", strings.Join(fixes, ", "))
+				span.LogKV("event", "parsed feed after recovery", "fixes", strings.Join(fixes, ", "))
+				span.SetTag("feed.recovered", true)
+				feedBody, err = repairedFeed, nil
+			}
+		}
+	}
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return nil, err
+	}
+	feed.Feed = feedBody
+	span.SetTag("feed.type", feedBody.FeedType)
+
+	if eTag := resp.Header.Get("Etag"); eTag != "" {
+		p.logger.Debug("ETag from feed request: ", eTag)
+		feed.ETag = eTag
+	}
+
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+		p.logger.Debug("Last-Modifed from feed request: ", lastModified)
+		parsed, err := time.ParseInLocation(time.RFC1123, lastModified, p.gmtLocation)
+		if err == nil {
+			feed.LastModified = parsed
+		}
+	}
+	span.LogKV("event", "parsed feed")
+	if p.parseCache != nil {
+		p.parseCache.put(url, feed, len(rawBody))
+	}
+	return feed, err
+}
+
+// FetchNextPages follows RFC 5005 link rel="next" of fetched feed page by page, up to maxPages pages,
+// and appends items of the pages, which are not on the previous pages, to the feed. Pages are fetched unconditionally.
+// Paging stops on the last page, on the link to already visited page, or on page fetch failure - items collected
+// so far are kept then. Returns the number of fetched pages.
+func (p *Fetcher) FetchNextPages(ctx context.Context, feed *RSSFeed, feedURL string, headers map[string]string, login *entity.FeedLogin, maxPages int) int {
+	span, ctx := p.setupTracingSpan(ctx, "fetch-next-pages")
+	defer span.Finish()
+	visited := map[string]bool{feedURL: true}
+	seenItems := make(map[string]bool, len(feed.Items))
+	for _, item := range feed.Items {
+		seenItems[item.GUID] = true
+	}
+	page, pageURL := feed.Feed, feedURL
+	pages := 0
+	for pages < maxPages {
+		next := feedNextPageURL(page)
+		if next == "" {
+			break
+		}
+		base, err := url.Parse(pageURL)
+		if err != nil {
+			break
+		}
+		nextURL, err := base.Parse(next)
+		if err != nil {
+			p.logger.Warn("Feed ", feedURL, " page ", pageURL, " has invalid next page link ", next, ": ", err)
+			break
+		}
+		pageURL = nextURL.String()
+		if visited[pageURL] {
+			p.logger.Warn("Feed ", feedURL, " paging loops to already fetched page ", pageURL)
+			span.LogKV("event", "paging loop detected")
+			break
+		}
+		visited[pageURL] = true
+		nextPage, err := p.Fetch(ctx, pageURL, headers, login, "", time.Time{})
+		if err != nil {
+			p.logger.Warn("Feed ", feedURL, " paging stopped, failure fetching page ", pageURL, ": ", err)
+			span.LogFields(
+				otLog.Error(err),
+			)
+			break
+		}
+		pages++
+		for _, item := range nextPage.Items {
+			if seenItems[item.GUID] {
+				continue
+			}
+			seenItems[item.GUID] = true
+			feed.Items = append(feed.Items, item)
+		}
+		page = nextPage.Feed
+	}
+	span.SetTag("feed.nextPages", pages)
+	return pages
+}
+
+// login executes feed login request and returns http client, which sends cookies set by login response.
+// Cookie jar lives only for the single fetch: session isn't shared between feeds and isn't kept between refreshes,
+// so expired sessions never need handling at the cost of login on every refresh.
+func (p *Fetcher) login(ctx context.Context, login *entity.FeedLogin) (*http.Client, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	client := *p.httpClient
+	client.Jar = jar
+	form := url.Values{}
+	for name, value := range login.Fields {
+		form.Set(name, value)
+	}
+	var req *http.Request
+	if login.Method == http.MethodGet {
+		req, err = http.NewRequest(http.MethodGet, login.URL, nil)
+		if err == nil {
+			req.URL.RawQuery = form.Encode()
+		}
+	} else {
+		req, err = http.NewRequest(http.MethodPost, login.URL, strings.NewReader(form.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFeedLoginFailed, err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", "Gofeed/1.0")
+	if p.hostPolicy != nil {
+		if err := p.hostPolicy.CheckHost(req.URL.Hostname()); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFeedLoginFailed, err)
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxLoginResponseSize))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%w: HTTP status %s", ErrFeedLoginFailed, resp.Status)
+	}
+	p.logger.Debug("Logged in to ", req.URL.Host, " for feed fetch")
+	return &client, nil
+}
+
+// recordHostFailure records fetch failure in feed host circuit breaker, reporting if breaker trips
+func (p *Fetcher) recordHostFailure(span opentracing.Span, host string, err error) {
+	if p.hostBreakers == nil {
+		return
+	}
+	if p.hostBreakers.Failure(host) {
+		p.logger.Warn("Feed host ", host, " circuit breaker opened after failure: ", err)
+		span.LogKV("event", "feed host circuit breaker opened")
+	}
+}
+
+func (p *Fetcher) setupTracingSpan(ctx context.Context, name string) (opentracing.Span, context.Context) {
+	span, ctx := opentracing.StartSpanFromContextWithTracer(ctx, p.tracer, name)
+	ext.Component.Set(span, "feedsFetcher")
+	return span, ctx
+}
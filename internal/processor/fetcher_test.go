@@ -0,0 +1,113 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Tarick/naca-rss-feeds/internal/circuitbreaker"
+	"github.com/Tarick/naca-rss-feeds/internal/entity"
+	"github.com/Tarick/naca-rss-feeds/internal/hostpolicy"
+)
+
+func TestFetchHalfOpenProbeRecordsOutcome(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		// Feed host hangs until the fetch is cancelled
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := serverURL.Host
+
+	tests := []struct {
+		name string
+		// slotTaken makes fetch wait for fetch slot
+		slotTaken bool
+		login     *entity.FeedLogin
+		timeout   time.Duration
+	}{
+		{name: "cancelled waiting for fetch slot", slotTaken: true, timeout: 50 * time.Millisecond},
+		{name: "login failed", login: &entity.FeedLogin{URL: server.URL + "/login"}, timeout: time.Second},
+		{name: "cancelled during probe request", timeout: 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Breaker is half-open on the next fetch, since open timeout is 0
+			breakers := circuitbreaker.NewRegistry(&circuitbreaker.Config{FailureThreshold: 1})
+			if !breakers.Failure(host) {
+				t.Fatal("breaker isn't tripped open")
+			}
+			fetcher := NewFetcher(nil, 1, breakers, nil, nil, nil)
+			if tt.slotTaken {
+				fetcher.fetchSlots <- struct{}{}
+				defer func() { <-fetcher.fetchSlots }()
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			if _, err := fetcher.Fetch(ctx, server.URL+"/feed.xml", nil, tt.login, "", time.Time{}); err == nil {
+				t.Fatal("Fetch() error = nil, want error")
+			}
+			// Breaker stuck in half-open state waits for the probe outcome and rejects every fetch
+			if err := breakers.Allow(host); err != nil {
+				t.Errorf("breaker rejects the next probe after fetch: %v", err)
+			}
+		})
+	}
+}
+
+func TestFetchRedirectHostPolicy(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(`<?xml version="1.0"?><rss version="2.0"><channel><title>Redirected</title></channel></rss>`))
+	}))
+	defer target.Close()
+	targetURL, err := url.Parse(target.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Redirect target is the same local server, reached by another host name
+	redirectURL := "http://localhost:" + targetURL.Port() + "/feed.xml"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, redirectURL, http.StatusFound)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		policy  hostpolicy.Config
+		wantErr bool
+	}{
+		{name: "redirect to allowed host", policy: hostpolicy.Config{AllowPrivateNetworks: true}},
+		{name: "redirect to denied host", policy: hostpolicy.Config{AllowPrivateNetworks: true, DeniedHosts: []string{"localhost"}}, wantErr: true},
+		{name: "redirect outside allowed hosts", policy: hostpolicy.Config{AllowPrivateNetworks: true, AllowedHosts: []string{"127.0.0.1"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetcher := NewFetcher(nil, 0, nil, hostpolicy.New(&tt.policy), nil, nil)
+			_, err := fetcher.Fetch(context.Background(), server.URL+"/feed.xml", nil, nil, "", time.Time{})
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("Fetch() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, hostpolicy.ErrHostNotAllowed) {
+				t.Errorf("Fetch() error = %v, want %v", err, hostpolicy.ErrHostNotAllowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Tarick/naca-rss-feeds/internal/entity"
+	"github.com/mmcdole/gofeed"
+)
+
+// itemFilterRule is compiled entity.FeedFilterRule
+type itemFilterRule struct {
+	field    string
+	pattern  *regexp.Regexp
+	category string
+}
+
+// itemFilter selects feed items to publish according to feed filter
+type itemFilter struct {
+	include []itemFilterRule
+	exclude []itemFilterRule
+}
+
+// newItemFilter compiles feed filter, nil filter matches all items
+func newItemFilter(filter *entity.FeedFilter) (*itemFilter, error) {
+	f := &itemFilter{}
+	if filter == nil {
+		return f, nil
+	}
+	var err error
+	if f.include, err = compileFilterRules(filter.Include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = compileFilterRules(filter.Exclude); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func compileFilterRules(rules []entity.FeedFilterRule) ([]itemFilterRule, error) {
+	compiled := make([]itemFilterRule, 0, len(rules))
+	for _, rule := range rules {
+		switch rule.Field {
+		case entity.FeedFilterFieldCategory:
+			compiled = append(compiled, itemFilterRule{field: rule.Field, category: rule.Pattern})
+		case entity.FeedFilterFieldTitle, entity.FeedFilterFieldContent:
+			pattern, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid filter pattern %q, %v", rule.Pattern, err)
+			}
+			compiled = append(compiled, itemFilterRule{field: rule.Field, pattern: pattern})
+		default:
+			return nil, fmt.Errorf("unsupported filter field %q", rule.Field)
+		}
+	}
+	return compiled, nil
+}
+
+// matches reports if item should be published: it must match any include rule (if there are any) and no exclude rules
+func (f *itemFilter) matches(item *gofeed.Item) bool {
+	for _, rule := range f.exclude {
+		if rule.matches(item) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, rule := range f.include {
+		if rule.matches(item) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r itemFilterRule) matches(item *gofeed.Item) bool {
+	switch r.field {
+	case entity.FeedFilterFieldTitle:
+		return r.pattern.MatchString(item.Title)
+	case entity.FeedFilterFieldContent:
+		return r.pattern.MatchString(item.Description) || r.pattern.MatchString(item.Content)
+	case entity.FeedFilterFieldCategory:
+		for _, category := range item.Categories {
+			if strings.EqualFold(strings.TrimSpace(category), r.category) {
+				return true
+			}
+		}
+	}
+	return false
+}
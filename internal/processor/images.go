@@ -0,0 +1,71 @@
+package processor
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// itemImageURL returns absolute URL of the lead image of item: item image (e.g. media tag), otherwise the first
+// <img src> of item content or description. Relative URLs are resolved against item link, feed link or feed URL.
+// Empty if item has no images or image URL is invalid.
+func itemImageURL(item *gofeed.Item, feed *gofeed.Feed, feedURL string) string {
+	src := ""
+	if item.Image != nil {
+		src = strings.TrimSpace(item.Image.URL)
+	}
+	if src == "" {
+		src = firstImageSrc(item.Content)
+	}
+	if src == "" {
+		src = firstImageSrc(item.Description)
+	}
+	if src == "" {
+		return ""
+	}
+	base, err := url.Parse(feedURL)
+	if err != nil {
+		return ""
+	}
+	for _, link := range []string{feed.Link, item.Link} {
+		if u, err := base.Parse(strings.TrimSpace(link)); err == nil && link != "" {
+			base = u
+		}
+	}
+	image, err := base.Parse(src)
+	if err != nil || (image.Scheme != "http" && image.Scheme != "https") {
+		return ""
+	}
+	return image.String()
+}
+
+// firstImageSrc returns src of the first img element with non-empty src in HTML, empty if there is none
+func firstImageSrc(text string) string {
+	if !strings.Contains(text, "<") {
+		return ""
+	}
+	tokenizer := html.NewTokenizer(strings.NewReader(text))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := tokenizer.TagName()
+			if atom.Lookup(name) != atom.Img {
+				continue
+			}
+			for hasAttr {
+				var key, value []byte
+				key, value, hasAttr = tokenizer.TagAttr()
+				src := strings.TrimSpace(string(value))
+				// Inline data images are not URLs downstream can fetch
+				if string(key) == "src" && src != "" && !strings.HasPrefix(src, "data:") {
+					return src
+				}
+			}
+		}
+	}
+}
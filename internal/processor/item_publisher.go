@@ -0,0 +1,177 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Tarick/naca-rss-feeds/internal/circuitbreaker"
+
+	"github.com/gofrs/uuid"
+)
+
+// Item publisher modes
+const (
+	// ItemPublishModeNSQ publishes items to Items service via NSQ, default
+	ItemPublishModeNSQ = "nsq"
+	// ItemPublishModeNoop discards items, dry-run
+	ItemPublishModeNoop = "noop"
+	// ItemPublishModeLog logs items instead of publishing, e.g. for local development without Items service
+	ItemPublishModeLog = "log"
+	// ItemPublishModeDigest publishes new items of feed refresh in digest messages via NSQ, see ItemsDigestMessage
+	ItemPublishModeDigest = "digest"
+)
+
+// ItemPublishConfig defines publishing of new items
+type ItemPublishConfig struct {
+	// Mode is "nsq" (default), "noop", "log" or "digest". Host and topic are used by "nsq" and "digest" modes only
+	Mode  string `mapstructure:"mode"`
+	Host  string `mapstructure:"host"`
+	Topic string `mapstructure:"topic"`
+	// CircuitBreaker stops publishing of items or digests after consecutive failures, failure_threshold 0 disables it
+	CircuitBreaker circuitbreaker.Config `mapstructure:"circuit_breaker"`
+	// DigestMaxItems in single digest, 100 if not set
+	DigestMaxItems int `mapstructure:"digest_max_items"`
+	// DigestMaxBytes of encoded items in single digest, 512KB if not set. Must be below nsqd --max-msg-size
+	DigestMaxBytes int `mapstructure:"digest_max_bytes"`
+}
+
+// Validate checks publisher mode and its settings
+func (c *ItemPublishConfig) Validate() error {
+	switch c.Mode {
+	case "", ItemPublishModeNSQ, ItemPublishModeDigest:
+		if c.Host == "" || c.Topic == "" {
+			return errors.New("host and topic must be set in nsq and digest modes")
+		}
+	case ItemPublishModeNoop, ItemPublishModeLog:
+	default:
+		return fmt.Errorf("unsupported mode '%s', must be '%s', '%s', '%s' or '%s'", c.Mode, ItemPublishModeNSQ, ItemPublishModeNoop, ItemPublishModeLog, ItemPublishModeDigest)
+	}
+	if c.DigestMaxItems < 0 || c.DigestMaxBytes < 0 {
+		return errors.New("digest_max_items and digest_max_bytes must not be negative")
+	}
+	return nil
+}
+
+// NewNoopItemPublisher returns item publisher, which discards items
+func NewNoopItemPublisher() *noopItemPublisher {
+	return &noopItemPublisher{}
+}
+
+type noopItemPublisher struct{}
+
+func (p *noopItemPublisher) PublishNewItem(
+	publicationUUID uuid.UUID,
+	title string,
+	description string,
+	content string,
+	url string,
+	languageCode string,
+	publishedDate time.Time,
+) error {
+	return nil
+}
+
+// NewLoggingItemPublisher returns item publisher, which logs items that would be published
+func NewLoggingItemPublisher(logger Logger) *loggingItemPublisher {
+	return &loggingItemPublisher{logger}
+}
+
+type loggingItemPublisher struct {
+	logger Logger
+}
+
+func (p *loggingItemPublisher) PublishNewItem(
+	publicationUUID uuid.UUID,
+	title string,
+	description string,
+	content string,
+	url string,
+	languageCode string,
+	publishedDate time.Time,
+) error {
+	p.logger.Info("Would publish item of publication ", publicationUUID, ": title '", title, "', url ", url,
+		", language code '", languageCode, "', published ", publishedDate.Format(time.RFC3339),
+		", description ", len(description), " bytes, content ", len(content), " bytes")
+	return nil
+}
+
+// ErrItemPublisherUnavailable is returned when items publishing is short-circuited by circuit breaker
+var ErrItemPublisherUnavailable = errors.New("item publisher is unavailable, circuit breaker is open")
+
+// CircuitBreaker guards calls to the failing dependency
+type CircuitBreaker interface {
+	// Allow returns error if call must be short-circuited
+	Allow() error
+	Success()
+	// Failure returns true if the breaker has been tripped open
+	Failure() bool
+}
+
+// NewCircuitBreakingItemPublisher wraps item publisher client with circuit breaker
+func NewCircuitBreakingItemPublisher(itemPublisherClient ItemPublisherClient, breaker CircuitBreaker, logger Logger) *circuitBreakingItemPublisher {
+	return &circuitBreakingItemPublisher{itemPublisherClient, breaker, logger}
+}
+
+type circuitBreakingItemPublisher struct {
+	itemPublisher ItemPublisherClient
+	breaker       CircuitBreaker
+	logger        Logger
+}
+
+func (p *circuitBreakingItemPublisher) PublishNewItem(
+	publicationUUID uuid.UUID,
+	title string,
+	description string,
+	content string,
+	url string,
+	languageCode string,
+	publishedDate time.Time,
+) error {
+	if err := p.breaker.Allow(); err != nil {
+		return ErrItemPublisherUnavailable
+	}
+	err := p.itemPublisher.PublishNewItem(publicationUUID, title, description, content, url, languageCode, publishedDate)
+	if err != nil {
+		if p.breaker.Failure() {
+			p.logger.Error("Item publisher circuit breaker opened after failure: ", err)
+		}
+		return err
+	}
+	p.breaker.Success()
+	return nil
+}
+
+// newInstrumentedItemPublisher wraps item publisher client with latency and errors metrics.
+// Metrics aren't labeled by feed to keep cardinality low.
+func newInstrumentedItemPublisher(itemPublisherClient ItemPublisherClient) *instrumentedItemPublisher {
+	return &instrumentedItemPublisher{itemPublisherClient}
+}
+
+type instrumentedItemPublisher struct {
+	itemPublisher ItemPublisherClient
+}
+
+func (p *instrumentedItemPublisher) PublishNewItem(
+	publicationUUID uuid.UUID,
+	title string,
+	description string,
+	content string,
+	url string,
+	languageCode string,
+	publishedDate time.Time,
+) error {
+	start := time.Now()
+	err := p.itemPublisher.PublishNewItem(publicationUUID, title, description, content, url, languageCode, publishedDate)
+	switch {
+	case err == ErrItemPublisherUnavailable:
+		// short-circuited calls don't reach Items service, so their latency isn't measured
+		itemPublishErrors.WithLabelValues("unavailable").Inc()
+	case err != nil:
+		itemPublishDuration.WithLabelValues("failure").Observe(time.Since(start).Seconds())
+		itemPublishErrors.WithLabelValues("error").Inc()
+	default:
+		itemPublishDuration.WithLabelValues("success").Observe(time.Since(start).Seconds())
+	}
+	return err
+}
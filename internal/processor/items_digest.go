@@ -0,0 +1,171 @@
+package processor
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// ItemsDigestMessageType is the type of items digest message
+const ItemsDigestMessageType = "ItemsDigest"
+
+const (
+	// defaultDigestMaxItems in single items digest
+	defaultDigestMaxItems = 100
+	// defaultDigestMaxBytes of encoded items in single items digest, half of nsqd default --max-msg-size
+	defaultDigestMaxBytes = 512 * 1024
+	// digestEnvelopeSize is reserved for digest fields besides items
+	digestEnvelopeSize = 1024
+)
+
+// ItemsDigestMessage is a batch of new items of the feed, published in digest mode instead of message per item.
+// Feed refresh with more new items than fit size caps publishes several digests.
+type ItemsDigestMessage struct {
+	// Type is always "ItemsDigest"
+	Type            string            `json:"type"`
+	PublicationUUID uuid.UUID         `json:"publication_uuid"`
+	FeedURL         string            `json:"feed_url"`
+	Items           []ItemsDigestItem `json:"items"`
+}
+
+// ItemsDigestItem is new item in items digest, with the same fields as published item
+type ItemsDigestItem struct {
+	GUID          string    `json:"guid"`
+	Title         string    `json:"title"`
+	Description   string    `json:"description"`
+	Content       string    `json:"content"`
+	URL           string    `json:"url"`
+	LanguageCode  string    `json:"language_code"`
+	PublishedDate time.Time `json:"published_date"`
+	// ImageURL is the lead image of item, set if images extraction is enabled and item has images
+	ImageURL string `json:"image_url,omitempty"`
+}
+
+// ItemsDigestPublisher publishes new items of the feed in batches
+type ItemsDigestPublisher interface {
+	PublishItemsDigest(*ItemsDigestMessage) error
+}
+
+// NewItemsDigestPublisher returns publisher of items digests as JSON messages
+func NewItemsDigestPublisher(producer MessageProducer) *itemsDigestPublisher {
+	return &itemsDigestPublisher{producer}
+}
+
+type itemsDigestPublisher struct {
+	producer MessageProducer
+}
+
+func (p *itemsDigestPublisher) PublishItemsDigest(digest *ItemsDigestMessage) error {
+	body, err := json.Marshal(digest)
+	if err != nil {
+		return err
+	}
+	return p.producer.Publish(body)
+}
+
+// NewCircuitBreakingItemsDigestPublisher wraps items digest publisher with circuit breaker,
+// short-circuited digests fail with ErrItemPublisherUnavailable
+func NewCircuitBreakingItemsDigestPublisher(publisher ItemsDigestPublisher, breaker CircuitBreaker, logger Logger) *circuitBreakingItemsDigestPublisher {
+	return &circuitBreakingItemsDigestPublisher{publisher, breaker, logger}
+}
+
+type circuitBreakingItemsDigestPublisher struct {
+	publisher ItemsDigestPublisher
+	breaker   CircuitBreaker
+	logger    Logger
+}
+
+func (p *circuitBreakingItemsDigestPublisher) PublishItemsDigest(digest *ItemsDigestMessage) error {
+	if err := p.breaker.Allow(); err != nil {
+		return ErrItemPublisherUnavailable
+	}
+	if err := p.publisher.PublishItemsDigest(digest); err != nil {
+		if p.breaker.Failure() {
+			p.logger.Error("Items digest publisher circuit breaker opened after failure: ", err)
+		}
+		return err
+	}
+	p.breaker.Success()
+	return nil
+}
+
+// itemsDigestSettings enable digest mode of processor
+type itemsDigestSettings struct {
+	publisher ItemsDigestPublisher
+	maxItems  int
+	maxBytes  int
+}
+
+// EnableItemsDigest makes processor publish new items of feed refresh in digests instead of one by one,
+// call it before processing starts. Digest is capped by the number of items and their encoded size from config.
+func (p *rssFeedsProcessor) EnableItemsDigest(publisher ItemsDigestPublisher, config *ItemPublishConfig) {
+	settings := &itemsDigestSettings{publisher, defaultDigestMaxItems, defaultDigestMaxBytes}
+	if config.DigestMaxItems > 0 {
+		settings.maxItems = config.DigestMaxItems
+	}
+	if config.DigestMaxBytes > 0 {
+		settings.maxBytes = config.DigestMaxBytes
+	}
+	p.itemsDigest = settings
+}
+
+// itemsDigest accumulates checked items of the feed into digest message within caps
+type itemsDigest struct {
+	settings *itemsDigestSettings
+	message  ItemsDigestMessage
+	items    []pendingItem
+	size     int
+}
+
+func newItemsDigest(settings *itemsDigestSettings, publicationUUID uuid.UUID, feedURL string) *itemsDigest {
+	return &itemsDigest{
+		settings: settings,
+		message:  ItemsDigestMessage{Type: ItemsDigestMessageType, PublicationUUID: publicationUUID, FeedURL: feedURL},
+		size:     digestEnvelopeSize,
+	}
+}
+
+// add appends item, if it fits caps. Item exceeding size cap alone is added to empty digest,
+// so it is still published if producer max message size allows it.
+func (d *itemsDigest) add(item pendingItem) bool {
+	digestItem := ItemsDigestItem{
+		GUID:          item.GUID,
+		Title:         item.Title,
+		Description:   item.description,
+		Content:       item.content,
+		URL:           item.Link,
+		LanguageCode:  item.languageCode,
+		PublishedDate: item.published.In(time.UTC),
+		ImageURL:      item.imageURL,
+	}
+	// Strings and time always encode
+	encoded, _ := json.Marshal(digestItem)
+	// comma separator
+	size := len(encoded) + 1
+	if len(d.items) > 0 && (len(d.items) >= d.settings.maxItems || d.size+size > d.settings.maxBytes) {
+		return false
+	}
+	d.message.Items = append(d.message.Items, digestItem)
+	d.items = append(d.items, item)
+	d.size += size
+	return true
+}
+
+func (d *itemsDigest) full() bool {
+	return len(d.items) >= d.settings.maxItems || d.size >= d.settings.maxBytes
+}
+
+func (d *itemsDigest) empty() bool {
+	return len(d.items) == 0
+}
+
+// take returns accumulated digest and its items, resetting the digest
+func (d *itemsDigest) take() (*ItemsDigestMessage, []pendingItem) {
+	message := d.message
+	items := d.items
+	d.message.Items = nil
+	d.items = nil
+	d.size = digestEnvelopeSize
+	return &message, items
+}
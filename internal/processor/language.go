@@ -0,0 +1,35 @@
+package processor
+
+import (
+	"strings"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/mmcdole/gofeed"
+)
+
+// normalizeLanguageCode converts language tag (e.g. "en-US", "de_DE", "FR") to two-letter ISO 639-1 code.
+// Returns empty string if tag doesn't start with valid code.
+func normalizeLanguageCode(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	if len(tag) != 2 || !govalidator.IsISO693Alpha2(tag) {
+		return ""
+	}
+	return tag
+}
+
+// itemLanguageCode resolves language of the item in multilingual feeds:
+// item level dc:language extension, then feed language code, then the language declared by the feed.
+func itemLanguageCode(item *gofeed.Item, feedLanguageCode string, declaredLanguage string) string {
+	for _, extension := range item.Extensions["dc"]["language"] {
+		if languageCode := normalizeLanguageCode(extension.Value); languageCode != "" {
+			return languageCode
+		}
+	}
+	if feedLanguageCode != "" {
+		return feedLanguageCode
+	}
+	return normalizeLanguageCode(declaredLanguage)
+}
@@ -6,3 +6,11 @@ type Logger interface {
 	Warn(args ...interface{})
 	Error(args ...interface{})
 }
+
+// nopLogger discards all messages
+type nopLogger struct{}
+
+func (nopLogger) Debug(args ...interface{}) {}
+func (nopLogger) Info(args ...interface{})  {}
+func (nopLogger) Warn(args ...interface{})  {}
+func (nopLogger) Error(args ...interface{}) {}
@@ -9,6 +9,7 @@ const (
 )
 
 // MessageType defines types of messages
+//
 //go:generate stringer -type=MessageType
 type MessageType uint
 
@@ -19,9 +20,13 @@ type MessageEnvelope struct {
 	Msg      interface{}
 }
 
-// FeedsUpdateOneMsg is used to trigger update for one feed using its publicationUUID
+// FeedsUpdateOneMsg is used to trigger update for one feed using its feedID
+// Force makes refresh ignore ETag and Last-Modified and process the full feed
 type FeedsUpdateOneMsg struct {
+	FeedID uuid.UUID `json:"feed_id"`
+	// PublicationUUID is set by producers, which predate feed ids, all feeds of the publication are refreshed then
 	PublicationUUID uuid.UUID `json:"publication_uuid,string"`
+	Force           bool      `json:"force"`
 }
 
 // FeedsUpdateAllMsg is used to trigger update of all feeds
@@ -29,10 +34,10 @@ type FeedsUpdateAllMsg struct {
 }
 
 // NewFeedsUpdateOneMessage returns message envelope with action to update one feed
-func NewFeedsUpdateOneMessage(publicationUUID uuid.UUID) *MessageEnvelope {
+func NewFeedsUpdateOneMessage(feedID uuid.UUID, force bool) *MessageEnvelope {
 	return &MessageEnvelope{
 		Type: FeedsUpdateOne,
-		Msg:  FeedsUpdateOneMsg{PublicationUUID: publicationUUID},
+		Msg:  FeedsUpdateOneMsg{FeedID: feedID, Force: force},
 	}
 }
 
@@ -0,0 +1,69 @@
+package processor
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// fetchDuration measures feed fetch and parse time.
+// It isn't labeled by feed host, since histogram series per host grow with the number of feeds
+var fetchDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Namespace: "naca_rss_feeds",
+	Name:      "fetch_duration_seconds",
+	Help:      "Duration of feed fetch and parse, excluding wait for free fetch slot",
+	Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+})
+
+// itemPublishDuration measures item publish calls to Items service, by result
+var itemPublishDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "naca_rss_feeds",
+	Name:      "item_publish_duration_seconds",
+	Help:      "Duration of new item publish to Items service, by result: success or failure",
+	Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+}, []string{"result"})
+
+// itemPublishErrors counts failed item publish calls, by reason: error or unavailable (short-circuited by circuit breaker)
+var itemPublishErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "naca_rss_feeds",
+	Name:      "item_publish_errors_total",
+	Help:      "Failed new item publishes to Items service, by reason: error or unavailable",
+}, []string{"reason"})
+
+// emptyFeedFetches counts successful feed fetches, which returned no items, per feed host.
+// Consecutive empty refreshes of each feed are tracked in repository, see ProcessingConfig.QuarantineAfterEmptyRefreshes
+var emptyFeedFetches = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "naca_rss_feeds",
+	Name:      "empty_feed_fetches_total",
+	Help:      "Successful feed fetches, which returned no items, per feed host",
+}, []string{"host"})
+
+// messageProcessingDuration measures processing of consumed messages, by message type
+var messageProcessingDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "naca_rss_feeds",
+	Name:      "message_processing_duration_seconds",
+	Help:      "Duration of consumed message processing, by message type",
+	Buckets:   prometheus.ExponentialBuckets(0.01, 2, 16),
+}, []string{"type"})
+
+// messageProcessingFailures counts consumed messages, which processing failed, by message type
+var messageProcessingFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "naca_rss_feeds",
+	Name:      "message_processing_failures_total",
+	Help:      "Failed consumed message processing, by message type",
+}, []string{"type"})
+
+// observeMessageProcessing records processing duration and failure of the message.
+// Undefined types are labeled "unknown" to keep cardinality low.
+func observeMessageProcessing(messageType MessageType, start time.Time, err error) {
+	label := "unknown"
+	switch messageType {
+	case FeedsUpdateOne, FeedsUpdateAll:
+		label = messageType.String()
+	}
+	messageProcessingDuration.WithLabelValues(label).Observe(time.Since(start).Seconds())
+	if err != nil {
+		messageProcessingFailures.WithLabelValues(label).Inc()
+	}
+}
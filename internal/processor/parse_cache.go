@@ -0,0 +1,147 @@
+package processor
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// ParseCacheConfig defines short-lived in-memory cache of parsed feeds, keyed by feed url and response ETag.
+// Refreshes of the same feed close together (e.g. duplicate refresh messages) reuse the parsed feed instead of
+// downloading the rest of the body and parsing it again. Feeds without ETag are never cached.
+type ParseCacheConfig struct {
+	// TTL in seconds of cached feed, 0 disables caching. At most 600
+	TTL int `mapstructure:"ttl"`
+	// MaxEntries bounds the number of cached feeds, the oldest one is evicted. 100 if not set
+	MaxEntries int `mapstructure:"max_entries"`
+	// MaxBodySize in bytes of feed response to cache, larger feeds are not cached. 1MB if not set.
+	// Cache memory is roughly bounded by max_entries * max_body_size
+	MaxBodySize int `mapstructure:"max_body_size"`
+}
+
+// maxParseCacheTTL keeps cache short-lived, it complements conditional requests, not replaces them
+const maxParseCacheTTL = 600
+
+const (
+	defaultParseCacheMaxEntries  = 100
+	defaultParseCacheMaxBodySize = 1024 * 1024
+)
+
+// Validate checks parse cache configuration values
+func (c *ParseCacheConfig) Validate() error {
+	if c.TTL < 0 || c.MaxEntries < 0 || c.MaxBodySize < 0 {
+		return errors.New("ttl, max_entries and max_body_size must not be negative")
+	}
+	if c.TTL > maxParseCacheTTL {
+		return errors.New("ttl must not exceed 600 seconds")
+	}
+	return nil
+}
+
+func (c *ParseCacheConfig) maxEntries() int {
+	if c.MaxEntries > 0 {
+		return c.MaxEntries
+	}
+	return defaultParseCacheMaxEntries
+}
+
+func (c *ParseCacheConfig) maxBodySize() int {
+	if c.MaxBodySize > 0 {
+		return c.MaxBodySize
+	}
+	return defaultParseCacheMaxBodySize
+}
+
+// parseCache keeps parsed feeds, entries are kept in insertion order, which is also their expiration order
+type parseCache struct {
+	mu          sync.Mutex
+	ttl         time.Duration
+	maxEntries  int
+	maxBodySize int
+	entries     map[string]*list.Element
+	order       *list.List
+}
+
+type parseCacheEntry struct {
+	key          string
+	feed         *gofeed.Feed
+	contentType  string
+	lastModified time.Time
+	expires      time.Time
+}
+
+func newParseCache(config *ParseCacheConfig) *parseCache {
+	return &parseCache{
+		ttl:         time.Duration(config.TTL) * time.Second,
+		maxEntries:  config.maxEntries(),
+		maxBodySize: config.maxBodySize(),
+		entries:     map[string]*list.Element{},
+		order:       list.New(),
+	}
+}
+
+func parseCacheKey(url string, etag string) string {
+	return url + "\n" + etag
+}
+
+// get returns copy of cached feed, nil if there is no such feed or it expired
+func (c *parseCache) get(url string, etag string) *RSSFeed {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evictExpired(time.Now())
+	element, ok := c.entries[parseCacheKey(url, etag)]
+	if !ok {
+		return nil
+	}
+	entry := element.Value.(*parseCacheEntry)
+	// Items slice is copied, so appending next pages doesn't change cached feed. Items themselves are not modified after parsing
+	feed := *entry.feed
+	feed.Items = append([]*gofeed.Item(nil), entry.feed.Items...)
+	return &RSSFeed{
+		Feed:         &feed,
+		ETag:         etag,
+		LastModified: entry.lastModified,
+		ContentType:  entry.contentType,
+	}
+}
+
+// put caches parsed feed, feeds without ETag or with body larger than max body size are skipped
+func (c *parseCache) put(url string, feed *RSSFeed, bodySize int) {
+	if feed.ETag == "" || bodySize > c.maxBodySize {
+		return
+	}
+	key := parseCacheKey(url, feed.ETag)
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.order.Remove(element)
+	}
+	cached := *feed.Feed
+	cached.Items = append([]*gofeed.Item(nil), feed.Items...)
+	c.entries[key] = c.order.PushBack(&parseCacheEntry{
+		key:          key,
+		feed:         &cached,
+		contentType:  feed.ContentType,
+		lastModified: feed.LastModified,
+		expires:      now.Add(c.ttl),
+	})
+	c.evictExpired(now)
+	for c.order.Len() > c.maxEntries {
+		c.remove(c.order.Front())
+	}
+}
+
+func (c *parseCache) evictExpired(now time.Time) {
+	for element := c.order.Front(); element != nil && !now.Before(element.Value.(*parseCacheEntry).expires); element = c.order.Front() {
+		c.remove(element)
+	}
+}
+
+func (c *parseCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*parseCacheEntry).key)
+}
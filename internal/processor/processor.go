@@ -2,13 +2,22 @@ package processor
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"sort"
+	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/Tarick/naca-rss-feeds/internal/circuitbreaker"
 	"github.com/Tarick/naca-rss-feeds/internal/entity"
+	"github.com/Tarick/naca-rss-feeds/internal/hostpolicy"
+	"github.com/Tarick/naca-rss-feeds/internal/tlspolicy"
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
 	otLog "github.com/opentracing/opentracing-go/log"
@@ -21,28 +30,122 @@ import (
 // ErrNotModified is used for Etag and Last-Modified handling
 var ErrNotModified = errors.New("not modified")
 
+// feedAcceptHeader lists supported feed formats: JSON Feed, RSS and Atom, with generic XML as fallback
+const feedAcceptHeader = "application/feed+json, application/rss+xml, application/atom+xml, application/xml;q=0.9, text/xml;q=0.9, */*;q=0.8"
+
+// ErrFeedHostUnavailable is returned when feed fetch is short-circuited by feed host circuit breaker
+var ErrFeedHostUnavailable = errors.New("feed host is unavailable, circuit breaker is open")
+
+// ErrMalformedMessage is returned for messages, which can't be processed regardless of number of attempts
+var ErrMalformedMessage = errors.New("malformed message")
+
+// permanentError marks error as non-retryable, so message consumer drops the message instead of requeueing it
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent reports that retrying won't help
+func (e *permanentError) Permanent() bool {
+	return true
+}
+
+// malformedMessageError wraps message decoding failure into permanent ErrMalformedMessage
+func malformedMessageError(format string, args ...interface{}) error {
+	return &permanentError{fmt.Errorf("%w: %s", ErrMalformedMessage, fmt.Sprintf(format, args...))}
+}
+
 // RSSFeed is extended feed with etag and lastmodified
 type RSSFeed struct {
 	*gofeed.Feed
 
 	ETag         string
 	LastModified time.Time
+	// FetchDuration is the time spent to fetch and parse the feed
+	FetchDuration time.Duration
+	// ContentType is response media type without parameters, e.g. "application/rss+xml"
+	ContentType string
+}
+
+// datedItem is feed item with resolved publication date
+type datedItem struct {
+	*gofeed.Item
+	published time.Time
+	// dedupDate is the publication date of processed item, see ProcessingConfig.DedupDate
+	dedupDate time.Time
+}
+
+// pendingItem is new item checked for publishing
+type pendingItem struct {
+	*gofeed.Item
+	processedItem *entity.ProcessedItem
+	published     time.Time
+	description   string
+	content       string
+	languageCode  string
+	// imageURL is lead image of item, if images extraction is enabled
+	imageURL string
+}
+
+// reversePendingItems collects all checked items and passes them on in reverse order, once checking is finished.
+// Returned channel is closed after all items are passed on or when ctx is cancelled.
+func reversePendingItems(ctx context.Context, pending <-chan pendingItem) <-chan pendingItem {
+	reversed := make(chan pendingItem)
+	go func() {
+		defer close(reversed)
+		items := []pendingItem{}
+		for candidate := range pending {
+			items = append(items, candidate)
+		}
+		for i := len(items) - 1; i >= 0; i-- {
+			select {
+			case reversed <- items[i]:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return reversed
 }
 
 // RSSFeedsUpdateProducer provides methods to call update (refresh news from) RSS Feed via messaging subsystem
 type RSSFeedsUpdateProducer interface {
-	SendUpdateOne(context.Context, uuid.UUID) error
+	SendUpdateOne(ctx context.Context, feedID uuid.UUID, force bool) error
+	SendUpdateOneDeferred(ctx context.Context, feedID uuid.UUID, force bool, delay time.Duration) error
 	SendUpdateAll(context.Context) error
 }
 
 // FeedsRepository defines repository methods
 type FeedsRepository interface {
 	GetAll(context.Context) ([]entity.Feed, error)
-	GetByPublicationUUID(context.Context, uuid.UUID) (*entity.Feed, error)
-	GetFeedHTTPMetadataByPublicationUUID(context.Context, uuid.UUID) (*entity.FeedHTTPMetadata, error)
+	GetByID(context.Context, uuid.UUID) (*entity.Feed, error)
+	GetByPublicationUUID(context.Context, uuid.UUID) ([]entity.Feed, error)
+	GetFeedHTTPMetadataByFeedID(context.Context, uuid.UUID) (*entity.FeedHTTPMetadata, error)
 	SaveFeedHTTPMetadata(context.Context, *entity.FeedHTTPMetadata) error
+	SaveFeedLastItemPublished(context.Context, uuid.UUID, time.Time) error
+	SaveFeedDetectedLanguageCode(context.Context, uuid.UUID, string) error
+	IncrementFeedFailures(context.Context, uuid.UUID, string) (int, error)
+	ResetFeedFailures(context.Context, uuid.UUID) error
+	DisableFeed(ctx context.Context, feedID uuid.UUID, reason string) error
+	SaveFeedFetchDuration(context.Context, uuid.UUID, time.Duration) error
+	SaveFeedContentCheck(ctx context.Context, feedID uuid.UUID, empty bool, contentType string) (int, string, error)
+	QuarantineFeed(ctx context.Context, feedID uuid.UUID, reason string) error
+	SaveItemSnapshot(context.Context, *entity.ItemSnapshot) error
+	PruneItemSnapshots(ctx context.Context, before time.Time) (int64, error)
 	SaveProcessedItem(context.Context, *entity.ProcessedItem) error
+	InsertProcessedItem(context.Context, *entity.ProcessedItem) error
 	ProcessedItemExists(context.Context, *entity.ProcessedItem) (bool, error)
+	ProcessedItemExistsByGUID(context.Context, *entity.ProcessedItem) (bool, error)
+	ProcessedItemExistsByDedupKey(ctx context.Context, dedupKey string) (bool, error)
+	FeedHasProcessedItems(ctx context.Context, feedID uuid.UUID) (bool, error)
+	RefreshAllCheckpointStore
 }
 
 type ItemPublisherClient interface {
@@ -57,50 +160,458 @@ type ItemPublisherClient interface {
 	) error
 }
 
+// WebhookNotifier sends notifications about new feed items to per-feed webhooks
+type WebhookNotifier interface {
+	Notify(ctx context.Context, url string, payload interface{}) error
+}
+
+// HostCircuitBreakers guard feed fetches with circuit breaker per feed host
+type HostCircuitBreakers interface {
+	// Allow returns error if fetch from host must be short-circuited
+	Allow(host string) error
+	Success(host string)
+	// Failure returns true if the breaker for host has been tripped open
+	Failure(host string) bool
+}
+
+// FetchConfig defines feeds retrieval configuration
+type FetchConfig struct {
+	// Timeout in seconds bounds fetch and parse of single feed, 0 means it's bounded only by message timeout.
+	// Feed fetch_timeout overrides it
+	Timeout int `mapstructure:"timeout"`
+	// Workers caps simultaneous outbound feed fetches worker-wide, independently of message handlers concurrency, 0 means no limit.
+	// Fetch waits for free slot until message processing context is cancelled.
+	Workers int `mapstructure:"workers"`
+	// HostCircuitBreaker stops fetches from the host after consecutive failures for a cooldown period
+	HostCircuitBreaker circuitbreaker.Config `mapstructure:"host_circuit_breaker"`
+	// HostPolicy restricts hosts feeds are fetched from, private networks are denied by default
+	HostPolicy hostpolicy.Config `mapstructure:"host_policy"`
+	// TLS defines minimum TLS version, additional CAs and hosts with unverified certificates, secure by default
+	TLS tlspolicy.Config `mapstructure:"tls"`
+	// Transport tunes connection reuse of the http transport shared by all fetches
+	Transport TransportConfig `mapstructure:"transport"`
+	// FollowNextPages is the maximum number of older pages followed by RFC 5005 link rel="next" to backfill history
+	// on the first fetch of feed (it has no processed items yet). 0 disables paging.
+	FollowNextPages int `mapstructure:"follow_next_pages"`
+	// LenientParsing attempts recovery of malformed XML feeds (raw ampersands, HTML entities, invalid characters)
+	// before failing the refresh
+	LenientParsing bool `mapstructure:"lenient_parsing"`
+	// ParseCache reuses parsed feed for refreshes of the same feed close together, disabled by default
+	ParseCache ParseCacheConfig `mapstructure:"parse_cache"`
+}
+
+// Validate checks fetch timeout, nested sections are validated separately
+func (c *FetchConfig) Validate() error {
+	if c.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	return nil
+}
+
+// TransportConfig tunes keep-alive connections pool of feed fetches, 0 keeps Go http.DefaultTransport settings.
+// Reused connections save TCP and TLS handshakes on frequently polled hosts
+type TransportConfig struct {
+	// MaxIdleConns across all hosts
+	MaxIdleConns int `mapstructure:"max_idle_conns"`
+	// MaxIdleConnsPerHost should be close to concurrent fetches of the same host, Go default is only 2
+	MaxIdleConnsPerHost int `mapstructure:"max_idle_conns_per_host"`
+	// MaxConnsPerHost limits all connections to single host, including active ones
+	MaxConnsPerHost int `mapstructure:"max_conns_per_host"`
+	// IdleConnTimeout in seconds to keep idle connection open
+	IdleConnTimeout int `mapstructure:"idle_conn_timeout"`
+}
+
+// Validate checks transport tuning values
+func (c *TransportConfig) Validate() error {
+	if c.MaxIdleConns < 0 || c.MaxIdleConnsPerHost < 0 || c.MaxConnsPerHost < 0 || c.IdleConnTimeout < 0 {
+		return errors.New("transport max_idle_conns, max_idle_conns_per_host, max_conns_per_host and idle_conn_timeout must not be negative")
+	}
+	return nil
+}
+
+// newTransport returns http transport shared by all fetches
+func newTransport(config *TransportConfig, tlsConfig *tlspolicy.Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlspolicy.New(tlsConfig)
+	if config.MaxIdleConns > 0 {
+		transport.MaxIdleConns = config.MaxIdleConns
+	}
+	if config.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+	}
+	if config.MaxConnsPerHost > 0 {
+		transport.MaxConnsPerHost = config.MaxConnsPerHost
+	}
+	if config.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = time.Duration(config.IdleConnTimeout) * time.Second
+	}
+	return transport
+}
+
+// ProcessingConfig defines feed items processing configuration
+type ProcessingConfig struct {
+	// MaxItemsPerRefresh caps new items published in single feed refresh, 0 means no limit.
+	// Items are published in ItemsOrder, the rest is deferred to the next refresh. Can be overridden per feed.
+	MaxItemsPerRefresh int `mapstructure:"max_items_per_refresh"`
+	// FirstFetchItemLimit caps items published on the first refresh of feed, which has no processed items.
+	// Only the newest items are published, the rest are saved as processed without publishing. 0 means no limit.
+	FirstFetchItemLimit int `mapstructure:"first_fetch_item_limit"`
+	// ItemsOrder defines the order of items processing by publication date, "newest_first" (default) or "oldest_first"
+	ItemsOrder string `mapstructure:"items_order"`
+	// PublishOrder defines the order new items are published in, "newest_first" or "oldest_first", independently of
+	// ItemsOrder, which decides items deferred by the cap. Empty publishes items in ItemsOrder as they are checked.
+	// Opposite order publishes items only after all new items of refresh are checked, holding them in memory.
+	PublishOrder string `mapstructure:"publish_order"`
+	// DedupMode defines how already processed items are detected:
+	// "strict" (default) matches GUID and publication date - item with changed date is published again as updated,
+	// "guid_only" matches GUID only - updates are never republished, but feeds jittering dates don't produce duplicates.
+	DedupMode string `mapstructure:"dedup_mode"`
+	// DedupDate defines date of processed item matched by "strict" dedup mode: "published" (default) is the item date,
+	// "latest" is the later of published and updated dates, so revised article is published again as updated.
+	// It has no effect in "guid_only" dedup mode, which ignores dates, so this combination is rejected.
+	DedupDate string `mapstructure:"dedup_date"`
+	// ProcessedItemConflict defines saving of processed item, which GUID is already saved: "upsert" (default) updates
+	// recorded date, "insert_only" keeps the first recorded date for audit. Recorded date never changes with insert_only,
+	// so processed items are matched by GUID only - updated items are never republished, "strict" dedup_mode is rejected.
+	ProcessedItemConflict string `mapstructure:"processed_item_conflict"`
+	// GlobalDedup additionally skips items already processed in any feed, matched by "url" (normalized item url)
+	// or "content_hash" (description and content). Empty disables it, dedup is then scoped per publication.
+	GlobalDedup string `mapstructure:"global_dedup"`
+	// MessageTimeout bounds processing of single message, in seconds. 0 means no timeout.
+	// Timed out message is requeued.
+	MessageTimeout int `mapstructure:"message_timeout"`
+	// TraceReference defines relation of message processing span to the sender span,
+	// "child_of" (default) links traces as parent-child chain, "follows_from" marks them as causally unrelated
+	TraceReference string `mapstructure:"trace_reference"`
+	// ContentPreference defines which item text is published, see ContentPreference* constants for fallback order
+	ContentPreference string `mapstructure:"content_preference"`
+	// MaxContentLength caps bytes of published item description and content each, 0 means no limit.
+	// Oversized text is handled according to OversizedContent, item metadata is published anyway
+	MaxContentLength int `mapstructure:"max_content_length"`
+	// OversizedContent defines handling of text exceeding MaxContentLength, see OversizedContent* constants
+	OversizedContent string `mapstructure:"oversized_content"`
+	// ExtractItemImages publishes absolute URL of item lead image, taken from item image or the first <img> of its
+	// content, in items digest and webhook notifications
+	ExtractItemImages bool `mapstructure:"extract_item_images"`
+	// DisableAfterFailures disables feed after this number of consecutive refresh failures, 0 means never
+	DisableAfterFailures int `mapstructure:"disable_after_failures"`
+	// QuarantineAfterEmptyRefreshes quarantines feed, which returned no items this number of refreshes in a row, 0 means never.
+	// Consecutive empty refreshes are tracked only if quarantine heuristics are enabled
+	QuarantineAfterEmptyRefreshes int `mapstructure:"quarantine_after_empty_refreshes"`
+	// QuarantineOnContentTypeChange quarantines feed, which response content type changed, e.g. from RSS to HTML
+	QuarantineOnContentTypeChange bool `mapstructure:"quarantine_on_content_type_change"`
+	// ItemSnapshots enables saving snapshots (title, url, content hash) of published items for auditing
+	ItemSnapshots bool `mapstructure:"item_snapshots"`
+	// ItemSnapshotsRetention is the number of days to keep item snapshots, pruned on refresh of all feeds. 0 keeps them forever
+	ItemSnapshotsRetention int `mapstructure:"item_snapshots_retention"`
+	// PublishBuffer is the number of checked new items waiting for publishing, checking of feed items waits
+	// when buffer is full, so memory is bounded when Items service is slow. 16 if not set
+	PublishBuffer int `mapstructure:"publish_buffer"`
+	// RefreshAllCheckpointTTL in seconds limits resuming of interrupted refresh of all feeds, older refresh starts over.
+	// 3600 if not set
+	RefreshAllCheckpointTTL int `mapstructure:"refresh_all_checkpoint_ttl"`
+	// DateLayouts are Go time layouts tried for item dates, which gofeed couldn't parse, e.g. "02.01.2006 15:04 MST".
+	// Parsed dates are converted to UTC.
+	DateLayouts []string `mapstructure:"date_layouts"`
+	// RefreshSpread in seconds spreads feed refreshes scheduled by refresh of all feeds, each feed refresh is delayed
+	// by its stable offset within spread. 0 sends all refreshes immediately. Must not exceed nsqd --max-req-timeout
+	RefreshSpread int `mapstructure:"refresh_spread"`
+}
+
+// MaxRefreshSpread in seconds is the default nsqd --max-req-timeout, the limit of deferred message delay
+const MaxRefreshSpread = 3600
+
+// DefaultRefreshAllCheckpointTTL of interrupted refresh of all feeds
+const DefaultRefreshAllCheckpointTTL = time.Hour
+
+func (c *ProcessingConfig) refreshAllCheckpointTTL() time.Duration {
+	if c.RefreshAllCheckpointTTL > 0 {
+		return time.Duration(c.RefreshAllCheckpointTTL) * time.Second
+	}
+	return DefaultRefreshAllCheckpointTTL
+}
+
+// defaultPublishBuffer of checked new items waiting for publishing
+const defaultPublishBuffer = 16
+
+// reversesPublishOrder returns true if new items are published in reverse of processing order
+func (c *ProcessingConfig) reversesPublishOrder() bool {
+	switch c.PublishOrder {
+	case ItemsOrderOldestFirst:
+		return c.ItemsOrder != ItemsOrderOldestFirst
+	case ItemsOrderNewestFirst:
+		return c.ItemsOrder == ItemsOrderOldestFirst
+	}
+	return false
+}
+
+func (c *ProcessingConfig) publishBuffer() int {
+	if c.PublishBuffer > 0 {
+		return c.PublishBuffer
+	}
+	return defaultPublishBuffer
+}
+
+const (
+	// ItemsOrderNewestFirst processes newest items first
+	ItemsOrderNewestFirst = "newest_first"
+	// ItemsOrderOldestFirst processes oldest items first
+	ItemsOrderOldestFirst = "oldest_first"
+
+	// DedupModeStrict matches processed items by GUID and publication date
+	DedupModeStrict = "strict"
+	// DedupModeGUIDOnly matches processed items by GUID, ignoring publication date
+	DedupModeGUIDOnly = "guid_only"
+
+	// DedupDatePublished matches processed items by published date, updated date is used only if it is missing
+	DedupDatePublished = "published"
+	// DedupDateLatest matches processed items by the later of published and updated dates
+	DedupDateLatest = "latest"
+
+	// ProcessedItemConflictUpsert updates date of already saved processed item
+	ProcessedItemConflictUpsert = "upsert"
+	// ProcessedItemConflictInsertOnly keeps already saved processed item unchanged
+	ProcessedItemConflictInsertOnly = "insert_only"
+
+	// GlobalDedupURL matches items across feeds by normalized url
+	GlobalDedupURL = "url"
+	// GlobalDedupContentHash matches items across feeds by hash of description and content
+	GlobalDedupContentHash = "content_hash"
+
+	// TraceReferenceChildOf makes message processing span a child of the sender span
+	TraceReferenceChildOf = "child_of"
+	// TraceReferenceFollowsFrom makes message processing span follow from the sender span
+	TraceReferenceFollowsFrom = "follows_from"
+
+	// ContentPreferenceBoth publishes description and content as they are in the feed (default)
+	ContentPreferenceBoth = "both"
+	// ContentPreferenceContentFirst publishes content (e.g. content:encoded), falling back to description if content is empty
+	ContentPreferenceContentFirst = "content_first"
+	// ContentPreferenceDescriptionFirst publishes description, falling back to content if description is empty
+	ContentPreferenceDescriptionFirst = "description_first"
+
+	// OversizedContentTruncate cuts oversized text to max_content_length, ending it with truncation indicator (default)
+	OversizedContentTruncate = "truncate"
+	// OversizedContentDrop publishes oversized text empty
+	OversizedContentDrop = "drop"
+)
+
+// truncatedContentIndicator ends truncated item text
+const truncatedContentIndicator = "… [truncated]"
+
+// Validate checks processing configuration values
+func (c *ProcessingConfig) Validate() error {
+	switch c.ItemsOrder {
+	case "", ItemsOrderNewestFirst, ItemsOrderOldestFirst:
+	default:
+		return fmt.Errorf("unsupported items_order '%s', must be '%s' or '%s'", c.ItemsOrder, ItemsOrderNewestFirst, ItemsOrderOldestFirst)
+	}
+	switch c.PublishOrder {
+	case "", ItemsOrderNewestFirst, ItemsOrderOldestFirst:
+	default:
+		return fmt.Errorf("unsupported publish_order '%s', must be '%s' or '%s'", c.PublishOrder, ItemsOrderNewestFirst, ItemsOrderOldestFirst)
+	}
+	switch c.DedupMode {
+	case "", DedupModeStrict, DedupModeGUIDOnly:
+	default:
+		return fmt.Errorf("unsupported dedup_mode '%s', must be '%s' or '%s'", c.DedupMode, DedupModeStrict, DedupModeGUIDOnly)
+	}
+	switch c.DedupDate {
+	case "", DedupDatePublished:
+	case DedupDateLatest:
+		if c.DedupMode == DedupModeGUIDOnly {
+			return fmt.Errorf("dedup_date '%s' has no effect with dedup_mode '%s', which ignores dates", DedupDateLatest, DedupModeGUIDOnly)
+		}
+	default:
+		return fmt.Errorf("unsupported dedup_date '%s', must be '%s' or '%s'", c.DedupDate, DedupDatePublished, DedupDateLatest)
+	}
+	switch c.ProcessedItemConflict {
+	case "", ProcessedItemConflictUpsert:
+	case ProcessedItemConflictInsertOnly:
+		// Empty dedup_mode is strict
+		if c.DedupMode != DedupModeGUIDOnly || c.DedupDate == DedupDateLatest {
+			return fmt.Errorf("processed_item_conflict '%s' keeps recorded dates, it can't be used with dedup_mode '%s' or dedup_date '%s'",
+				ProcessedItemConflictInsertOnly, DedupModeStrict, DedupDateLatest)
+		}
+	default:
+		return fmt.Errorf("unsupported processed_item_conflict '%s', must be '%s' or '%s'", c.ProcessedItemConflict, ProcessedItemConflictUpsert, ProcessedItemConflictInsertOnly)
+	}
+	switch c.GlobalDedup {
+	case "", GlobalDedupURL, GlobalDedupContentHash:
+	default:
+		return fmt.Errorf("unsupported global_dedup '%s', must be '%s' or '%s'", c.GlobalDedup, GlobalDedupURL, GlobalDedupContentHash)
+	}
+	switch c.TraceReference {
+	case "", TraceReferenceChildOf, TraceReferenceFollowsFrom:
+	default:
+		return fmt.Errorf("unsupported trace_reference '%s', must be '%s' or '%s'", c.TraceReference, TraceReferenceChildOf, TraceReferenceFollowsFrom)
+	}
+	switch c.ContentPreference {
+	case "", ContentPreferenceBoth, ContentPreferenceContentFirst, ContentPreferenceDescriptionFirst:
+	default:
+		return fmt.Errorf("unsupported content_preference '%s', must be '%s', '%s' or '%s'", c.ContentPreference, ContentPreferenceBoth, ContentPreferenceContentFirst, ContentPreferenceDescriptionFirst)
+	}
+	switch c.OversizedContent {
+	case "", OversizedContentTruncate, OversizedContentDrop:
+	default:
+		return fmt.Errorf("unsupported oversized_content '%s', must be '%s' or '%s'", c.OversizedContent, OversizedContentTruncate, OversizedContentDrop)
+	}
+	if c.MaxContentLength != 0 && c.MaxContentLength <= len(truncatedContentIndicator) {
+		return fmt.Errorf("max_content_length must be 0 or greater than %d", len(truncatedContentIndicator))
+	}
+	if c.MessageTimeout < 0 {
+		return fmt.Errorf("message_timeout must not be negative")
+	}
+	if c.MaxItemsPerRefresh < 0 {
+		return fmt.Errorf("max_items_per_refresh must not be negative")
+	}
+	if c.FirstFetchItemLimit < 0 {
+		return fmt.Errorf("first_fetch_item_limit must not be negative")
+	}
+	if c.DisableAfterFailures < 0 {
+		return fmt.Errorf("disable_after_failures must not be negative")
+	}
+	if c.QuarantineAfterEmptyRefreshes < 0 {
+		return fmt.Errorf("quarantine_after_empty_refreshes must not be negative")
+	}
+	if c.ItemSnapshotsRetention < 0 {
+		return fmt.Errorf("item_snapshots_retention must not be negative")
+	}
+	if c.PublishBuffer < 0 {
+		return fmt.Errorf("publish_buffer must not be negative")
+	}
+	if c.RefreshAllCheckpointTTL < 0 {
+		return fmt.Errorf("refresh_all_checkpoint_ttl must not be negative")
+	}
+	for _, layout := range c.DateLayouts {
+		if strings.TrimSpace(layout) == "" {
+			return fmt.Errorf("date_layouts must not have empty layouts")
+		}
+	}
+	if c.RefreshSpread < 0 || c.RefreshSpread > MaxRefreshSpread {
+		return fmt.Errorf("refresh_spread must be between 0 and %d", MaxRefreshSpread)
+	}
+	return nil
+}
+
+// FeedFailureAlerter notifies operators about chronically failing feeds and their recovery
+type FeedFailureAlerter interface {
+	FeedFailed(ctx context.Context, feed *entity.Feed, failures int, err error) error
+	FeedRecovered(ctx context.Context, feed *entity.Feed, previousFailures int) error
+	FeedDisabled(ctx context.Context, feed *entity.Feed, failures int) error
+	FeedQuarantined(ctx context.Context, feed *entity.Feed) error
+}
+
+// NewItemsNotification is webhook payload with new items found in the feed
+type NewItemsNotification struct {
+	PublicationUUID uuid.UUID `json:"publication_uuid"`
+	FeedID          uuid.UUID `json:"feed_id"`
+	FeedURL         string    `json:"feed_url"`
+	// FeedSelfURL is canonical feed URL declared by the feed itself (atom:link rel="self"), if any
+	FeedSelfURL string                     `json:"feed_self_url,omitempty"`
+	Items       []NewItemsNotificationItem `json:"items"`
+}
+
+// NewItemsNotificationItem is short description of new item in webhook payload
+type NewItemsNotificationItem struct {
+	GUID          string    `json:"guid"`
+	Title         string    `json:"title"`
+	URL           string    `json:"url"`
+	PublishedDate time.Time `json:"published_date"`
+	// Podcast is set for items with iTunes tags
+	Podcast *PodcastEpisode `json:"podcast,omitempty"`
+	// SourceURL is the original feed of item, republished by aggregator feed
+	SourceURL string `json:"source_url,omitempty"`
+	// ImageURL is the lead image of item, set if images extraction is enabled and item has images
+	ImageURL string `json:"image_url,omitempty"`
+}
+
 // Handler for consumer
 type rssFeedsProcessor struct {
-	repository          FeedsRepository
-	feedsUpdater        RSSFeedsUpdateProducer
-	itemPublisher       ItemPublisherClient
-	logger              Logger
-	tracer              opentracing.Tracer
-	GMTTimeZoneLocation *time.Location
+	repository      FeedsRepository
+	feedsUpdater    RSSFeedsUpdateProducer
+	itemPublisher   ItemPublisherClient
+	webhookNotifier WebhookNotifier
+	failureAlerter  FeedFailureAlerter
+	fetcher         *Fetcher
+	// followNextPages is the number of RFC 5005 archive pages to follow on the first fetch of feed
+	followNextPages int
+	// fetchTimeout bounds feed fetch unless feed overrides it, 0 means no timeout
+	fetchTimeout     time.Duration
+	processingConfig ProcessingConfig
+	logger           Logger
+	tracer           opentracing.Tracer
+	// itemsDigest is set in digest mode, see EnableItemsDigest
+	itemsDigest *itemsDigestSettings
 }
 
 // NewRSSFeedsProcessor creates processor for messaging feeds operations
-func NewRSSFeedsProcessor(repository FeedsRepository, feedsUpdateProducer RSSFeedsUpdateProducer, itemPublisherClient ItemPublisherClient, logger Logger, tracer opentracing.Tracer) *rssFeedsProcessor {
-	GMTTimeZoneLocation, err := time.LoadLocation("GMT")
-	if err != nil {
-		panic(err)
+// webhookNotifier, failureAlerter and hostBreakers are optional, nil disables webhook notifications, alerting and per-host circuit breaking
+func NewRSSFeedsProcessor(fetchConfig *FetchConfig, processingConfig *ProcessingConfig, repository FeedsRepository, feedsUpdateProducer RSSFeedsUpdateProducer, itemPublisherClient ItemPublisherClient, webhookNotifier WebhookNotifier, failureAlerter FeedFailureAlerter, hostBreakers HostCircuitBreakers, logger Logger, tracer opentracing.Tracer) *rssFeedsProcessor {
+	if fetchConfig.TLS.IsWeakened() {
+		logger.Warn("Feed fetches TLS security is weakened: min version ", fetchConfig.TLS.MinVersion, ", certificates are not verified for hosts ", fetchConfig.TLS.InsecureSkipVerifyHosts)
+	}
+	httpClient := &http.Client{Transport: newTransport(&fetchConfig.Transport, &fetchConfig.TLS)}
+	fetcher := NewFetcher(httpClient, fetchConfig.Workers, hostBreakers, hostpolicy.New(&fetchConfig.HostPolicy), logger, tracer)
+	if fetchConfig.ParseCache.TTL > 0 {
+		fetcher.EnableParseCache(&fetchConfig.ParseCache)
+	}
+	if fetchConfig.LenientParsing {
+		fetcher.EnableLenientParsing()
 	}
 	return &rssFeedsProcessor{
 		repository,
 		feedsUpdateProducer,
-		itemPublisherClient,
+		newInstrumentedItemPublisher(itemPublisherClient),
+		webhookNotifier,
+		failureAlerter,
+		fetcher,
+		fetchConfig.FollowNextPages,
+		time.Duration(fetchConfig.Timeout) * time.Second,
+		*processingConfig,
 		logger,
 		tracer,
-		GMTTimeZoneLocation,
+		nil,
 	}
 }
 
 // Process is a gateway for message consumption - handles incoming data and calls related handlers
 // It uses json.RawMessage to delay the unmarshalling of message content - Type is unmarshalled first.
+// Messages, which can't be decoded, are reported with permanent ErrMalformedMessage, so they are not requeued.
 // TODO: currently only FeedsUpdateMsg types, we'll need more in the future.
-func (p *rssFeedsProcessor) Process(data []byte) error {
+func (p *rssFeedsProcessor) Process(data []byte) (err error) {
 	var msg json.RawMessage
 	message := MessageEnvelope{Msg: &msg}
 	if err := json.Unmarshal(data, &message); err != nil {
-		return err
+		p.logger.Error("Failure unmarshalling message envelope: ", err)
+		return malformedMessageError("envelope: %v", err)
 	}
 	// Setup tracing span
+	// Metadata is injected by producer in TextMap format, so the trace survives NSQ hop regardless of HTTP (Zipkin B3) propagation
+	spanOptions := []opentracing.StartSpanOption{}
 	messageSpanContext, err := p.tracer.Extract(opentracing.TextMap, opentracing.TextMapCarrier(message.Metadata))
 	if err != nil {
 		p.logger.Debug("No tracing information in message metadata: ", err)
+	} else if p.processingConfig.TraceReference == TraceReferenceFollowsFrom {
+		spanOptions = append(spanOptions, opentracing.FollowsFrom(messageSpanContext))
+	} else {
+		// Refresh is caused by the sender (e.g. API request), so by default message processing is its child
+		spanOptions = append(spanOptions, opentracing.ChildOf(messageSpanContext))
 	}
-	span := p.tracer.StartSpan("process-message", opentracing.FollowsFrom(messageSpanContext))
+	span := p.tracer.StartSpan("process-message", spanOptions...)
 	defer span.Finish()
 	ext.Component.Set(span, "rssFeedsProcessor")
 	ctx := opentracing.ContextWithSpan(context.Background(), span)
+	if p.processingConfig.MessageTimeout > 0 {
+		// Cancellation flows into feed fetch and repository calls
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(p.processingConfig.MessageTimeout)*time.Second)
+		defer cancel()
+	}
+	start := time.Now()
+	defer func() {
+		observeMessageProcessing(message.Type, start, err)
+	}()
 
 	switch message.Type {
 	case FeedsUpdateOne:
@@ -110,102 +621,460 @@ func (p *rssFeedsProcessor) Process(data []byte) error {
 			span.LogFields(
 				otLog.Error(err),
 			)
+			return malformedMessageError("FeedsUpdateOneMsg content: %v", err)
+		}
+		if msgContent.FeedID == uuid.Nil && msgContent.PublicationUUID == uuid.Nil {
+			err := malformedMessageError("FeedsUpdateOneMsg has empty feed_id and publication_uuid")
+			p.logger.Error(err)
+			span.LogFields(
+				otLog.Error(err),
+			)
 			return err
 		}
-		return p.refreshFeed(ctx, msgContent.PublicationUUID)
+		if msgContent.FeedID == uuid.Nil {
+			// Message of producer, which predates feed ids
+			return p.refreshPublicationFeeds(ctx, msgContent.PublicationUUID, msgContent.Force)
+		}
+		return p.refreshFeedMessage(ctx, msgContent.FeedID, msgContent.Force)
 	case FeedsUpdateAll:
 		// No body here, just refresh
-		return p.refreshAllFeeds(ctx)
+		_, err := p.refreshAllFeeds(ctx)
+		return err
 	default:
 		p.logger.Error("Undefined message type: ", message.Type)
 		span.LogFields(
 			otLog.Error(fmt.Errorf("Underfined message type: %s", message.Type)),
 		)
-		// TODO: implement common errors
-		return fmt.Errorf("Undefined message type: %v", message.Type)
+		return malformedMessageError("undefined message type: %v", message.Type)
+	}
+}
+
+// refreshFeedMessage refreshes feed requested by message
+func (p *rssFeedsProcessor) refreshFeedMessage(ctx context.Context, feedID uuid.UUID, force bool) error {
+	err := p.refreshFeed(ctx, feedID, force, time.Time{}, noProgress)
+	if errors.Is(err, hostpolicy.ErrHostNotAllowed) {
+		// Retrying won't help, message is dropped until host policy or feed url changes
+		p.logger.Warn("Feed ", feedID, " skipped: ", err)
+		return nil
+	}
+	return err
+}
+
+// refreshPublicationFeeds refreshes all feeds of the publication, returns the first error after trying every feed
+func (p *rssFeedsProcessor) refreshPublicationFeeds(ctx context.Context, publicationUUID uuid.UUID, force bool) error {
+	dbFeeds, err := p.repository.GetByPublicationUUID(ctx, publicationUUID)
+	if err != nil {
+		return fmt.Errorf("couldn't get feeds of publication from repository, %v", err)
 	}
+	if len(dbFeeds) == 0 {
+		return fmt.Errorf("repository doesn't have feeds of publication %v", publicationUUID)
+	}
+	var firstErr error
+	for i := range dbFeeds {
+		if err := p.refreshFeedMessage(ctx, dbFeeds[i].ID, force); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// RefreshFeed synchronously refreshes single feed, reporting progress events to the callback
+func (p *rssFeedsProcessor) RefreshFeed(ctx context.Context, feedID uuid.UUID, force bool, progress RefreshProgressFunc) error {
+	return p.refreshFeed(ctx, feedID, force, time.Time{}, progress)
+}
+
+// RepublishFeed synchronously re-fetches feed and publishes again its items published since the date,
+// including already processed ones. Items, which are not in the feed anymore, can't be republished.
+func (p *rssFeedsProcessor) RepublishFeed(ctx context.Context, feedID uuid.UUID, since time.Time, progress RefreshProgressFunc) error {
+	return p.refreshFeed(ctx, feedID, true, since, progress)
 }
 
 // refreshFeed refreshes single feed
 // uses feed metadata (Etag, LastModified) and retrieves it from the source to check if the feed is new
 // parses it and if there are new items (checked agains processed items repository) - publishes to items service messaging system
-func (p *rssFeedsProcessor) refreshFeed(ctx context.Context, publicationUUID uuid.UUID) error {
+// force skips feed metadata, so the full feed is retrieved and processed
+// non-zero republishSince publishes items since that date regardless of being processed before, older items are skipped
+func (p *rssFeedsProcessor) refreshFeed(ctx context.Context, feedID uuid.UUID, force bool, republishSince time.Time, progress RefreshProgressFunc) error {
 	span, ctx := p.setupTracingSpan(ctx, "refresh-feed")
 	defer span.Finish()
-	span.SetTag("feed.publicationUUID", publicationUUID)
+	span.SetTag("feed.ID", feedID)
+	span.SetTag("feed.forceRefresh", force)
+	republish := !republishSince.IsZero()
+	if republish {
+		span.SetTag("feed.republishSince", republishSince.String())
+	}
 
-	dbFeed, err := p.repository.GetByPublicationUUID(ctx, publicationUUID)
+	dbFeed, err := p.repository.GetByID(ctx, feedID)
 	if err != nil {
 		return fmt.Errorf("couldn't get feed item from repository, %v", err)
 	}
 	if dbFeed == nil {
 		span.LogKV("event", "no feed to refresh")
-		return fmt.Errorf("repository doesn't have items with this publication uuid %v", publicationUUID)
+		return fmt.Errorf("repository doesn't have feed with id %v", feedID)
+	}
+	span.SetTag("feed.publicationUUID", dbFeed.PublicationUUID)
+	if !dbFeed.Enabled {
+		p.logger.Info("Feed ", dbFeed.URL, " is disabled (", dbFeed.DisabledReason, "), skipping refresh")
+		span.LogKV("event", "feed is disabled")
+		return nil
 	}
-	dbFeedMetadata, err := p.repository.GetFeedHTTPMetadataByPublicationUUID(ctx, publicationUUID)
+	if dbFeed.QuarantineReason != "" && !force {
+		// Forced refresh is allowed, so operator can review feed output before releasing it
+		p.logger.Info("Feed ", dbFeed.URL, " is quarantined (", dbFeed.QuarantineReason, "), skipping refresh")
+		span.LogKV("event", "feed is quarantined")
+		return nil
+	}
+	dbFeedMetadata, err := p.repository.GetFeedHTTPMetadataByFeedID(ctx, feedID)
 	if err != nil {
 		return fmt.Errorf("couldn't get feed HTTP metadata from repository, %v", err)
 	}
 	if dbFeedMetadata == nil {
-		return fmt.Errorf("repository doesn't have HTTP metadata items with this publication uuid %v", publicationUUID)
+		return fmt.Errorf("repository doesn't have HTTP metadata of feed with id %v", feedID)
 	}
 	p.logger.Debug(fmt.Sprintf("Got feed item from db, %v, with metadata %v", dbFeed, dbFeedMetadata))
-	feed, err := p.readFeedFromURL(ctx, dbFeed.URL, dbFeedMetadata.ETag, dbFeedMetadata.LastModified)
+	etag, lastModified := dbFeedMetadata.ETag, dbFeedMetadata.LastModified
+	if force {
+		p.logger.Info("Force refresh of feed ", dbFeed.URL, ", ignoring ETag and Last-Modified")
+		etag, lastModified = "", time.Time{}
+	}
+	feed, err := p.fetchFeed(ctx, dbFeed, etag, lastModified)
 	if err == ErrNotModified {
 		p.logger.Debug("Feed ", dbFeed.URL, " skipped: ", err)
 		span.LogKV("event", "feed update skipped as not modified")
+		p.recordFeedSuccess(ctx, dbFeed)
+		progress(RefreshEvent{Type: RefreshEventNotModified})
 		return nil
 	}
 	if err != nil {
+		p.recordFeedFailure(ctx, dbFeed, err)
 		return err
 	}
-	p.logger.Info("Feed ", dbFeed.URL, " returned ", len(feed.Items), " items")
+	p.recordFeedSuccess(ctx, dbFeed)
+	if err := p.repository.SaveFeedFetchDuration(ctx, dbFeed.ID, feed.FetchDuration); err != nil {
+		p.logger.Error("Failure saving feed ", dbFeed.ID, " fetch duration: ", err)
+	}
+	p.logger.Info("Feed ", dbFeed.URL, " returned ", len(feed.Items), " items in ", feed.FetchDuration)
+	if len(feed.Items) == 0 {
+		// Feed consistently returning no items is likely broken, it is quarantined after configured number of such refreshes
+		span.LogKV("event", "feed returned no items")
+		if feedURL, err := url.Parse(dbFeed.URL); err == nil {
+			emptyFeedFetches.WithLabelValues(feedURL.Host).Inc()
+		}
+	}
+	// Feed without processed items is fetched first time, e.g. it's new or its earlier items were all filtered out.
+	// Last item published date can't tell it, since it's not set for feeds processed before it was introduced
+	firstFetch := false
+	if (p.followNextPages > 0 || p.processingConfig.FirstFetchItemLimit > 0) && !republish {
+		hasProcessedItems, err := p.repository.FeedHasProcessedItems(ctx, dbFeed.ID)
+		if err != nil {
+			return fmt.Errorf("couldn't check processed items of feed, %v", err)
+		}
+		firstFetch = !hasProcessedItems
+	}
+	if p.followNextPages > 0 && firstFetch {
+		// Backfill history of new feed from archive pages, regular refreshes get only the current page
+		if pages := p.fetcher.FetchNextPages(ctx, feed, dbFeed.URL, dbFeed.Headers, dbFeed.Login, p.followNextPages); pages > 0 {
+			p.logger.Info("Feed ", dbFeed.URL, " backfilled from ", pages, " archive pages, ", len(feed.Items), " items in total")
+		}
+	}
+	if p.quarantineSuspiciousFeed(ctx, dbFeed, feed) {
+		// Suspicious output is not published, feed waits for review
+		return nil
+	}
+	if feed.FeedLink != "" && feed.FeedLink != dbFeed.URL {
+		// Feed moved or is served from mirror, self link is informational and is passed to webhook
+		p.logger.Debug("Feed ", dbFeed.URL, " declares self link ", feed.FeedLink)
+		span.SetTag("feed.selfURL", feed.FeedLink)
+	}
+	if dbFeed.LanguageCode == "" {
+		p.detectFeedLanguage(ctx, dbFeed, feed.Feed)
+	}
+	progress(RefreshEvent{Type: RefreshEventFetched, Count: len(feed.Items)})
+	datedItems := make([]datedItem, 0, len(feed.Items))
+	unparsedDates := 0
+	unparsedExample := ""
 	for _, item := range feed.Items {
-		var itemPublished *time.Time
-		if item.PublishedParsed == nil {
-			if item.UpdatedParsed != nil {
-				itemPublished = item.UpdatedParsed
-			} else {
-				p.logger.Error("Item ", item.GUID, " doesn't have set Published or Updated fields, skipping")
-				span.LogFields(
-					otLog.Error(err),
-				)
+		published, unparsed, ok := itemDate(item, p.processingConfig.DateLayouts)
+		if unparsed != "" {
+			unparsedDates++
+			unparsedExample = unparsed
+		}
+		if ok {
+			datedItems = append(datedItems, datedItem{item, published, p.itemDedupDate(item, published)})
+		} else {
+			p.logger.Error("Item ", item.GUID, " doesn't have set Published or Updated fields, skipping")
+			span.LogKV("event", "item without date skipped")
+		}
+	}
+	if unparsedDates > 0 {
+		// Logged once per refresh, so operators can report broken dates to the publisher or add date layout
+		p.logger.Warn("Feed ", dbFeed.URL, " has ", unparsedDates, " items with dates, which can't be parsed, e.g. '", unparsedExample, "'")
+		span.LogKV("event", "items with unparsed dates", "count", unparsedDates)
+	}
+	// Sorting makes processing deterministic regardless of feed order - the cap on published items defers the tail.
+	// Items without any date are skipped above, so they never take part in ordering.
+	sort.SliceStable(datedItems, func(i, j int) bool {
+		if p.processingConfig.ItemsOrder == ItemsOrderOldestFirst {
+			return datedItems[i].published.Before(datedItems[j].published)
+		}
+		return datedItems[i].published.After(datedItems[j].published)
+	})
+	progress(RefreshEvent{Type: RefreshEventItemsFound, Count: len(datedItems)})
+	if p.processingConfig.FirstFetchItemLimit > 0 && firstFetch {
+		datedItems = p.markFirstFetchBacklogSeen(ctx, dbFeed, datedItems)
+	}
+	maxItems := p.processingConfig.MaxItemsPerRefresh
+	if dbFeed.MaxItemsPerRefresh != nil {
+		maxItems = *dbFeed.MaxItemsPerRefresh
+	}
+	filter, err := newItemFilter(dbFeed.Filter)
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return fmt.Errorf("couldn't apply feed filter, %v", err)
+	}
+	// Items are checked (dedup, filter) and published in two stages connected by bounded buffer.
+	// Checking waits when publishing backs up, so slow Items service doesn't pile checked items up in memory.
+	pipelineCtx, cancelPipeline := context.WithCancel(ctx)
+	defer cancelPipeline()
+	// Both stages report progress
+	progress = synchronizedProgress(progress)
+	pending := make(chan pendingItem, p.processingConfig.publishBuffer())
+	capped := false
+	var checkErr error
+	go func() {
+		defer close(pending)
+		// Cap counts items handed to publishing
+		sent := 0
+		for _, dated := range datedItems {
+			if err := pipelineCtx.Err(); err != nil {
+				checkErr = err
+				return
+			}
+			item := dated.Item
+			itemPublished := dated.published
+			if republish && itemPublished.Before(republishSince) {
 				continue
 			}
-		} else {
-			itemPublished = item.PublishedParsed
+			processedItem := &entity.ProcessedItem{
+				GUID:            item.GUID,
+				PublicationUUID: dbFeed.PublicationUUID,
+				FeedID:          dbFeed.ID,
+				PublicationDate: dated.dedupDate,
+				DedupKey:        globalDedupKey(p.processingConfig.GlobalDedup, item),
+			}
+			exists := false
+			if !republish {
+				var err error
+				exists, err = p.processedItemExists(pipelineCtx, processedItem)
+				if err != nil {
+					p.logger.Error("Couldn't process item with GUID ", processedItem.GUID, "error: ", err)
+					span.LogFields(
+						otLog.Error(err),
+					)
+					continue
+				}
+			}
+			// Skip if such feed (GUID and PubDate) already exist in db as processed item
+			// If Pubdate is different - item will be updated, unless GUID only dedup mode is used.
+			// If Pubdate is missing - Update date will be used, otherwise skipped.
+			// Republishing skips the check, so downstream gets already processed items again.
+			if exists {
+				p.logger.Debug("Item ", item.GUID, "with publish date ", item.Published, " already exist, skipping processing")
+				span.LogKV("event", "item already exists, skipping processing")
+				continue
+			}
+			if !filter.matches(item) {
+				p.logger.Debug("Item ", item.GUID, " filtered out")
+				span.LogKV("event", "item filtered out")
+				progress(RefreshEvent{Type: RefreshEventItemFiltered, GUID: item.GUID})
+				if dbFeed.Filter.RecordFiltered {
+					if err := p.saveProcessedItem(pipelineCtx, processedItem); err != nil {
+						p.logger.Error("Failure saving filtered out item as processed: ", err)
+					}
+				}
+				continue
+			}
+			if !republish && processedItem.DedupKey != "" {
+				duplicate, err := p.repository.ProcessedItemExistsByDedupKey(pipelineCtx, processedItem.DedupKey)
+				if err != nil {
+					p.logger.Error("Couldn't check item ", item.GUID, " across feeds, error: ", err)
+					span.LogFields(
+						otLog.Error(err),
+					)
+					continue
+				}
+				if duplicate {
+					// Saved as processed for this feed too, so it isn't checked again on the next refresh
+					p.logger.Debug("Item ", item.GUID, " was already processed in another feed, skipping")
+					span.LogKV("event", "item already processed in another feed, skipping")
+					if err := p.saveProcessedItem(pipelineCtx, processedItem); err != nil {
+						p.logger.Error("Failure saving duplicate item as processed: ", err)
+					}
+					continue
+				}
+			}
+			if maxItems > 0 && sent >= maxItems {
+				capped = true
+				return
+			}
+			description, content := p.selectItemText(item)
+			description, content = p.capItemText(item, description, content)
+			imageURL := ""
+			if p.processingConfig.ExtractItemImages {
+				imageURL = itemImageURL(item, feed.Feed, dbFeed.URL)
+			}
+			select {
+			case pending <- pendingItem{
+				Item:          item,
+				processedItem: processedItem,
+				published:     itemPublished,
+				description:   description,
+				content:       content,
+				languageCode:  itemLanguageCode(item, dbFeed.LanguageCode, feed.Language),
+				imageURL:      imageURL,
+			}:
+				sent++
+			case <-pipelineCtx.Done():
+				checkErr = pipelineCtx.Err()
+				return
+			}
 		}
-		processedItem := &entity.ProcessedItem{
-			GUID:            item.GUID,
-			PublicationUUID: dbFeed.PublicationUUID,
-			PublicationDate: *itemPublished,
+	}()
+	// stopPipeline cancels checking and waits for it to finish, unpublished items are left for the next refresh
+	stopPipeline := func() {
+		cancelPipeline()
+		for range pending {
 		}
-		exists, err := p.repository.ProcessedItemExists(ctx, processedItem)
-		if err != nil {
-			p.logger.Error("Couldn't process item with GUID ", processedItem.GUID, "error: ", err)
+	}
+	// Track the newest publication date among processed items to detect dead feeds
+	var lastItemPublished time.Time
+	newItems := []NewItemsNotificationItem{}
+	// published saves published item as processed and collects it for webhook notification
+	published := func(candidate pendingItem) {
+		item := candidate.Item
+		itemPublished := &candidate.published
+		p.logger.Info("Pushed item ", item.GUID, " to process")
+		span.LogKV("event", "pushed item to process")
+		progress(RefreshEvent{Type: RefreshEventItemPublished, GUID: item.GUID})
+		if err := p.saveProcessedItem(ctx, candidate.processedItem); err != nil {
+			p.logger.Error("Failure saving new processed item: ", err)
+			return
+		}
+		if p.processingConfig.ItemSnapshots {
+			snapshot := &entity.ItemSnapshot{
+				FeedID:          dbFeed.ID,
+				PublicationUUID: dbFeed.PublicationUUID,
+				GUID:            item.GUID,
+				Title:           item.Title,
+				URL:             item.Link,
+				ContentHash:     contentHash(candidate.description, candidate.content),
+				LanguageCode:    candidate.languageCode,
+				PublicationDate: *itemPublished,
+			}
+			// Snapshot is for auditing only, item is already published and saved as processed
+			if err := p.repository.SaveItemSnapshot(ctx, snapshot); err != nil {
+				p.logger.Error("Failure saving snapshot of item ", item.GUID, ": ", err)
+			}
+		}
+		if itemPublished.After(lastItemPublished) {
+			lastItemPublished = *itemPublished
+		}
+		newItems = append(newItems, NewItemsNotificationItem{
+			GUID:          item.GUID,
+			Title:         item.Title,
+			URL:           item.Link,
+			PublishedDate: itemPublished.In(time.UTC),
+			Podcast:       podcastEpisode(item),
+			SourceURL:     itemSourceURL(item),
+			ImageURL:      candidate.imageURL,
+		})
+	}
+	// In digest mode items are published in batches, items of failed digest are published on the next refresh
+	var digest *itemsDigest
+	if p.itemsDigest != nil {
+		digest = newItemsDigest(p.itemsDigest, dbFeed.PublicationUUID, dbFeed.URL)
+	}
+	// digestFailed is set when digest isn't published, its items aren't saved as processed
+	digestFailed := false
+	// flushDigest publishes accumulated digest, only ErrItemPublisherUnavailable is returned to stop the refresh
+	flushDigest := func() error {
+		if digest.empty() {
+			return nil
+		}
+		message, items := digest.take()
+		if err := p.itemsDigest.publisher.PublishItemsDigest(message); err != nil {
+			if err == ErrItemPublisherUnavailable {
+				return err
+			}
+			p.logger.Error("failed to publish digest of ", len(items), " new items of publication ", dbFeed.PublicationUUID, " with error ", err)
 			span.LogFields(
 				otLog.Error(err),
 			)
-			continue
+			digestFailed = true
+			return nil
 		}
-		// Skip if such feed (GUID and PubDate) already exist in db as processed item
-		// If Pubdate is different - item will be updated.
-		// If Pubdate is missing - Update date will be used, otherwise skipped.
-		if exists {
-			p.logger.Debug("Item ", item.GUID, "with publish date ", item.Published, " already exist, skipping processing")
-			span.LogKV("event", "item already exists, skipping processing")
+		span.LogKV("event", "pushed items digest", "items", len(items))
+		for _, candidate := range items {
+			published(candidate)
+		}
+		return nil
+	}
+	publishQueue := (<-chan pendingItem)(pending)
+	if p.processingConfig.reversesPublishOrder() {
+		publishQueue = reversePendingItems(pipelineCtx, pending)
+	}
+	for candidate := range publishQueue {
+		if err := ctx.Err(); err != nil {
+			// Message processing timed out, the rest of items will be processed on requeue
+			stopPipeline()
+			p.logger.Error("Stopping refresh of feed ", dbFeed.ID, ": ", err)
+			span.LogFields(
+				otLog.Error(err),
+			)
+			return err
+		}
+		if digest != nil {
+			var flushErr error
+			if !digest.add(candidate) {
+				flushErr = flushDigest()
+				digest.add(candidate)
+			}
+			if flushErr == nil && digest.full() {
+				flushErr = flushDigest()
+			}
+			if flushErr != nil {
+				// Stop burning through items while downstream is down, message will be requeued and feed refreshed later
+				stopPipeline()
+				p.logger.Error("Stopping refresh of feed ", dbFeed.ID, ": ", flushErr)
+				span.LogFields(
+					otLog.Error(flushErr),
+				)
+				return flushErr
+			}
 			continue
 		}
+		item := candidate.Item
 		// Publish new item to Items service
 		err = p.itemPublisher.PublishNewItem(
-			publicationUUID,
+			dbFeed.PublicationUUID,
 			item.Title,
-			item.Description,
-			item.Content,
+			candidate.description,
+			candidate.content,
 			item.Link,
-			dbFeed.LanguageCode,
-			itemPublished.In(time.UTC))
+			candidate.languageCode,
+			candidate.published.In(time.UTC))
 
+		if err == ErrItemPublisherUnavailable {
+			// Stop burning through items while downstream is down, message will be requeued and feed refreshed later
+			stopPipeline()
+			p.logger.Error("Stopping refresh of feed ", dbFeed.ID, ": ", err)
+			span.LogFields(
+				otLog.Error(err),
+			)
+			return err
+		}
 		if err != nil {
 			p.logger.Error("failed to publish new item ", item.GUID, " of publication ", dbFeed.PublicationUUID, " with error ", err)
 			span.LogFields(
@@ -213,13 +1082,65 @@ func (p *rssFeedsProcessor) refreshFeed(ctx context.Context, publicationUUID uui
 			)
 			continue
 		}
-		p.logger.Info("Pushed item ", item.GUID, " to process")
-		span.LogKV("event", "pushed item to process")
-		if err := p.repository.SaveProcessedItem(ctx, processedItem); err != nil {
-			p.logger.Error("Failure saving new processed item: ", err)
-			continue
+		published(candidate)
+	}
+	// Checking is finished once pending is closed, so its results are safe to read
+	if checkErr != nil {
+		// Message processing timed out, the rest of items will be processed on requeue
+		p.logger.Error("Stopping refresh of feed ", dbFeed.ID, ": ", checkErr)
+		span.LogFields(
+			otLog.Error(checkErr),
+		)
+		return checkErr
+	}
+	if digest != nil {
+		if err := flushDigest(); err != nil {
+			// Metadata isn't saved, so requeued message publishes the rest of items
+			p.logger.Error("Stopping refresh of feed ", dbFeed.ID, ": ", err)
+			span.LogFields(
+				otLog.Error(err),
+			)
+			return err
+		}
+	}
+	if capped {
+		p.logger.Warn("Feed ", dbFeed.URL, " reached cap of ", maxItems, " new items per refresh, the rest is deferred to the next refresh")
+		span.LogKV("event", "new items per refresh cap reached")
+	}
+	if p.webhookNotifier != nil && dbFeed.WebhookURL != "" && len(newItems) > 0 {
+		notification := &NewItemsNotification{
+			PublicationUUID: dbFeed.PublicationUUID,
+			FeedID:          dbFeed.ID,
+			FeedURL:         dbFeed.URL,
+			FeedSelfURL:     feed.FeedLink,
+			Items:           newItems,
+		}
+		// Webhook is best effort - items are already published and saved as processed
+		if err := p.webhookNotifier.Notify(ctx, dbFeed.WebhookURL, notification); err != nil {
+			p.logger.Error("Failure notifying webhook ", dbFeed.WebhookURL, " about new items: ", err)
+			span.LogFields(
+				otLog.Error(err),
+			)
+		} else {
+			span.LogKV("event", "notified webhook about new items")
+		}
+	}
+	if !lastItemPublished.IsZero() && (dbFeed.LastItemPublished == nil || lastItemPublished.After(*dbFeed.LastItemPublished)) {
+		if err := p.repository.SaveFeedLastItemPublished(ctx, dbFeed.ID, lastItemPublished.In(time.UTC)); err != nil {
+			p.logger.Error("Failure saving feed last item published date: ", err)
+			span.LogFields(
+				otLog.Error(err),
+			)
+		} else {
+			span.LogKV("event", "saved feed last item published date")
 		}
 	}
+	if capped || digestFailed {
+		// HTTP metadata is not saved, so the next refresh gets the full feed again instead of Not Modified
+		// and publishes deferred items and items of failed digest
+		p.logger.Info("Partially updated feed ", dbFeed.ID)
+		return nil
+	}
 	// Update Feed
 	dbFeedMetadata.ETag = feed.ETag
 	dbFeedMetadata.LastModified = feed.LastModified
@@ -230,119 +1151,350 @@ func (p *rssFeedsProcessor) refreshFeed(ctx context.Context, publicationUUID uui
 		return fmt.Errorf("couldn't save feed HTTP metadata, %v", err)
 	}
 	span.LogKV("event", "saved feed http metadata")
-	p.logger.Info("Successfully updated feed ", dbFeed.PublicationUUID)
+	p.logger.Info("Successfully updated feed ", dbFeed.ID)
 	return nil
 }
 
-// readFeedFromURL fetches feed from url and returns parsed feed
-// Uses Etag and Last-Modified to verify if feed didn't change
-func (p *rssFeedsProcessor) readFeedFromURL(ctx context.Context, url string, etag string, lastModified time.Time) (feed *RSSFeed, err error) {
-	span, ctx := p.setupTracingSpan(ctx, "read-feed-from-url")
+// markFirstFetchBacklogSeen keeps for publishing the newest FirstFetchItemLimit items of feed without processed items,
+// and saves the rest as processed without publishing, so new feed history doesn't flood downstream and
+// the following refreshes publish only new items. Returns kept items in the original order.
+func (p *rssFeedsProcessor) markFirstFetchBacklogSeen(ctx context.Context, dbFeed *entity.Feed, datedItems []datedItem) []datedItem {
+	limit := p.processingConfig.FirstFetchItemLimit
+	if len(datedItems) <= limit {
+		return datedItems
+	}
+	span, ctx := p.setupTracingSpan(ctx, "mark-first-fetch-backlog-seen")
 	defer span.Finish()
-	span.SetTag("feed.url", url)
-
-	var client = http.Client{}
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		return nil, err
+	newestFirst := make([]datedItem, len(datedItems))
+	copy(newestFirst, datedItems)
+	sort.SliceStable(newestFirst, func(i, j int) bool {
+		return newestFirst[i].published.After(newestFirst[j].published)
+	})
+	newest := make(map[*gofeed.Item]bool, limit)
+	for _, dated := range newestFirst[:limit] {
+		newest[dated.Item] = true
 	}
-	req.Header.Set("User-Agent", "Gofeed/1.0")
-
-	if etag != "" {
-		req.Header.Set("If-None-Match", etag)
-		p.logger.Debug("Set etag for feed retrieval: ", req.Header.Get("If-None-Match"))
+	kept := make([]datedItem, 0, limit)
+	for _, dated := range datedItems {
+		if newest[dated.Item] {
+			kept = append(kept, dated)
+			continue
+		}
+		processedItem := &entity.ProcessedItem{
+			GUID:            dated.Item.GUID,
+			PublicationUUID: dbFeed.PublicationUUID,
+			FeedID:          dbFeed.ID,
+			PublicationDate: dated.dedupDate,
+			DedupKey:        globalDedupKey(p.processingConfig.GlobalDedup, dated.Item),
+		}
+		if err := p.saveProcessedItem(ctx, processedItem); err != nil {
+			// Not saved item is considered again on the next refresh, when feed already has published items
+			p.logger.Error("Failure marking first fetch backlog item ", dated.Item.GUID, " as processed: ", err)
+			span.LogFields(
+				otLog.Error(err),
+			)
+		}
 	}
+	p.logger.Info("First fetch of feed ", dbFeed.URL, ": publishing newest ", len(kept), " items, ", len(datedItems)-len(kept), " older items marked as processed")
+	span.LogKV("event", "marked first fetch backlog as processed", "marked", len(datedItems)-len(kept))
+	return kept
+}
 
-	req.Header.Set("If-Modified-Since", lastModified.In(p.GMTTimeZoneLocation).Format(time.RFC1123))
-	p.logger.Debug("Set If-Modified-Since header for feed retrieval: ", req.Header.Get("If-Modified-Since"))
-	// Injecting tracing span into outgoing requests - shown with Istio Envoy tracing
-	span.Tracer().Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
-
-	resp, err := client.Do(req)
-	span.LogKV("event", "queried feed remote endpoint")
-
-	if err != nil {
+// detectFeedLanguage fills in missing feed language code from the language, declared by the feed, and saves it
+func (p *rssFeedsProcessor) detectFeedLanguage(ctx context.Context, dbFeed *entity.Feed, feed *gofeed.Feed) {
+	span, ctx := p.setupTracingSpan(ctx, "detect-feed-language")
+	defer span.Finish()
+	languageCode := normalizeLanguageCode(feed.Language)
+	if languageCode == "" {
+		p.logger.Warn("Feed ", dbFeed.URL, " doesn't have language code and declares unsupported language '", feed.Language, "'")
+		span.LogKV("event", "feed language not detected")
+		return
+	}
+	if err := p.repository.SaveFeedDetectedLanguageCode(ctx, dbFeed.ID, languageCode); err != nil {
+		p.logger.Error("Failure saving detected feed ", dbFeed.ID, " language code: ", err)
 		span.LogFields(
 			otLog.Error(err),
 		)
-		return nil, err
 	}
+	dbFeed.LanguageCode = languageCode
+	p.logger.Info("Detected feed ", dbFeed.URL, " language code ", languageCode)
+	span.SetTag("feed.languageCode", languageCode)
+}
 
-	if resp != nil {
-		defer func() {
-			ce := resp.Body.Close()
-			if ce != nil {
-				err = ce
-			}
-		}()
+// selectItemText returns description and content to publish according to content preference.
+// With preference for single field the selected non-empty text is published as content and description is left empty.
+func (p *rssFeedsProcessor) selectItemText(item *gofeed.Item) (description string, content string) {
+	switch p.processingConfig.ContentPreference {
+	case ContentPreferenceContentFirst:
+		if strings.TrimSpace(item.Content) != "" {
+			return "", item.Content
+		}
+		return "", item.Description
+	case ContentPreferenceDescriptionFirst:
+		if strings.TrimSpace(item.Description) != "" {
+			return "", item.Description
+		}
+		return "", item.Content
+	default:
+		return item.Description, item.Content
 	}
-	p.logger.Debug("Got HTTP response: ", resp.StatusCode)
-	ext.HTTPStatusCode.Set(span, uint16(resp.StatusCode))
+}
 
-	if resp.StatusCode == http.StatusNotModified {
-		return nil, ErrNotModified
+// capItemText applies max content length to item description and content, logging oversized text
+func (p *rssFeedsProcessor) capItemText(item *gofeed.Item, description string, content string) (string, string) {
+	maxLength := p.processingConfig.MaxContentLength
+	if maxLength == 0 {
+		return description, content
+	}
+	if len(description) > maxLength {
+		p.logger.Warn("Item ", item.GUID, " description of ", len(description), " bytes exceeds max content length, ", p.oversizedContentAction())
+		description = p.capText(description)
 	}
+	if len(content) > maxLength {
+		p.logger.Warn("Item ", item.GUID, " content of ", len(content), " bytes exceeds max content length, ", p.oversizedContentAction())
+		content = p.capText(content)
+	}
+	return description, content
+}
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, gofeed.HTTPError{
-			StatusCode: resp.StatusCode,
-			Status:     resp.Status,
-		}
+func (p *rssFeedsProcessor) oversizedContentAction() string {
+	if p.processingConfig.OversizedContent == OversizedContentDrop {
+		return "dropped"
+	}
+	return "truncated"
+}
+
+// capText drops or truncates oversized text to max content length on UTF-8 character boundary, with truncation indicator
+func (p *rssFeedsProcessor) capText(text string) string {
+	if p.processingConfig.OversizedContent == OversizedContentDrop {
+		return ""
+	}
+	cut := p.processingConfig.MaxContentLength - len(truncatedContentIndicator)
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
 	}
+	return text[:cut] + truncatedContentIndicator
+}
 
-	feed = &RSSFeed{}
+// fetchFeed fetches feed bounded by its fetch timeout, which takes precedence over worker wide one
+func (p *rssFeedsProcessor) fetchFeed(ctx context.Context, dbFeed *entity.Feed, etag string, lastModified time.Time) (*RSSFeed, error) {
+	timeout := p.fetchTimeout
+	if dbFeed.FetchTimeout != nil {
+		timeout = time.Duration(*dbFeed.FetchTimeout) * time.Second
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return p.fetcher.Fetch(ctx, dbFeed.URL, dbFeed.Headers, dbFeed.Login, etag, lastModified)
+}
+
+// processedItemExists checks processed items repository according to dedup mode
+// Insert only saving keeps the first recorded date, so only GUID is matched then.
+func (p *rssFeedsProcessor) processedItemExists(ctx context.Context, processedItem *entity.ProcessedItem) (bool, error) {
+	if p.processingConfig.DedupMode == DedupModeGUIDOnly || p.processingConfig.ProcessedItemConflict == ProcessedItemConflictInsertOnly {
+		return p.repository.ProcessedItemExistsByGUID(ctx, processedItem)
+	}
+	return p.repository.ProcessedItemExists(ctx, processedItem)
+}
+
+// saveProcessedItem saves processed item according to processed item conflict behavior
+func (p *rssFeedsProcessor) saveProcessedItem(ctx context.Context, processedItem *entity.ProcessedItem) error {
+	if p.processingConfig.ProcessedItemConflict == ProcessedItemConflictInsertOnly {
+		return p.repository.InsertProcessedItem(ctx, processedItem)
+	}
+	return p.repository.SaveProcessedItem(ctx, processedItem)
+}
 
-	feedBody, err := gofeed.NewParser().Parse(resp.Body)
+// recordFeedFailure increments feed consecutive failures and alerts if failures cross the threshold
+func (p *rssFeedsProcessor) recordFeedFailure(ctx context.Context, dbFeed *entity.Feed, feedErr error) {
+	span, ctx := p.setupTracingSpan(ctx, "record-feed-failure")
+	defer span.Finish()
+	failures, err := p.repository.IncrementFeedFailures(ctx, dbFeed.ID, feedErr.Error())
 	if err != nil {
+		p.logger.Error("Failure saving feed ", dbFeed.ID, " consecutive failures: ", err)
 		span.LogFields(
 			otLog.Error(err),
 		)
-		return nil, err
+		return
 	}
-	feed.Feed = feedBody
+	dbFeed.ConsecutiveFailures = failures
+	span.SetTag("feed.consecutiveFailures", failures)
+	if p.failureAlerter != nil {
+		if err := p.failureAlerter.FeedFailed(ctx, dbFeed, failures, feedErr); err != nil {
+			p.logger.Error("Failure sending feed ", dbFeed.ID, " failure alert: ", err)
+			span.LogFields(
+				otLog.Error(err),
+			)
+		}
+	}
+	if p.processingConfig.DisableAfterFailures > 0 && failures >= p.processingConfig.DisableAfterFailures {
+		p.disableFailingFeed(ctx, dbFeed, failures)
+	}
+}
 
-	if eTag := resp.Header.Get("Etag"); eTag != "" {
-		p.logger.Debug("ETag from feed request: ", eTag)
-		feed.ETag = eTag
+// disableFailingFeed disables feed, which failed too many times in a row, and alerts about it
+func (p *rssFeedsProcessor) disableFailingFeed(ctx context.Context, dbFeed *entity.Feed, failures int) {
+	span, ctx := p.setupTracingSpan(ctx, "disable-failing-feed")
+	defer span.Finish()
+	if err := p.repository.DisableFeed(ctx, dbFeed.ID, entity.FeedDisabledReasonFailures); err != nil {
+		p.logger.Error("Failure disabling feed ", dbFeed.ID, " after ", failures, " failures: ", err)
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return
+	}
+	dbFeed.Enabled = false
+	dbFeed.DisabledReason = entity.FeedDisabledReasonFailures
+	p.logger.Warn("Feed ", dbFeed.URL, " (publication ", dbFeed.PublicationUUID, ") disabled after ", failures, " consecutive failures")
+	if p.failureAlerter == nil {
+		return
 	}
+	if err := p.failureAlerter.FeedDisabled(ctx, dbFeed, failures); err != nil {
+		p.logger.Error("Failure sending feed ", dbFeed.ID, " disable alert: ", err)
+		span.LogFields(
+			otLog.Error(err),
+		)
+	}
+}
 
-	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
-		p.logger.Debug("Last-Modifed from feed request: ", lastModified)
-		parsed, err := time.ParseInLocation(time.RFC1123, lastModified, p.GMTTimeZoneLocation)
-		if err == nil {
-			feed.LastModified = parsed
+// quarantineSuspiciousFeed applies quarantine heuristics to fetched feed and quarantines it if they match.
+// Already quarantined feeds (refreshed with force for review) are not checked.
+func (p *rssFeedsProcessor) quarantineSuspiciousFeed(ctx context.Context, dbFeed *entity.Feed, feed *RSSFeed) bool {
+	if dbFeed.QuarantineReason != "" || (p.processingConfig.QuarantineAfterEmptyRefreshes == 0 && !p.processingConfig.QuarantineOnContentTypeChange) {
+		return false
+	}
+	span, ctx := p.setupTracingSpan(ctx, "check-feed-quarantine")
+	defer span.Finish()
+	emptyRefreshes, previousContentType, err := p.repository.SaveFeedContentCheck(ctx, dbFeed.ID, len(feed.Items) == 0, feed.ContentType)
+	if err != nil {
+		// Heuristics are best effort, refresh goes on
+		p.logger.Error("Failure saving feed ", dbFeed.ID, " content check: ", err)
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return false
+	}
+	var reason string
+	switch {
+	case p.processingConfig.QuarantineOnContentTypeChange && previousContentType != "" && feed.ContentType != "" && feed.ContentType != previousContentType:
+		reason = entity.FeedQuarantineReasonContentType
+		p.logger.Warn("Feed ", dbFeed.URL, " content type changed from ", previousContentType, " to ", feed.ContentType)
+	case p.processingConfig.QuarantineAfterEmptyRefreshes > 0 && emptyRefreshes >= p.processingConfig.QuarantineAfterEmptyRefreshes:
+		reason = entity.FeedQuarantineReasonEmpty
+		p.logger.Warn("Feed ", dbFeed.URL, " returned no items ", emptyRefreshes, " refreshes in a row")
+	default:
+		return false
+	}
+	if err := p.repository.QuarantineFeed(ctx, dbFeed.ID, reason); err != nil {
+		p.logger.Error("Failure quarantining feed ", dbFeed.ID, ": ", err)
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return false
+	}
+	dbFeed.QuarantineReason = reason
+	span.SetTag("feed.quarantineReason", reason)
+	p.logger.Warn("Feed ", dbFeed.URL, " (publication ", dbFeed.PublicationUUID, ") quarantined: ", reason)
+	if p.failureAlerter != nil {
+		if err := p.failureAlerter.FeedQuarantined(ctx, dbFeed); err != nil {
+			p.logger.Error("Failure sending feed ", dbFeed.ID, " quarantine alert: ", err)
+			span.LogFields(
+				otLog.Error(err),
+			)
 		}
 	}
-	span.LogKV("event", "parsed feed")
-	return feed, err
+	return true
+}
+
+// recordFeedSuccess resets feed consecutive failures, saves fetch time and alerts about feed recovery
+func (p *rssFeedsProcessor) recordFeedSuccess(ctx context.Context, dbFeed *entity.Feed) {
+	span, ctx := p.setupTracingSpan(ctx, "record-feed-success")
+	defer span.Finish()
+	previousFailures := dbFeed.ConsecutiveFailures
+	if err := p.repository.ResetFeedFailures(ctx, dbFeed.ID); err != nil {
+		p.logger.Error("Failure resetting feed ", dbFeed.ID, " consecutive failures: ", err)
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return
+	}
+	dbFeed.ConsecutiveFailures = 0
+	dbFeed.LastError = ""
+	if previousFailures == 0 {
+		return
+	}
+	p.logger.Info("Feed ", dbFeed.ID, " recovered after ", previousFailures, " failures")
+	if p.failureAlerter == nil {
+		return
+	}
+	if err := p.failureAlerter.FeedRecovered(ctx, dbFeed, previousFailures); err != nil {
+		p.logger.Error("Failure sending feed ", dbFeed.ID, " recovery alert: ", err)
+		span.LogFields(
+			otLog.Error(err),
+		)
+	}
 }
 
 // Refresh all feeds.
 // Gets all feeds ids from db and pushes per-feed messages to process.
-func (p *rssFeedsProcessor) refreshAllFeeds(ctx context.Context) error {
+func (p *rssFeedsProcessor) refreshAllFeeds(ctx context.Context) (*RefreshAllSummary, error) {
 	span, ctx := p.setupTracingSpan(ctx, "refresh-all-feeds")
 	defer span.Finish()
 
-	dbFeeds, err := p.repository.GetAll(ctx)
+	summary, err := ScheduleRefreshAllCheckpointed(ctx, p.repository, p.repository, p.feedsUpdater, p.processingConfig.refreshAllCheckpointTTL(), time.Duration(p.processingConfig.RefreshSpread)*time.Second, p.logger)
+	if err != nil && summary != nil {
+		// Requeued message resumes from checkpoint
+		span.LogFields(
+			otLog.Error(err),
+		)
+		p.logger.Error("Refresh of all feeds is interrupted after scheduling ", summary.Scheduled, " feeds: ", err)
+		return summary, err
+	}
 	if err != nil {
-		return fmt.Errorf("couldn't get feeds from repository, %v", err)
+		return nil, fmt.Errorf("couldn't get feeds from repository, %v", err)
 	}
-	if len(dbFeeds) == 0 {
+	if summary.Total == 0 {
 		span.LogKV("error", "no feeds returned")
-		return fmt.Errorf("couldn't get feeds records ids, empty set returned")
+		return summary, fmt.Errorf("couldn't get feeds records ids, empty set returned")
 	}
-	p.logger.Debug("Got ", len(dbFeeds), " feeds to refresh from db")
-	// FIXME: go parallel
-	for _, dbFeed := range dbFeeds {
-		if err := p.feedsUpdater.SendUpdateOne(ctx, dbFeed.PublicationUUID); err != nil {
-			p.logger.Error("Failure publishing feed refresh for PublicationUUID", dbFeed.PublicationUUID, ": ", err)
-			continue
-		}
-		p.logger.Debug("Published feed refresh for PublicationUUID", dbFeed.PublicationUUID)
+	span.LogKV("event", "finished sending feeds update", "scheduled", summary.Scheduled, "failed", summary.Failed, "resumed", summary.Resumed)
+	p.logger.Info("Scheduled refresh of ", summary.Scheduled, " feeds out of ", summary.Total, ", failed ", summary.Failed, ", already handled by interrupted refresh ", summary.Resumed)
+	p.pruneItemSnapshots(ctx)
+	if summary.Scheduled == 0 && summary.Resumed == 0 {
+		return summary, fmt.Errorf("failed to schedule refresh of all %d feeds", summary.Total)
+	}
+	return summary, nil
+}
 
+// pruneItemSnapshots deletes item snapshots older than retention
+func (p *rssFeedsProcessor) pruneItemSnapshots(ctx context.Context) {
+	if p.processingConfig.ItemSnapshotsRetention == 0 {
+		return
 	}
-	span.LogKV("event", "finished sending feeds update")
-	return nil
+	span, ctx := p.setupTracingSpan(ctx, "prune-item-snapshots")
+	defer span.Finish()
+	before := time.Now().AddDate(0, 0, -p.processingConfig.ItemSnapshotsRetention)
+	deleted, err := p.repository.PruneItemSnapshots(ctx, before)
+	if err != nil {
+		p.logger.Error("Failure pruning item snapshots: ", err)
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return
+	}
+	p.logger.Info("Pruned ", deleted, " item snapshots older than ", before)
+}
+
+// contentHash returns hex SHA256 of published item text
+func contentHash(description string, content string) string {
+	hash := sha256.New()
+	hash.Write([]byte(description))
+	// separator keeps moving text between fields detectable
+	hash.Write([]byte{0})
+	hash.Write([]byte(content))
+	return hex.EncodeToString(hash.Sum(nil))
 }
 
 func (p *rssFeedsProcessor) setupTracingSpan(ctx context.Context, name string) (opentracing.Span, context.Context) {
@@ -0,0 +1,933 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Tarick/naca-rss-feeds/internal/entity"
+	"github.com/Tarick/naca-rss-feeds/internal/hostpolicy"
+	"github.com/gofrs/uuid"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+// fakeRepository keeps single feed and its processed items in memory with the semantics of PostgreSQL repository,
+// methods not used by feed refresh panic
+type fakeRepository struct {
+	FeedsRepository
+	mu        sync.Mutex
+	feed      *entity.Feed
+	metadata  *entity.FeedHTTPMetadata
+	processed map[string]entity.ProcessedItem
+}
+
+func newFakeRepository(feedURL string) *fakeRepository {
+	feedID := uuid.Must(uuid.NewV4())
+	return &fakeRepository{
+		feed:      &entity.Feed{ID: feedID, PublicationUUID: uuid.Must(uuid.NewV4()), URL: feedURL, LanguageCode: "en", Enabled: true},
+		metadata:  &entity.FeedHTTPMetadata{FeedID: feedID},
+		processed: map[string]entity.ProcessedItem{},
+	}
+}
+
+func (r *fakeRepository) GetByID(ctx context.Context, id uuid.UUID) (*entity.Feed, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.feed.ID != id {
+		return nil, nil
+	}
+	feed := *r.feed
+	return &feed, nil
+}
+
+func (r *fakeRepository) GetByPublicationUUID(ctx context.Context, publicationUUID uuid.UUID) ([]entity.Feed, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.feed.PublicationUUID != publicationUUID {
+		return []entity.Feed{}, nil
+	}
+	return []entity.Feed{*r.feed}, nil
+}
+
+func (r *fakeRepository) GetFeedHTTPMetadataByFeedID(ctx context.Context, feedID uuid.UUID) (*entity.FeedHTTPMetadata, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	metadata := *r.metadata
+	return &metadata, nil
+}
+
+func (r *fakeRepository) SaveFeedHTTPMetadata(ctx context.Context, metadata *entity.FeedHTTPMetadata) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	saved := *metadata
+	r.metadata = &saved
+	return nil
+}
+
+func (r *fakeRepository) SaveFeedLastItemPublished(ctx context.Context, id uuid.UUID, published time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.feed.LastItemPublished = &published
+	return nil
+}
+
+func (r *fakeRepository) SaveFeedFetchDuration(context.Context, uuid.UUID, time.Duration) error {
+	return nil
+}
+
+func (r *fakeRepository) IncrementFeedFailures(ctx context.Context, id uuid.UUID, lastError string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.feed.ConsecutiveFailures++
+	r.feed.LastError = lastError
+	return r.feed.ConsecutiveFailures, nil
+}
+
+func (r *fakeRepository) ResetFeedFailures(context.Context, uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.feed.ConsecutiveFailures = 0
+	r.feed.LastError = ""
+	return nil
+}
+
+func (r *fakeRepository) DisableFeed(ctx context.Context, id uuid.UUID, reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.feed.Enabled = false
+	r.feed.DisabledReason = reason
+	return nil
+}
+
+// SaveProcessedItem upserts processed item date, like ON CONFLICT (guid) DO UPDATE
+func (r *fakeRepository) SaveProcessedItem(ctx context.Context, item *entity.ProcessedItem) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.processed[item.GUID] = *item
+	return nil
+}
+
+// InsertProcessedItem keeps already saved processed item, like ON CONFLICT (guid) DO NOTHING
+func (r *fakeRepository) InsertProcessedItem(ctx context.Context, item *entity.ProcessedItem) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.processed[item.GUID]; !ok {
+		r.processed[item.GUID] = *item
+	}
+	return nil
+}
+
+func (r *fakeRepository) ProcessedItemExists(ctx context.Context, item *entity.ProcessedItem) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	saved, ok := r.processed[item.GUID]
+	return ok && saved.PublicationUUID == item.PublicationUUID && saved.PublicationDate.Equal(item.PublicationDate), nil
+}
+
+func (r *fakeRepository) ProcessedItemExistsByGUID(ctx context.Context, item *entity.ProcessedItem) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	saved, ok := r.processed[item.GUID]
+	return ok && saved.PublicationUUID == item.PublicationUUID, nil
+}
+
+func (r *fakeRepository) FeedHasProcessedItems(ctx context.Context, feedID uuid.UUID) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, item := range r.processed {
+		if item.FeedID == feedID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *fakeRepository) processedItem(guid string) (entity.ProcessedItem, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item, ok := r.processed[guid]
+	return item, ok
+}
+
+// recordingItemPublisher records titles of published items
+type recordingItemPublisher struct {
+	mu     sync.Mutex
+	titles []string
+}
+
+func (p *recordingItemPublisher) PublishNewItem(publicationUUID uuid.UUID, title string, description string, content string, url string, languageCode string, publishedDate time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.titles = append(p.titles, title)
+	return nil
+}
+
+// published returns titles published since the previous call
+func (p *recordingItemPublisher) published() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	titles := p.titles
+	p.titles = nil
+	return titles
+}
+
+// fixtureServer serves testdata fixture with the content type, recording Accept header of the last request
+type fixtureServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	name   string
+	accept string
+}
+
+func newFixtureServer(t *testing.T, name string, contentType string) *fixtureServer {
+	t.Helper()
+	s := &fixtureServer{name: name}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.accept = r.Header.Get("Accept")
+		name := s.name
+		s.mu.Unlock()
+		if name == "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write(body)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// serve switches served fixture, e.g. to the next version of the feed, empty name makes server fail
+func (s *fixtureServer) serve(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+func (s *fixtureServer) lastAccept() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accept
+}
+
+// newTestProcessor creates processor, which fetches feeds from local test servers
+func newTestProcessor(processingConfig ProcessingConfig, repository FeedsRepository, publisher ItemPublisherClient) *rssFeedsProcessor {
+	fetchConfig := &FetchConfig{HostPolicy: hostpolicy.Config{AllowPrivateNetworks: true}}
+	return NewRSSFeedsProcessor(fetchConfig, &processingConfig, repository, nil, publisher, nil, nil, nil, nopLogger{}, opentracing.NoopTracer{})
+}
+
+func TestRefreshFeedJSONFeed(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+	}{
+		{name: "served as JSON Feed", contentType: "application/feed+json"},
+		{name: "served as JSON", contentType: "application/json; charset=utf-8"},
+		{name: "served as plain text", contentType: "text/plain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFixtureServer(t, "jsonfeed.json", tt.contentType)
+			repository := newFakeRepository(server.URL + "/feed.json")
+			publisher := &recordingItemPublisher{}
+			p := newTestProcessor(ProcessingConfig{}, repository, publisher)
+
+			if err := p.RefreshFeed(context.Background(), repository.feed.ID, false, noProgress); err != nil {
+				t.Fatalf("RefreshFeed() error = %v", err)
+			}
+			if accept := server.lastAccept(); accept != feedAcceptHeader {
+				t.Errorf("Accept = %q, want %q", accept, feedAcceptHeader)
+			}
+			wantTitles := []string{"Second post", "First post"}
+			if got := publisher.published(); !reflect.DeepEqual(got, wantTitles) {
+				t.Errorf("published = %v, want %v", got, wantTitles)
+			}
+			item, ok := repository.processedItem("https://example.org/posts/1")
+			if !ok {
+				t.Fatal("item isn't saved as processed")
+			}
+			wantDate := time.Date(2020, 6, 1, 8, 0, 0, 0, time.UTC)
+			if !item.PublicationDate.Equal(wantDate) {
+				t.Errorf("processed item date = %v, want %v", item.PublicationDate, wantDate)
+			}
+		})
+	}
+}
+
+// refreshFixtures refreshes feed, which serves the fixtures one by one, and returns titles published by every refresh
+func refreshFixtures(t *testing.T, processingConfig ProcessingConfig, fixtures ...string) (*fakeRepository, [][]string) {
+	t.Helper()
+	server := newFixtureServer(t, fixtures[0], "application/rss+xml")
+	repository := newFakeRepository(server.URL + "/feed.xml")
+	publisher := &recordingItemPublisher{}
+	p := newTestProcessor(processingConfig, repository, publisher)
+	published := [][]string{}
+	for _, fixture := range fixtures {
+		server.serve(fixture)
+		if err := p.RefreshFeed(context.Background(), repository.feed.ID, false, noProgress); err != nil {
+			t.Fatalf("RefreshFeed() of %s error = %v", fixture, err)
+		}
+		published = append(published, publisher.published())
+	}
+	return repository, published
+}
+
+func TestRefreshFeedDedupMode(t *testing.T) {
+	tests := []struct {
+		name      string
+		dedupMode string
+		want      [][]string
+	}{
+		{
+			name:      "strict mode publishes item with changed date again",
+			dedupMode: DedupModeStrict,
+			want:      [][]string{{"Item B", "Item A"}, {"Item C", "Item A"}},
+		},
+		{
+			name:      "default mode is strict",
+			dedupMode: "",
+			want:      [][]string{{"Item B", "Item A"}, {"Item C", "Item A"}},
+		},
+		{
+			name:      "guid only mode ignores changed date",
+			dedupMode: DedupModeGUIDOnly,
+			want:      [][]string{{"Item B", "Item A"}, {"Item C"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository, published := refreshFixtures(t, ProcessingConfig{DedupMode: tt.dedupMode}, "news.xml", "news-date-changed.xml")
+			if !reflect.DeepEqual(published, tt.want) {
+				t.Errorf("published = %v, want %v", published, tt.want)
+			}
+			// Upsert records the latest date in both modes
+			item, _ := repository.processedItem("https://example.org/a")
+			wantDate := time.Date(2020, 6, 1, 10, 0, 1, 0, time.UTC)
+			if tt.dedupMode != DedupModeGUIDOnly && !item.PublicationDate.Equal(wantDate) {
+				t.Errorf("processed item date = %v, want %v", item.PublicationDate, wantDate)
+			}
+		})
+	}
+}
+
+func TestRefreshFeedDedupDate(t *testing.T) {
+	tests := []struct {
+		name      string
+		dedupDate string
+		want      [][]string
+		// wantDate is recorded date of revised article
+		wantDate time.Time
+	}{
+		{
+			name:      "published date ignores revision",
+			dedupDate: DedupDatePublished,
+			want:      [][]string{{"Skewed article", "Unchanged article", "Revised article"}, nil},
+			wantDate:  time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "default is published date",
+			dedupDate: "",
+			want:      [][]string{{"Skewed article", "Unchanged article", "Revised article"}, nil},
+			wantDate:  time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "latest date republishes revised article only",
+			dedupDate: DedupDateLatest,
+			want:      [][]string{{"Skewed article", "Unchanged article", "Revised article"}, {"Revised article"}},
+			wantDate:  time.Date(2020, 6, 4, 10, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository, published := refreshFixtures(t, ProcessingConfig{DedupDate: tt.dedupDate}, "articles.xml", "articles-revised.xml")
+			if !reflect.DeepEqual(published, tt.want) {
+				t.Errorf("published = %v, want %v", published, tt.want)
+			}
+			item, _ := repository.processedItem("https://example.org/articles/revised")
+			if !item.PublicationDate.Equal(tt.wantDate) {
+				t.Errorf("revised article date = %v, want %v", item.PublicationDate, tt.wantDate)
+			}
+			// Updated date earlier than published, e.g. clock skew of publisher, doesn't replace published date
+			item, _ = repository.processedItem("https://example.org/articles/skewed")
+			if wantDate := time.Date(2020, 6, 3, 10, 0, 0, 0, time.UTC); !item.PublicationDate.Equal(wantDate) {
+				t.Errorf("skewed article date = %v, want %v", item.PublicationDate, wantDate)
+			}
+		})
+	}
+}
+
+func TestProcessingConfigValidateDedupDate(t *testing.T) {
+	tests := []struct {
+		name      string
+		dedupMode string
+		dedupDate string
+		wantErr   bool
+	}{
+		{name: "latest with strict dedup", dedupMode: DedupModeStrict, dedupDate: DedupDateLatest},
+		{name: "latest with default dedup", dedupDate: DedupDateLatest},
+		{name: "published with guid only dedup", dedupMode: DedupModeGUIDOnly, dedupDate: DedupDatePublished},
+		{name: "latest with guid only dedup has no effect", dedupMode: DedupModeGUIDOnly, dedupDate: DedupDateLatest, wantErr: true},
+		{name: "unsupported date", dedupDate: "updated", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := ProcessingConfig{DedupMode: tt.dedupMode, DedupDate: tt.dedupDate}
+			if err := c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRefreshFeedProcessedItemConflict(t *testing.T) {
+	firstDate := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	changedDate := time.Date(2020, 6, 1, 10, 0, 1, 0, time.UTC)
+	tests := []struct {
+		name      string
+		dedupMode string
+		conflict  string
+		// want is published by refreshes of feed, which changed date of Item A
+		want [][]string
+		// wantDate is recorded date of Item A after refreshes
+		wantDate time.Time
+		// wantConflictDate is recorded date after saving the item with the same GUID and changed date
+		wantConflictDate time.Time
+	}{
+		{
+			name:             "upsert republishes updated item and records its date",
+			dedupMode:        DedupModeStrict,
+			conflict:         ProcessedItemConflictUpsert,
+			want:             [][]string{{"Item B", "Item A"}, {"Item C", "Item A"}},
+			wantDate:         changedDate,
+			wantConflictDate: changedDate,
+		},
+		{
+			name:             "default is upsert",
+			dedupMode:        DedupModeStrict,
+			want:             [][]string{{"Item B", "Item A"}, {"Item C", "Item A"}},
+			wantDate:         changedDate,
+			wantConflictDate: changedDate,
+		},
+		{
+			name:             "upsert with guid only dedup",
+			dedupMode:        DedupModeGUIDOnly,
+			conflict:         ProcessedItemConflictUpsert,
+			want:             [][]string{{"Item B", "Item A"}, {"Item C"}},
+			wantDate:         firstDate,
+			wantConflictDate: changedDate,
+		},
+		{
+			name:             "insert only never republishes and keeps the first date",
+			dedupMode:        DedupModeGUIDOnly,
+			conflict:         ProcessedItemConflictInsertOnly,
+			want:             [][]string{{"Item B", "Item A"}, {"Item C"}},
+			wantDate:         firstDate,
+			wantConflictDate: firstDate,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processingConfig := ProcessingConfig{DedupMode: tt.dedupMode, ProcessedItemConflict: tt.conflict}
+			if err := processingConfig.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			repository, published := refreshFixtures(t, processingConfig, "news.xml", "news-date-changed.xml")
+			if !reflect.DeepEqual(published, tt.want) {
+				t.Errorf("published = %v, want %v", published, tt.want)
+			}
+			item, ok := repository.processedItem("https://example.org/a")
+			if !ok {
+				t.Fatal("item isn't saved as processed")
+			}
+			if !item.PublicationDate.Equal(tt.wantDate) {
+				t.Errorf("processed item date = %v, want %v", item.PublicationDate, tt.wantDate)
+			}
+
+			// GUID conflict, e.g. concurrent refresh of the feed saving the item first
+			p := newTestProcessor(processingConfig, repository, &recordingItemPublisher{})
+			ctx := context.Background()
+			if err := p.saveProcessedItem(ctx, &entity.ProcessedItem{GUID: "https://example.org/conflict", PublicationUUID: repository.feed.PublicationUUID, FeedID: repository.feed.ID, PublicationDate: firstDate}); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.saveProcessedItem(ctx, &entity.ProcessedItem{GUID: "https://example.org/conflict", PublicationUUID: repository.feed.PublicationUUID, FeedID: repository.feed.ID, PublicationDate: changedDate}); err != nil {
+				t.Fatalf("saveProcessedItem() of conflicting item error = %v", err)
+			}
+			item, _ = repository.processedItem("https://example.org/conflict")
+			if !item.PublicationDate.Equal(tt.wantConflictDate) {
+				t.Errorf("conflicting item date = %v, want %v", item.PublicationDate, tt.wantConflictDate)
+			}
+		})
+	}
+}
+
+func TestProcessingConfigValidateProcessedItemConflict(t *testing.T) {
+	tests := []struct {
+		name      string
+		conflict  string
+		dedupMode string
+		dedupDate string
+		wantErr   bool
+	}{
+		{name: "upsert with strict dedup", conflict: ProcessedItemConflictUpsert, dedupMode: DedupModeStrict},
+		{name: "upsert with guid only dedup", conflict: ProcessedItemConflictUpsert, dedupMode: DedupModeGUIDOnly},
+		{name: "insert only with guid only dedup", conflict: ProcessedItemConflictInsertOnly, dedupMode: DedupModeGUIDOnly},
+		{name: "insert only with strict dedup", conflict: ProcessedItemConflictInsertOnly, dedupMode: DedupModeStrict, wantErr: true},
+		{name: "insert only with default dedup", conflict: ProcessedItemConflictInsertOnly, wantErr: true},
+		{name: "insert only with latest date", conflict: ProcessedItemConflictInsertOnly, dedupMode: DedupModeGUIDOnly, dedupDate: DedupDateLatest, wantErr: true},
+		{name: "unsupported conflict", conflict: "ignore", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := ProcessingConfig{ProcessedItemConflict: tt.conflict, DedupMode: tt.dedupMode, DedupDate: tt.dedupDate}
+			if err := c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRefreshFeedFirstFetchItemLimit(t *testing.T) {
+	tests := []struct {
+		name                string
+		firstFetchItemLimit int
+		want                [][]string
+	}{
+		{
+			name:                "no limit publishes whole feed on first fetch",
+			firstFetchItemLimit: 0,
+			want:                [][]string{{"Item D", "Item C", "Item B", "Item A"}, {"Item E"}},
+		},
+		{
+			name:                "limit publishes only newest items on first fetch",
+			firstFetchItemLimit: 2,
+			want:                [][]string{{"Item D", "Item C"}, {"Item E"}},
+		},
+		{
+			name:                "limit of the feed size publishes whole feed",
+			firstFetchItemLimit: 4,
+			want:                [][]string{{"Item D", "Item C", "Item B", "Item A"}, {"Item E"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository, published := refreshFixtures(t, ProcessingConfig{FirstFetchItemLimit: tt.firstFetchItemLimit}, "backlog.xml", "backlog-next.xml")
+			if !reflect.DeepEqual(published, tt.want) {
+				t.Errorf("published = %v, want %v", published, tt.want)
+			}
+			// Backlog items beyond the limit are seen, so the next fetch doesn't publish them
+			for _, guid := range []string{"https://example.org/a", "https://example.org/b"} {
+				if _, ok := repository.processedItem(guid); !ok {
+					t.Errorf("item %s isn't saved as processed", guid)
+				}
+			}
+		})
+	}
+}
+
+func TestRefreshFeedFirstFetchItemLimitOnlyFirstFetch(t *testing.T) {
+	server := newFixtureServer(t, "backlog-next.xml", "application/rss+xml")
+	repository := newFakeRepository(server.URL + "/feed.xml")
+	// Feed has processed items, so its new items are published regardless of first fetch limit.
+	// Last item published date isn't set, like for feeds processed before it was introduced
+	repository.processed["https://example.org/old"] = entity.ProcessedItem{
+		GUID:            "https://example.org/old",
+		PublicationUUID: repository.feed.PublicationUUID,
+		FeedID:          repository.feed.ID,
+		PublicationDate: time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC),
+	}
+	publisher := &recordingItemPublisher{}
+	p := newTestProcessor(ProcessingConfig{FirstFetchItemLimit: 1}, repository, publisher)
+
+	if err := p.RefreshFeed(context.Background(), repository.feed.ID, false, noProgress); err != nil {
+		t.Fatalf("RefreshFeed() error = %v", err)
+	}
+	want := []string{"Item E", "Item D", "Item C", "Item B", "Item A"}
+	if got := publisher.published(); !reflect.DeepEqual(got, want) {
+		t.Errorf("published = %v, want %v", got, want)
+	}
+}
+
+func TestRefreshFeedDisableAfterFailures(t *testing.T) {
+	tests := []struct {
+		name                 string
+		disableAfterFailures int
+		// refreshes fail, if true, or succeed
+		refreshes    []bool
+		wantEnabled  bool
+		wantFailures int
+	}{
+		{
+			name:                 "never disabled by default",
+			disableAfterFailures: 0,
+			refreshes:            []bool{true, true, true, true, true},
+			wantEnabled:          true,
+			wantFailures:         5,
+		},
+		{
+			name:                 "below threshold",
+			disableAfterFailures: 3,
+			refreshes:            []bool{true, true},
+			wantEnabled:          true,
+			wantFailures:         2,
+		},
+		{
+			name:                 "disabled at threshold",
+			disableAfterFailures: 3,
+			refreshes:            []bool{true, true, true},
+			wantEnabled:          false,
+			wantFailures:         3,
+		},
+		{
+			name:                 "disabled on the first failure with threshold 1",
+			disableAfterFailures: 1,
+			refreshes:            []bool{true},
+			wantEnabled:          false,
+			wantFailures:         1,
+		},
+		{
+			name:                 "success resets consecutive failures",
+			disableAfterFailures: 3,
+			refreshes:            []bool{true, true, false, true, true},
+			wantEnabled:          true,
+			wantFailures:         2,
+		},
+		{
+			name:                 "disabled feed isn't refreshed",
+			disableAfterFailures: 2,
+			refreshes:            []bool{true, true, true, false},
+			wantEnabled:          false,
+			wantFailures:         2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFixtureServer(t, "", "application/rss+xml")
+			repository := newFakeRepository(server.URL + "/feed.xml")
+			p := newTestProcessor(ProcessingConfig{DisableAfterFailures: tt.disableAfterFailures}, repository, &recordingItemPublisher{})
+			for _, fail := range tt.refreshes {
+				fixture := "news.xml"
+				if fail {
+					fixture = ""
+				}
+				server.serve(fixture)
+				p.RefreshFeed(context.Background(), repository.feed.ID, false, noProgress)
+			}
+			feed, _ := repository.GetByID(context.Background(), repository.feed.ID)
+			if feed.Enabled != tt.wantEnabled {
+				t.Errorf("feed enabled = %t, want %t", feed.Enabled, tt.wantEnabled)
+			}
+			if feed.ConsecutiveFailures != tt.wantFailures {
+				t.Errorf("consecutive failures = %d, want %d", feed.ConsecutiveFailures, tt.wantFailures)
+			}
+			wantReason := ""
+			if !tt.wantEnabled {
+				wantReason = entity.FeedDisabledReasonFailures
+			}
+			if feed.DisabledReason != wantReason {
+				t.Errorf("disabled reason = %q, want %q", feed.DisabledReason, wantReason)
+			}
+		})
+	}
+}
+
+func TestProcessMalformedMessage(t *testing.T) {
+	repository := newFakeRepository("https://example.org/feed.xml")
+	tests := []struct {
+		name          string
+		body          string
+		wantMalformed bool
+	}{
+		{name: "not JSON", body: `refresh please`, wantMalformed: true},
+		{name: "truncated envelope", body: `{"type":0,"Msg":{"feed_id":`, wantMalformed: true},
+		{name: "type isn't number", body: `{"type":"one","Msg":{}}`, wantMalformed: true},
+		{name: "content isn't object", body: `{"type":0,"Msg":"refresh"}`, wantMalformed: true},
+		{name: "malformed UUID", body: `{"type":0,"Msg":{"feed_id":"not-a-uuid"}}`, wantMalformed: true},
+		{name: "UUID of wrong length", body: `{"type":0,"Msg":{"feed_id":"6ba7b810-9dad-11d1-80b4"}}`, wantMalformed: true},
+		{name: "UUID isn't string", body: `{"type":0,"Msg":{"feed_id":42}}`, wantMalformed: true},
+		{name: "nil UUID", body: `{"type":0,"Msg":{"feed_id":"00000000-0000-0000-0000-000000000000"}}`, wantMalformed: true},
+		{name: "missing UUID", body: `{"type":0,"Msg":{"force":true}}`, wantMalformed: true},
+		{name: "undefined type", body: `{"type":42,"Msg":{}}`, wantMalformed: true},
+		{name: "unknown feed is retried", body: `{"type":0,"Msg":{"feed_id":"6ba7b810-9dad-11d1-80b4-00c04fd430c8"}}`, wantMalformed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProcessor(ProcessingConfig{}, repository, &recordingItemPublisher{})
+			err := p.Process([]byte(tt.body))
+			if err == nil {
+				t.Fatal("Process() error = nil")
+			}
+			if errors.Is(err, ErrMalformedMessage) != tt.wantMalformed {
+				t.Errorf("Process() error = %v, malformed = %t, want %t", err, !tt.wantMalformed, tt.wantMalformed)
+			}
+			var permanentErr interface{ Permanent() bool }
+			if permanent := errors.As(err, &permanentErr) && permanentErr.Permanent(); permanent != tt.wantMalformed {
+				t.Errorf("Process() error = %v, permanent = %t, want %t", err, permanent, tt.wantMalformed)
+			}
+		})
+	}
+}
+
+// referenceRecordingTracer records types of references of started spans by operation name, which mock tracer doesn't keep
+type referenceRecordingTracer struct {
+	*mocktracer.MockTracer
+	mu         sync.Mutex
+	references map[string][]opentracing.SpanReferenceType
+}
+
+func (t *referenceRecordingTracer) StartSpan(operationName string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	options := opentracing.StartSpanOptions{}
+	for _, opt := range opts {
+		opt.Apply(&options)
+	}
+	t.mu.Lock()
+	for _, reference := range options.References {
+		t.references[operationName] = append(t.references[operationName], reference.Type)
+	}
+	t.mu.Unlock()
+	return t.MockTracer.StartSpan(operationName, opts...)
+}
+
+// capturingMessageProducer keeps the last published message
+type capturingMessageProducer struct {
+	message []byte
+}
+
+func (p *capturingMessageProducer) Publish(message []byte) error {
+	p.message = message
+	return nil
+}
+
+func (p *capturingMessageProducer) DeferredPublish(delay time.Duration, message []byte) error {
+	p.message = message
+	return nil
+}
+
+func TestProcessSpanParentage(t *testing.T) {
+	tests := []struct {
+		name           string
+		traceReference string
+		wantReference  opentracing.SpanReferenceType
+	}{
+		{name: "child of sender by default", traceReference: "", wantReference: opentracing.ChildOfRef},
+		{name: "child of sender", traceReference: TraceReferenceChildOf, wantReference: opentracing.ChildOfRef},
+		{name: "follows from sender", traceReference: TraceReferenceFollowsFrom, wantReference: opentracing.FollowsFromRef},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracer := &referenceRecordingTracer{MockTracer: mocktracer.New(), references: map[string][]opentracing.SpanReferenceType{}}
+			repository := newFakeRepository("https://example.org/feed.xml")
+			p := NewRSSFeedsProcessor(&FetchConfig{}, &ProcessingConfig{TraceReference: tt.traceReference}, repository, nil, &recordingItemPublisher{}, nil, nil, nil, nopLogger{}, tracer)
+
+			// API request sends refresh message, which crosses NSQ as bytes
+			producer := &capturingMessageProducer{}
+			requestSpan := tracer.StartSpan("api-request")
+			ctx := opentracing.ContextWithSpan(context.Background(), requestSpan)
+			if err := NewFeedsUpdateProducer(producer, tracer).SendUpdateOne(ctx, repository.feed.ID, false); err != nil {
+				t.Fatalf("SendUpdateOne() error = %v", err)
+			}
+			requestSpan.Finish()
+			// Feed fetch fails, only spans matter
+			p.Process(producer.message)
+
+			spans := map[string]*mocktracer.MockSpan{}
+			for _, span := range tracer.FinishedSpans() {
+				spans[span.OperationName] = span
+			}
+			for _, name := range []string{"api-request", "send-update-one-feed", "process-message", "refresh-feed"} {
+				if spans[name] == nil {
+					t.Fatalf("span %s isn't finished, finished spans: %v", name, tracer.FinishedSpans())
+				}
+			}
+			traceID := spans["api-request"].SpanContext.TraceID
+			for name, span := range spans {
+				if span.SpanContext.TraceID != traceID {
+					t.Errorf("span %s trace = %d, want %d", name, span.SpanContext.TraceID, traceID)
+				}
+			}
+			if parent := spans["process-message"].ParentID; parent != spans["send-update-one-feed"].SpanContext.SpanID {
+				t.Errorf("process-message parent = %d, want send-update-one-feed span %d", parent, spans["send-update-one-feed"].SpanContext.SpanID)
+			}
+			if references := tracer.references["process-message"]; !reflect.DeepEqual(references, []opentracing.SpanReferenceType{tt.wantReference}) {
+				t.Errorf("process-message references = %v, want %v", references, tt.wantReference)
+			}
+			if parent := spans["refresh-feed"].ParentID; parent != spans["process-message"].SpanContext.SpanID {
+				t.Errorf("refresh-feed parent = %d, want process-message span %d", parent, spans["process-message"].SpanContext.SpanID)
+			}
+		})
+	}
+}
+
+func TestProcessWithoutTracingMetadataStartsTrace(t *testing.T) {
+	tracer := &referenceRecordingTracer{MockTracer: mocktracer.New(), references: map[string][]opentracing.SpanReferenceType{}}
+	repository := newFakeRepository("https://example.org/feed.xml")
+	p := NewRSSFeedsProcessor(&FetchConfig{}, &ProcessingConfig{}, repository, nil, &recordingItemPublisher{}, nil, nil, nil, nopLogger{}, tracer)
+
+	p.Process([]byte(`{"type":0,"Msg":{"feed_id":"` + repository.feed.ID.String() + `"}}`))
+
+	if references := tracer.references["process-message"]; len(references) != 0 {
+		t.Errorf("process-message references = %v, want none", references)
+	}
+}
+
+func TestProcessFeedsUpdateOneMsgByPublicationUUID(t *testing.T) {
+	server := newFixtureServer(t, "jsonfeed.json", "application/feed+json")
+	repository := newFakeRepository(server.URL + "/feed.json")
+	publisher := &recordingItemPublisher{}
+	p := newTestProcessor(ProcessingConfig{}, repository, publisher)
+
+	// Messages of producers, which predate feed ids, refresh all feeds of the publication
+	if err := p.Process([]byte(`{"type":0,"Msg":{"publication_uuid":"` + repository.feed.PublicationUUID.String() + `"}}`)); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	wantTitles := []string{"Second post", "First post"}
+	if got := publisher.published(); !reflect.DeepEqual(got, wantTitles) {
+		t.Errorf("published = %v, want %v", got, wantTitles)
+	}
+	if err := p.Process([]byte(`{"type":0,"Msg":{"publication_uuid":"` + uuid.Must(uuid.NewV4()).String() + `"}}`)); err == nil {
+		t.Error("Process() of publication without feeds error = nil, want error")
+	}
+	if err := p.Process([]byte(`{"type":0,"Msg":{}}`)); err == nil {
+		t.Error("Process() of message without feed_id and publication_uuid error = nil, want error")
+	}
+}
+
+// flakyDigestPublisher fails the first digest and records items count of published ones
+type flakyDigestPublisher struct {
+	mu        sync.Mutex
+	failed    bool
+	published []int
+}
+
+func (p *flakyDigestPublisher) PublishItemsDigest(digest *ItemsDigestMessage) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.failed {
+		p.failed = true
+		return errors.New("digest rejected")
+	}
+	p.published = append(p.published, len(digest.Items))
+	return nil
+}
+
+func TestRefreshFeedFailedDigestIsRepublished(t *testing.T) {
+	body, err := ioutil.ReadFile(filepath.Join("testdata", "jsonfeed.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const etag = `"v1"`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Type", "application/feed+json")
+		w.Write(body)
+	}))
+	defer server.Close()
+	repository := newFakeRepository(server.URL + "/feed.json")
+	publisher := &flakyDigestPublisher{}
+	p := newTestProcessor(ProcessingConfig{}, repository, &recordingItemPublisher{})
+	p.EnableItemsDigest(publisher, &ItemPublishConfig{})
+
+	if err := p.RefreshFeed(context.Background(), repository.feed.ID, false, noProgress); err != nil {
+		t.Fatalf("RefreshFeed() error = %v", err)
+	}
+	// Items of failed digest aren't processed, HTTP metadata isn't saved, so the next refresh isn't Not Modified
+	if _, ok := repository.processedItem("https://example.org/posts/1"); ok {
+		t.Error("item of failed digest is saved as processed")
+	}
+	if metadata, _ := repository.GetFeedHTTPMetadataByFeedID(context.Background(), repository.feed.ID); metadata.ETag != "" {
+		t.Errorf("ETag = %q saved after failed digest, want none", metadata.ETag)
+	}
+	if err := p.RefreshFeed(context.Background(), repository.feed.ID, false, noProgress); err != nil {
+		t.Fatalf("RefreshFeed() error = %v", err)
+	}
+	if !reflect.DeepEqual(publisher.published, []int{2}) {
+		t.Errorf("published digests of %v items, want [2]", publisher.published)
+	}
+	if _, ok := repository.processedItem("https://example.org/posts/1"); !ok {
+		t.Error("item isn't saved as processed")
+	}
+	if metadata, _ := repository.GetFeedHTTPMetadataByFeedID(context.Background(), repository.feed.ID); metadata.ETag != etag {
+		t.Errorf("ETag = %q, want %q", metadata.ETag, etag)
+	}
+}
+
+func TestRefreshFeedFollowNextPagesOnlyFirstFetch(t *testing.T) {
+	const page = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom">
+  <channel>
+    <title>Example news</title>
+    <link>https://example.org/</link>
+    <description>Example news</description>
+    %s
+    <item>
+      <guid>%s</guid>
+      <title>%s</title>
+      <pubDate>%s</pubDate>
+    </item>
+  </channel>
+</rss>`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		switch r.URL.Path {
+		case "/feed.xml":
+			fmt.Fprintf(w, page, `<atom:link rel="next" href="/archive.xml"/>`, "https://example.org/new", "New item", "Fri, 05 Jun 2020 10:00:00 GMT")
+		case "/archive.xml":
+			fmt.Fprintf(w, page, "", "https://example.org/archived", "Archived item", "Mon, 01 Jun 2020 10:00:00 GMT")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name              string
+		hasProcessedItems bool
+		want              []string
+	}{
+		{name: "first fetch backfills archive", want: []string{"New item", "Archived item"}},
+		{name: "feed with processed items fetches current page only", hasProcessedItems: true, want: []string{"New item"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := newFakeRepository(server.URL + "/feed.xml")
+			if tt.hasProcessedItems {
+				// Last item published date isn't set, like for feeds processed before it was introduced
+				repository.processed["https://example.org/old"] = entity.ProcessedItem{
+					GUID:            "https://example.org/old",
+					PublicationUUID: repository.feed.PublicationUUID,
+					FeedID:          repository.feed.ID,
+					PublicationDate: time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC),
+				}
+			}
+			publisher := &recordingItemPublisher{}
+			fetchConfig := &FetchConfig{HostPolicy: hostpolicy.Config{AllowPrivateNetworks: true}, FollowNextPages: 1}
+			p := NewRSSFeedsProcessor(fetchConfig, &ProcessingConfig{}, repository, nil, publisher, nil, nil, nil, nopLogger{}, opentracing.NoopTracer{})
+
+			if err := p.RefreshFeed(context.Background(), repository.feed.ID, false, noProgress); err != nil {
+				t.Fatalf("RefreshFeed() error = %v", err)
+			}
+			if got := publisher.published(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("published = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package processor
+
+import "sync"
+
+// Feed refresh progress event types
+const (
+	// RefreshEventNotModified is sent when feed didn't change since the last refresh
+	RefreshEventNotModified = "not_modified"
+	// RefreshEventFetched is sent when feed is retrieved and parsed, with the number of items in the feed
+	RefreshEventFetched = "fetched"
+	// RefreshEventItemsFound is sent with the number of items with publication date to process
+	RefreshEventItemsFound = "items_found"
+	// RefreshEventItemPublished is sent for each new item published to Items service
+	RefreshEventItemPublished = "item_published"
+	// RefreshEventItemFiltered is sent for each new item skipped by feed filter
+	RefreshEventItemFiltered = "item_filtered"
+	// RefreshEventDone is sent when refresh finished successfully
+	RefreshEventDone = "done"
+	// RefreshEventError is sent when refresh failed, with error text
+	RefreshEventError = "error"
+)
+
+// RefreshEvent describes feed refresh progress
+type RefreshEvent struct {
+	Type  string `json:"type"`
+	Count int    `json:"count,omitempty"`
+	GUID  string `json:"guid,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// RefreshProgressFunc receives feed refresh progress events, it is called synchronously from refresh
+type RefreshProgressFunc func(RefreshEvent)
+
+// synchronizedProgress serializes calls of progress callback made from concurrent refresh stages
+func synchronizedProgress(progress RefreshProgressFunc) RefreshProgressFunc {
+	var mu sync.Mutex
+	return func(event RefreshEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		progress(event)
+	}
+}
+
+// noProgress is used when nobody is interested in refresh progress, e.g. for messaging
+func noProgress(RefreshEvent) {}
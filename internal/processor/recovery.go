@@ -0,0 +1,104 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"unicode/utf8"
+)
+
+const (
+	// fixControlCharacters removes characters not allowed in XML, e.g. stray form feeds and NUL bytes
+	fixControlCharacters = "stripped invalid control characters"
+	// fixInvalidUTF8 replaces bytes, which are not valid UTF-8
+	fixInvalidUTF8 = "replaced invalid UTF-8"
+	// fixRawAmpersands escapes ampersands, which don't start an entity, e.g. "Tom & Jerry" or unescaped url query
+	fixRawAmpersands = "escaped raw ampersands"
+	// fixHTMLEntities converts HTML named entities unknown to XML, e.g. &nbsp;, to numeric references
+	fixHTMLEntities = "converted HTML entities"
+)
+
+// xmlEntityReference matches entity reference after ampersand: XML predefined, numeric or named one
+var xmlEntityReference = regexp.MustCompile(`^(?:#[0-9]+|#[xX][0-9a-fA-F]+|[A-Za-z][A-Za-z0-9]*);`)
+
+// xmlPredefinedEntities are entities known to XML parser without declaration
+var xmlPredefinedEntities = map[string]bool{"amp;": true, "lt;": true, "gt;": true, "quot;": true, "apos;": true}
+
+// repairFeedXML attempts lenient recovery of malformed XML feed body and returns repaired body with the list of
+// applied fixes, empty if nothing was repaired. CDATA sections are kept as they are, except for invalid characters.
+// Recovery is best effort - structural damage, e.g. unclosed tags, is not repaired.
+func repairFeedXML(body []byte) ([]byte, []string) {
+	applied := map[string]bool{}
+	repaired := make([]byte, 0, len(body))
+	for i := 0; i < len(body); {
+		if bytes.HasPrefix(body[i:], []byte("<![CDATA[")) {
+			end := bytes.Index(body[i:], []byte("]]>"))
+			if end < 0 {
+				end = len(body) - i
+			} else {
+				end += len("]]>")
+			}
+			repaired = appendValidXMLChars(repaired, body[i:i+end], applied)
+			i += end
+			continue
+		}
+		if body[i] == '&' {
+			reference := xmlEntityReference.Find(body[i+1:])
+			switch {
+			case reference == nil:
+				repaired = append(repaired, "&amp;"...)
+				applied[fixRawAmpersands] = true
+				i++
+				continue
+			case reference[0] != '#' && !xmlPredefinedEntities[string(reference)]:
+				entity := "&" + string(reference)
+				if unescaped := html.UnescapeString(entity); unescaped != entity {
+					for _, r := range unescaped {
+						repaired = append(repaired, fmt.Sprintf("&#%d;", r)...)
+					}
+				} else {
+					// Unknown entity is kept as text
+					repaired = append(repaired, "&amp;"...)
+					repaired = append(repaired, reference...)
+				}
+				applied[fixHTMLEntities] = true
+				i += 1 + len(reference)
+				continue
+			}
+		}
+		next := bytes.IndexAny(body[i+1:], "&<")
+		if next < 0 {
+			next = len(body)
+		} else {
+			next += i + 1
+		}
+		repaired = appendValidXMLChars(repaired, body[i:next], applied)
+		i = next
+	}
+	fixes := []string{}
+	for _, fix := range []string{fixControlCharacters, fixInvalidUTF8, fixRawAmpersands, fixHTMLEntities} {
+		if applied[fix] {
+			fixes = append(fixes, fix)
+		}
+	}
+	return repaired, fixes
+}
+
+// appendValidXMLChars appends text, dropping control characters not allowed in XML and replacing invalid UTF-8
+func appendValidXMLChars(dst []byte, text []byte, applied map[string]bool) []byte {
+	for len(text) > 0 {
+		r, size := utf8.DecodeRune(text)
+		switch {
+		case r == utf8.RuneError && size == 1:
+			dst = append(dst, string(utf8.RuneError)...)
+			applied[fixInvalidUTF8] = true
+		case r < 0x20 && r != '\t' && r != '\n' && r != '\r', r == 0xFFFE, r == 0xFFFF:
+			applied[fixControlCharacters] = true
+		default:
+			dst = append(dst, text[:size]...)
+		}
+		text = text[size:]
+	}
+	return dst
+}
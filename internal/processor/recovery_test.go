@@ -0,0 +1,138 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRepairFeedXML(t *testing.T) {
+	tests := []struct {
+		name      string
+		fixture   string
+		wantFixes []string
+		// wantTitles and wantDescription of the first item are checked if repaired feed is parsed
+		wantTitles      []string
+		wantDescription string
+		wantParseErr    bool
+	}{
+		{
+			name:            "raw ampersands",
+			fixture:         "malformed-ampersands.xml",
+			wantFixes:       []string{fixRawAmpersands},
+			wantTitles:      []string{"Tom & Jerry", "Q&A session"},
+			wantDescription: "Fish & chips & peas",
+		},
+		{
+			name:            "stray control characters",
+			fixture:         "malformed-control-chars.xml",
+			wantFixes:       []string{fixControlCharacters},
+			wantTitles:      []string{"Pagebreak", "Verticaltab"},
+			wantDescription: "Nullbyte and bell",
+		},
+		{
+			name:            "HTML entities",
+			fixture:         "malformed-html-entities.xml",
+			wantFixes:       []string{fixHTMLEntities},
+			wantTitles:      []string{"Café menu"},
+			wantDescription: "Prices — €5 & &unknownentity;",
+		},
+		{
+			name:            "invalid UTF-8",
+			fixture:         "malformed-invalid-utf8.xml",
+			wantFixes:       []string{fixInvalidUTF8},
+			wantTitles:      []string{"Broken �� byte"},
+			wantDescription: "Valid é and truncated �",
+		},
+		{
+			name:         "unclosed tags are beyond repair",
+			fixture:      "malformed-beyond-repair.xml",
+			wantFixes:    []string{fixRawAmpersands},
+			wantParseErr: true,
+		},
+		{
+			name:       "well formed feed isn't changed",
+			fixture:    "news.xml",
+			wantFixes:  []string{},
+			wantTitles: []string{"Item B", "Item A"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := ioutil.ReadFile(filepath.Join("testdata", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			repaired, fixes := repairFeedXML(body)
+			if !reflect.DeepEqual(fixes, tt.wantFixes) {
+				t.Errorf("fixes = %v, want %v", fixes, tt.wantFixes)
+			}
+			if len(fixes) == 0 && !bytes.Equal(repaired, body) {
+				t.Error("body without fixes is changed")
+			}
+			feed, err := newFeedParser().Parse(bytes.NewReader(repaired))
+			if tt.wantParseErr {
+				if err == nil {
+					t.Error("repaired feed is parsed, want parse error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() of repaired feed error = %v", err)
+			}
+			titles := []string{}
+			for _, item := range feed.Items {
+				titles = append(titles, item.Title)
+			}
+			if !reflect.DeepEqual(titles, tt.wantTitles) {
+				t.Errorf("titles = %q, want %q", titles, tt.wantTitles)
+			}
+			if tt.wantDescription != "" && feed.Items[0].Description != tt.wantDescription {
+				t.Errorf("description = %q, want %q", feed.Items[0].Description, tt.wantDescription)
+			}
+		})
+	}
+}
+
+func TestRepairFeedXMLKeepsCDATA(t *testing.T) {
+	repaired, _ := repairFeedXML([]byte("<description><![CDATA[Kept <b>as is</b> & unescaped\x0c]]></description>"))
+	if want := "<description><![CDATA[Kept <b>as is</b> & unescaped]]></description>"; string(repaired) != want {
+		t.Errorf("repaired = %q, want %q", repaired, want)
+	}
+}
+
+func TestFetchMalformedFeed(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		lenient bool
+		wantErr bool
+	}{
+		// Parser tolerates raw ampersands and HTML entities itself, recovery is needed only if other damage fails parsing
+		{name: "raw ampersands are tolerated", fixture: "malformed-ampersands.xml"},
+		{name: "HTML entities are tolerated", fixture: "malformed-html-entities.xml"},
+		{name: "control characters fail strict parsing", fixture: "malformed-control-chars.xml", wantErr: true},
+		{name: "control characters are recovered", fixture: "malformed-control-chars.xml", lenient: true},
+		{name: "feed beyond repair fails", fixture: "malformed-beyond-repair.xml", lenient: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFixtureServer(t, tt.fixture, "application/rss+xml")
+			fetcher := NewFetcher(server.Client(), 0, nil, nil, nil, nil)
+			if tt.lenient {
+				fetcher.EnableLenientParsing()
+			}
+			feed, err := fetcher.Fetch(context.Background(), server.URL, nil, nil, "", time.Time{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Fetch() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err == nil && len(feed.Items) == 0 {
+				t.Error("recovered feed has no items")
+			}
+		})
+	}
+}
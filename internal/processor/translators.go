@@ -0,0 +1,156 @@
+package processor
+
+import (
+	"github.com/mmcdole/gofeed"
+	"github.com/mmcdole/gofeed/atom"
+	ext "github.com/mmcdole/gofeed/extensions"
+	"github.com/mmcdole/gofeed/rss"
+)
+
+// itemSourceURLKey is item custom field with the URL of original feed, the item was republished from
+const itemSourceURLKey = "source_url"
+
+// feedNextPageKey is feed custom field with RFC 5005 link rel="next" to the next (older) page of paged feed
+const feedNextPageKey = "next_page_url"
+
+// PodcastEpisode is iTunes podcast metadata of feed item
+type PodcastEpisode struct {
+	Duration    string `json:"duration,omitempty"`
+	Episode     string `json:"episode,omitempty"`
+	Season      string `json:"season,omitempty"`
+	EpisodeType string `json:"episode_type,omitempty"`
+	Explicit    string `json:"explicit,omitempty"`
+}
+
+// newFeedParser creates feed parser with translators, which capture podcast iTunes tags and items source
+func newFeedParser() *gofeed.Parser {
+	parser := gofeed.NewParser()
+	parser.RSSTranslator = &rssTranslator{}
+	parser.AtomTranslator = &atomTranslator{}
+	return parser
+}
+
+// rssTranslator captures items <source> URL and channel atom:link rel="next", discarded by default translation
+type rssTranslator struct {
+	gofeed.DefaultRSSTranslator
+}
+
+func (t *rssTranslator) Translate(feed interface{}) (*gofeed.Feed, error) {
+	result, err := t.DefaultRSSTranslator.Translate(feed)
+	if err != nil {
+		return nil, err
+	}
+	rssFeed, ok := feed.(*rss.Feed)
+	if !ok {
+		return result, nil
+	}
+	for _, link := range rssFeed.Extensions["atom"]["link"] {
+		if link.Attrs["rel"] == "next" && link.Attrs["href"] != "" {
+			setFeedCustom(result, feedNextPageKey, link.Attrs["href"])
+			break
+		}
+	}
+	// Default translation maps items one to one, keeping the order
+	if len(rssFeed.Items) != len(result.Items) {
+		return result, nil
+	}
+	for i, rssItem := range rssFeed.Items {
+		if rssItem.Source != nil && rssItem.Source.URL != "" {
+			setItemCustom(result.Items[i], itemSourceURLKey, rssItem.Source.URL)
+		}
+	}
+	return result, nil
+}
+
+// atomTranslator captures entries <source> self link, feed link rel="next" and fills iTunes extension.
+// Default RSS translation already fills iTunes extension, default Atom translation keeps itunes: elements only as raw extensions.
+type atomTranslator struct {
+	gofeed.DefaultAtomTranslator
+}
+
+func (t *atomTranslator) Translate(feed interface{}) (*gofeed.Feed, error) {
+	result, err := t.DefaultAtomTranslator.Translate(feed)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range result.Items {
+		if item.ITunesExt == nil && item.Extensions != nil {
+			if _, ok := item.Extensions["itunes"]; ok {
+				item.ITunesExt = ext.NewITunesItemExtension(item.Extensions["itunes"])
+			}
+		}
+	}
+	atomFeed, ok := feed.(*atom.Feed)
+	if !ok {
+		return result, nil
+	}
+	for _, link := range atomFeed.Links {
+		if link.Rel == "next" && link.Href != "" {
+			setFeedCustom(result, feedNextPageKey, link.Href)
+			break
+		}
+	}
+	if len(atomFeed.Entries) != len(result.Items) {
+		return result, nil
+	}
+	for i, entry := range atomFeed.Entries {
+		if entry.Source == nil {
+			continue
+		}
+		for _, link := range entry.Source.Links {
+			if link.Rel == "self" && link.Href != "" {
+				setItemCustom(result.Items[i], itemSourceURLKey, link.Href)
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+func setItemCustom(item *gofeed.Item, key string, value string) {
+	if item.Custom == nil {
+		item.Custom = map[string]string{}
+	}
+	item.Custom[key] = value
+}
+
+func setFeedCustom(feed *gofeed.Feed, key string, value string) {
+	if feed.Custom == nil {
+		feed.Custom = map[string]string{}
+	}
+	feed.Custom[key] = value
+}
+
+// feedNextPageURL returns link to the next page of paged feed as declared by the feed, possibly relative
+func feedNextPageURL(feed *gofeed.Feed) string {
+	if feed.Custom == nil {
+		return ""
+	}
+	return feed.Custom[feedNextPageKey]
+}
+
+// itemSourceURL returns URL of the original feed of republished item, empty if item has no source
+func itemSourceURL(item *gofeed.Item) string {
+	if item.Custom == nil {
+		return ""
+	}
+	return item.Custom[itemSourceURLKey]
+}
+
+// podcastEpisode returns iTunes metadata of item, nil if item has no iTunes tags
+func podcastEpisode(item *gofeed.Item) *PodcastEpisode {
+	if item.ITunesExt == nil {
+		return nil
+	}
+	episode := &PodcastEpisode{
+		Duration:    item.ITunesExt.Duration,
+		Episode:     item.ITunesExt.Episode,
+		Season:      item.ITunesExt.Season,
+		EpisodeType: item.ITunesExt.EpisodeType,
+		Explicit:    item.ITunesExt.Explicit,
+	}
+	if *episode == (PodcastEpisode{}) {
+		return nil
+	}
+	return episode
+}
@@ -0,0 +1,60 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPodcastEpisode(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		want    map[string]*PodcastEpisode
+	}{
+		{
+			name:    "RSS",
+			fixture: "podcast.xml",
+			want: map[string]*PodcastEpisode{
+				"Episode two": {Duration: "01:02:03", Episode: "2", Season: "1", EpisodeType: "full", Explicit: "yes"},
+				"Trailer":     {Duration: "95", EpisodeType: "trailer"},
+				"Show notes":  nil,
+			},
+		},
+		{
+			name:    "Atom",
+			fixture: "podcast-atom.xml",
+			want: map[string]*PodcastEpisode{
+				"Episode two": {Duration: "3723", Episode: "2", Season: "1", EpisodeType: "full", Explicit: "no"},
+				"Show notes":  nil,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			feed, err := newFeedParser().Parse(f)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if len(feed.Items) != len(tt.want) {
+				t.Fatalf("got %d items, want %d", len(feed.Items), len(tt.want))
+			}
+			for _, item := range feed.Items {
+				want, ok := tt.want[item.Title]
+				if !ok {
+					t.Errorf("unexpected item %q", item.Title)
+					continue
+				}
+				if got := podcastEpisode(item); !reflect.DeepEqual(got, want) {
+					t.Errorf("podcastEpisode(%q) = %+v, want %+v", item.Title, got, want)
+				}
+			}
+		})
+	}
+}
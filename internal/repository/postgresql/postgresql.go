@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/Tarick/naca-rss-feeds/internal/entity"
@@ -13,6 +14,7 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/gofrs/uuid"
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/log/zapadapter"
 	"github.com/jackc/pgx/v4/pgxpool"
@@ -29,13 +31,71 @@ type Config struct {
 	LogLevel       string `mapstructure:"log_level"`
 	MinConnections int32  `mapstructure:"min_connections"`
 	MaxConnections int32  `mapstructure:"max_connections"`
+	// ConnectAttempts to connect on startup, so service waits for database starting later. 0 means no attempts limit
+	ConnectAttempts int `mapstructure:"connect_attempts"`
+	// ConnectBackoff in seconds before the second attempt, doubled for each next one up to ConnectMaxBackoff
+	ConnectBackoff    int `mapstructure:"connect_backoff"`
+	ConnectMaxBackoff int `mapstructure:"connect_max_backoff"`
+	// ConnectMaxDuration in seconds to keep retrying connection. 0 means no time limit
+	ConnectMaxDuration int `mapstructure:"connect_max_duration"`
+	// ConnectFailFast disables connection retries, for environments which restart the service themselves
+	ConnectFailFast bool `mapstructure:"connect_fail_fast"`
+}
+
+// Validate checks connection retry configuration
+func (c *Config) Validate() error {
+	if c.ConnectAttempts < 0 || c.ConnectBackoff < 0 || c.ConnectMaxBackoff < 0 || c.ConnectMaxDuration < 0 {
+		return errors.New("connect_attempts, connect_backoff, connect_max_backoff and connect_max_duration must not be negative")
+	}
+	return nil
+}
+
+// retriesConnect returns true if connection is retried on startup
+func (c *Config) retriesConnect() bool {
+	return !c.ConnectFailFast && (c.ConnectAttempts > 0 || c.ConnectMaxDuration > 0)
+}
+
+// Hot path statements, prepared on every pool connection and executed by name
+const (
+	saveProcessedItemStmt = "save-processed-item"
+	saveProcessedItemSQL  = "INSERT INTO processed_items (guid, feeds_publication_uuid, pubDate, dedup_key, feed_id) VALUES ($1, $2, $3, NULLIF($4, ''), $5) ON CONFLICT (guid) DO UPDATE SET pubDate=EXCLUDED.pubDate, dedup_key=COALESCE(EXCLUDED.dedup_key, processed_items.dedup_key)"
+
+	insertProcessedItemStmt = "insert-processed-item"
+	insertProcessedItemSQL  = "INSERT INTO processed_items (guid, feeds_publication_uuid, pubDate, dedup_key, feed_id) VALUES ($1, $2, $3, NULLIF($4, ''), $5) ON CONFLICT (guid) DO NOTHING"
+
+	processedItemExistsStmt = "processed-item-exists"
+	processedItemExistsSQL  = "select exists (select 1 from processed_items where (guid=$1 AND feeds_publication_uuid=$2 AND pubDate=$3))"
+
+	processedItemExistsByGUIDStmt = "processed-item-exists-by-guid"
+	processedItemExistsByGUIDSQL  = "select exists (select 1 from processed_items where (guid=$1 AND feeds_publication_uuid=$2))"
+
+	processedItemExistsByDedupKeyStmt = "processed-item-exists-by-dedup-key"
+	processedItemExistsByDedupKeySQL  = "select exists (select 1 from processed_items where dedup_key=$1)"
+)
+
+var preparedStatements = map[string]string{
+	saveProcessedItemStmt:             saveProcessedItemSQL,
+	insertProcessedItemStmt:           insertProcessedItemSQL,
+	processedItemExistsStmt:           processedItemExistsSQL,
+	processedItemExistsByGUIDStmt:     processedItemExistsByGUIDSQL,
+	processedItemExistsByDedupKeyStmt: processedItemExistsByDedupKeySQL,
 }
 
 type Repository struct {
-	pool   *pgxpool.Pool
+	pool *pgxpool.Pool
+	// db runs queries either on the pool or, for repository passed to WithTx callback, inside the transaction
+	db     querier
 	tracer opentracing.Tracer
 }
 
+// querier is common subset of pgxpool.Pool and pgx.Tx used by repository methods
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
 func NewZapLogger(logger *zap.Logger) *zapadapter.Logger {
 	return zapadapter.NewLogger(logger)
 }
@@ -63,50 +123,163 @@ func New(databaseConfig *Config, logger pgx.Logger, tracer opentracing.Tracer) (
 	poolConfig.ConnConfig.LogLevel = logLevelMapping[databaseConfig.LogLevel]
 	poolConfig.MaxConns = databaseConfig.MaxConnections
 	poolConfig.MinConns = databaseConfig.MinConnections
+	poolConfig.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+		for name, sql := range preparedStatements {
+			if _, err := conn.Prepare(ctx, name, sql); err != nil {
+				return fmt.Errorf("failure preparing statement '%s': %w", name, err)
+			}
+		}
+		return nil
+	}
 
-	pool, err := pgxpool.ConnectConfig(context.Background(), poolConfig)
+	pool, err := connect(poolConfig, databaseConfig, logger)
 	if err != nil {
 		return nil, err
 	}
-	return &Repository{pool: pool, tracer: tracer}, nil
+	return &Repository{pool: pool, db: pool, tracer: tracer}, nil
+}
+
+// connect creates connection pool, retrying with exponential backoff until attempts or max duration are exhausted
+func connect(poolConfig *pgxpool.Config, databaseConfig *Config, logger pgx.Logger) (*pgxpool.Pool, error) {
+	if !databaseConfig.retriesConnect() {
+		return pgxpool.ConnectConfig(context.Background(), poolConfig)
+	}
+	var deadline time.Time
+	if databaseConfig.ConnectMaxDuration > 0 {
+		deadline = time.Now().Add(time.Duration(databaseConfig.ConnectMaxDuration) * time.Second)
+	}
+	maxBackoff := time.Duration(databaseConfig.ConnectMaxBackoff) * time.Second
+	backoff := time.Duration(databaseConfig.ConnectBackoff) * time.Second
+	for attempt := 1; ; attempt++ {
+		pool, err := pgxpool.ConnectConfig(context.Background(), poolConfig)
+		if err == nil {
+			if attempt > 1 {
+				logger.Log(context.Background(), pgx.LogLevelInfo, "connected to database", map[string]interface{}{"attempt": attempt})
+			}
+			return pool, nil
+		}
+		if databaseConfig.ConnectAttempts > 0 && attempt >= databaseConfig.ConnectAttempts {
+			return nil, fmt.Errorf("giving up connecting to database after %d attempts: %w", attempt, err)
+		}
+		if !deadline.IsZero() {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return nil, fmt.Errorf("giving up connecting to database after %d seconds: %w", databaseConfig.ConnectMaxDuration, err)
+			}
+			if backoff > remaining {
+				backoff = remaining
+			}
+		}
+		logger.Log(context.Background(), pgx.LogLevelWarn, "failure connecting to database, retrying", map[string]interface{}{
+			"attempt": attempt,
+			"backoff": backoff.String(),
+			"err":     err,
+		})
+		time.Sleep(backoff)
+		backoff *= 2
+		if maxBackoff > 0 && backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// WithTx runs fn inside database transaction. Repository passed to fn executes all its methods within this transaction.
+// Transaction is committed if fn returns nil and rolled back otherwise (or on panic).
+// Calling WithTx on transactional repository creates nested transaction (savepoint).
+func (repository *Repository) WithTx(ctx context.Context, fn func(tx *Repository) error) (err error) {
+	span, ctx := repository.setupTracingSpan(ctx, "transaction", "")
+	defer span.Finish()
+	tx, err := repository.db.Begin(ctx)
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback(ctx)
+			panic(p)
+		}
+	}()
+	if err = fn(&Repository{pool: repository.pool, db: tx, tracer: repository.tracer}); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		if rbErr := tx.Rollback(ctx); rbErr != nil {
+			return fmt.Errorf("%w, transaction rollback failure: %v", err, rbErr)
+		}
+		span.LogKV("event", "rolled back transaction")
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return err
+	}
+	span.LogKV("event", "committed transaction")
+	return nil
 }
 
+// uniqueViolationCode is PostgreSQL error code of unique constraint violation
+const uniqueViolationCode = "23505"
+
 func (repository *Repository) Create(ctx context.Context, f *entity.Feed) error {
-	query := "insert into feeds (publication_uuid, url, language_code) values ($1, $2, $3)"
-	span, ctx := repository.setupTracingSpan(ctx, "get-feed-http-metadata", query)
+	query := "insert into feeds (id, publication_uuid, url, language_code, webhook_url, max_items_per_refresh, headers, login, filter, fetch_timeout) values ($10, $1, $2, $3, $4, $5, $6, $7, $8, $9)"
+	span, ctx := repository.setupTracingSpan(ctx, "create-feed", query)
 	defer span.Finish()
-	_, err := repository.pool.Exec(ctx, query, f.PublicationUUID, f.URL, f.LanguageCode)
+	_, err := repository.db.Exec(ctx, query, f.PublicationUUID, f.URL, f.LanguageCode, f.WebhookURL, f.MaxItemsPerRefresh, feedHeaders(f), f.Login, f.Filter, f.FetchTimeout, f.ID)
 	if err != nil {
 		span.LogFields(
 			otLog.Error(err),
 		)
+		// Feed with the same id or publication and url
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode {
+			return entity.ErrFeedExists
+		}
 	} else {
 		span.LogKV("event", "created feed")
 	}
 	return err
 }
 
+// feedHeaders returns feed headers for jsonb column, which is not nullable
+func feedHeaders(f *entity.Feed) map[string]string {
+	if f.Headers == nil {
+		return map[string]string{}
+	}
+	return f.Headers
+}
+
 func (repository *Repository) Update(ctx context.Context, f *entity.Feed) error {
-	query := "update feeds set url=$1, language_code=$2 where publication_uuid=$3"
+	// Re-enabling resets consecutive failures, so the feed isn't disabled again on the first failure
+	query := "update feeds set url=$1, language_code=$2, webhook_url=$3, max_items_per_refresh=$4, enabled=$5, disabled_reason=$6, consecutive_failures=(case when $5 and not enabled then 0 else consecutive_failures end), headers=$8, login=$9, filter=$10, fetch_timeout=$11 where id=$7"
 	span, ctx := repository.setupTracingSpan(ctx, "update-feed", query)
 	defer span.Finish()
-	_, err := repository.pool.Exec(ctx, query, f.URL, f.LanguageCode, f.PublicationUUID)
+	_, err := repository.db.Exec(ctx, query, f.URL, f.LanguageCode, f.WebhookURL, f.MaxItemsPerRefresh, f.Enabled, f.DisabledReason, f.ID, feedHeaders(f), f.Login, f.Filter, f.FetchTimeout)
 	if err != nil {
 		span.LogFields(
 			otLog.Error(err),
 		)
+		// Publication already has another feed with the url
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode {
+			return entity.ErrFeedExists
+		}
 	} else {
 		span.LogKV("event", "updated feed")
 	}
 	return err
 }
 
-func (repository *Repository) Delete(ctx context.Context, publicationUUID uuid.UUID) error {
-	query := "delete from feeds where publication_uuid=$1"
+func (repository *Repository) Delete(ctx context.Context, id uuid.UUID) error {
+	query := "delete from feeds where id=$1"
 	span, ctx := repository.setupTracingSpan(ctx, "delete-feed", query)
 	defer span.Finish()
-	span.LogKV("publicationUUID", publicationUUID)
-	result, err := repository.pool.Exec(ctx, query, publicationUUID)
+	span.LogKV("feedID", id)
+	result, err := repository.db.Exec(ctx, query, id)
 	if err != nil {
 		span.LogFields(
 			otLog.Error(err),
@@ -115,20 +288,114 @@ func (repository *Repository) Delete(ctx context.Context, publicationUUID uuid.U
 	}
 	if result.RowsAffected() != 1 {
 		span.LogKV("event", "didn't find the feed to delete")
-		return errors.New(fmt.Sprint("feeds delete from db execution didn't delete record for UUID ", publicationUUID))
+		return errors.New(fmt.Sprint("feeds delete from db execution didn't delete record for ID ", id))
 	}
 
 	span.LogKV("event", "delete feed")
 	return err
 }
 
-func (repository *Repository) GetByPublicationUUID(ctx context.Context, publicationUUID uuid.UUID) (*entity.Feed, error) {
-	query := "select publication_uuid, url, language_code from feeds where publication_uuid=$1"
-	span, ctx := repository.setupTracingSpan(ctx, "get-feed-by-publicationUUID", query)
+// CreateWithAudit creates feed and saves audit record of it in the same transaction
+func (repository *Repository) CreateWithAudit(ctx context.Context, f *entity.Feed, record *entity.AuditRecord) error {
+	return repository.WithTx(ctx, func(tx *Repository) error {
+		if err := tx.Create(ctx, f); err != nil {
+			return err
+		}
+		return tx.SaveAuditRecord(ctx, record)
+	})
+}
+
+// UpdateWithAudit updates feed and saves audit record of it in the same transaction
+func (repository *Repository) UpdateWithAudit(ctx context.Context, f *entity.Feed, record *entity.AuditRecord) error {
+	return repository.WithTx(ctx, func(tx *Repository) error {
+		if err := tx.Update(ctx, f); err != nil {
+			return err
+		}
+		return tx.SaveAuditRecord(ctx, record)
+	})
+}
+
+// DeleteWithAudit deletes feed and saves audit record of it in the same transaction
+func (repository *Repository) DeleteWithAudit(ctx context.Context, id uuid.UUID, record *entity.AuditRecord) error {
+	return repository.WithTx(ctx, func(tx *Repository) error {
+		if err := tx.Delete(ctx, id); err != nil {
+			return err
+		}
+		return tx.SaveAuditRecord(ctx, record)
+	})
+}
+
+// SaveAuditRecord saves record of feed change, call it within transaction of the change
+func (repository *Repository) SaveAuditRecord(ctx context.Context, record *entity.AuditRecord) error {
+	query := "insert into audit_log (action, publication_uuid, feed_id, before, after, actor) values ($1, $2, $3, $4, $5, $6)"
+	span, ctx := repository.setupTracingSpan(ctx, "save-audit-record", query)
+	defer span.Finish()
+	// RawMessage is passed as []byte, so nil snapshot is stored as null
+	_, err := repository.db.Exec(ctx, query, record.Action, record.PublicationUUID, record.FeedID, []byte(record.Before), []byte(record.After), record.Actor)
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+	} else {
+		span.LogKV("event", "saved audit record")
+	}
+	return err
+}
+
+// GetAuditRecords returns page of audit records, the newest first.
+// Nil publicationUUID and feedID don't filter records, so both nil return records of all feeds
+func (repository *Repository) GetAuditRecords(ctx context.Context, publicationUUID uuid.UUID, feedID uuid.UUID, limit int, offset int) ([]entity.AuditRecord, error) {
+	query := "select id, action, publication_uuid, feed_id, before, after, actor, created_at from audit_log where ($1::uuid is null or publication_uuid=$1) and ($4::uuid is null or feed_id=$4) order by created_at desc, id desc limit $2 offset $3"
+	span, ctx := repository.setupTracingSpan(ctx, "get-audit-records", query)
+	defer span.Finish()
+	var publicationUUIDParam, feedIDParam *uuid.UUID
+	if publicationUUID != uuid.Nil {
+		publicationUUIDParam = &publicationUUID
+	}
+	if feedID != uuid.Nil {
+		feedIDParam = &feedID
+	}
+	rows, err := repository.db.Query(ctx, query, publicationUUIDParam, limit, offset, feedIDParam)
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []entity.AuditRecord{}
+	for rows.Next() {
+		a := entity.AuditRecord{}
+		var before, after []byte
+		if err := rows.Scan(&a.ID, &a.Action, &a.PublicationUUID, &a.FeedID, &before, &after, &a.Actor, &a.CreatedAt); err != nil {
+			span.LogFields(
+				otLog.Error(err),
+			)
+			return nil, err
+		}
+		a.Before, a.After = before, after
+		records = append(records, a)
+	}
+	if err := rows.Err(); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return nil, err
+	}
+	span.LogKV("records number", len(records))
+	return records, nil
+}
+
+// GetByID returns feed with the id, nil if there is no such feed
+func (repository *Repository) GetByID(ctx context.Context, id uuid.UUID) (*entity.Feed, error) {
+	// Headers, login and filter are selected only here, feed lists don't expose them
+	query := "select id, publication_uuid, url, language_code, webhook_url, max_items_per_refresh, fetch_timeout, last_item_published, consecutive_failures, last_error, last_fetched, last_fetch_duration_ms, enabled, disabled_reason, quarantine_reason, quarantined_at, headers, login, filter from feeds where id=$1"
+	span, ctx := repository.setupTracingSpan(ctx, "get-feed-by-id", query)
 	defer span.Finish()
 
 	f := &entity.Feed{}
-	err := repository.pool.QueryRow(ctx, query, publicationUUID).Scan(&f.PublicationUUID, &f.URL, &f.LanguageCode)
+	err := repository.db.QueryRow(ctx, query, id).Scan(&f.ID, &f.PublicationUUID, &f.URL, &f.LanguageCode, &f.WebhookURL, &f.MaxItemsPerRefresh, &f.FetchTimeout, &f.LastItemPublished, &f.ConsecutiveFailures, &f.LastError, &f.LastFetched, &f.LastFetchDurationMs, &f.Enabled, &f.DisabledReason, &f.QuarantineReason, &f.QuarantinedAt, &f.Headers, &f.Login, &f.Filter)
 	if err != nil && err == pgx.ErrNoRows {
 		span.LogKV("event", "feed not found")
 		return nil, nil
@@ -143,12 +410,18 @@ func (repository *Repository) GetByPublicationUUID(ctx context.Context, publicat
 	span.LogKV("event", "got feed")
 	return f, nil
 }
-func (repository *Repository) GetFeedHTTPMetadataByPublicationUUID(ctx context.Context, publicationUUID uuid.UUID) (*entity.FeedHTTPMetadata, error) {
-	query := "SELECT publication_uuid, COALESCE(etag, 'noetag'), COALESCE(last_modified,$2) FROM feeds WHERE publication_uuid=$1"
+
+// GetByPublicationUUID returns feeds of the publication ordered by feed ID, empty if publication has no feeds
+func (repository *Repository) GetByPublicationUUID(ctx context.Context, publicationUUID uuid.UUID) ([]entity.Feed, error) {
+	return repository.GetByPublicationUUIDs(ctx, []uuid.UUID{publicationUUID})
+}
+
+func (repository *Repository) GetFeedHTTPMetadataByFeedID(ctx context.Context, id uuid.UUID) (*entity.FeedHTTPMetadata, error) {
+	query := "SELECT id, COALESCE(etag, 'noetag'), COALESCE(last_modified,$2) FROM feeds WHERE id=$1"
 	span, ctx := repository.setupTracingSpan(ctx, "get-feed-http-metadata", query)
 	defer span.Finish()
 	m := &entity.FeedHTTPMetadata{}
-	err := repository.pool.QueryRow(ctx, query, publicationUUID, time.Time{}).Scan(&m.PublicationUUID, &m.ETag, &m.LastModified)
+	err := repository.db.QueryRow(ctx, query, id, time.Time{}).Scan(&m.FeedID, &m.ETag, &m.LastModified)
 	if err != nil && err == pgx.ErrNoRows {
 		span.LogFields(
 			otLog.Error(err),
@@ -165,10 +438,10 @@ func (repository *Repository) GetFeedHTTPMetadataByPublicationUUID(ctx context.C
 	return m, nil
 }
 func (repository *Repository) SaveFeedHTTPMetadata(ctx context.Context, m *entity.FeedHTTPMetadata) error {
-	query := "update feeds set etag=$1, last_modified=$2 where publication_uuid=$3"
+	query := "update feeds set etag=$1, last_modified=$2 where id=$3"
 	span, ctx := repository.setupTracingSpan(ctx, "save-feed-http-metadata", query)
 	defer span.Finish()
-	_, err := repository.pool.Exec(ctx, query, m.ETag, m.LastModified, m.PublicationUUID)
+	_, err := repository.db.Exec(ctx, query, m.ETag, m.LastModified, m.FeedID)
 	if err != nil {
 		span.LogFields(
 			otLog.Error(err),
@@ -180,10 +453,10 @@ func (repository *Repository) SaveFeedHTTPMetadata(ctx context.Context, m *entit
 }
 
 func (repository *Repository) GetAll(ctx context.Context) ([]entity.Feed, error) {
-	query := "select publication_uuid, url, language_code from feeds"
+	query := "select id, publication_uuid, url, language_code, webhook_url, max_items_per_refresh, fetch_timeout, last_item_published, consecutive_failures, last_error, last_fetched, last_fetch_duration_ms, enabled, disabled_reason, quarantine_reason, quarantined_at from feeds"
 	span, ctx := repository.setupTracingSpan(ctx, "repository-feeds-get-all", query)
 	defer span.Finish()
-	rows, err := repository.pool.Query(ctx, query)
+	rows, err := repository.db.Query(ctx, query)
 	if err != nil {
 		span.LogFields(
 			otLog.Error(err),
@@ -196,7 +469,7 @@ func (repository *Repository) GetAll(ctx context.Context) ([]entity.Feed, error)
 	feeds := []entity.Feed{}
 	for rows.Next() {
 		f := entity.Feed{}
-		if err := rows.Scan(&f.PublicationUUID, &f.URL, &f.LanguageCode); err != nil {
+		if err := rows.Scan(&f.ID, &f.PublicationUUID, &f.URL, &f.LanguageCode, &f.WebhookURL, &f.MaxItemsPerRefresh, &f.FetchTimeout, &f.LastItemPublished, &f.ConsecutiveFailures, &f.LastError, &f.LastFetched, &f.LastFetchDurationMs, &f.Enabled, &f.DisabledReason, &f.QuarantineReason, &f.QuarantinedAt); err != nil {
 			span.LogFields(
 				otLog.Error(err),
 			)
@@ -215,39 +488,896 @@ func (repository *Repository) GetAll(ctx context.Context) ([]entity.Feed, error)
 	return feeds, nil
 }
 
-func (repository *Repository) SaveProcessedItem(ctx context.Context, i *entity.ProcessedItem) error {
-	query := "INSERT INTO processed_items (guid, feeds_publication_uuid, pubDate) VALUES ($1, $2, $3) ON CONFLICT (guid) DO UPDATE SET pubDate=EXCLUDED.pubDate"
-	span, ctx := repository.setupTracingSpan(ctx, "save-processed-item", query)
+// GetPage returns page of feeds ordered by publication UUID and feed ID and the total number of feeds
+func (repository *Repository) GetPage(ctx context.Context, limit int, offset int) ([]entity.Feed, int64, error) {
+	countQuery := "select count(*) from feeds"
+	query := "select id, publication_uuid, url, language_code, webhook_url, max_items_per_refresh, fetch_timeout, last_item_published, consecutive_failures, last_error, last_fetched, last_fetch_duration_ms, enabled, disabled_reason, quarantine_reason, quarantined_at from feeds order by publication_uuid, id limit $1 offset $2"
+	span, ctx := repository.setupTracingSpan(ctx, "repository-feeds-get-page", query)
+	defer span.Finish()
+	var total int64
+	if err := repository.db.QueryRow(ctx, countQuery).Scan(&total); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return nil, 0, err
+	}
+	rows, err := repository.db.Query(ctx, query, limit, offset)
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	feeds := []entity.Feed{}
+	for rows.Next() {
+		f := entity.Feed{}
+		if err := rows.Scan(&f.ID, &f.PublicationUUID, &f.URL, &f.LanguageCode, &f.WebhookURL, &f.MaxItemsPerRefresh, &f.FetchTimeout, &f.LastItemPublished, &f.ConsecutiveFailures, &f.LastError, &f.LastFetched, &f.LastFetchDurationMs, &f.Enabled, &f.DisabledReason, &f.QuarantineReason, &f.QuarantinedAt); err != nil {
+			span.LogFields(
+				otLog.Error(err),
+			)
+			return nil, 0, err
+		}
+		feeds = append(feeds, f)
+	}
+	if err := rows.Err(); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return nil, 0, err
+	}
+	span.LogKV("items number", len(feeds), "total", total)
+	return feeds, total, nil
+}
+
+// GetByPublicationUUIDs returns feeds of publications from the list ordered by publication UUID and feed ID,
+// publications without feeds are ignored
+func (repository *Repository) GetByPublicationUUIDs(ctx context.Context, publicationUUIDs []uuid.UUID) ([]entity.Feed, error) {
+	query := "select id, publication_uuid, url, language_code, webhook_url, max_items_per_refresh, fetch_timeout, last_item_published, consecutive_failures, last_error, last_fetched, last_fetch_duration_ms, enabled, disabled_reason, quarantine_reason, quarantined_at from feeds where publication_uuid = ANY($1::uuid[]) order by publication_uuid, id"
+	span, ctx := repository.setupTracingSpan(ctx, "repository-feeds-get-by-publication-uuids", query)
+	defer span.Finish()
+	uuids := make([]string, len(publicationUUIDs))
+	for i, publicationUUID := range publicationUUIDs {
+		uuids[i] = publicationUUID.String()
+	}
+	rows, err := repository.db.Query(ctx, query, uuids)
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return nil, err
+	}
+	span.LogKV("event", "query DB for feeds by publication uuids")
+	defer rows.Close()
+
+	feeds := []entity.Feed{}
+	for rows.Next() {
+		f := entity.Feed{}
+		if err := rows.Scan(&f.ID, &f.PublicationUUID, &f.URL, &f.LanguageCode, &f.WebhookURL, &f.MaxItemsPerRefresh, &f.FetchTimeout, &f.LastItemPublished, &f.ConsecutiveFailures, &f.LastError, &f.LastFetched, &f.LastFetchDurationMs, &f.Enabled, &f.DisabledReason, &f.QuarantineReason, &f.QuarantinedAt); err != nil {
+			span.LogFields(
+				otLog.Error(err),
+			)
+			return nil, err
+		}
+		feeds = append(feeds, f)
+	}
+	if err := rows.Err(); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return nil, err
+	}
+	span.LogKV("items number", len(feeds))
+
+	return feeds, nil
+}
+
+// GetPublicationUUIDsByURLs returns publication UUIDs of feeds with urls from the list, keyed by url.
+// Url used by several feeds is returned with one of their publications, missing urls are ignored
+func (repository *Repository) GetPublicationUUIDsByURLs(ctx context.Context, urls []string) (map[string]uuid.UUID, error) {
+	query := "select distinct on (url) url, publication_uuid from feeds where url = ANY($1::text[]) order by url, publication_uuid"
+	span, ctx := repository.setupTracingSpan(ctx, "repository-feeds-get-publication-uuids-by-urls", query)
 	defer span.Finish()
-	_, err := repository.pool.Exec(ctx, query, i.GUID, i.PublicationUUID, i.PublicationDate)
+	rows, err := repository.db.Query(ctx, query, urls)
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return nil, err
+	}
+	defer rows.Close()
+	publicationUUIDs := map[string]uuid.UUID{}
+	for rows.Next() {
+		var (
+			url             string
+			publicationUUID uuid.UUID
+		)
+		if err := rows.Scan(&url, &publicationUUID); err != nil {
+			span.LogFields(
+				otLog.Error(err),
+			)
+			return nil, err
+		}
+		publicationUUIDs[url] = publicationUUID
+	}
+	if err := rows.Err(); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return nil, err
+	}
+	span.LogKV("items number", len(publicationUUIDs))
+	return publicationUUIDs, nil
+}
+
+// SaveFeedLastItemPublished moves feed last item publication date forward, older dates are ignored
+func (repository *Repository) SaveFeedLastItemPublished(ctx context.Context, id uuid.UUID, lastItemPublished time.Time) error {
+	query := "update feeds set last_item_published=$1 where id=$2 and (last_item_published is null or last_item_published < $1)"
+	span, ctx := repository.setupTracingSpan(ctx, "save-feed-last-item-published", query)
+	defer span.Finish()
+	_, err := repository.db.Exec(ctx, query, lastItemPublished, id)
 	if err != nil {
 		span.LogFields(
 			otLog.Error(err),
 		)
 	} else {
-		span.LogKV("event", "saved processed item")
+		span.LogKV("event", "saved feed last item published date")
 	}
 	return err
 }
 
-func (repository *Repository) ProcessedItemExists(ctx context.Context, i *entity.ProcessedItem) (bool, error) {
-	var exists bool
-	query := "select exists (select 1 from processed_items where (guid=$1 AND feeds_publication_uuid=$2 AND pubDate=$3))"
-	span, ctx := repository.setupTracingSpan(ctx, "check-processed-item-exists", query)
+// IncrementFeedFailures increases feed consecutive failures counter, saves the last error and fetch time and returns new counter value
+func (repository *Repository) IncrementFeedFailures(ctx context.Context, id uuid.UUID, lastError string) (int, error) {
+	query := "update feeds set consecutive_failures=consecutive_failures+1, last_error=$1, last_fetched=now() where id=$2 returning consecutive_failures"
+	span, ctx := repository.setupTracingSpan(ctx, "increment-feed-failures", query)
 	defer span.Finish()
-	row := repository.pool.QueryRow(ctx, query, i.GUID, i.PublicationUUID, i.PublicationDate)
-	if err := row.Scan(&exists); err != nil {
+	var failures int
+	if err := repository.db.QueryRow(ctx, query, lastError, id).Scan(&failures); err != nil {
 		span.LogFields(
 			otLog.Error(err),
 		)
-		return false, err
+		return 0, err
 	}
-	if exists == true {
-		span.LogKV("event", "processed item already exists")
-		return true, nil
+	span.LogKV("consecutive failures", failures)
+	return failures, nil
+}
+
+// SaveFeedFetchDuration saves fetch and parse time of the last successful feed refresh
+func (repository *Repository) SaveFeedFetchDuration(ctx context.Context, id uuid.UUID, duration time.Duration) error {
+	query := "update feeds set last_fetch_duration_ms=$1 where id=$2"
+	span, ctx := repository.setupTracingSpan(ctx, "save-feed-fetch-duration", query)
+	defer span.Finish()
+	_, err := repository.db.Exec(ctx, query, duration.Milliseconds(), id)
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+	} else {
+		span.LogKV("event", "saved feed fetch duration")
 	}
-	span.LogKV("event", "processed item doesn't exist")
-	return false, nil
+	return err
+}
+
+// DisableFeed disables enabled feed with the reason
+func (repository *Repository) DisableFeed(ctx context.Context, id uuid.UUID, reason string) error {
+	query := "update feeds set enabled=false, disabled_reason=$1 where id=$2 and enabled"
+	span, ctx := repository.setupTracingSpan(ctx, "disable-feed", query)
+	defer span.Finish()
+	_, err := repository.db.Exec(ctx, query, reason, id)
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+	} else {
+		span.LogKV("event", "disabled feed")
+	}
+	return err
+}
+
+// SaveFeedContentCheck counts feed consecutive refreshes without items and saves response content type, if not empty.
+// Returns the number of consecutive empty refreshes and content type saved before.
+func (repository *Repository) SaveFeedContentCheck(ctx context.Context, id uuid.UUID, empty bool, contentType string) (int, string, error) {
+	query := "update feeds f set consecutive_empty_refreshes=(case when $2 then f.consecutive_empty_refreshes+1 else 0 end), last_content_type=(case when $3='' then f.last_content_type else $3 end) from (select last_content_type from feeds where id=$1 for update) previous where f.id=$1 returning f.consecutive_empty_refreshes, previous.last_content_type"
+	span, ctx := repository.setupTracingSpan(ctx, "save-feed-content-check", query)
+	defer span.Finish()
+	var emptyRefreshes int
+	var previousContentType string
+	if err := repository.db.QueryRow(ctx, query, id, empty, contentType).Scan(&emptyRefreshes, &previousContentType); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return 0, "", err
+	}
+	span.LogKV("emptyRefreshes", emptyRefreshes, "previousContentType", previousContentType)
+	return emptyRefreshes, previousContentType, nil
+}
+
+// QuarantineFeed marks feed as quarantined with the reason
+func (repository *Repository) QuarantineFeed(ctx context.Context, id uuid.UUID, reason string) error {
+	query := "update feeds set quarantine_reason=$1, quarantined_at=NOW() where id=$2"
+	span, ctx := repository.setupTracingSpan(ctx, "quarantine-feed", query)
+	defer span.Finish()
+	_, err := repository.db.Exec(ctx, query, reason, id)
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+	} else {
+		span.LogKV("event", "quarantined feed")
+	}
+	return err
+}
+
+// ClearFeedQuarantine releases feed from quarantine and resets quarantine heuristics state,
+// so current content type becomes the new baseline
+func (repository *Repository) ClearFeedQuarantine(ctx context.Context, id uuid.UUID) error {
+	query := "update feeds set quarantine_reason='', quarantined_at=NULL, consecutive_empty_refreshes=0, last_content_type='' where id=$1"
+	span, ctx := repository.setupTracingSpan(ctx, "clear-feed-quarantine", query)
+	defer span.Finish()
+	_, err := repository.db.Exec(ctx, query, id)
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+	} else {
+		span.LogKV("event", "cleared feed quarantine")
+	}
+	return err
+}
+
+// ResetFeedFailures zeroes feed consecutive failures counter and last error, saves fetch time
+func (repository *Repository) ResetFeedFailures(ctx context.Context, id uuid.UUID) error {
+	query := "update feeds set consecutive_failures=0, last_error='', last_fetched=now() where id=$1"
+	span, ctx := repository.setupTracingSpan(ctx, "reset-feed-failures", query)
+	defer span.Finish()
+	_, err := repository.db.Exec(ctx, query, id)
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+	} else {
+		span.LogKV("event", "reset feed consecutive failures")
+	}
+	return err
+}
+
+// SaveFeedDetectedLanguageCode sets feed language code only if it is empty, so language code set by user is kept
+func (repository *Repository) SaveFeedDetectedLanguageCode(ctx context.Context, id uuid.UUID, languageCode string) error {
+	query := "update feeds set language_code=$1 where id=$2 and language_code=''"
+	span, ctx := repository.setupTracingSpan(ctx, "save-feed-detected-language-code", query)
+	defer span.Finish()
+	_, err := repository.db.Exec(ctx, query, languageCode, id)
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+	} else {
+		span.LogKV("event", "saved feed detected language code")
+	}
+	return err
+}
+
+// GetStaleFeeds returns feeds, which didn't publish new items since the cutoff date (or never published anything)
+func (repository *Repository) GetStaleFeeds(ctx context.Context, since time.Time) ([]entity.Feed, error) {
+	query := "select id, publication_uuid, url, language_code, webhook_url, max_items_per_refresh, fetch_timeout, last_item_published, consecutive_failures, last_error, last_fetched, last_fetch_duration_ms, enabled, disabled_reason, quarantine_reason, quarantined_at from feeds where last_item_published is null or last_item_published < $1"
+	span, ctx := repository.setupTracingSpan(ctx, "repository-feeds-get-stale", query)
+	defer span.Finish()
+	rows, err := repository.db.Query(ctx, query, since)
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return nil, err
+	}
+	span.LogKV("event", "query DB for stale feeds")
+	defer rows.Close()
+
+	feeds := []entity.Feed{}
+	for rows.Next() {
+		f := entity.Feed{}
+		if err := rows.Scan(&f.ID, &f.PublicationUUID, &f.URL, &f.LanguageCode, &f.WebhookURL, &f.MaxItemsPerRefresh, &f.FetchTimeout, &f.LastItemPublished, &f.ConsecutiveFailures, &f.LastError, &f.LastFetched, &f.LastFetchDurationMs, &f.Enabled, &f.DisabledReason, &f.QuarantineReason, &f.QuarantinedAt); err != nil {
+			span.LogFields(
+				otLog.Error(err),
+			)
+			return nil, err
+		}
+		feeds = append(feeds, f)
+	}
+	if err := rows.Err(); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return nil, err
+	}
+	span.LogKV("items number", len(feeds))
+
+	return feeds, nil
+}
+
+// GetFailingFeeds returns page of feeds with at least minFailures consecutive failures, most failing first
+func (repository *Repository) GetFailingFeeds(ctx context.Context, minFailures int, limit int, offset int) ([]entity.Feed, error) {
+	query := "select id, publication_uuid, url, language_code, webhook_url, max_items_per_refresh, fetch_timeout, last_item_published, consecutive_failures, last_error, last_fetched, last_fetch_duration_ms, enabled, disabled_reason, quarantine_reason, quarantined_at from feeds where consecutive_failures >= $1 order by consecutive_failures desc, id limit $2 offset $3"
+	span, ctx := repository.setupTracingSpan(ctx, "repository-feeds-get-failing", query)
+	defer span.Finish()
+	rows, err := repository.db.Query(ctx, query, minFailures, limit, offset)
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return nil, err
+	}
+	span.LogKV("event", "query DB for failing feeds")
+	defer rows.Close()
+
+	feeds := []entity.Feed{}
+	for rows.Next() {
+		f := entity.Feed{}
+		if err := rows.Scan(&f.ID, &f.PublicationUUID, &f.URL, &f.LanguageCode, &f.WebhookURL, &f.MaxItemsPerRefresh, &f.FetchTimeout, &f.LastItemPublished, &f.ConsecutiveFailures, &f.LastError, &f.LastFetched, &f.LastFetchDurationMs, &f.Enabled, &f.DisabledReason, &f.QuarantineReason, &f.QuarantinedAt); err != nil {
+			span.LogFields(
+				otLog.Error(err),
+			)
+			return nil, err
+		}
+		feeds = append(feeds, f)
+	}
+	if err := rows.Err(); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return nil, err
+	}
+	span.LogKV("items number", len(feeds))
+
+	return feeds, nil
+}
+
+// GetSlowestFeeds returns feeds with the longest last fetch duration, slowest first
+func (repository *Repository) GetSlowestFeeds(ctx context.Context, limit int) ([]entity.Feed, error) {
+	query := "select id, publication_uuid, url, language_code, webhook_url, max_items_per_refresh, fetch_timeout, last_item_published, consecutive_failures, last_error, last_fetched, last_fetch_duration_ms, enabled, disabled_reason, quarantine_reason, quarantined_at from feeds where last_fetch_duration_ms is not null order by last_fetch_duration_ms desc limit $1"
+	span, ctx := repository.setupTracingSpan(ctx, "repository-feeds-get-slowest", query)
+	defer span.Finish()
+	rows, err := repository.db.Query(ctx, query, limit)
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return nil, err
+	}
+	span.LogKV("event", "query DB for slowest feeds")
+	defer rows.Close()
+
+	feeds := []entity.Feed{}
+	for rows.Next() {
+		f := entity.Feed{}
+		if err := rows.Scan(&f.ID, &f.PublicationUUID, &f.URL, &f.LanguageCode, &f.WebhookURL, &f.MaxItemsPerRefresh, &f.FetchTimeout, &f.LastItemPublished, &f.ConsecutiveFailures, &f.LastError, &f.LastFetched, &f.LastFetchDurationMs, &f.Enabled, &f.DisabledReason, &f.QuarantineReason, &f.QuarantinedAt); err != nil {
+			span.LogFields(
+				otLog.Error(err),
+			)
+			return nil, err
+		}
+		feeds = append(feeds, f)
+	}
+	if err := rows.Err(); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return nil, err
+	}
+	span.LogKV("items number", len(feeds))
+
+	return feeds, nil
+}
+
+// GetQuarantinedFeeds returns feeds quarantined for manual review, the longest quarantined first
+func (repository *Repository) GetQuarantinedFeeds(ctx context.Context) ([]entity.Feed, error) {
+	query := "select id, publication_uuid, url, language_code, webhook_url, max_items_per_refresh, fetch_timeout, last_item_published, consecutive_failures, last_error, last_fetched, last_fetch_duration_ms, enabled, disabled_reason, quarantine_reason, quarantined_at from feeds where quarantine_reason <> '' order by quarantined_at"
+	span, ctx := repository.setupTracingSpan(ctx, "repository-feeds-get-quarantined", query)
+	defer span.Finish()
+	rows, err := repository.db.Query(ctx, query)
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return nil, err
+	}
+	span.LogKV("event", "query DB for quarantined feeds")
+	defer rows.Close()
+
+	feeds := []entity.Feed{}
+	for rows.Next() {
+		f := entity.Feed{}
+		if err := rows.Scan(&f.ID, &f.PublicationUUID, &f.URL, &f.LanguageCode, &f.WebhookURL, &f.MaxItemsPerRefresh, &f.FetchTimeout, &f.LastItemPublished, &f.ConsecutiveFailures, &f.LastError, &f.LastFetched, &f.LastFetchDurationMs, &f.Enabled, &f.DisabledReason, &f.QuarantineReason, &f.QuarantinedAt); err != nil {
+			span.LogFields(
+				otLog.Error(err),
+			)
+			return nil, err
+		}
+		feeds = append(feeds, f)
+	}
+	if err := rows.Err(); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return nil, err
+	}
+	span.LogKV("items number", len(feeds))
+
+	return feeds, nil
+}
+
+func (repository *Repository) SaveProcessedItem(ctx context.Context, i *entity.ProcessedItem) error {
+	span, ctx := repository.setupTracingSpan(ctx, "save-processed-item", saveProcessedItemSQL)
+	defer span.Finish()
+	_, err := repository.db.Exec(ctx, saveProcessedItemStmt, i.GUID, i.PublicationUUID, i.PublicationDate, i.DedupKey, i.FeedID)
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+	} else {
+		span.LogKV("event", "saved processed item")
+	}
+	return err
+}
+
+// InsertProcessedItem saves processed item unless item with the same GUID is already saved, recorded item is never changed
+func (repository *Repository) InsertProcessedItem(ctx context.Context, i *entity.ProcessedItem) error {
+	span, ctx := repository.setupTracingSpan(ctx, "insert-processed-item", insertProcessedItemSQL)
+	defer span.Finish()
+	tag, err := repository.db.Exec(ctx, insertProcessedItemStmt, i.GUID, i.PublicationUUID, i.PublicationDate, i.DedupKey, i.FeedID)
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+	} else if tag.RowsAffected() == 0 {
+		span.LogKV("event", "processed item already exists, kept unchanged")
+	} else {
+		span.LogKV("event", "inserted processed item")
+	}
+	return err
+}
+
+// CreateProcessedItem saves processed item, ErrProcessedItemExists is returned if item with the same GUID is already saved
+func (repository *Repository) CreateProcessedItem(ctx context.Context, i *entity.ProcessedItem) error {
+	span, ctx := repository.setupTracingSpan(ctx, "create-processed-item", insertProcessedItemSQL)
+	defer span.Finish()
+	tag, err := repository.db.Exec(ctx, insertProcessedItemStmt, i.GUID, i.PublicationUUID, i.PublicationDate, i.DedupKey, i.FeedID)
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		span.LogKV("event", "processed item already exists")
+		return entity.ErrProcessedItemExists
+	}
+	span.LogKV("event", "created processed item")
+	return nil
+}
+
+func (repository *Repository) ProcessedItemExists(ctx context.Context, i *entity.ProcessedItem) (bool, error) {
+	var exists bool
+	span, ctx := repository.setupTracingSpan(ctx, "check-processed-item-exists", processedItemExistsSQL)
+	defer span.Finish()
+	row := repository.db.QueryRow(ctx, processedItemExistsStmt, i.GUID, i.PublicationUUID, i.PublicationDate)
+	if err := row.Scan(&exists); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return false, err
+	}
+	if exists == true {
+		span.LogKV("event", "processed item already exists")
+		return true, nil
+	}
+	span.LogKV("event", "processed item doesn't exist")
+	return false, nil
+}
+
+// ProcessedItemExistsByGUID checks processed item by GUID only, ignoring publication date
+func (repository *Repository) ProcessedItemExistsByGUID(ctx context.Context, i *entity.ProcessedItem) (bool, error) {
+	var exists bool
+	span, ctx := repository.setupTracingSpan(ctx, "check-processed-item-exists-by-guid", processedItemExistsByGUIDSQL)
+	defer span.Finish()
+	row := repository.db.QueryRow(ctx, processedItemExistsByGUIDStmt, i.GUID, i.PublicationUUID)
+	if err := row.Scan(&exists); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return false, err
+	}
+	if exists {
+		span.LogKV("event", "processed item already exists")
+		return true, nil
+	}
+	span.LogKV("event", "processed item doesn't exist")
+	return false, nil
+}
+
+// ProcessedItemExistsByDedupKey checks if item with the dedup key was processed in any feed
+func (repository *Repository) ProcessedItemExistsByDedupKey(ctx context.Context, dedupKey string) (bool, error) {
+	var exists bool
+	span, ctx := repository.setupTracingSpan(ctx, "check-processed-item-exists-by-dedup-key", processedItemExistsByDedupKeySQL)
+	defer span.Finish()
+	if err := repository.db.QueryRow(ctx, processedItemExistsByDedupKeyStmt, dedupKey).Scan(&exists); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return false, err
+	}
+	span.LogKV("exists", exists)
+	return exists, nil
+}
+
+// FeedHasProcessedItems checks if any item of the feed was processed, feed without them is fetched first time
+func (repository *Repository) FeedHasProcessedItems(ctx context.Context, feedID uuid.UUID) (bool, error) {
+	var exists bool
+	query := "select exists (select 1 from processed_items where feed_id=$1)"
+	span, ctx := repository.setupTracingSpan(ctx, "check-feed-has-processed-items", query)
+	defer span.Finish()
+	if err := repository.db.QueryRow(ctx, query, feedID).Scan(&exists); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return false, err
+	}
+	span.LogKV("exists", exists)
+	return exists, nil
+}
+
+// GetMaintenance returns maintenance mode state
+func (repository *Repository) GetMaintenance(ctx context.Context) (*entity.Maintenance, error) {
+	query := "select enabled, reason, updated_at from maintenance"
+	span, ctx := repository.setupTracingSpan(ctx, "get-maintenance", query)
+	defer span.Finish()
+	m := &entity.Maintenance{}
+	if err := repository.db.QueryRow(ctx, query).Scan(&m.Enabled, &m.Reason, &m.UpdatedAt); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return nil, err
+	}
+	span.LogKV("maintenance", m.Enabled)
+	return m, nil
+}
+
+// SetMaintenance enables or disables maintenance mode, setting its updated time
+func (repository *Repository) SetMaintenance(ctx context.Context, m *entity.Maintenance) error {
+	query := "update maintenance set enabled=$1, reason=$2, updated_at=NOW() returning updated_at"
+	span, ctx := repository.setupTracingSpan(ctx, "set-maintenance", query)
+	defer span.Finish()
+	if err := repository.db.QueryRow(ctx, query, m.Enabled, m.Reason).Scan(&m.UpdatedAt); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return err
+	}
+	span.LogKV("maintenance", m.Enabled)
+	return nil
+}
+
+// GetFeedStats returns aggregate numbers of feed processing, nil if there is no such feed
+func (repository *Repository) GetFeedStats(ctx context.Context, id uuid.UUID) (*entity.FeedStats, error) {
+	query := `select f.id, f.publication_uuid, count(p.guid),
+		count(p.guid) filter (where p.created_at > now() - interval '24 hours'),
+		count(p.guid) filter (where p.created_at > now() - interval '7 days'),
+		count(p.guid) filter (where p.created_at > now() - interval '30 days'),
+		f.last_fetched, f.last_item_published, f.consecutive_failures, f.last_error, f.consecutive_empty_refreshes
+		from feeds f left join processed_items p on p.feed_id = f.id
+		where f.id=$1 group by f.id`
+	span, ctx := repository.setupTracingSpan(ctx, "get-feed-stats", query)
+	defer span.Finish()
+	s := &entity.FeedStats{}
+	err := repository.db.QueryRow(ctx, query, id).Scan(&s.FeedID, &s.PublicationUUID, &s.ProcessedItems,
+		&s.ProcessedItemsLastDay, &s.ProcessedItemsLastWeek, &s.ProcessedItemsLastMonth,
+		&s.LastFetched, &s.LastItemPublished, &s.ConsecutiveFailures, &s.LastError, &s.ConsecutiveEmptyRefreshes)
+	if err == pgx.ErrNoRows {
+		span.LogKV("event", "no feed")
+		return nil, nil
+	}
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return nil, err
+	}
+	span.LogKV("processedItems", s.ProcessedItems)
+	return s, nil
+}
+
+// GetFeedsRefreshStatus returns refresh outcome of every feed since the date and the number of items processed since then
+func (repository *Repository) GetFeedsRefreshStatus(ctx context.Context, since time.Time) ([]entity.FeedRefreshStatus, error) {
+	query := `select f.id, f.publication_uuid, f.url,
+		case when not f.enabled or f.quarantine_reason <> '' then $2
+			when f.last_fetched is null or f.last_fetched < $1 then $3
+			when f.consecutive_failures > 0 then $4
+			else $5 end,
+		f.last_fetched, f.last_error,
+		(select count(*) from processed_items p where p.feed_id = f.id and p.created_at >= $1)
+		from feeds f order by f.publication_uuid, f.id`
+	span, ctx := repository.setupTracingSpan(ctx, "get-feeds-refresh-status", query)
+	defer span.Finish()
+	rows, err := repository.db.Query(ctx, query, since, entity.RefreshOutcomeSkipped, entity.RefreshOutcomePending, entity.RefreshOutcomeFailed, entity.RefreshOutcomeSucceeded)
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return nil, err
+	}
+	defer rows.Close()
+	statuses := []entity.FeedRefreshStatus{}
+	for rows.Next() {
+		s := entity.FeedRefreshStatus{}
+		if err := rows.Scan(&s.FeedID, &s.PublicationUUID, &s.URL, &s.Outcome, &s.LastFetched, &s.LastError, &s.NewItems); err != nil {
+			span.LogFields(
+				otLog.Error(err),
+			)
+			return nil, err
+		}
+		statuses = append(statuses, s)
+	}
+	if err := rows.Err(); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return nil, err
+	}
+	span.LogKV("feedsNumber", len(statuses))
+	return statuses, nil
+}
+
+// GetRefreshAllCheckpoint returns progress of unfinished refresh of all feeds, nil if there is none
+func (repository *Repository) GetRefreshAllCheckpoint(ctx context.Context) (*entity.RefreshAllCheckpoint, error) {
+	query := "select last_feed_id, started_at, updated_at from refresh_all_checkpoint where last_feed_id is not null"
+	span, ctx := repository.setupTracingSpan(ctx, "get-refresh-all-checkpoint", query)
+	defer span.Finish()
+	c := &entity.RefreshAllCheckpoint{}
+	err := repository.db.QueryRow(ctx, query).Scan(&c.LastFeedID, &c.StartedAt, &c.UpdatedAt)
+	if err == pgx.ErrNoRows {
+		span.LogKV("event", "no refresh all checkpoint")
+		return nil, nil
+	}
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return nil, err
+	}
+	span.LogKV("lastFeedID", c.LastFeedID.String())
+	return c, nil
+}
+
+// SaveRefreshAllCheckpoint stores progress of refresh of all feeds, setting its updated time
+func (repository *Repository) SaveRefreshAllCheckpoint(ctx context.Context, c *entity.RefreshAllCheckpoint) error {
+	query := "update refresh_all_checkpoint set last_feed_id=$1, started_at=$2, updated_at=NOW() returning updated_at"
+	span, ctx := repository.setupTracingSpan(ctx, "save-refresh-all-checkpoint", query)
+	defer span.Finish()
+	if err := repository.db.QueryRow(ctx, query, c.LastFeedID, c.StartedAt).Scan(&c.UpdatedAt); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return err
+	}
+	span.LogKV("lastFeedID", c.LastFeedID.String())
+	return nil
+}
+
+// ClearRefreshAllCheckpoint removes progress of finished refresh of all feeds
+func (repository *Repository) ClearRefreshAllCheckpoint(ctx context.Context) error {
+	query := "update refresh_all_checkpoint set last_feed_id=null, started_at=null, updated_at=NOW()"
+	span, ctx := repository.setupTracingSpan(ctx, "clear-refresh-all-checkpoint", query)
+	defer span.Finish()
+	if _, err := repository.db.Exec(ctx, query); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return err
+	}
+	span.LogKV("event", "cleared refresh all checkpoint")
+	return nil
+}
+
+// requiredProcessedItemsIndexes are needed by processed items queries, created by migrations
+var requiredProcessedItemsIndexes = map[string]string{
+	"processed_items_pkey":                  "unique index on guid, used by processed item upsert",
+	"processed_items_guid_feed_pubdate_idx": "index on (guid, feeds_publication_uuid, pubDate), used by processed item existence check",
+	"processed_items_dedup_key_idx":         "index on dedup_key, used by global dedup across feeds",
+}
+
+// SaveItemSnapshot saves snapshot of published item
+func (repository *Repository) SaveItemSnapshot(ctx context.Context, i *entity.ItemSnapshot) error {
+	query := "insert into processed_item_snapshots (guid, feeds_publication_uuid, title, url, content_hash, language_code, pubDate, feed_id) values ($1, $2, $3, $4, $5, $6, $7, $8)"
+	span, ctx := repository.setupTracingSpan(ctx, "save-item-snapshot", query)
+	defer span.Finish()
+	_, err := repository.db.Exec(ctx, query, i.GUID, i.PublicationUUID, i.Title, i.URL, i.ContentHash, i.LanguageCode, i.PublicationDate, i.FeedID)
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+	} else {
+		span.LogKV("event", "saved item snapshot")
+	}
+	return err
+}
+
+// GetItemSnapshots returns snapshots of feed items published since the date, newest first
+func (repository *Repository) GetItemSnapshots(ctx context.Context, feedID uuid.UUID, since time.Time) ([]entity.ItemSnapshot, error) {
+	query := "select feed_id, feeds_publication_uuid, guid, title, url, content_hash, language_code, pubDate, created_at from processed_item_snapshots where feed_id=$1 and pubDate >= $2 order by pubDate desc"
+	span, ctx := repository.setupTracingSpan(ctx, "get-item-snapshots", query)
+	defer span.Finish()
+	rows, err := repository.db.Query(ctx, query, feedID, since)
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return nil, err
+	}
+	defer rows.Close()
+
+	snapshots := []entity.ItemSnapshot{}
+	for rows.Next() {
+		i := entity.ItemSnapshot{}
+		if err := rows.Scan(&i.FeedID, &i.PublicationUUID, &i.GUID, &i.Title, &i.URL, &i.ContentHash, &i.LanguageCode, &i.PublicationDate, &i.CreatedAt); err != nil {
+			span.LogFields(
+				otLog.Error(err),
+			)
+			return nil, err
+		}
+		snapshots = append(snapshots, i)
+	}
+	if err := rows.Err(); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return nil, err
+	}
+	span.LogKV("items number", len(snapshots))
+	return snapshots, nil
+}
+
+// PruneItemSnapshots deletes snapshots created before the date, returns the number of deleted snapshots
+func (repository *Repository) PruneItemSnapshots(ctx context.Context, before time.Time) (int64, error) {
+	query := "delete from processed_item_snapshots where created_at < $1"
+	span, ctx := repository.setupTracingSpan(ctx, "prune-item-snapshots", query)
+	defer span.Finish()
+	result, err := repository.db.Exec(ctx, query, before)
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return 0, err
+	}
+	span.LogKV("deleted", result.RowsAffected())
+	return result.RowsAffected(), nil
+}
+
+// requiredTables are created by migrations, service can't work without any of them
+var requiredTables = []string{"feeds", "processed_items", "processed_item_snapshots", "audit_log", "maintenance", "refresh_all_checkpoint"}
+
+// migrateCommand applies migrations, see migrations directory
+const migrateCommand = "tern migrate --migrations migrations/migrations --config migrations/tern.conf"
+
+// SchemaError is returned by schema checks of database, which isn't migrated or is migrated partially
+type SchemaError struct {
+	// Table, which doesn't exist
+	Table string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("table %s does not exist, database is not migrated, apply migrations with '%s'", e.Table, migrateCommand)
+}
+
+// CheckTables verifies that tables created by migrations exist, returns *SchemaError for the first missing one
+func (repository *Repository) CheckTables(ctx context.Context) error {
+	query := "select tablename from pg_tables where schemaname=current_schema()"
+	span, ctx := repository.setupTracingSpan(ctx, "check-tables", query)
+	defer span.Finish()
+	rows, err := repository.db.Query(ctx, query)
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return err
+	}
+	defer rows.Close()
+	tables := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			span.LogFields(
+				otLog.Error(err),
+			)
+			return err
+		}
+		tables[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return err
+	}
+	for _, name := range requiredTables {
+		if !tables[name] {
+			err := &SchemaError{Table: name}
+			span.LogFields(
+				otLog.Error(err),
+			)
+			return err
+		}
+	}
+	span.LogKV("event", "tables exist")
+	return nil
+}
+
+// CheckSchema verifies that tables exist and processed_items table has indexes and constraints required by
+// processed items queries
+func (repository *Repository) CheckSchema(ctx context.Context) error {
+	if err := repository.CheckTables(ctx); err != nil {
+		return err
+	}
+	query := "select indexname, indexdef from pg_indexes where schemaname=current_schema() and tablename='processed_items'"
+	span, ctx := repository.setupTracingSpan(ctx, "check-schema", query)
+	defer span.Finish()
+	rows, err := repository.db.Query(ctx, query)
+	if err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return err
+	}
+	defer rows.Close()
+	indexes := map[string]string{}
+	for rows.Next() {
+		var name, definition string
+		if err := rows.Scan(&name, &definition); err != nil {
+			span.LogFields(
+				otLog.Error(err),
+			)
+			return err
+		}
+		indexes[name] = definition
+	}
+	if err := rows.Err(); err != nil {
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return err
+	}
+	for name, description := range requiredProcessedItemsIndexes {
+		if _, ok := indexes[name]; !ok {
+			err := fmt.Errorf("incompatible schema, processed_items table is missing %s %s, apply migrations", name, description)
+			span.LogFields(
+				otLog.Error(err),
+			)
+			return err
+		}
+	}
+	if !strings.HasPrefix(indexes["processed_items_pkey"], "CREATE UNIQUE INDEX") || !strings.HasSuffix(indexes["processed_items_pkey"], "(guid)") {
+		err := fmt.Errorf("incompatible schema, processed_items_pkey must be unique index on guid, got: %s", indexes["processed_items_pkey"])
+		span.LogFields(
+			otLog.Error(err),
+		)
+		return err
+	}
+	span.LogKV("event", "schema is compatible")
+	return nil
+}
+
+// Close closes all pool connections
+func (repository *Repository) Close() {
+	repository.pool.Close()
+}
+
+// Ping checks database connection with round trip on pooled connection
+func (repository *Repository) Ping(ctx context.Context) error {
+	conn, err := repository.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	return conn.Conn().Ping(ctx)
 }
 
 // Healthcheck is needed for application healtchecks
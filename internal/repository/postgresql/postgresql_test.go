@@ -0,0 +1,279 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Tarick/naca-rss-feeds/internal/entity"
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/opentracing/opentracing-go"
+)
+
+// newTestRepository connects to migrated database from TEST_DATABASE_* environment variables,
+// test is skipped if TEST_DATABASE_HOSTNAME isn't set
+func newTestRepository(tb testing.TB) *Repository {
+	tb.Helper()
+	hostname := os.Getenv("TEST_DATABASE_HOSTNAME")
+	if hostname == "" {
+		tb.Skip("TEST_DATABASE_HOSTNAME isn't set, skipping database test")
+	}
+	config := &Config{
+		Hostname: hostname,
+		Name:     os.Getenv("TEST_DATABASE_NAME"),
+		Username: os.Getenv("TEST_DATABASE_USERNAME"),
+		Password: os.Getenv("TEST_DATABASE_PASSWORD"),
+		SSLMode:  os.Getenv("TEST_DATABASE_SSLMODE"),
+	}
+	if config.SSLMode == "" {
+		config.SSLMode = "disable"
+	}
+	repository, err := New(config, nil, opentracing.NoopTracer{})
+	if err != nil {
+		tb.Fatalf("failure connecting to test database, %v", err)
+	}
+	tb.Cleanup(repository.Close)
+	return repository
+}
+
+func newTestFeed(tb testing.TB, repository *Repository) *entity.Feed {
+	tb.Helper()
+	id, publicationUUID := uuid.Must(uuid.NewV4()), uuid.Must(uuid.NewV4())
+	tb.Cleanup(func() {
+		// Feed may be absent after rollback
+		repository.Delete(context.Background(), id)
+	})
+	return &entity.Feed{
+		ID:              id,
+		PublicationUUID: publicationUUID,
+		URL:             "https://example.org/" + id.String() + ".xml",
+		LanguageCode:    "en",
+	}
+}
+
+func TestWithTx(t *testing.T) {
+	errCallback := errors.New("callback failure")
+	tests := []struct {
+		name      string
+		fn        func(tx *Repository, outer, inner *entity.Feed) error
+		wantErr   error
+		wantPanic bool
+		wantOuter bool
+		wantInner bool
+	}{
+		{
+			name: "commits when callback succeeds",
+			fn: func(tx *Repository, outer, inner *entity.Feed) error {
+				return tx.Create(context.Background(), outer)
+			},
+			wantOuter: true,
+		},
+		{
+			name: "rolls back when callback fails",
+			fn: func(tx *Repository, outer, inner *entity.Feed) error {
+				if err := tx.Create(context.Background(), outer); err != nil {
+					return err
+				}
+				return errCallback
+			},
+			wantErr: errCallback,
+		},
+		{
+			name: "rolls back when statement fails",
+			fn: func(tx *Repository, outer, inner *entity.Feed) error {
+				if err := tx.Create(context.Background(), outer); err != nil {
+					return err
+				}
+				// Second feed of the publication with the same url violates unique constraint
+				inner.PublicationUUID, inner.URL = outer.PublicationUUID, outer.URL
+				return tx.Create(context.Background(), inner)
+			},
+			wantErr: entity.ErrFeedExists,
+		},
+		{
+			name: "rolls back on panic",
+			fn: func(tx *Repository, outer, inner *entity.Feed) error {
+				if err := tx.Create(context.Background(), outer); err != nil {
+					return err
+				}
+				panic(errCallback)
+			},
+			wantPanic: true,
+		},
+		{
+			name: "rolls back nested transaction only",
+			fn: func(tx *Repository, outer, inner *entity.Feed) error {
+				if err := tx.Create(context.Background(), outer); err != nil {
+					return err
+				}
+				err := tx.WithTx(context.Background(), func(nested *Repository) error {
+					if err := nested.Create(context.Background(), inner); err != nil {
+						return err
+					}
+					return errCallback
+				})
+				if !errors.Is(err, errCallback) {
+					return err
+				}
+				return nil
+			},
+			wantOuter: true,
+		},
+	}
+	repository := newTestRepository(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			outer, inner := newTestFeed(t, repository), newTestFeed(t, repository)
+			err := func() (err error) {
+				defer func() {
+					if p := recover(); p != nil {
+						if !tt.wantPanic {
+							panic(p)
+						}
+					} else if tt.wantPanic {
+						t.Error("WithTx() didn't re-panic")
+					}
+				}()
+				return repository.WithTx(ctx, func(tx *Repository) error {
+					return tt.fn(tx, outer, inner)
+				})
+			}()
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("WithTx() error = %v, want %v", err, tt.wantErr)
+			}
+			for _, check := range []struct {
+				feed *entity.Feed
+				want bool
+			}{{outer, tt.wantOuter}, {inner, tt.wantInner}} {
+				f, err := repository.GetByID(ctx, check.feed.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if (f != nil) != check.want {
+					t.Errorf("feed %s saved = %t, want %t", check.feed.ID, f != nil, check.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPublicationFeeds(t *testing.T) {
+	repository := newTestRepository(t)
+	ctx := context.Background()
+	first, second := newTestFeed(t, repository), newTestFeed(t, repository)
+	second.PublicationUUID = first.PublicationUUID
+	for _, feed := range []*entity.Feed{first, second} {
+		if err := repository.Create(ctx, feed); err != nil {
+			t.Fatal(err)
+		}
+	}
+	feeds, err := repository.GetByPublicationUUID(ctx, first.PublicationUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[uuid.UUID]bool{}
+	for _, feed := range feeds {
+		ids[feed.ID] = true
+	}
+	if len(feeds) != 2 || !ids[first.ID] || !ids[second.ID] {
+		t.Fatalf("publication feeds = %v, want %s and %s", feeds, first.ID, second.ID)
+	}
+	// Deleting one feed keeps the other feed of the publication
+	if err := repository.Delete(ctx, first.ID); err != nil {
+		t.Fatal(err)
+	}
+	feeds, err = repository.GetByPublicationUUID(ctx, first.PublicationUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feeds) != 1 || feeds[0].ID != second.ID {
+		t.Errorf("publication feeds after delete = %v, want %s", feeds, second.ID)
+	}
+}
+
+func TestProcessedItemGUIDConflict(t *testing.T) {
+	firstDate := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	changedDate := time.Date(2020, 6, 1, 10, 0, 1, 0, time.UTC)
+	tests := []struct {
+		name     string
+		save     func(repository *Repository, ctx context.Context, i *entity.ProcessedItem) error
+		wantErr  error
+		wantDate time.Time
+	}{
+		{name: "upsert records changed date", save: (*Repository).SaveProcessedItem, wantDate: changedDate},
+		{name: "insert only keeps the first date", save: (*Repository).InsertProcessedItem, wantDate: firstDate},
+		{name: "create reports existing item", save: (*Repository).CreateProcessedItem, wantErr: entity.ErrProcessedItemExists, wantDate: firstDate},
+	}
+	repository := newTestRepository(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			feed := newTestFeed(t, repository)
+			if err := repository.Create(ctx, feed); err != nil {
+				t.Fatal(err)
+			}
+			item := &entity.ProcessedItem{GUID: feed.URL + "#item", PublicationUUID: feed.PublicationUUID, FeedID: feed.ID, PublicationDate: firstDate}
+			if err := tt.save(repository, ctx, item); err != nil {
+				t.Fatalf("first save error = %v", err)
+			}
+			conflicting := *item
+			conflicting.PublicationDate = changedDate
+			if err := tt.save(repository, ctx, &conflicting); !errors.Is(err, tt.wantErr) {
+				t.Errorf("conflicting save error = %v, want %v", err, tt.wantErr)
+			}
+			for date, want := range map[time.Time]bool{firstDate: tt.wantDate.Equal(firstDate), changedDate: tt.wantDate.Equal(changedDate)} {
+				exists, err := repository.ProcessedItemExists(ctx, &entity.ProcessedItem{GUID: item.GUID, PublicationUUID: item.PublicationUUID, PublicationDate: date})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if exists != want {
+					t.Errorf("item recorded with date %v = %t, want %t", date, exists, want)
+				}
+			}
+			// Existence by GUID doesn't depend on recorded date
+			if exists, err := repository.ProcessedItemExistsByGUID(ctx, &conflicting); err != nil || !exists {
+				t.Errorf("ProcessedItemExistsByGUID() = %t, %v, want true", exists, err)
+			}
+		})
+	}
+}
+
+// BenchmarkProcessedItemExists compares named prepared statement with the same inline SQL, parsed by server on each call
+func BenchmarkProcessedItemExists(b *testing.B) {
+	repository := newTestRepository(b)
+	ctx := context.Background()
+	feed := newTestFeed(b, repository)
+	if err := repository.Create(ctx, feed); err != nil {
+		b.Fatal(err)
+	}
+	item := &entity.ProcessedItem{
+		GUID:            feed.URL + "#item",
+		PublicationUUID: feed.PublicationUUID,
+		FeedID:          feed.ID,
+		PublicationDate: time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC),
+	}
+	if err := repository.SaveProcessedItem(ctx, item); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("prepared statement", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := repository.ProcessedItemExists(ctx, item); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("inline SQL", func(b *testing.B) {
+		var exists bool
+		for i := 0; i < b.N; i++ {
+			// Simple protocol bypasses statement cache of pgx, which would prepare inline SQL implicitly
+			if err := repository.pool.QueryRow(ctx, processedItemExistsSQL, pgx.QuerySimpleProtocol(true), item.GUID, item.PublicationUUID, item.PublicationDate).Scan(&exists); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
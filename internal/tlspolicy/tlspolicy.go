@@ -0,0 +1,108 @@
+package tlspolicy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/Tarick/naca-rss-feeds/internal/hostpolicy"
+)
+
+// versions maps configured TLS versions
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// defaultMinVersion is used if min version is not configured
+const defaultMinVersion = tls.VersionTLS12
+
+// Config defines TLS settings of feed fetches, defaults are secure
+type Config struct {
+	// MinVersion is the minimum TLS version: "1.0", "1.1", "1.2" (default) or "1.3"
+	MinVersion string `mapstructure:"min_version"`
+	// CAFile is PEM bundle of CAs trusted in addition to system ones, e.g. internal CA of private feeds
+	CAFile string `mapstructure:"ca_file"`
+	// InsecureSkipVerifyHosts are hosts, including their subdomains, whose certificates are not verified.
+	// Use it deliberately for self-signed certificates only, traffic of these hosts can be intercepted
+	InsecureSkipVerifyHosts []string `mapstructure:"insecure_skip_verify_hosts"`
+}
+
+// Validate checks TLS version and CA bundle
+func (c *Config) Validate() error {
+	if _, ok := versions[c.MinVersion]; c.MinVersion != "" && !ok {
+		return fmt.Errorf("unsupported min_version '%s', must be one of 1.0, 1.1, 1.2, 1.3", c.MinVersion)
+	}
+	if c.CAFile != "" {
+		if _, err := loadCAFile(c.CAFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsWeakened returns true if configuration is less secure than defaults, so it should be logged loudly
+func (c *Config) IsWeakened() bool {
+	minVersion, ok := versions[c.MinVersion]
+	return (ok && minVersion < defaultMinVersion) || len(c.InsecureSkipVerifyHosts) > 0
+}
+
+// New returns TLS client configuration. Invalid min version and CA bundle are ignored, check them with Config.Validate.
+// Certificates are verified for all hosts except insecure ones, verification is done in VerifyConnection,
+// since InsecureSkipVerify of crypto/tls can't be scoped by host.
+func New(config *Config) *tls.Config {
+	tlsConfig := &tls.Config{MinVersion: defaultMinVersion}
+	if minVersion, ok := versions[config.MinVersion]; ok {
+		tlsConfig.MinVersion = minVersion
+	}
+	if config.CAFile != "" {
+		if roots, err := loadCAFile(config.CAFile); err == nil {
+			tlsConfig.RootCAs = roots
+		}
+	}
+	insecureHosts := hostpolicy.NewHosts(config.InsecureSkipVerifyHosts)
+	if len(insecureHosts) == 0 {
+		return tlsConfig
+	}
+	roots := tlsConfig.RootCAs
+	tlsConfig.InsecureSkipVerify = true
+	tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+		if insecureHosts.Match(state.ServerName) {
+			return nil
+		}
+		if len(state.PeerCertificates) == 0 {
+			return errors.New("tls: server didn't present certificate")
+		}
+		opts := x509.VerifyOptions{
+			DNSName:       state.ServerName,
+			Roots:         roots,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, cert := range state.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		_, err := state.PeerCertificates[0].Verify(opts)
+		return err
+	}
+	return tlsConfig
+}
+
+// loadCAFile returns system CAs with CAs from PEM bundle added
+func loadCAFile(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading ca_file, %v", err)
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil || roots == nil {
+		roots = x509.NewCertPool()
+	}
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("ca_file %s has no PEM certificates", path)
+	}
+	return roots, nil
+}
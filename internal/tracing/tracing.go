@@ -1,7 +1,10 @@
 package tracing
 
 import (
+	"context"
 	"io"
+	"net"
+	"net/http"
 
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/uber/jaeger-client-go"
@@ -23,8 +26,13 @@ type Config struct {
 	Disabled          bool    `mapstructure:"disabled"`
 }
 
-// New returns an instance of opentracing Tracer based on Jaeger instance
+// New returns an instance of opentracing Tracer based on Jaeger instance.
+// If tracing is disabled or Jaeger tracer fails to initialize, no-op tracer is returned, so tracing never blocks the service.
+// Returned closer is never nil.
 func New(config Config, logger jaeger.Logger) (opentracing.Tracer, io.Closer, error) {
+	if config.Disabled {
+		return opentracing.NoopTracer{}, nopCloser{}, nil
+	}
 	cfg := &jaegerConfig.Configuration{
 		ServiceName: config.ServiceName,
 		Sampler: &jaegerConfig.SamplerConfig{
@@ -44,6 +52,42 @@ func New(config Config, logger jaeger.Logger) (opentracing.Tracer, io.Closer, er
 		jaegerConfig.Extractor(opentracing.HTTPHeaders, zipkinPropagator),
 		jaegerConfig.Injector(opentracing.HTTPHeaders, zipkinPropagator),
 		jaegerConfig.ZipkinSharedRPCSpan(true))
+	if err != nil {
+		logger.Error("Failure initializing tracer, falling back to no-op tracer: " + err.Error())
+		return opentracing.NoopTracer{}, nopCloser{}, nil
+	}
+	return tracer, closer, nil
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
 
-	return tracer, closer, err
+// defaultAgentAddress is used by Jaeger client if agent address is not configured
+const defaultAgentAddress = "localhost:6831"
+
+// CheckReachability checks tracing backend of the configuration. HTTP collector endpoint is requested, any response means it's reachable.
+// Agent receives spans over UDP, which isn't acknowledged, so only its host resolution is checked.
+func CheckReachability(ctx context.Context, config Config) error {
+	if config.CollectorEndpoint != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, config.CollectorEndpoint, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+	address := config.AgentAddress
+	if address == "" {
+		address = defaultAgentAddress
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	_, err = net.DefaultResolver.LookupHost(ctx, host)
+	return err
 }
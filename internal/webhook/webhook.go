@@ -0,0 +1,119 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Tarick/naca-rss-feeds/internal/hostpolicy"
+)
+
+// SignatureHeader holds HMAC SHA256 hex signature of the request body, signed with shared secret
+const SignatureHeader = "X-Naca-Signature"
+
+// Config defines webhook notifications configuration
+type Config struct {
+	// Secret is used to sign payloads, signing is skipped if empty
+	Secret string `mapstructure:"secret"`
+	// Timeout of single webhook call, in seconds
+	Timeout int `mapstructure:"timeout"`
+	// Attempts to deliver notification
+	Attempts int `mapstructure:"attempts"`
+	// RetryDelay between attempts, in seconds
+	RetryDelay int `mapstructure:"retry_delay"`
+}
+
+// placeholderSecret is the secret of sample configuration, it must be replaced
+const placeholderSecret = "changeme"
+
+// Validate rejects sample secret and negative timings
+func (c *Config) Validate() error {
+	if c.Secret == placeholderSecret {
+		return errors.New("secret is the sample 'changeme' value, set a real secret")
+	}
+	if c.Timeout < 0 || c.Attempts < 0 || c.RetryDelay < 0 {
+		return errors.New("timeout, attempts and retry_delay must not be negative")
+	}
+	return nil
+}
+
+type notifier struct {
+	httpClient *http.Client
+	secret     []byte
+	attempts   int
+	retryDelay time.Duration
+}
+
+// New creates webhook notifier, which POSTs JSON payloads to webhook urls.
+// Webhook urls are set with feeds by API clients, so hostPolicy is enforced on every dialed address, nil means no restrictions
+func New(config *Config, hostPolicy *hostpolicy.Policy) *notifier {
+	attempts := config.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	httpClient := &http.Client{
+		Timeout: time.Duration(config.Timeout) * time.Second,
+	}
+	if hostPolicy != nil {
+		httpClient.Transport = hostPolicy.Transport()
+	}
+	return &notifier{
+		httpClient: httpClient,
+		secret:     []byte(config.Secret),
+		attempts:   attempts,
+		retryDelay: time.Duration(config.RetryDelay) * time.Second,
+	}
+}
+
+// Notify sends payload to url, retrying on failures
+func (n *notifier) Notify(ctx context.Context, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		err = n.send(ctx, url, body)
+		if err == nil || attempt >= n.attempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(n.retryDelay):
+		}
+	}
+}
+
+func (n *notifier) send(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if len(n.secret) > 0 {
+		req.Header.Set(SignatureHeader, "sha256="+n.sign(body))
+	}
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s returned status %s", url, resp.Status)
+	}
+	return nil
+}
+
+func (n *notifier) sign(body []byte) string {
+	mac := hmac.New(sha256.New, n.secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
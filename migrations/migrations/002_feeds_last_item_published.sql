@@ -0,0 +1,10 @@
+-- Write your migrate up statements here
+
+ALTER TABLE feeds ADD COLUMN last_item_published timestamptz;
+
+---- create above / drop below ----
+
+ALTER TABLE feeds DROP COLUMN last_item_published;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
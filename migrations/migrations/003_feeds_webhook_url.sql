@@ -0,0 +1,10 @@
+-- Write your migrate up statements here
+
+ALTER TABLE feeds ADD COLUMN webhook_url text NOT NULL DEFAULT '';
+
+---- create above / drop below ----
+
+ALTER TABLE feeds DROP COLUMN webhook_url;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
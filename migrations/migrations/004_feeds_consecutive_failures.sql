@@ -0,0 +1,10 @@
+-- Write your migrate up statements here
+
+ALTER TABLE feeds ADD COLUMN consecutive_failures integer NOT NULL DEFAULT 0;
+
+---- create above / drop below ----
+
+ALTER TABLE feeds DROP COLUMN consecutive_failures;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
@@ -0,0 +1,10 @@
+-- Write your migrate up statements here
+
+ALTER TABLE feeds ADD COLUMN max_items_per_refresh integer;
+
+---- create above / drop below ----
+
+ALTER TABLE feeds DROP COLUMN max_items_per_refresh;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
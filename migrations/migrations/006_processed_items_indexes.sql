@@ -0,0 +1,12 @@
+-- Write your migrate up statements here
+
+-- guid is the primary key (unique index processed_items_pkey), used by processed item upsert.
+-- Composite index supports processed item existence check by guid, feed and publication date.
+CREATE INDEX IF NOT EXISTS processed_items_guid_feed_pubdate_idx ON processed_items (guid, feeds_publication_uuid, pubDate);
+
+---- create above / drop below ----
+
+DROP INDEX IF EXISTS processed_items_guid_feed_pubdate_idx;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
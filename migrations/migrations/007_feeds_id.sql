@@ -0,0 +1,40 @@
+-- Write your migrate up statements here
+
+-- Feeds get their own id, so publication may have several feeds (e.g. news, comments and category feeds).
+-- Existing feeds take publication uuid as id, it is unique until publication gets another feed. New ids are
+-- generated by the API, so no extension (pgcrypto) is needed. Processed items keep feeds_publication_uuid,
+-- since deduplication is scoped by publication, and reference the feed by feed_id for cascade delete.
+-- See docs/multiple-feeds-per-publication.md
+ALTER TABLE feeds ADD COLUMN id uuid;
+UPDATE feeds SET id = publication_uuid;
+ALTER TABLE feeds ALTER COLUMN id SET NOT NULL;
+
+ALTER TABLE processed_items ADD COLUMN feed_id uuid;
+UPDATE processed_items SET feed_id = feeds_publication_uuid;
+ALTER TABLE processed_items ALTER COLUMN feed_id SET NOT NULL;
+ALTER TABLE processed_items DROP CONSTRAINT processed_items_feeds_publication_uuid_fkey;
+
+ALTER TABLE feeds DROP CONSTRAINT feeds_pkey;
+ALTER TABLE feeds ADD PRIMARY KEY (id);
+ALTER TABLE feeds ADD CONSTRAINT feeds_publication_uuid_url_key UNIQUE (publication_uuid, url);
+
+ALTER TABLE processed_items ADD CONSTRAINT processed_items_feed_id_fkey FOREIGN KEY (feed_id) REFERENCES feeds(id) ON DELETE CASCADE;
+CREATE INDEX processed_items_feed_id_idx ON processed_items (feed_id);
+
+---- create above / drop below ----
+
+-- Rollback fails if any publication has several feeds, remove extra feeds first
+DROP INDEX IF EXISTS processed_items_feed_id_idx;
+ALTER TABLE processed_items DROP CONSTRAINT processed_items_feed_id_fkey;
+
+ALTER TABLE feeds DROP CONSTRAINT feeds_publication_uuid_url_key;
+ALTER TABLE feeds DROP CONSTRAINT feeds_pkey;
+ALTER TABLE feeds ADD PRIMARY KEY (publication_uuid);
+
+ALTER TABLE processed_items ADD CONSTRAINT processed_items_feeds_publication_uuid_fkey FOREIGN KEY (feeds_publication_uuid) REFERENCES feeds(publication_uuid) ON DELETE CASCADE;
+ALTER TABLE processed_items DROP COLUMN feed_id;
+
+ALTER TABLE feeds DROP COLUMN id;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
@@ -0,0 +1,12 @@
+-- Write your migrate up statements here
+
+ALTER TABLE feeds ADD COLUMN last_error text NOT NULL DEFAULT '';
+ALTER TABLE feeds ADD COLUMN last_fetched timestamptz;
+
+---- create above / drop below ----
+
+ALTER TABLE feeds DROP COLUMN last_fetched;
+ALTER TABLE feeds DROP COLUMN last_error;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
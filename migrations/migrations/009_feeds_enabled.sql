@@ -0,0 +1,12 @@
+-- Write your migrate up statements here
+
+ALTER TABLE feeds ADD COLUMN enabled boolean NOT NULL DEFAULT true;
+ALTER TABLE feeds ADD COLUMN disabled_reason text NOT NULL DEFAULT '';
+
+---- create above / drop below ----
+
+ALTER TABLE feeds DROP COLUMN disabled_reason;
+ALTER TABLE feeds DROP COLUMN enabled;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
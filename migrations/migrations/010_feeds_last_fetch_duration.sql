@@ -0,0 +1,12 @@
+-- Write your migrate up statements here
+
+ALTER TABLE feeds ADD COLUMN last_fetch_duration_ms integer;
+CREATE INDEX feeds_last_fetch_duration_ms_idx ON feeds (last_fetch_duration_ms);
+
+---- create above / drop below ----
+
+DROP INDEX feeds_last_fetch_duration_ms_idx;
+ALTER TABLE feeds DROP COLUMN last_fetch_duration_ms;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
@@ -0,0 +1,23 @@
+-- Write your migrate up statements here
+
+CREATE TABLE processed_item_snapshots (
+  id bigserial PRIMARY KEY,
+  guid text NOT NULL,
+  feed_id uuid NOT NULL REFERENCES feeds(id) ON DELETE CASCADE,
+  feeds_publication_uuid uuid NOT NULL,
+  title text NOT NULL,
+  url text NOT NULL,
+  content_hash text NOT NULL,
+  language_code varchar(2) NOT NULL,
+  pubDate timestamptz NOT NULL,
+  created_at timestamptz NOT NULL DEFAULT NOW()
+);
+CREATE INDEX processed_item_snapshots_feed_id_pubdate_idx ON processed_item_snapshots (feed_id, pubDate);
+CREATE INDEX processed_item_snapshots_created_at_idx ON processed_item_snapshots (created_at);
+
+---- create above / drop below ----
+
+DROP TABLE processed_item_snapshots;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
@@ -0,0 +1,10 @@
+-- Write your migrate up statements here
+
+ALTER TABLE feeds ADD COLUMN filter jsonb;
+
+---- create above / drop below ----
+
+ALTER TABLE feeds DROP COLUMN filter;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
@@ -0,0 +1,14 @@
+-- Write your migrate up statements here
+
+-- dedup_key is normalized item url or content hash, set when worker global dedup is enabled.
+-- Partial index keeps it small for deployments without global dedup, and lookup by key is not scoped by feed.
+ALTER TABLE processed_items ADD COLUMN dedup_key text;
+CREATE INDEX processed_items_dedup_key_idx ON processed_items (dedup_key) WHERE dedup_key IS NOT NULL;
+
+---- create above / drop below ----
+
+DROP INDEX IF EXISTS processed_items_dedup_key_idx;
+ALTER TABLE processed_items DROP COLUMN dedup_key;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
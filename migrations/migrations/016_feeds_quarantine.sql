@@ -0,0 +1,18 @@
+-- Write your migrate up statements here
+
+-- Feed is quarantined when quarantine_reason is not empty.
+-- consecutive_empty_refreshes and last_content_type are tracked for quarantine heuristics.
+ALTER TABLE feeds ADD COLUMN quarantine_reason text NOT NULL DEFAULT '';
+ALTER TABLE feeds ADD COLUMN quarantined_at timestamptz;
+ALTER TABLE feeds ADD COLUMN consecutive_empty_refreshes integer NOT NULL DEFAULT 0;
+ALTER TABLE feeds ADD COLUMN last_content_type text NOT NULL DEFAULT '';
+
+---- create above / drop below ----
+
+ALTER TABLE feeds DROP COLUMN last_content_type;
+ALTER TABLE feeds DROP COLUMN consecutive_empty_refreshes;
+ALTER TABLE feeds DROP COLUMN quarantined_at;
+ALTER TABLE feeds DROP COLUMN quarantine_reason;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
@@ -0,0 +1,23 @@
+-- Write your migrate up statements here
+
+-- Audit trail of feed changes. feed_id is not a foreign key, records outlive deleted feeds.
+-- before is null for created feed, after is null for deleted feed.
+CREATE TABLE audit_log (
+    id bigserial PRIMARY KEY,
+    action text NOT NULL,
+    publication_uuid uuid NOT NULL,
+    feed_id uuid NOT NULL,
+    before jsonb,
+    after jsonb,
+    actor text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT NOW()
+);
+CREATE INDEX audit_log_publication_uuid_idx ON audit_log (publication_uuid, created_at);
+CREATE INDEX audit_log_feed_id_idx ON audit_log (feed_id, created_at);
+
+---- create above / drop below ----
+
+DROP TABLE audit_log;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
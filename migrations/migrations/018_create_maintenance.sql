@@ -0,0 +1,17 @@
+-- Write your migrate up statements here
+
+-- Single row maintenance mode flag, shared by all API and worker instances
+CREATE TABLE maintenance (
+    id boolean PRIMARY KEY DEFAULT true CHECK (id),
+    enabled boolean NOT NULL DEFAULT false,
+    reason text NOT NULL DEFAULT '',
+    updated_at timestamptz NOT NULL DEFAULT NOW()
+);
+INSERT INTO maintenance DEFAULT VALUES;
+
+---- create above / drop below ----
+
+DROP TABLE maintenance;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
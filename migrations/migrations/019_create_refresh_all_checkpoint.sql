@@ -0,0 +1,18 @@
+-- Write your migrate up statements here
+
+-- Single row progress of the refresh of all feeds, so interrupted refresh resumes instead of starting over.
+-- last_feed_id is null when there is no unfinished refresh
+CREATE TABLE refresh_all_checkpoint (
+    id boolean PRIMARY KEY DEFAULT true CHECK (id),
+    last_feed_id uuid,
+    started_at timestamptz,
+    updated_at timestamptz NOT NULL DEFAULT NOW()
+);
+INSERT INTO refresh_all_checkpoint DEFAULT VALUES;
+
+---- create above / drop below ----
+
+DROP TABLE refresh_all_checkpoint;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
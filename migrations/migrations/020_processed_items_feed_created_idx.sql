@@ -0,0 +1,14 @@
+-- Write your migrate up statements here
+
+-- Used by feed statistics, which count processed items of the feed by processing time.
+-- It covers lookups by feed_id, so the index on feed_id alone is dropped
+CREATE INDEX IF NOT EXISTS processed_items_feed_id_created_at_idx ON processed_items (feed_id, created_at);
+DROP INDEX IF EXISTS processed_items_feed_id_idx;
+
+---- create above / drop below ----
+
+CREATE INDEX IF NOT EXISTS processed_items_feed_id_idx ON processed_items (feed_id);
+DROP INDEX IF EXISTS processed_items_feed_id_created_at_idx;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
@@ -0,0 +1,11 @@
+-- Write your migrate up statements here
+
+-- Per feed override of worker fetch timeout, in seconds. NULL uses worker setting
+ALTER TABLE feeds ADD COLUMN fetch_timeout integer;
+
+---- create above / drop below ----
+
+ALTER TABLE feeds DROP COLUMN fetch_timeout;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
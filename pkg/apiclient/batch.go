@@ -0,0 +1,95 @@
+package apiclient
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gofrs/uuid"
+)
+
+// FeedError is failure of batch operation on a single feed
+type FeedError struct {
+	FeedID uuid.UUID
+	Err    error
+}
+
+func (e *FeedError) Error() string {
+	return fmt.Sprintf("feed %s: %v", e.FeedID, e.Err)
+}
+
+func (e *FeedError) Unwrap() error {
+	return e.Err
+}
+
+// BatchError is returned by batch operations, when operation failed for some of the feeds.
+// Errors are in the order of requested feed IDs
+type BatchError struct {
+	Errors []*FeedError
+}
+
+func (e *BatchError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d of batch operations failed: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// RefreshRSSFeeds triggers refresh of the feeds with bounded concurrency, force ignores feeds caching headers.
+// Failed feeds are reported as *BatchError
+func (c *client) RefreshRSSFeeds(ctx context.Context, feedIDs []uuid.UUID, force bool) error {
+	return c.forEach(ctx, feedIDs, func(ctx context.Context, feedID uuid.UUID) error {
+		return c.RefreshRSSFeed(ctx, feedID, force)
+	})
+}
+
+// DeleteRSSFeeds deletes the feeds with bounded concurrency. Failed feeds are reported as *BatchError
+func (c *client) DeleteRSSFeeds(ctx context.Context, feedIDs []uuid.UUID) error {
+	return c.forEach(ctx, feedIDs, c.DeleteRSSFeed)
+}
+
+// forEach runs fn for every feed ID by pool of client concurrency workers and collects failures into *BatchError.
+// Feeds not started before context is done fail with context error
+func (c *client) forEach(ctx context.Context, feedIDs []uuid.UUID, fn func(context.Context, uuid.UUID) error) error {
+	errs := make([]error, len(feedIDs))
+	workers := c.concurrency
+	if workers > len(feedIDs) {
+		workers = len(feedIDs)
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				errs[i] = fn(ctx, feedIDs[i])
+			}
+		}()
+	}
+	for i := range feedIDs {
+		if ctx.Err() != nil {
+			errs[i] = ctx.Err()
+			continue
+		}
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	batchErr := &BatchError{}
+	for i, err := range errs {
+		if err != nil {
+			batchErr.Errors = append(batchErr.Errors, &FeedError{FeedID: feedIDs[i], Err: err})
+		}
+	}
+	if len(batchErr.Errors) > 0 {
+		return batchErr
+	}
+	return nil
+}
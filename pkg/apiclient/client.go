@@ -6,8 +6,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Tarick/naca-rss-feeds/internal/application/server"
@@ -16,37 +20,163 @@ import (
 	"github.com/gofrs/uuid"
 )
 
-const feedsCRUDPath string = "/feeds"
+const (
+	feedsCRUDPath    string = "/feeds"
+	refreshFeedsPath string = "/refreshFeeds"
+	publicationsPath string = "/publications"
+)
+
+// Client is RSS Feeds API client
+type Client interface {
+	GetRSSFeedByID(ctx context.Context, feedID uuid.UUID) (entity.Feed, error)
+	GetRSSFeedsByPublicationUUID(ctx context.Context, publicationUUID uuid.UUID) ([]entity.Feed, error)
+	GetAllRSSFeeds(ctx context.Context) ([]entity.Feed, error)
+	GetRSSFeedsByPublicationUUIDs(ctx context.Context, publicationUUIDs []uuid.UUID) ([]entity.Feed, []uuid.UUID, error)
+	CreateRSSFeed(ctx context.Context, publicationUUID uuid.UUID, URL string, LanguageCode string) (entity.Feed, error)
+	EnsureRSSFeed(ctx context.Context, publicationUUID uuid.UUID, URL string, LanguageCode string) (entity.Feed, error)
+	UpdateRSSFeed(ctx context.Context, feedID uuid.UUID, publicationUUID uuid.UUID, URL string, LanguageCode string) error
+	DeleteRSSFeed(ctx context.Context, feedID uuid.UUID) error
+	RefreshRSSFeed(ctx context.Context, feedID uuid.UUID, force bool) error
+	RefreshRSSFeeds(ctx context.Context, feedIDs []uuid.UUID, force bool) error
+	DeleteRSSFeeds(ctx context.Context, feedIDs []uuid.UUID) error
+	Close() error
+}
+
+// defaultPerRequestTimeout bounds every request, unless changed with WithPerRequestTimeout
+const defaultPerRequestTimeout = time.Minute
+
+// defaultConcurrency of batch operations, unless changed with WithConcurrency
+const defaultConcurrency = 4
+
+// Option configures client
+type Option func(*client)
+
+// WithPerRequestTimeout bounds every request with timeout, in addition to deadline of the passed context.
+// Expired timeout is reported as context.DeadlineExceeded. 0 disables it, so only the passed context bounds requests.
+func WithPerRequestTimeout(timeout time.Duration) Option {
+	return func(c *client) {
+		c.perRequestTimeout = timeout
+	}
+}
+
+// WithConcurrency bounds the number of simultaneous requests of batch operations, e.g. RefreshRSSFeeds.
+// Values below 1 make batch operations sequential.
+func WithConcurrency(n int) Option {
+	return func(c *client) {
+		if n < 1 {
+			n = 1
+		}
+		c.concurrency = n
+	}
+}
 
-// TODO: WithTimeout?
 // New creates RSS Feeds API http client
-func New(baseURL string) (*client, error) {
+func New(baseURL string, opts ...Option) (Client, error) {
 	url, err := url.Parse(baseURL)
 	if err != nil {
 		return nil, err
 	}
-	return &client{
-		baseURL: url,
-		httpClient: &http.Client{
-			Timeout: time.Minute,
-		}}, nil
+	c := &client{
+		baseURL:           url,
+		httpClient:        &http.Client{},
+		perRequestTimeout: defaultPerRequestTimeout,
+		concurrency:       defaultConcurrency,
+		closed:            make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
 }
 
 // TODO: add logger
 type client struct {
-	baseURL    *url.URL
-	httpClient *http.Client
+	baseURL           *url.URL
+	httpClient        *http.Client
+	perRequestTimeout time.Duration
+	// concurrency of batch operations requests
+	concurrency int
+	// closed is closed by Close to cancel outstanding requests
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// ErrClosed is returned by requests of closed client
+var ErrClosed = errors.New("apiclient: client is closed")
+
+// ErrFeedExists is returned by CreateRSSFeed, when publication already has feed with the url
+var ErrFeedExists = errors.New("feed already exists")
+
+// Close cancels outstanding requests and closes idle connections, client can't be used after it
+func (c *client) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	c.httpClient.CloseIdleConnections()
+	return nil
+}
+
+// do sends request bound by context, per request timeout and client Close. Context errors are returned unwrapped,
+// so callers can detect them with errors.Is. Closing response body releases the request context.
+func (c *client) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	select {
+	case <-c.closed:
+		return nil, ErrClosed
+	default:
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	if c.perRequestTimeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, c.perRequestTimeout)
+		cancelRequest := cancel
+		cancel = func() {
+			cancelTimeout()
+			cancelRequest()
+		}
+	}
+	go func() {
+		select {
+		case <-c.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	res, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		select {
+		case <-c.closed:
+			return nil, ErrClosed
+		default:
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+	res.Body = &cancelOnCloseBody{res.Body, cancel}
+	return res, nil
+}
+
+// cancelOnCloseBody cancels request context when response body is closed
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnCloseBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
 }
 
-func (c *client) GetRSSFeedByPublicationUUID(ctx context.Context, publicationUUID uuid.UUID) (entity.Feed, error) {
-	rel := &url.URL{Path: fmt.Sprintf("%s/%s", feedsCRUDPath, publicationUUID)}
+func (c *client) GetRSSFeedByID(ctx context.Context, feedID uuid.UUID) (entity.Feed, error) {
+	rel := &url.URL{Path: fmt.Sprintf("%s/%s", feedsCRUDPath, feedID)}
 	u := c.baseURL.ResolveReference(rel)
 	req, err := http.NewRequest("GET", u.String(), nil)
 	if err != nil {
 		return entity.Feed{}, err
 	}
-	req = req.WithContext(ctx)
-	res, err := c.httpClient.Do(req)
+	res, err := c.do(ctx, req)
 	if err != nil {
 		return entity.Feed{}, err
 	}
@@ -73,15 +203,15 @@ func (c *client) GetRSSFeedByPublicationUUID(ctx context.Context, publicationUUI
 	return feed, nil
 }
 
-func (c *client) GetAllRSSFeeds(ctx context.Context) ([]entity.Feed, error) {
-	rel := &url.URL{Path: feedsCRUDPath}
+// GetRSSFeedsByPublicationUUID returns feeds of the publication, empty if publication has no feeds
+func (c *client) GetRSSFeedsByPublicationUUID(ctx context.Context, publicationUUID uuid.UUID) ([]entity.Feed, error) {
+	rel := &url.URL{Path: fmt.Sprintf("%s/%s/feeds", publicationsPath, publicationUUID)}
 	u := c.baseURL.ResolveReference(rel)
 	req, err := http.NewRequest("GET", u.String(), nil)
 	if err != nil {
 		return nil, err
 	}
-	req = req.WithContext(ctx)
-	res, err := c.httpClient.Do(req)
+	res, err := c.do(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -91,18 +221,119 @@ func (c *client) GetAllRSSFeeds(ctx context.Context) ([]entity.Feed, error) {
 		if err = json.NewDecoder(res.Body).Decode(&errRes); err == nil {
 			return nil, errors.New(errRes.ErrorText)
 		}
-
-		return nil, fmt.Errorf("unknown error, status code: %d", res.StatusCode)
+		return nil, fmt.Errorf("unknown error, status code: %d, message: %v", res.StatusCode, res.Status)
 	}
 	feeds := []entity.Feed{}
 	if err = json.NewDecoder(res.Body).Decode(&feeds); err != nil {
-		return []entity.Feed{}, err
+		return nil, err
 	}
 	return feeds, nil
 }
 
-func (c *client) UpdateRSSFeed(ctx context.Context, publicationUUID uuid.UUID, URL string, LanguageCode string) error {
-	feed := &entity.Feed{
+// feedsPageSize is the number of feeds requested per page
+const feedsPageSize = 500
+
+// GetAllRSSFeeds returns all feeds, requesting them page by page by Link header rel="next"
+func (c *client) GetAllRSSFeeds(ctx context.Context) ([]entity.Feed, error) {
+	rel := &url.URL{Path: feedsCRUDPath, RawQuery: url.Values{"limit": {strconv.Itoa(feedsPageSize)}}.Encode()}
+	u := c.baseURL.ResolveReference(rel)
+	feeds := []entity.Feed{}
+	for u != nil {
+		page, next, err := c.getRSSFeedsPage(ctx, u)
+		if err != nil {
+			return []entity.Feed{}, err
+		}
+		feeds = append(feeds, page...)
+		u = next
+	}
+	return feeds, nil
+}
+
+// getRSSFeedsPage returns feeds page and url of the next page, nil if it's the last one
+func (c *client) getRSSFeedsPage(ctx context.Context, u *url.URL) ([]entity.Feed, *url.URL, error) {
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := c.do(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusBadRequest {
+		var errRes server.ErrResponseBody
+		if err = json.NewDecoder(res.Body).Decode(&errRes); err == nil {
+			return nil, nil, errors.New(errRes.ErrorText)
+		}
+
+		return nil, nil, fmt.Errorf("unknown error, status code: %d", res.StatusCode)
+	}
+	feeds := []entity.Feed{}
+	if err = json.NewDecoder(res.Body).Decode(&feeds); err != nil {
+		return nil, nil, err
+	}
+	nextLink := linkRel(res.Header.Get("Link"), "next")
+	if nextLink == "" {
+		return feeds, nil, nil
+	}
+	next, err := url.Parse(nextLink)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid next page link %s, %v", nextLink, err)
+	}
+	return feeds, u.ResolveReference(next), nil
+}
+
+// linkRel returns target of the link with relation from RFC 8288 Link header, empty if there is no such link
+func linkRel(header string, rel string) string {
+	for _, link := range strings.Split(header, ",") {
+		parts := strings.Split(link, ";")
+		for _, param := range parts[1:] {
+			if strings.TrimSpace(param) == `rel="`+rel+`"` {
+				return strings.Trim(strings.TrimSpace(parts[0]), "<>")
+			}
+		}
+	}
+	return ""
+}
+
+// GetRSSFeedsByPublicationUUIDs returns feeds of the publications and the list of publication UUIDs without feeds
+func (c *client) GetRSSFeedsByPublicationUUIDs(ctx context.Context, publicationUUIDs []uuid.UUID) ([]entity.Feed, []uuid.UUID, error) {
+	body, err := json.Marshal(&server.FeedsBatchGetRequestBody{PublicationUUIDs: publicationUUIDs})
+	if err != nil {
+		return nil, nil, err
+	}
+	rel := &url.URL{Path: fmt.Sprintf("%s/batch-get", feedsCRUDPath)}
+	u := c.baseURL.ResolveReference(rel)
+	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := c.do(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusBadRequest {
+		var errRes server.ErrResponseBody
+		if err = json.NewDecoder(res.Body).Decode(&errRes); err == nil {
+			return nil, nil, errors.New(errRes.ErrorText)
+		}
+		return nil, nil, fmt.Errorf("unknown error, status code: %d, message: %v", res.StatusCode, res.Status)
+	}
+	result := struct {
+		Feeds   []entity.Feed `json:"feeds"`
+		Missing []uuid.UUID   `json:"missing"`
+	}{}
+	if err = json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, nil, err
+	}
+	return result.Feeds, result.Missing, nil
+}
+
+// UpdateRSSFeed changes feed url and language. publicationUUID must be the publication of the feed, feeds can't move to another publication
+func (c *client) UpdateRSSFeed(ctx context.Context, feedID uuid.UUID, publicationUUID uuid.UUID, URL string, LanguageCode string) error {
+	feed := &updateFeedRequest{
 		PublicationUUID: publicationUUID,
 		URL:             URL,
 		LanguageCode:    LanguageCode,
@@ -111,15 +342,14 @@ func (c *client) UpdateRSSFeed(ctx context.Context, publicationUUID uuid.UUID, U
 	if err != nil {
 		return err
 	}
-	rel := &url.URL{Path: fmt.Sprintf("%s/%s", feedsCRUDPath, publicationUUID)}
+	rel := &url.URL{Path: fmt.Sprintf("%s/%s", feedsCRUDPath, feedID)}
 	u := c.baseURL.ResolveReference(rel)
 	req, err := http.NewRequest("PUT", u.String(), bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
-	req = req.WithContext(ctx)
 	req.Header.Set("Content-Type", "application/json")
-	res, err := c.httpClient.Do(req)
+	res, err := c.do(ctx, req)
 	if err != nil {
 		return err
 	}
@@ -134,7 +364,41 @@ func (c *client) UpdateRSSFeed(ctx context.Context, publicationUUID uuid.UUID, U
 	return nil
 }
 
-func (c *client) CreateRSSFeed(ctx context.Context, publicationUUID uuid.UUID, URL string, LanguageCode string) error {
+// updateFeedRequest is feed update request body. Only changed fields are sent, since omitted ones are kept by server,
+// while entity.Feed would reset them, e.g. disable feed with zero Enabled
+type updateFeedRequest struct {
+	PublicationUUID uuid.UUID `json:"publication_uuid"`
+	URL             string    `json:"url"`
+	LanguageCode    string    `json:"language_code,omitempty"`
+}
+
+// CreateRSSFeed creates feed and returns it with generated id, ErrFeedExists if publication already has feed with the url
+func (c *client) CreateRSSFeed(ctx context.Context, publicationUUID uuid.UUID, URL string, LanguageCode string) (entity.Feed, error) {
+	return c.createRSSFeed(ctx, publicationUUID, URL, LanguageCode)
+}
+
+// EnsureRSSFeed creates feed and returns it. If publication already has feed with the url, existing feed is returned
+// unchanged instead, so it's safe to retry or race. Returned feed language may differ from requested then.
+func (c *client) EnsureRSSFeed(ctx context.Context, publicationUUID uuid.UUID, URL string, LanguageCode string) (entity.Feed, error) {
+	feed, err := c.createRSSFeed(ctx, publicationUUID, URL, LanguageCode)
+	if !errors.Is(err, ErrFeedExists) {
+		return feed, err
+	}
+	feeds, err := c.GetRSSFeedsByPublicationUUID(ctx, publicationUUID)
+	if err != nil {
+		return entity.Feed{}, err
+	}
+	for _, feed := range feeds {
+		if feed.URL == URL {
+			return feed, nil
+		}
+	}
+	// Feed was deleted or changed after the conflict
+	return entity.Feed{}, ErrFeedExists
+}
+
+// createRSSFeed creates feed and returns created feed, ErrFeedExists on conflict
+func (c *client) createRSSFeed(ctx context.Context, publicationUUID uuid.UUID, URL string, LanguageCode string) (entity.Feed, error) {
 	feed := &entity.Feed{
 		PublicationUUID: publicationUUID,
 		URL:             URL,
@@ -142,22 +406,51 @@ func (c *client) CreateRSSFeed(ctx context.Context, publicationUUID uuid.UUID, U
 	}
 	body, err := json.Marshal(feed)
 	if err != nil {
-		return err
+		return entity.Feed{}, err
 	}
 	rel := &url.URL{Path: feedsCRUDPath}
 	u := c.baseURL.ResolveReference(rel)
 	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(body))
 	if err != nil {
-		return err
+		return entity.Feed{}, err
 	}
-	req = req.WithContext(ctx)
 	req.Header.Set("Content-Type", "application/json")
-	res, err := c.httpClient.Do(req)
+	res, err := c.do(ctx, req)
+	if err != nil {
+		return entity.Feed{}, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusCreated:
+		created := entity.Feed{}
+		if err = json.NewDecoder(res.Body).Decode(&created); err != nil {
+			return entity.Feed{}, err
+		}
+		return created, nil
+	case http.StatusConflict:
+		return entity.Feed{}, ErrFeedExists
+	}
+	// handle error
+	var errRes server.ErrResponseBody
+	if err = json.NewDecoder(res.Body).Decode(&errRes); err == nil {
+		return entity.Feed{}, errors.New(errRes.ErrorText)
+	}
+	return entity.Feed{}, fmt.Errorf("unknown error, status code: %d, message: %v", res.StatusCode, res.Status)
+}
+
+func (c *client) DeleteRSSFeed(ctx context.Context, feedID uuid.UUID) error {
+	rel := &url.URL{Path: fmt.Sprintf("%s/%s", feedsCRUDPath, feedID)}
+	u := c.baseURL.ResolveReference(rel)
+	req, err := http.NewRequest("DELETE", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := c.do(ctx, req)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
-	if res.StatusCode == http.StatusCreated {
+	if res.StatusCode == http.StatusNoContent {
 		return nil
 	}
 	// handle error
@@ -168,15 +461,18 @@ func (c *client) CreateRSSFeed(ctx context.Context, publicationUUID uuid.UUID, U
 	return fmt.Errorf("unknown error, status code: %d, message: %v", res.StatusCode, res.Status)
 }
 
-func (c *client) DeleteRSSFeed(ctx context.Context, publicationUUID uuid.UUID) error {
-	rel := &url.URL{Path: fmt.Sprintf("%s/%s", feedsCRUDPath, publicationUUID)}
+// RefreshRSSFeed triggers refresh of the feed, force ignores feed caching headers
+func (c *client) RefreshRSSFeed(ctx context.Context, feedID uuid.UUID, force bool) error {
+	rel := &url.URL{
+		Path:     fmt.Sprintf("%s/%s", refreshFeedsPath, feedID),
+		RawQuery: url.Values{"force": {strconv.FormatBool(force)}}.Encode(),
+	}
 	u := c.baseURL.ResolveReference(rel)
-	req, err := http.NewRequest("DELETE", u.String(), nil)
+	req, err := http.NewRequest("PUT", u.String(), nil)
 	if err != nil {
 		return err
 	}
-	req = req.WithContext(ctx)
-	res, err := c.httpClient.Do(req)
+	res, err := c.do(ctx, req)
 	if err != nil {
 		return err
 	}
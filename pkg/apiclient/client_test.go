@@ -0,0 +1,74 @@
+package apiclient
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Tarick/naca-rss-feeds/internal/application/server"
+	"github.com/Tarick/naca-rss-feeds/internal/entity"
+	"github.com/gofrs/uuid"
+	"github.com/opentracing/opentracing-go"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(args ...interface{}) {}
+func (nopLogger) Info(args ...interface{})  {}
+func (nopLogger) Warn(args ...interface{})  {}
+func (nopLogger) Error(args ...interface{}) {}
+func (nopLogger) Fatal(args ...interface{}) {}
+
+// fakeRepository serves and updates single feed, methods not overridden panic
+type fakeRepository struct {
+	server.FeedsRepository
+	feed *entity.Feed
+}
+
+func (r *fakeRepository) GetByID(ctx context.Context, id uuid.UUID) (*entity.Feed, error) {
+	if r.feed != nil && r.feed.ID == id {
+		feed := *r.feed
+		return &feed, nil
+	}
+	return nil, nil
+}
+
+func (r *fakeRepository) UpdateWithAudit(ctx context.Context, feed *entity.Feed, audit *entity.AuditRecord) error {
+	updated := *feed
+	r.feed = &updated
+	return nil
+}
+
+func TestUpdateRSSFeedKeepsFeedEnabled(t *testing.T) {
+	feed := &entity.Feed{
+		ID:              uuid.Must(uuid.NewV4()),
+		PublicationUUID: uuid.Must(uuid.NewV4()),
+		URL:             "https://example.com/feed.xml",
+		LanguageCode:    "en",
+		Enabled:         true,
+	}
+	repository := &fakeRepository{feed: feed}
+	handler := server.NewHandler(nopLogger{}, opentracing.NoopTracer{}, repository, nil, nil, "", nil, nil)
+	srv, err := server.New(server.Config{}, nopLogger{}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	c, err := New(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.UpdateRSSFeed(context.Background(), feed.ID, feed.PublicationUUID, "https://example.com/news.xml", "de"); err != nil {
+		t.Fatal(err)
+	}
+	updated := repository.feed
+	if updated.URL != "https://example.com/news.xml" || updated.LanguageCode != "de" {
+		t.Errorf("feed url and language = %s, %s, want updated", updated.URL, updated.LanguageCode)
+	}
+	if !updated.Enabled || updated.DisabledReason != "" {
+		t.Errorf("feed is disabled by update, enabled = %v, disabled reason = %q", updated.Enabled, updated.DisabledReason)
+	}
+}